@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/plusk0/webserver/internal/auth"
+	"github.com/plusk0/webserver/internal/database"
+)
+
+const (
+	accessTokenNameMax    = 50
+	accessTokensPerUser   = 50
+	accessTokenMaxExpires = 366
+)
+
+// validateToken checks the bearer token for an endpoint needing scope. It
+// takes Chirpy's own JWTs, which may do anything, and OAuth access tokens
+// and personal access tokens, which need to have been granted scope.
+func (conf *apiConfig) validateToken(ctx context.Context, token, scope string) (uuid.UUID, error) {
+	if !strings.HasPrefix(token, auth.PersonalAccessTokenPrefix) {
+		return auth.ValidateJWTScope(token, conf.jwtKeys, scope)
+	}
+	pat, err := conf.dbQueries.GetPersonalAccessToken(ctx, auth.HashToken(token))
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if !auth.HasScope(pat.Scope, scope) {
+		return uuid.Nil, auth.ErrInsufficientScope
+	}
+	if err := conf.dbQueries.TouchPersonalAccessToken(ctx, pat.ID); err != nil {
+		fmt.Printf("Failed to record token use: %v\n", err)
+	}
+	return pat.UserID, nil
+}
+
+// createAccessTokenHandlerFunc makes a personal access token. The token
+// itself is only in this response. Tokens can't make more tokens; that
+// takes a login.
+func (conf *apiConfig) createAccessTokenHandlerFunc(w http.ResponseWriter, r *http.Request) {
+	tk, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	userID, err := auth.ValidateJWT(tk, conf.jwtKeys)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	var req accessTokenReq
+	if err := readJSON(r, &req); err != nil {
+		respondWithError(w, 400, "Something went wrong")
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" || utf8.RuneCountInString(name) > accessTokenNameMax {
+		respondWithError(w, 400, "Name must be 1 to 50 characters")
+		return
+	}
+	scopes, err := parseScopes(strings.Join(req.Scopes, " "))
+	if err != nil {
+		respondWithError(w, 400, "Invalid scopes: "+err.(*oauthError).Description)
+		return
+	}
+	if req.ExpiresInDays < 0 || req.ExpiresInDays > accessTokenMaxExpires {
+		respondWithError(w, 400, "expires_in_days must be between 1 and 366, or 0 for no expiry")
+		return
+	}
+	var expires sql.NullTime
+	if req.ExpiresInDays > 0 {
+		expires = sql.NullTime{Time: time.Now().AddDate(0, 0, req.ExpiresInDays), Valid: true}
+	}
+
+	n, err := conf.dbQueries.CountUserPersonalAccessTokens(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, 500, "Failed to create token")
+		return
+	}
+	if n >= accessTokensPerUser {
+		respondWithError(w, 409, "Too many tokens, revoke some first")
+		return
+	}
+	token, err := auth.MakePersonalAccessToken()
+	if err != nil {
+		respondWithError(w, 500, "Failed to create token")
+		return
+	}
+
+	tx, err := conf.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, 500, "Failed to create token")
+		return
+	}
+	defer tx.Rollback()
+	q := conf.dbQueries.WithTx(tx)
+
+	params := database.CreatePersonalAccessTokenParams{
+		UserID:    userID,
+		Name:      name,
+		TokenHash: auth.HashToken(token),
+		Scope:     strings.Join(scopes, " "),
+		ExpiresAt: expires,
+	}
+	pat, err := q.CreatePersonalAccessToken(r.Context(), params)
+	if err != nil {
+		respondWithError(w, 500, "Failed to create token")
+		return
+	}
+	details := map[string]any{"token_id": pat.ID, "scope": pat.Scope}
+	if err := audit(r.Context(), q, auditTokenCreated, userID, details); err != nil {
+		respondWithError(w, 500, "Failed to create token")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		respondWithError(w, 500, "Failed to create token")
+		return
+	}
+	out := dbAccessTokenToJSON(pat)
+	out.Token = token
+	respondWithJSON(w, 201, out)
+}
+
+func (conf *apiConfig) getAccessTokensHandlerFunc(w http.ResponseWriter, r *http.Request) {
+	tk, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	userID, err := auth.ValidateJWT(tk, conf.jwtKeys)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	tokens, err := conf.dbQueries.GetUserPersonalAccessTokens(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, 500, "Failed to get tokens")
+		return
+	}
+	out := make([]AccessToken, 0, len(tokens))
+	for _, t := range tokens {
+		out = append(out, dbAccessTokenToJSON(t))
+	}
+	respondWithJSON(w, 200, out)
+}
+
+func (conf *apiConfig) revokeAccessTokenHandlerFunc(w http.ResponseWriter, r *http.Request) {
+	tk, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	userID, err := auth.ValidateJWT(tk, conf.jwtKeys)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	tokenID, err := uuid.Parse(r.PathValue("tokenID"))
+	if err != nil {
+		respondWithError(w, 404, "Token not found")
+		return
+	}
+
+	tx, err := conf.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, 500, "Failed to revoke token")
+		return
+	}
+	defer tx.Rollback()
+	q := conf.dbQueries.WithTx(tx)
+
+	n, err := q.RevokePersonalAccessToken(r.Context(), database.RevokePersonalAccessTokenParams{ID: tokenID, UserID: userID})
+	if err != nil {
+		respondWithError(w, 500, "Failed to revoke token")
+		return
+	}
+	if n == 0 {
+		respondWithError(w, 404, "Token not found")
+		return
+	}
+	if err := audit(r.Context(), q, auditTokenRevoked, userID, map[string]any{"token_id": tokenID}); err != nil {
+		respondWithError(w, 500, "Failed to revoke token")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		respondWithError(w, 500, "Failed to revoke token")
+		return
+	}
+	w.WriteHeader(204)
+}
+
+func dbAccessTokenToJSON(db database.PersonalAccessToken) AccessToken {
+	t := AccessToken{
+		ID:        db.ID,
+		Name:      db.Name,
+		Scopes:    strings.Fields(db.Scope),
+		CreatedAt: db.CreatedAt,
+	}
+	if db.ExpiresAt.Valid {
+		t.ExpiresAt = &db.ExpiresAt.Time
+	}
+	if db.LastUsedAt.Valid {
+		t.LastUsedAt = &db.LastUsedAt.Time
+	}
+	return t
+}
@@ -28,7 +28,13 @@ func (cfg *apiConfig) metricsResetHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 	cfg.fileserverHits.Store(0)
-	_, err := cfg.dbQueries.ResetUsers(r.Context())
+	// Chirps outlive their author under the anonymize policy, so clear
+	// them explicitly instead of relying on the users cascade.
+	_, err := cfg.dbQueries.ResetChirps(r.Context())
+	if err != nil {
+		log.Fatal("Failed to reset chirps")
+	}
+	_, err = cfg.dbQueries.ResetUsers(r.Context())
 	if err != nil {
 		log.Fatal("Failed to reset users")
 	}
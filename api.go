@@ -1,44 +1,86 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"net/url"
+	"os"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/plusk0/webserver/internal/auth"
 	"github.com/plusk0/webserver/internal/database"
+	"github.com/plusk0/webserver/internal/handles"
+	"github.com/plusk0/webserver/internal/richtext"
 )
 
+// refreshTokenTTL is how long a refresh token lasts. Each refresh issues a
+// new one, so a login lasts as long as it's used at least this often.
+const refreshTokenTTL = time.Hour
+
 func (conf *apiConfig) usersHandlerFunc(w http.ResponseWriter, r *http.Request) {
 	usr, err := getUsrReq(r)
 	if err != nil {
 		respondWithError(w, 404, "Failed")
+		return
+	}
+	if !validEmail(usr.Email) {
+		respondWithError(w, 400, "Invalid email address")
+		return
+	}
+	if !conf.checkNewPassword(w, usr.Password, usr.Email) {
+		return
 	}
 
 	hash, err := auth.HashPassword(usr.Password)
 	if err != nil {
 		respondWithError(w, 404, "Failed")
+		return
+	}
+
+	// A handle is optional at sign-up; without one the account gets a
+	// placeholder it can change later.
+	handle := handles.Generated()
+	if usr.Handle != "" {
+		handle = strings.TrimPrefix(usr.Handle, "@")
+		if err := handles.Validate(handle); err != nil {
+			respondWithError(w, 400, err.Error())
+			return
+		}
 	}
 
-	params := database.CreateUserParams{usr.Email, hash}
+	params := database.CreateUserParams{Email: usr.Email, Password: hash, Handle: handle}
 	dbUsr, err := conf.dbQueries.CreateUser(r.Context(), params)
+	switch uniqueConstraint(err) {
+	case "users_email_key":
+		respondWithError(w, 409, "Email is taken")
+		return
+	case "users_handle_key":
+		respondWithError(w, 409, "Handle is taken")
+		return
+	}
 	if err != nil {
-		log.Fatal("Failed to create User")
+		respondWithError(w, 500, "Failed to create user")
+		return
 	}
 
-	tk, err := auth.MakeJWT(dbUsr.ID, conf.JWTKey)
+	tk, rTK, err := conf.startSession(r.Context(), r, dbUsr.ID)
 	if err != nil {
-		log.Fatal("Failed to make JWT")
+		respondWithError(w, 500, "Failed to create user")
+		return
 	}
-	rTK, err := auth.MakeRefreshToken()
-	if err != nil {
-		log.Fatal("Failed to make rTK")
+	// Signing up shouldn't fail because the mail server is down; the link
+	// can be sent again later.
+	if err := conf.sendVerification(r.Context(), dbUsr.ID, dbUsr.Email); err != nil {
+		fmt.Printf("Failed to send verification email: %v\n", err)
 	}
 	user := dbUserToSafeJSON(dbUsr, tk, rTK)
 	respondWithJSON(w, 201, user)
@@ -48,10 +90,12 @@ func (conf *apiConfig) userUpdateHandlerFunc(w http.ResponseWriter, r *http.Requ
 	tk, err := auth.GetBearerToken(r.Header)
 	if err != nil {
 		respondWithError(w, 401, "Invalid Header")
+		return
 	}
-	validUser, err := auth.ValidateJWT(tk, conf.JWTKey)
+	validUser, err := auth.ValidateJWT(tk, conf.jwtKeys)
 	if err != nil {
-		fmt.Println(err)
+		respondWithError(w, 401, "Unauthorized")
+		return
 	}
 
 	usr, err := getUsrReq(r)
@@ -59,84 +103,393 @@ func (conf *apiConfig) userUpdateHandlerFunc(w http.ResponseWriter, r *http.Requ
 		respondWithError(w, 401, "Failed")
 		return
 	}
+	if !validEmail(usr.Email) {
+		respondWithError(w, 400, "Invalid email address")
+		return
+	}
+	current, err := conf.dbQueries.GetUserByID(r.Context(), validUser)
+	if err != nil {
+		respondWithError(w, 401, "Failed")
+		return
+	}
+	samePassword, err := auth.CheckPasswordHash(usr.Password, current.Password)
+	if err != nil {
+		respondWithError(w, 401, "Failed")
+		return
+	}
+	// Passwords from before the policy can be kept, but not newly set
+	if !samePassword && !conf.checkNewPassword(w, usr.Password, usr.Email) {
+		return
+	}
 	hash, err := auth.HashPassword(usr.Password)
 	if err != nil {
 		respondWithError(w, 401, "Failed")
 		return
 	}
-	params := database.UpdateUserParams{validUser, usr.Email, hash}
-	user, err := conf.dbQueries.UpdateUser(r.Context(), params)
+
+	tx, err := conf.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, 500, "Failed to update user")
+		return
+	}
+	defer tx.Rollback()
+	q := conf.dbQueries.WithTx(tx)
+
+	params := database.UpdateUserParams{ID: validUser, Email: usr.Email, Password: hash}
+	user, err := q.UpdateUser(r.Context(), params)
 	if err != nil {
 		respondWithError(w, 401, "Failed")
 		return
 	}
-	respondWithJSON(w, 200, dbUserToUserJSON(user))
+	// A new password logs out every other login
+	var version int32
+	if !samePassword {
+		if version, err = revokeUserAccess(r.Context(), q, user.ID); err != nil {
+			respondWithError(w, 500, "Failed to update user")
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		respondWithError(w, 500, "Failed to update user")
+		return
+	}
+	out := dbUserToUserJSON(user)
+	// This login included, so it gets a fresh session to carry on with
+	if !samePassword {
+		conf.revoked.SetTokenVersion(user.ID, version)
+		if out.Token, out.RefreshToken, err = conf.startSession(r.Context(), r, user.ID); err != nil {
+			respondWithError(w, 500, "Failed to start a new session")
+			return
+		}
+	}
+	// A changed address has to be verified again. Past the resend limit
+	// the user can ask for the link once it has cleared.
+	if user.Email != current.Email {
+		wait, err := conf.verificationWait(r.Context(), user.ID)
+		if err == nil && wait == 0 {
+			err = conf.sendVerification(r.Context(), user.ID, user.Email)
+		}
+		if err != nil {
+			fmt.Printf("Failed to send verification email: %v\n", err)
+		}
+	}
+	respondWithJSON(w, 200, out)
 }
 
-func (conf *apiConfig) loginHandlerFunc(w http.ResponseWriter, r *http.Request) {
-	usr, err := getUsrReq(r)
+func (conf *apiConfig) userDeleteHandlerFunc(w http.ResponseWriter, r *http.Request) {
+	tk, err := auth.GetBearerToken(r.Header)
 	if err != nil {
-		respondWithError(w, 401, "invalid Username or Password")
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	userID, err := auth.ValidateJWT(tk, conf.jwtKeys)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		respondWithError(w, 400, "Something went wrong")
+		return
+	}
+	defer r.Body.Close()
+	var req deleteUsrReq
+	if err := json.Unmarshal(data, &req); err != nil || req.Password == "" {
+		respondWithError(w, 400, "Password confirmation required")
+		return
+	}
+
+	user, err := conf.dbQueries.GetUserByID(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, 404, "User not found")
+		return
+	}
+	valid, err := auth.CheckPasswordHash(req.Password, user.Password)
+	if err != nil || !valid {
+		respondWithError(w, 403, "Invalid password")
+		return
+	}
+
+	tx, err := conf.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, 500, "Failed to delete user")
+		return
+	}
+	defer tx.Rollback()
+	q := conf.dbQueries.WithTx(tx)
+
+	if err := q.RevokeUserTokens(r.Context(), userID); err != nil {
+		respondWithError(w, 500, "Failed to delete user")
+		return
+	}
+	author := uuid.NullUUID{UUID: userID, Valid: true}
+	var chirps int64
+	if conf.chirpDeletionPolicy == "anonymize" {
+		chirps, err = q.AnonymizeUserChirps(r.Context(), author)
+	} else {
+		chirps, err = q.DeleteUserChirps(r.Context(), author)
+	}
+	if err != nil {
+		respondWithError(w, 500, "Failed to delete user")
+		return
+	}
+	exports, err := q.GetUserExportJobs(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, 500, "Failed to delete user")
+		return
+	}
+	if err := q.DeleteUser(r.Context(), userID); err != nil {
+		respondWithError(w, 500, "Failed to delete user")
 		return
 	}
+	details := map[string]any{"policy": conf.chirpDeletionPolicy, "chirps": chirps}
+	if err := audit(r.Context(), q, auditUserDeleted, userID, details); err != nil {
+		respondWithError(w, 500, "Failed to delete user")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		respondWithError(w, 500, "Failed to delete user")
+		return
+	}
+	// Tokens are checked against the user's token version, which is gone now
+	conf.revoked.Forget(userID)
+	for _, job := range exports {
+		_ = os.Remove(conf.exportPath(job.ID))
+	}
+	w.WriteHeader(204)
+}
 
-	user, err := conf.dbQueries.GetUser(r.Context(), usr.Email)
+func (conf *apiConfig) userPreferencesHandlerFunc(w http.ResponseWriter, r *http.Request) {
+	tk, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	userID, err := auth.ValidateJWT(tk, conf.jwtKeys)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		respondWithError(w, 400, "Something went wrong")
+		return
+	}
+	defer r.Body.Close()
+	var req preferencesReq
+	if err := json.Unmarshal(data, &req); err != nil {
+		respondWithError(w, 400, "Something went wrong")
+		return
+	}
+	params := database.UpdateUserPreferencesParams{ID: userID, HideSensitive: req.HideSensitive}
+	if err := conf.dbQueries.UpdateUserPreferences(r.Context(), params); err != nil {
+		respondWithError(w, 500, "Failed to update preferences")
+		return
+	}
+	respondWithJSON(w, 200, req)
+}
+
+func (conf *apiConfig) loginHandlerFunc(w http.ResponseWriter, r *http.Request) {
+	usr, err := getUsrReq(r)
 	if err != nil {
 		respondWithError(w, 401, "invalid Username or Password")
 		return
 	}
 
-	valid, err := auth.CheckPasswordHash(usr.Password, user.Password)
-	if !valid {
+	user, err := conf.checkLogin(r.Context(), r, usr.Email, usr.Password)
+	var locked *loginLockedError
+	if errors.As(err, &locked) {
+		locked.setRetryAfter(w)
+		respondWithError(w, 429, "Too many failed login attempts, try again later")
+		return
+	}
+	if errors.Is(err, errLoginFailed) {
 		respondWithError(w, 401, "invalid Username or Password")
+		return
+	}
+	if errors.Is(err, errAccountSuspended) {
+		respondWithError(w, 403, "Account is suspended")
+		return
 	}
 	if err != nil {
-		log.Fatalf("Failed to check Login credentials: %v", err)
+		respondWithError(w, 500, "Failed to log in")
+		return
 	}
 
-	tk, err := auth.MakeJWT(user.ID, conf.JWTKey)
+	twoFactor, err := conf.twoFactorEnabled(r.Context(), user.ID)
 	if err != nil {
-		log.Fatal("Failed to make JWT")
+		respondWithError(w, 500, "Failed to log in")
+		return
 	}
-	rTK, err := auth.MakeRefreshToken()
+	if twoFactor {
+		challenge, err := conf.startLoginChallenge(r.Context(), user.ID)
+		if err != nil {
+			respondWithError(w, 500, "Failed to log in")
+			return
+		}
+		respondWithJSON(w, 200, challenge)
+		return
+	}
+	conf.completeLogin(w, r, user)
+}
+
+// completeLogin issues the access and refresh tokens once every factor
+// has been checked. Each login is a new session.
+func (conf *apiConfig) completeLogin(w http.ResponseWriter, r *http.Request, user database.User) {
+	err := conf.checkNotSuspended(r.Context(), user.ID)
+	if errors.Is(err, errAccountSuspended) {
+		respondWithError(w, 403, "Account is suspended")
+		return
+	}
+	if err != nil {
+		respondWithError(w, 500, "Failed to log in")
+		return
+	}
+	tk, rTK, err := conf.startSession(r.Context(), r, user.ID)
 	if err != nil {
-		log.Fatal("Failed to make rTK")
+		respondWithError(w, 500, "Failed to log in")
+		return
 	}
-	params := database.CreateTokenParams{rTK, user.ID, time.Now().Add(time.Hour)}
-	conf.dbQueries.CreateToken(r.Context(), params)
 	respondWithJSON(w, 200, dbUserToSafeJSON(user, tk, rTK))
 }
 
+// issueRefreshToken makes a refresh token in family. Only its hash is
+// stored.
+func (conf *apiConfig) issueRefreshToken(ctx context.Context, q *database.Queries, userID, family uuid.UUID) (string, error) {
+	rTK, err := auth.MakeRefreshToken()
+	if err != nil {
+		return "", err
+	}
+	params := database.CreateTokenParams{
+		TokenHash: auth.HashToken(rTK),
+		UserID:    userID,
+		FamilyID:  family,
+		ExpiresAt: time.Now().Add(refreshTokenTTL),
+	}
+	if _, err := q.CreateToken(ctx, params); err != nil {
+		return "", err
+	}
+	return rTK, nil
+}
+
+// refreshHandlerFunc swaps a refresh token for a new access token and a
+// new refresh token. The old one stops working; if it's ever presented
+// again someone else has a copy, and the whole family is revoked, logging
+// out both them and the real user.
 func (conf *apiConfig) refreshHandlerFunc(w http.ResponseWriter, r *http.Request) {
 	tk, err := auth.GetBearerToken(r.Header)
 	if err != nil {
-		log.Fatal("Failed to get header Token")
+		respondWithError(w, 401, "Invalid token")
+		return
 	}
-	dbToken, err := conf.dbQueries.GetToken(r.Context(), tk)
-	if err != nil || dbToken.RevokedAt.Valid || dbToken.Token == "" {
+	dbToken, err := conf.dbQueries.GetToken(r.Context(), auth.HashToken(tk))
+	if err != nil {
 		respondWithError(w, 401, "Invalid token")
+		return
 	}
-	tkNew, err := auth.MakeJWT(dbToken.UserID, conf.JWTKey)
+	if dbToken.RotatedAt.Valid {
+		conf.revokeReusedFamily(r.Context(), dbToken)
+		respondWithError(w, 401, "Invalid token")
+		return
+	}
+	if dbToken.RevokedAt.Valid || time.Now().After(dbToken.ExpiresAt) {
+		respondWithError(w, 401, "Invalid token")
+		return
+	}
+	err = conf.checkNotSuspended(r.Context(), dbToken.UserID)
+	if errors.Is(err, errAccountSuspended) {
+		respondWithError(w, 403, "Account is suspended")
+		return
+	}
+	if err != nil {
+		respondWithError(w, 500, "Failed to refresh token")
+		return
+	}
+
+	tx, err := conf.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, 500, "Failed to refresh token")
+		return
+	}
+	defer tx.Rollback()
+	q := conf.dbQueries.WithTx(tx)
+
+	n, err := q.RotateToken(r.Context(), dbToken.TokenHash)
+	if err != nil {
+		respondWithError(w, 500, "Failed to refresh token")
+		return
+	}
+	if n == 0 {
+		// Another request rotated it first: the same token was used twice
+		tx.Rollback()
+		conf.revokeReusedFamily(r.Context(), dbToken)
+		respondWithError(w, 401, "Invalid token")
+		return
+	}
+	rTK, err := conf.issueRefreshToken(r.Context(), q, dbToken.UserID, dbToken.FamilyID)
 	if err != nil {
-		log.Fatal("Failed to make JWT")
+		respondWithError(w, 500, "Failed to refresh token")
+		return
+	}
+	if err := q.TouchSession(r.Context(), database.TouchSessionParams{ID: dbToken.FamilyID, Ip: conf.remoteIP(r)}); err != nil {
+		respondWithError(w, 500, "Failed to refresh token")
+		return
+	}
+	tkNew, err := auth.MakeSessionJWT(dbToken.UserID, dbToken.FamilyID, conf.jwtKeys)
+	if err != nil {
+		respondWithError(w, 500, "Failed to refresh token")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		respondWithError(w, 500, "Failed to refresh token")
+		return
 	}
 	tokenMap := map[string]string{
-		"token": tkNew,
+		"token":         tkNew,
+		"refresh_token": rTK,
 	}
 	respondWithJSON(w, 200, tokenMap)
 }
 
+func (conf *apiConfig) revokeReusedFamily(ctx context.Context, token database.RefreshToken) {
+	n, err := conf.dbQueries.RevokeTokenFamily(ctx, token.FamilyID)
+	if err != nil {
+		fmt.Printf("Failed to revoke reused token family: %v\n", err)
+		return
+	}
+	if err := conf.denySessions(ctx, conf.dbQueries, token.FamilyID); err != nil {
+		fmt.Printf("Failed to revoke reused token family: %v\n", err)
+		return
+	}
+	details := map[string]any{"family_id": token.FamilyID, "revoked": n}
+	if err := audit(ctx, conf.dbQueries, auditRefreshTokenReused, token.UserID, details); err != nil {
+		fmt.Printf("Failed to audit refresh token reuse: %v\n", err)
+	}
+}
+
+// revokeHandlerFunc logs out: the refresh token, every token rotated from
+// the same login and the access tokens it handed out stop working.
 func (conf *apiConfig) revokeHandlerFunc(w http.ResponseWriter, r *http.Request) {
 	tk, err := auth.GetBearerToken(r.Header)
 	if err != nil {
-		log.Fatal("Failed to get header Token")
+		respondWithError(w, 401, "Invalid token")
+		return
 	}
-	_, err = conf.dbQueries.RevokeToken(r.Context(), tk)
+	dbToken, err := conf.dbQueries.GetToken(r.Context(), auth.HashToken(tk))
 	if err != nil {
 		respondWithError(w, 400, "Failed to revoke Token")
+		return
 	}
-
-	respondWithJSON(w, 204, "")
+	if _, err := conf.dbQueries.RevokeTokenFamily(r.Context(), dbToken.FamilyID); err != nil {
+		respondWithError(w, 400, "Failed to revoke Token")
+		return
+	}
+	if err := conf.denySessions(r.Context(), conf.dbQueries, dbToken.FamilyID); err != nil {
+		respondWithError(w, 500, "Failed to revoke Token")
+		return
+	}
+	w.WriteHeader(204)
 }
 
 func dbUserToSafeJSON(db database.User, tk string, rTK string) User {
@@ -149,6 +502,8 @@ func dbUserToSafeJSON(db database.User, tk string, rTK string) User {
 		tk,
 		rTK,
 		db.IsChirpyRed,
+		db.Handle,
+		db.EmailVerifiedAt.Valid,
 	}
 }
 
@@ -162,6 +517,8 @@ func dbUserToUserJSON(db database.UpdateUserRow) User {
 		"",
 		"",
 		db.IsChirpyRed,
+		db.Handle,
+		db.EmailVerifiedAt.Valid,
 	}
 }
 
@@ -196,11 +553,14 @@ func (conf *apiConfig) validateHandlerFunc(w http.ResponseWriter, r *http.Reques
 		respondWithError(w, 401, "Unauthorized")
 		return
 	}
-	validUser, err := auth.ValidateJWT(tk, conf.JWTKey)
+	validUser, err := conf.validateToken(r.Context(), tk, "chirps:write")
 	if err != nil {
-		fmt.Println(err)
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	if !conf.requireVerified(w, r, validUser) {
+		return
 	}
-	req.UserID = validUser
 
 	data, err := io.ReadAll(r.Body)
 	if err != nil {
@@ -213,11 +573,60 @@ func (conf *apiConfig) validateHandlerFunc(w http.ResponseWriter, r *http.Reques
 		respondWithError(w, 400, "Something went wrong")
 		return
 	}
-	if len(req.Body) > 140 {
+	if len(req.Body) > chirpMaxRawLength || richtext.VisibleLength(req.Body) > chirpMaxLength {
 		respondWithError(w, 400, "Chirp is too long")
 		return
 	}
-	dirty := strings.Split(req.Body, " ")
+	if len(req.ContentWarning) > contentWarningMaxLength {
+		respondWithError(w, 400, "Content warning is too long")
+		return
+	}
+	payload := cleanChirpBody(req.Body)
+	args := database.CreateChirpParams{
+		Body:           payload,
+		UserID:         uuid.NullUUID{UUID: validUser, Valid: true},
+		ContentWarning: req.ContentWarning,
+		// A content warning implies the chirp needs hiding
+		Sensitive: req.Sensitive || req.ContentWarning != "",
+		BodyHtml:  conf.renderChirpBody(r.Context(), payload),
+	}
+	insertedChirp, err := conf.dbQueries.CreateChirp(r.Context(), args)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	conf.notifyMentions(r.Context(), insertedChirp)
+	conf.federateChirp(r.Context(), "Create", insertedChirp)
+	respondWithJSON(w, 201, dbChirpToJSON(insertedChirp))
+}
+
+// chirpMaxLength counts visible characters; chirpMaxRawLength bounds the
+// markup around them, such as long link targets.
+const (
+	chirpMaxLength          = 140
+	chirpMaxRawLength       = 1000
+	contentWarningMaxLength = 100
+)
+
+// renderChirpBody pre-renders body as HTML. Mentions link to the mentioned
+// user's profile page and hashtags to every chirp carrying them.
+func (conf *apiConfig) renderChirpBody(ctx context.Context, body string) string {
+	return richtext.Render(body, richtext.Links{
+		Mention: func(handle string) string {
+			user, err := conf.dbQueries.GetUserByHandle(ctx, handle)
+			if err != nil {
+				return ""
+			}
+			return "/u/" + user.ID.String()
+		},
+		Hashtag: func(tag string) string {
+			return "/api/chirps?hashtag=" + url.QueryEscape(tag)
+		},
+	})
+}
+
+func cleanChirpBody(body string) string {
+	dirty := strings.Split(body, " ")
 	dirtyWords := []string{"kerfuffle", "sharbert", "fornax"}
 	var cleanWords []string
 	for _, v := range dirty {
@@ -228,55 +637,117 @@ func (conf *apiConfig) validateHandlerFunc(w http.ResponseWriter, r *http.Reques
 		}
 		cleanWords = append(cleanWords, v)
 	}
-	payload := strings.Join(cleanWords, " ")
-	args := database.CreateChirpParams{payload, req.UserID}
-	insertedChirp, err := conf.dbQueries.CreateChirp(r.Context(), args)
-	if err != nil {
-		respondWithError(w, 401, "Unauthorized")
-		return
-	}
-	respondWithJSON(w, 201, dbChirpToJSON(insertedChirp))
+	return strings.Join(cleanWords, " ")
 }
 
 func (conf *apiConfig) getChirpsHandlerFunc(w http.ResponseWriter, r *http.Request) {
-	f := r.URL.Query().Get("author_id")
-	var id uuid.UUID
-	var filtering bool
-	var err error
-	if f != "" {
-		filtering = true
-		id, err = uuid.Parse(f)
-		if err != nil {
-			filtering = false
+	var filter chirpFilter
+	if f := r.URL.Query().Get("author_id"); f != "" {
+		id, err := uuid.Parse(f)
+		if err == nil {
+			filter.AuthorID = uuid.NullUUID{UUID: id, Valid: true}
 		}
 	}
+	filter.Descending = r.URL.Query().Get("sort") == "desc"
+	filter.Hashtag = r.URL.Query().Get("hashtag")
 
-	s := r.URL.Query().Get("sort")
-	sortAscending := s != "desc"
+	var err error
+	filter.HideSensitive, err = conf.hideSensitive(r)
+	if err != nil {
+		respondWithError(w, 400, "Invalid hide_sensitive value")
+		return
+	}
 
-	chirps, err := conf.dbQueries.GetChirps(r.Context())
+	chirps, err := conf.listChirps(r.Context(), filter)
 	if err != nil {
 		respondWithError(w, 400, "Failed to get Chirps")
+		return
 	}
 	var jsonChirps []Chirp
+	ids := make([]uuid.UUID, 0, len(chirps))
 	for _, v := range chirps {
-		if filtering {
-			if v.UserID != id {
-				continue
-			}
+		jsonChirps = append(jsonChirps, dbChirpToJSON(v))
+		ids = append(ids, v.ID)
+	}
+	if includeAuthor(r) {
+		if err := conf.withAuthors(r.Context(), jsonChirps); err != nil {
+			respondWithError(w, 500, "Failed to get authors")
+			return
 		}
-		jsonChirp := dbChirpToJSON(v)
-		jsonChirps = append(jsonChirps, jsonChirp)
+	}
+	conf.views.Record(ids...)
+	respondWithJSON(w, 200, jsonChirps)
+}
 
-		sort.Slice(jsonChirps, func(i, j int) bool {
-			if sortAscending {
-				return jsonChirps[i].CreatedAt.Before(jsonChirps[j].CreatedAt)
-			}
-			return jsonChirps[i].CreatedAt.After(jsonChirps[j].CreatedAt)
-		})
+// chirpFilter selects chirps for the list endpoint and the feeds. Limit 0
+// means no limit; it keeps the first chirps in the requested order.
+type chirpFilter struct {
+	AuthorID      uuid.NullUUID
+	Hashtag       string
+	HideSensitive bool
+	Descending    bool
+	Limit         int
+}
 
+func (conf *apiConfig) listChirps(ctx context.Context, filter chirpFilter) ([]database.Chirp, error) {
+	chirps, err := conf.dbQueries.GetChirps(ctx)
+	if err != nil {
+		return nil, err
 	}
-	respondWithJSON(w, 200, jsonChirps)
+	var out []database.Chirp
+	for _, v := range chirps {
+		if filter.AuthorID.Valid && v.UserID.UUID != filter.AuthorID.UUID {
+			continue
+		}
+		if filter.HideSensitive && v.Sensitive {
+			continue
+		}
+		if filter.Hashtag != "" && !hasHashtag(v.Body, filter.Hashtag) {
+			continue
+		}
+		out = append(out, v)
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		if filter.Descending {
+			return out[i].CreatedAt.After(out[j].CreatedAt)
+		}
+		return out[i].CreatedAt.Before(out[j].CreatedAt)
+	})
+	if filter.Limit > 0 && len(out) > filter.Limit {
+		out = out[:filter.Limit]
+	}
+	return out, nil
+}
+
+func hasHashtag(body, tag string) bool {
+	for _, t := range richtext.Hashtags(body) {
+		if strings.EqualFold(t, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// hideSensitive decides whether a chirp list leaves out sensitive chirps.
+// The hide_sensitive parameter wins; otherwise a signed-in user's saved
+// preference applies, and anonymous readers see everything.
+func (conf *apiConfig) hideSensitive(r *http.Request) (bool, error) {
+	if v := r.URL.Query().Get("hide_sensitive"); v != "" {
+		return strconv.ParseBool(v)
+	}
+	tk, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		return false, nil
+	}
+	userID, err := conf.validateToken(r.Context(), tk, "chirps:read")
+	if err != nil {
+		return false, nil
+	}
+	user, err := conf.dbQueries.GetUserByID(r.Context(), userID)
+	if err != nil {
+		return false, nil
+	}
+	return user.HideSensitive, nil
 }
 
 func (conf *apiConfig) getChirpHandlerFunc(w http.ResponseWriter, r *http.Request) {
@@ -290,7 +761,15 @@ func (conf *apiConfig) getChirpHandlerFunc(w http.ResponseWriter, r *http.Reques
 		respondWithError(w, 404, "ChirpNotFound")
 		return
 	}
-	respondWithJSON(w, 200, dbChirpToJSON(chirp))
+	conf.views.Record(chirp.ID)
+	jsonChirps := []Chirp{dbChirpToJSON(chirp)}
+	if includeAuthor(r) {
+		if err := conf.withAuthors(r.Context(), jsonChirps); err != nil {
+			respondWithError(w, 500, "Failed to get author")
+			return
+		}
+	}
+	respondWithJSON(w, 200, jsonChirps[0])
 }
 
 func (conf *apiConfig) deleteChirpHandlerFunc(w http.ResponseWriter, r *http.Request) {
@@ -299,7 +778,7 @@ func (conf *apiConfig) deleteChirpHandlerFunc(w http.ResponseWriter, r *http.Req
 		respondWithError(w, 401, "Token not found")
 		return
 	}
-	validUser, err := auth.ValidateJWT(tk, conf.JWTKey)
+	validUser, err := conf.validateToken(r.Context(), tk, "chirps:write")
 	if err != nil {
 		respondWithError(w, 403, "User not Authorized")
 		return
@@ -314,7 +793,7 @@ func (conf *apiConfig) deleteChirpHandlerFunc(w http.ResponseWriter, r *http.Req
 		respondWithError(w, 404, "ChirpNotFound")
 		return
 	}
-	if chirp.UserID != validUser {
+	if chirp.UserID.UUID != validUser {
 		respondWithError(w, 403, "User not Authorized")
 		return
 	}
@@ -323,13 +802,88 @@ func (conf *apiConfig) deleteChirpHandlerFunc(w http.ResponseWriter, r *http.Req
 		respondWithError(w, 404, "Chirp not found")
 		return
 	}
+	conf.federateChirp(r.Context(), "Delete", chirp)
 	if err == nil {
 		w.WriteHeader(204)
 	}
 }
 
 func dbChirpToJSON(db database.Chirp) Chirp {
-	return Chirp{db.ID, db.CreatedAt, db.UpdatedAt, db.Body, db.UserID}
+	bodyHTML := db.BodyHtml
+	if bodyHTML == "" {
+		// Chirps from before rendering existed; their mentions stay unlinked
+		bodyHTML = richtext.Render(db.Body, richtext.Links{})
+	}
+	return Chirp{
+		db.ID,
+		db.CreatedAt,
+		db.UpdatedAt,
+		db.Body,
+		bodyHTML,
+		db.UserID.UUID,
+		db.ContentWarning,
+		db.Sensitive,
+		nil,
+	}
+}
+
+// chirpSensitiveHandlerFunc lets the author or a moderator flag a chirp as
+// sensitive after the fact, or clear the flag again.
+func (conf *apiConfig) chirpSensitiveHandlerFunc(w http.ResponseWriter, r *http.Request) {
+	tk, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, 401, "Token not found")
+		return
+	}
+	userID, err := conf.validateToken(r.Context(), tk, "chirps:write")
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, 404, "Failed to parse ChirpID")
+		return
+	}
+
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		respondWithError(w, 400, "Something went wrong")
+		return
+	}
+	defer r.Body.Close()
+	var req sensitiveReq
+	if err := json.Unmarshal(data, &req); err != nil {
+		respondWithError(w, 400, "Something went wrong")
+		return
+	}
+
+	chirp, err := conf.dbQueries.GetChirp(r.Context(), chirpID)
+	if err != nil {
+		respondWithError(w, 404, "ChirpNotFound")
+		return
+	}
+	// Authors flag their own chirps, but a flag a moderator set stays until
+	// a moderator clears it
+	byModerator := chirp.UserID.UUID != userID || chirp.SensitiveByModerator
+	if chirp.UserID.UUID != userID || (chirp.SensitiveByModerator && !req.Sensitive) {
+		user, err := conf.dbQueries.GetUserByID(r.Context(), userID)
+		if err != nil || !user.IsModerator {
+			respondWithError(w, 403, "User not Authorized")
+			return
+		}
+	}
+	params := database.SetChirpSensitiveParams{
+		ID:                   chirpID,
+		Sensitive:            req.Sensitive,
+		SensitiveByModerator: req.Sensitive && byModerator,
+	}
+	chirp, err = conf.dbQueries.SetChirpSensitive(r.Context(), params)
+	if err != nil {
+		respondWithError(w, 500, "Failed to update chirp")
+		return
+	}
+	respondWithJSON(w, 200, dbChirpToJSON(chirp))
 }
 
 func (conf *apiConfig) webhookHandlerFunc(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/google/uuid"
+	"github.com/plusk0/webserver/internal/database"
+)
+
+const (
+	auditUserDeleted        = "user.deleted"
+	auditPasswordReset      = "user.password_reset"
+	auditTwoFactorEnabled   = "user.2fa_enabled"
+	auditTwoFactorDisabled  = "user.2fa_disabled"
+	auditPasskeyAdded       = "user.passkey_added"
+	auditPasskeyRemoved     = "user.passkey_removed"
+	auditPasskeyCloned      = "user.passkey_clone_detected"
+	auditIdentityLinked     = "user.identity_linked"
+	auditTokenCreated       = "user.token_created"
+	auditTokenRevoked       = "user.token_revoked"
+	auditRefreshTokenReused = "user.refresh_token_reused"
+	auditSessionRevoked     = "user.session_revoked"
+	auditAccountLocked      = "user.locked"
+	auditAccountUnlocked    = "user.unlocked"
+	auditUserSuspended      = "user.suspended"
+	auditUserUnsuspended    = "user.unsuspended"
+)
+
+// audit records event in the audit trail. It takes the queries to use so the
+// entry can be written in the same transaction as the change it describes.
+func audit(ctx context.Context, q *database.Queries, event string, userID uuid.UUID, details map[string]any) error {
+	data, err := json.Marshal(details)
+	if err != nil {
+		return err
+	}
+	params := database.CreateAuditEntryParams{
+		Event:   event,
+		UserID:  uuid.NullUUID{UUID: userID, Valid: userID != uuid.Nil},
+		Details: string(data),
+	}
+	return q.CreateAuditEntry(ctx, params)
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/plusk0/webserver/internal/auth"
+	"github.com/plusk0/webserver/internal/database"
+	"github.com/plusk0/webserver/internal/takeout"
+)
+
+const (
+	exportLinkTTL = 15 * time.Minute
+	// How long a finished archive is kept before the sweeper deletes it
+	exportRetention     = 7 * 24 * time.Hour
+	exportSweepInterval = time.Hour
+	// Minimum time between a user's exports, counted from the last request
+	exportCooldown = time.Hour
+)
+
+func (conf *apiConfig) exportHandlerFunc(w http.ResponseWriter, r *http.Request) {
+	tk, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	userID, err := auth.ValidateJWT(tk, conf.jwtKeys)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	last, err := conf.dbQueries.GetLatestExportJob(r.Context(), userID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, 500, "Failed to queue export")
+		return
+	}
+	if err == nil && last.Status != "pending" && last.Status != "running" {
+		if wait := time.Until(last.CreatedAt.Add(exportCooldown)); wait > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+			respondWithError(w, 429, "An export was made recently, try again later")
+			return
+		}
+	}
+	// The database allows one queued or running export per user
+	job, err := conf.dbQueries.CreateExportJob(r.Context(), userID)
+	if isUniqueViolation(err) {
+		respondWithError(w, 409, "An export is already in progress")
+		return
+	}
+	if err != nil {
+		respondWithError(w, 500, "Failed to queue export")
+		return
+	}
+	go func() { conf.exportQueue <- job.ID }()
+	respondWithJSON(w, 202, conf.dbExportJobToJSON(job))
+}
+
+func (conf *apiConfig) exportStatusHandlerFunc(w http.ResponseWriter, r *http.Request) {
+	tk, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	userID, err := auth.ValidateJWT(tk, conf.jwtKeys)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	jobID, err := uuid.Parse(r.PathValue("jobID"))
+	if err != nil {
+		respondWithError(w, 404, "Export not found")
+		return
+	}
+	job, err := conf.dbQueries.GetExportJob(r.Context(), jobID)
+	if err != nil || job.UserID != userID {
+		respondWithError(w, 404, "Export not found")
+		return
+	}
+	respondWithJSON(w, 200, conf.dbExportJobToJSON(job))
+}
+
+// exportDownloadHandlerFunc serves a finished archive to anyone holding a
+// valid signed link, so it can be opened straight from a browser.
+func (conf *apiConfig) exportDownloadHandlerFunc(w http.ResponseWriter, r *http.Request) {
+	if err := auth.VerifySignedURL(r.URL.Path, r.URL.Query(), conf.linkSecret); err != nil {
+		respondWithError(w, 403, "Invalid download link")
+		return
+	}
+	jobID, err := uuid.Parse(r.PathValue("jobID"))
+	if err != nil {
+		respondWithError(w, 404, "Export not found")
+		return
+	}
+	// An expired archive may still be on disk until the next sweep
+	job, err := conf.dbQueries.GetExportJob(r.Context(), jobID)
+	if err != nil || !job.ExpiresAt.Valid || job.ExpiresAt.Time.Before(time.Now()) {
+		respondWithError(w, 404, "Export not found")
+		return
+	}
+	f, err := os.Open(conf.exportPath(jobID))
+	if err != nil {
+		respondWithError(w, 404, "Export not found")
+		return
+	}
+	defer f.Close()
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="chirpy-export.zip"`)
+	http.ServeContent(w, r, "chirpy-export.zip", time.Time{}, f)
+}
+
+// runExportWorker builds queued archives one at a time. Jobs left unfinished
+// by a previous run are picked up again on startup.
+func (conf *apiConfig) runExportWorker() {
+	jobs, err := conf.dbQueries.GetUnfinishedExportJobs(context.Background())
+	if err != nil {
+		fmt.Printf("Failed to load unfinished exports: %v\n", err)
+	}
+	go func() {
+		for _, job := range jobs {
+			conf.exportQueue <- job.ID
+		}
+	}()
+	for jobID := range conf.exportQueue {
+		conf.runExport(jobID)
+	}
+}
+
+func (conf *apiConfig) runExport(jobID uuid.UUID) {
+	ctx := context.Background()
+	job, err := conf.dbQueries.GetExportJob(ctx, jobID)
+	if err != nil {
+		fmt.Printf("Failed to load export %s: %v\n", jobID, err)
+		return
+	}
+	if err := conf.dbQueries.StartExportJob(ctx, jobID); err != nil {
+		fmt.Printf("Failed to start export %s: %v\n", jobID, err)
+		return
+	}
+
+	params := database.FinishExportJobParams{
+		ID:        jobID,
+		Status:    "done",
+		ExpiresAt: sql.NullTime{Time: time.Now().Add(exportRetention), Valid: true},
+	}
+	if err := conf.writeExport(ctx, job.UserID, conf.exportPath(jobID)); err != nil {
+		fmt.Printf("Export %s failed: %v\n", jobID, err)
+		params.Status = "failed"
+		params.Error = "Failed to build archive"
+	}
+	if err := conf.dbQueries.FinishExportJob(ctx, params); err != nil {
+		fmt.Printf("Failed to finish export %s: %v\n", jobID, err)
+	}
+}
+
+// sweepExports deletes archives past their expiry, along with their jobs.
+func (conf *apiConfig) sweepExports() {
+	for {
+		ctx := context.Background()
+		jobs, err := conf.dbQueries.GetExpiredExportJobs(ctx)
+		if err != nil {
+			fmt.Printf("Failed to load expired exports: %v\n", err)
+		}
+		for _, job := range jobs {
+			if err := os.Remove(conf.exportPath(job.ID)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				fmt.Printf("Failed to delete export %s: %v\n", job.ID, err)
+				continue
+			}
+			if err := conf.dbQueries.DeleteExportJob(ctx, job.ID); err != nil {
+				fmt.Printf("Failed to delete export job %s: %v\n", job.ID, err)
+			}
+		}
+		time.Sleep(exportSweepInterval)
+	}
+}
+
+func (conf *apiConfig) writeExport(ctx context.Context, userID uuid.UUID, path string) error {
+	user, err := conf.dbQueries.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	chirps, err := conf.dbQueries.GetChirpsByAuthor(ctx, uuid.NullUUID{UUID: userID, Valid: true})
+	if err != nil {
+		return err
+	}
+	messages, err := conf.dbQueries.GetMessagesSince(ctx, database.GetMessagesSinceParams{UserID: userID, Since: 0})
+	if err != nil {
+		return err
+	}
+	notifications, err := conf.dbQueries.GetNotifications(ctx, database.GetNotificationsParams{UserID: userID})
+	if err != nil {
+		return err
+	}
+	sessions, err := conf.dbQueries.GetAllUserSessions(ctx, userID)
+	if err != nil {
+		return err
+	}
+	followers, err := conf.dbQueries.GetUserFollowers(ctx, userID)
+	if err != nil {
+		return err
+	}
+	likes, err := conf.dbQueries.GetRemoteLikesOfUser(ctx, uuid.NullUUID{UUID: userID, Valid: true})
+	if err != nil {
+		return err
+	}
+
+	datasets := []struct {
+		name    string
+		records any
+	}{
+		{"profile", profileExport{user.ID, user.CreatedAt, user.UpdatedAt, user.Email, user.IsChirpyRed, user.Handle, user.DisplayName, user.Bio, user.AvatarUrl}},
+		{"chirps", mapSlice(chirps, dbChirpToJSON)},
+		{"messages", mapSlice(messages, dbMessageToJSON)},
+		{"notifications", mapSlice(notifications, dbNotificationToJSON)},
+		{"sessions", mapSlice(sessions, func(s database.Session) sessionExport {
+			return sessionExport{s.ID, s.Device, s.UserAgent, s.Ip, s.CreatedAt, s.LastUsedAt}
+		})},
+		{"followers", mapSlice(followers, func(f database.Follower) followerExport {
+			return followerExport{f.ActorID, f.CreatedAt}
+		})},
+		{"likes", mapSlice(likes, func(l database.RemoteLike) likeExport {
+			return likeExport{l.ChirpID, l.ActorID, l.CreatedAt}
+		})},
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	// Write to a temporary name so a crash never leaves a truncated archive
+	// behind the download link.
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	archive := takeout.NewArchive(f)
+	for _, d := range datasets {
+		if err := archive.Add(d.name, d.records); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := archive.Close(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (conf *apiConfig) exportPath(jobID uuid.UUID) string {
+	return filepath.Join(conf.exportDir, jobID.String()+".zip")
+}
+
+func (conf *apiConfig) dbExportJobToJSON(db database.ExportJob) ExportJob {
+	job := ExportJob{
+		ID:        db.ID,
+		CreatedAt: db.CreatedAt,
+		Status:    db.Status,
+		Error:     db.Error,
+	}
+	if db.ExpiresAt.Valid {
+		job.ExpiresAt = &db.ExpiresAt.Time
+	}
+	if db.Status == "done" {
+		path := fmt.Sprintf("/api/exports/%s/download", db.ID)
+		job.DownloadURL = path + "?" + auth.SignURL(path, time.Now().Add(exportLinkTTL), conf.linkSecret)
+	}
+	return job
+}
+
+func mapSlice[T, U any](in []T, f func(T) U) []U {
+	out := make([]U, 0, len(in))
+	for _, v := range in {
+		out = append(out, f(v))
+	}
+	return out
+}
@@ -0,0 +1,608 @@
+package main
+
+import (
+	"context"
+	"crypto/rsa"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/plusk0/webserver/internal/activitypub"
+	"github.com/plusk0/webserver/internal/database"
+	"github.com/plusk0/webserver/internal/richtext"
+)
+
+const (
+	inboxMaxBytes     = 1 << 20
+	deliveryBatchSize = 50
+	deliveryInterval  = 10 * time.Second
+)
+
+// Federation needs stable absolute IDs, so it is only switched on when
+// BASE_URL is set. Without it every ActivityPub route answers 404.
+func (conf *apiConfig) federating() bool {
+	return conf.baseURL != ""
+}
+
+func (conf *apiConfig) actorURL(userID uuid.UUID) string {
+	return conf.baseURL + "/ap/users/" + userID.String()
+}
+
+func (conf *apiConfig) noteURL(chirpID uuid.UUID) string {
+	return conf.baseURL + "/ap/chirps/" + chirpID.String()
+}
+
+func (conf *apiConfig) webfingerHandlerFunc(w http.ResponseWriter, r *http.Request) {
+	if !conf.federating() {
+		respondWithError(w, 404, "Federation is disabled")
+		return
+	}
+	base, _ := url.Parse(conf.baseURL)
+	resource := r.URL.Query().Get("resource")
+
+	// Accounts are acct:<handle>@<host>. Actor URLs are accepted too, as
+	// are user IDs, which is how accounts were named before handles.
+	var user database.User
+	var err error
+	switch {
+	case strings.HasPrefix(resource, "acct:"):
+		name, host, _ := strings.Cut(strings.TrimPrefix(resource, "acct:"), "@")
+		if !strings.EqualFold(host, base.Host) {
+			respondWithError(w, 404, "User not found")
+			return
+		}
+		if id, parseErr := uuid.Parse(name); parseErr == nil {
+			user, err = conf.dbQueries.GetUserByID(r.Context(), id)
+		} else {
+			user, err = conf.dbQueries.GetUserByHandle(r.Context(), name)
+		}
+	case strings.HasPrefix(resource, conf.baseURL+"/ap/users/"):
+		id, parseErr := uuid.Parse(strings.TrimPrefix(resource, conf.baseURL+"/ap/users/"))
+		if parseErr != nil {
+			respondWithError(w, 404, "User not found")
+			return
+		}
+		user, err = conf.dbQueries.GetUserByID(r.Context(), id)
+	default:
+		err = errors.New("unsupported resource")
+	}
+	if err != nil {
+		respondWithError(w, 404, "User not found")
+		return
+	}
+	userID := user.ID
+
+	doc := activitypub.WebFinger{
+		Subject: "acct:" + user.Handle + "@" + base.Host,
+		Aliases: []string{conf.actorURL(userID), conf.baseURL + "/u/" + userID.String()},
+		Links: []activitypub.WebFingerLink{
+			{Rel: "self", Type: activitypub.ContentType, Href: conf.actorURL(userID)},
+			{Rel: "http://webfinger.net/rel/profile-page", Type: "text/html", Href: conf.baseURL + "/u/" + userID.String()},
+		},
+	}
+	respondWithActivity(w, 200, "application/jrd+json", doc)
+}
+
+func (conf *apiConfig) actorHandlerFunc(w http.ResponseWriter, r *http.Request) {
+	user, ok := conf.federatedUser(w, r)
+	if !ok {
+		return
+	}
+	actor, err := conf.actorDocument(r.Context(), user)
+	if err != nil {
+		respondWithError(w, 500, "Failed to load actor key")
+		return
+	}
+	respondWithActivity(w, 200, activitypub.ContentType, actor)
+}
+
+func (conf *apiConfig) actorDocument(ctx context.Context, user database.User) (activitypub.Actor, error) {
+	key, err := conf.actorKey(ctx, user.ID)
+	if err != nil {
+		return activitypub.Actor{}, err
+	}
+	id := conf.actorURL(user.ID)
+	actor := activitypub.Actor{
+		Context:           []string{activitypub.Context, activitypub.SecurityV1},
+		ID:                id,
+		Type:              "Person",
+		PreferredUsername: user.Handle,
+		Name:              user.DisplayName,
+		Summary:           html.EscapeString(user.Bio),
+		URL:               conf.baseURL + "/u/" + user.ID.String(),
+		Inbox:             id + "/inbox",
+		Outbox:            id + "/outbox",
+		Followers:         id + "/followers",
+		Endpoints:         &activitypub.Endpoints{SharedInbox: conf.baseURL + "/ap/inbox"},
+		PublicKey:         activitypub.PublicKey{ID: id + "#main-key", Owner: id, PublicKeyPem: key.PublicKey},
+	}
+	if user.AvatarUrl != "" {
+		actor.Icon = &activitypub.Image{Type: "Image", URL: user.AvatarUrl}
+	}
+	return actor, nil
+}
+
+func (conf *apiConfig) outboxHandlerFunc(w http.ResponseWriter, r *http.Request) {
+	user, ok := conf.federatedUser(w, r)
+	if !ok {
+		return
+	}
+	filter := chirpFilter{AuthorID: uuid.NullUUID{UUID: user.ID, Valid: true}, Descending: true, Limit: feedChirpLimit}
+	chirps, err := conf.listChirps(r.Context(), filter)
+	if err != nil {
+		respondWithError(w, 500, "Failed to get Chirps")
+		return
+	}
+	items := make([]activitypub.Activity, 0, len(chirps))
+	for _, c := range chirps {
+		activity, err := conf.chirpActivity("Create", c)
+		if err != nil {
+			respondWithError(w, 500, "Failed to build outbox")
+			return
+		}
+		items = append(items, activity)
+	}
+	outbox := activitypub.OrderedCollection{
+		Context:      activitypub.Context,
+		ID:           conf.actorURL(user.ID) + "/outbox",
+		Type:         "OrderedCollection",
+		TotalItems:   len(items),
+		OrderedItems: items,
+	}
+	respondWithActivity(w, 200, activitypub.ContentType, outbox)
+}
+
+func (conf *apiConfig) followersHandlerFunc(w http.ResponseWriter, r *http.Request) {
+	user, ok := conf.federatedUser(w, r)
+	if !ok {
+		return
+	}
+	count, err := conf.dbQueries.CountFollowers(r.Context(), user.ID)
+	if err != nil {
+		respondWithError(w, 500, "Failed to count followers")
+		return
+	}
+	// Only the count is public; the list itself stays private
+	followers := activitypub.OrderedCollection{
+		Context:    activitypub.Context,
+		ID:         conf.actorURL(user.ID) + "/followers",
+		Type:       "OrderedCollection",
+		TotalItems: int(count),
+	}
+	respondWithActivity(w, 200, activitypub.ContentType, followers)
+}
+
+func (conf *apiConfig) noteHandlerFunc(w http.ResponseWriter, r *http.Request) {
+	if !conf.federating() {
+		respondWithError(w, 404, "Federation is disabled")
+		return
+	}
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, 404, "Chirp not found")
+		return
+	}
+	chirp, err := conf.dbQueries.GetChirp(r.Context(), chirpID)
+	if err != nil || !chirp.UserID.Valid {
+		respondWithError(w, 404, "Chirp not found")
+		return
+	}
+	note := conf.chirpNote(chirp)
+	note.Context = activitypub.Context
+	respondWithActivity(w, 200, activitypub.ContentType, note)
+}
+
+// inboxHandlerFunc serves both personal inboxes and the shared one. Every
+// delivery must carry an HTTP Signature by a key of the activity's actor.
+func (conf *apiConfig) inboxHandlerFunc(w http.ResponseWriter, r *http.Request) {
+	if !conf.federating() {
+		respondWithError(w, 404, "Federation is disabled")
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, inboxMaxBytes))
+	if err != nil {
+		respondWithError(w, 413, "Activity is too large")
+		return
+	}
+	defer r.Body.Close()
+
+	var activity activitypub.Activity
+	if err := json.Unmarshal(body, &activity); err != nil || activity.Actor == "" {
+		respondWithError(w, 400, "Invalid activity")
+		return
+	}
+	actor, err := conf.verifyInbox(r, body, activity.Actor)
+	if err != nil {
+		respondWithError(w, 401, "Invalid signature")
+		return
+	}
+	if err := conf.handleActivity(r.Context(), actor, activity); err != nil {
+		fmt.Printf("Failed to handle %s from %s: %v\n", activity.Type, actor.ID, err)
+		respondWithError(w, 400, "Failed to handle activity")
+		return
+	}
+	w.WriteHeader(202)
+}
+
+// verifyInbox checks the request signature and that the signing key
+// belongs to actorID. Known actors are checked against their stored key
+// first; if that fails their document is fetched again in case the key
+// was rotated.
+func (conf *apiConfig) verifyInbox(r *http.Request, body []byte, actorID string) (database.RemoteActor, error) {
+	var actor database.RemoteActor
+	err := activitypub.VerifyActor(r, body, actorID, func(fresh bool) (string, error) {
+		var err error
+		if fresh {
+			actor, err = conf.fetchRemoteActor(r.Context(), actorID)
+		} else {
+			actor, err = conf.dbQueries.GetRemoteActor(r.Context(), actorID)
+		}
+		return actor.PublicKey, err
+	})
+	return actor, err
+}
+
+func (conf *apiConfig) fetchRemoteActor(ctx context.Context, id string) (database.RemoteActor, error) {
+	doc, err := activitypub.FetchActor(ctx, conf.federationClient, id)
+	if err != nil {
+		return database.RemoteActor{}, err
+	}
+	if doc.PublicKey.Owner != doc.ID || doc.Inbox == "" {
+		return database.RemoteActor{}, errors.New("actor document is incomplete")
+	}
+	params := database.UpsertRemoteActorParams{
+		ID:          doc.ID,
+		Username:    doc.PreferredUsername,
+		Inbox:       doc.Inbox,
+		SharedInbox: doc.DeliveryInbox(),
+		PublicKey:   doc.PublicKey.PublicKeyPem,
+	}
+	return conf.dbQueries.UpsertRemoteActor(ctx, params)
+}
+
+func (conf *apiConfig) handleActivity(ctx context.Context, actor database.RemoteActor, activity activitypub.Activity) error {
+	objectID := activitypub.ObjectID(activity.Object)
+	switch activity.Type {
+	case "Follow":
+		userID, ok := conf.localID(objectID, "/ap/users/")
+		if !ok {
+			return errors.New("can only follow local users")
+		}
+		if _, err := conf.dbQueries.GetUserByID(ctx, userID); err != nil {
+			return err
+		}
+		added, err := conf.dbQueries.AddFollower(ctx, database.AddFollowerParams{UserID: userID, ActorID: actor.ID})
+		if err != nil {
+			return err
+		}
+		// A repeated Follow is accepted again but only notifies once
+		if added > 0 {
+			conf.notifyRemote(ctx, userID, actor.ID, notificationFollow, uuid.NullUUID{})
+		}
+		accept := activitypub.Activity{
+			Context: activitypub.Context,
+			ID:      conf.actorURL(userID) + "#accepts/" + uuid.NewString(),
+			Type:    "Accept",
+			Actor:   conf.actorURL(userID),
+			Object:  rawJSON(activity),
+		}
+		return conf.deliver(ctx, userID, accept, []string{actor.SharedInbox})
+
+	case "Undo":
+		var inner activitypub.Activity
+		if err := json.Unmarshal(activity.Object, &inner); err != nil || inner.Actor != actor.ID {
+			return errors.New("can only undo embedded activities of the same actor")
+		}
+		switch inner.Type {
+		case "Follow":
+			if userID, ok := conf.localID(activitypub.ObjectID(inner.Object), "/ap/users/"); ok {
+				return conf.dbQueries.RemoveFollower(ctx, database.RemoveFollowerParams{UserID: userID, ActorID: actor.ID})
+			}
+		case "Like":
+			if chirpID, ok := conf.localID(activitypub.ObjectID(inner.Object), "/ap/chirps/"); ok {
+				return conf.dbQueries.RemoveRemoteLike(ctx, database.RemoveRemoteLikeParams{ActorID: actor.ID, ChirpID: chirpID})
+			}
+		}
+		return nil
+
+	case "Create":
+		if activitypub.ObjectType(activity.Object) != "Note" {
+			return nil
+		}
+		var note activitypub.Note
+		if err := json.Unmarshal(activity.Object, &note); err != nil {
+			return err
+		}
+		if note.ID == "" || note.AttributedTo != actor.ID {
+			return errors.New("note must be attributed to the sending actor")
+		}
+		published, err := time.Parse(time.RFC3339, note.Published)
+		if err != nil {
+			published = time.Now()
+		}
+		params := database.CreateRemoteNoteParams{
+			ID:        note.ID,
+			ActorID:   actor.ID,
+			Content:   richtext.Sanitize(note.Content),
+			InReplyTo: note.InReplyTo,
+			Published: published.UTC(),
+		}
+		return conf.dbQueries.CreateRemoteNote(ctx, params)
+
+	case "Delete":
+		if objectID == actor.ID {
+			return conf.dbQueries.DeleteRemoteActor(ctx, actor.ID)
+		}
+		_, err := conf.dbQueries.DeleteRemoteNote(ctx, database.DeleteRemoteNoteParams{ID: objectID, ActorID: actor.ID})
+		return err
+
+	case "Like":
+		chirpID, ok := conf.localID(objectID, "/ap/chirps/")
+		if !ok {
+			return nil
+		}
+		chirp, err := conf.dbQueries.GetChirp(ctx, chirpID)
+		if err != nil {
+			return err
+		}
+		added, err := conf.dbQueries.AddRemoteLike(ctx, database.AddRemoteLikeParams{ActorID: actor.ID, ChirpID: chirpID})
+		if err != nil {
+			return err
+		}
+		if added > 0 && chirp.UserID.Valid {
+			conf.notifyRemote(ctx, chirp.UserID.UUID, actor.ID, notificationLike, uuid.NullUUID{UUID: chirpID, Valid: true})
+		}
+		return nil
+	}
+	// Anything else is accepted and ignored
+	return nil
+}
+
+// federateChirp sends a Create or Delete for chirp to the author's
+// followers. It never fails the request that triggered it.
+func (conf *apiConfig) federateChirp(ctx context.Context, typ string, chirp database.Chirp) {
+	if !conf.federating() || !chirp.UserID.Valid {
+		return
+	}
+	followers, err := conf.dbQueries.GetFollowerActors(ctx, chirp.UserID.UUID)
+	if err != nil || len(followers) == 0 {
+		return
+	}
+	activity, err := conf.chirpActivity(typ, chirp)
+	if err != nil {
+		fmt.Printf("Failed to build %s activity: %v\n", typ, err)
+		return
+	}
+	inboxes := make([]string, 0, len(followers))
+	for _, f := range followers {
+		inboxes = append(inboxes, f.SharedInbox)
+	}
+	if err := conf.deliver(ctx, chirp.UserID.UUID, activity, inboxes); err != nil {
+		fmt.Printf("Failed to queue %s activity: %v\n", typ, err)
+	}
+}
+
+// federateProfile tells followers that user's profile changed.
+func (conf *apiConfig) federateProfile(ctx context.Context, user database.User) {
+	if !conf.federating() {
+		return
+	}
+	followers, err := conf.dbQueries.GetFollowerActors(ctx, user.ID)
+	if err != nil || len(followers) == 0 {
+		return
+	}
+	actor, err := conf.actorDocument(ctx, user)
+	if err != nil {
+		fmt.Printf("Failed to build actor for %s: %v\n", user.ID, err)
+		return
+	}
+	update := activitypub.Activity{
+		Context: activitypub.Context,
+		ID:      conf.actorURL(user.ID) + "#updates/" + uuid.NewString(),
+		Type:    "Update",
+		Actor:   conf.actorURL(user.ID),
+		Object:  rawJSON(actor),
+		To:      []string{activitypub.Public},
+	}
+	inboxes := make([]string, 0, len(followers))
+	for _, f := range followers {
+		inboxes = append(inboxes, f.SharedInbox)
+	}
+	if err := conf.deliver(ctx, user.ID, update, inboxes); err != nil {
+		fmt.Printf("Failed to queue profile update: %v\n", err)
+	}
+}
+
+// deliver queues activity for every distinct inbox and wakes the worker.
+func (conf *apiConfig) deliver(ctx context.Context, userID uuid.UUID, activity activitypub.Activity, inboxes []string) error {
+	body, err := json.Marshal(activity)
+	if err != nil {
+		return err
+	}
+	seen := map[string]bool{}
+	for _, inbox := range inboxes {
+		if inbox == "" || seen[inbox] {
+			continue
+		}
+		seen[inbox] = true
+		params := database.CreateDeliveryParams{UserID: userID, Inbox: inbox, Body: string(body)}
+		if err := conf.dbQueries.CreateDelivery(ctx, params); err != nil {
+			return err
+		}
+	}
+	select {
+	case conf.deliveryWake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// runDeliveryWorker sends queued activities. Failed deliveries are retried
+// with exponential backoff until activitypub.MaxAttempts, unless the
+// remote rejected them outright.
+func (conf *apiConfig) runDeliveryWorker() {
+	ticker := time.NewTicker(deliveryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-conf.deliveryWake:
+		}
+		conf.runDeliveries(context.Background())
+	}
+}
+
+func (conf *apiConfig) runDeliveries(ctx context.Context) {
+	due, err := conf.dbQueries.GetDueDeliveries(ctx, deliveryBatchSize)
+	if err != nil {
+		fmt.Printf("Failed to load deliveries: %v\n", err)
+		return
+	}
+	keys := map[uuid.UUID]*rsa.PrivateKey{}
+	for _, d := range due {
+		key, ok := keys[d.UserID]
+		if !ok {
+			stored, err := conf.actorKey(ctx, d.UserID)
+			if err == nil {
+				key, err = activitypub.ParsePrivateKey(stored.PrivateKey)
+			}
+			if err != nil {
+				fmt.Printf("Failed to load key for %s: %v\n", d.UserID, err)
+				continue
+			}
+			keys[d.UserID] = key
+		}
+
+		keyID := conf.actorURL(d.UserID) + "#main-key"
+		err := activitypub.Post(ctx, conf.federationClient, d.Inbox, []byte(d.Body), keyID, key)
+		if err == nil {
+			if err := conf.dbQueries.FinishDelivery(ctx, d.ID); err != nil {
+				fmt.Printf("Failed to finish delivery %s: %v\n", d.ID, err)
+			}
+			continue
+		}
+		attempt := int(d.Attempts) + 1
+		params := database.RetryDeliveryParams{
+			ID:            d.ID,
+			Status:        "pending",
+			LastError:     err.Error(),
+			NextAttemptAt: time.Now().Add(activitypub.Backoff(attempt)),
+		}
+		if attempt >= activitypub.MaxAttempts || activitypub.Permanent(err) {
+			params.Status = "failed"
+		}
+		if err := conf.dbQueries.RetryDelivery(ctx, params); err != nil {
+			fmt.Printf("Failed to reschedule delivery %s: %v\n", d.ID, err)
+		}
+	}
+}
+
+// actorKey returns userID's signing key, creating it on first use.
+func (conf *apiConfig) actorKey(ctx context.Context, userID uuid.UUID) (database.ActorKey, error) {
+	key, err := conf.dbQueries.GetActorKey(ctx, userID)
+	if !errors.Is(err, sql.ErrNoRows) {
+		return key, err
+	}
+	pub, priv, err := activitypub.GenerateKey()
+	if err != nil {
+		return key, err
+	}
+	params := database.CreateActorKeyParams{UserID: userID, PublicKey: pub, PrivateKey: priv}
+	if err := conf.dbQueries.CreateActorKey(ctx, params); err != nil {
+		return key, err
+	}
+	// Another request may have won the race; whichever key is stored wins
+	return conf.dbQueries.GetActorKey(ctx, userID)
+}
+
+func (conf *apiConfig) chirpNote(c database.Chirp) activitypub.Note {
+	note := activitypub.Note{
+		ID:           conf.noteURL(c.ID),
+		Type:         "Note",
+		AttributedTo: conf.actorURL(c.UserID.UUID),
+		Content:      strings.ReplaceAll(dbChirpToJSON(c).BodyHTML, `href="/`, `href="`+conf.baseURL+`/`),
+		Summary:      c.ContentWarning,
+		Sensitive:    c.Sensitive || c.ContentWarning != "",
+		URL:          conf.baseURL + "/c/" + c.ID.String(),
+		Published:    c.CreatedAt.UTC().Format(time.RFC3339),
+		To:           []string{activitypub.Public},
+		Cc:           []string{conf.actorURL(c.UserID.UUID) + "/followers"},
+	}
+	if c.UpdatedAt.After(c.CreatedAt) {
+		note.Updated = c.UpdatedAt.UTC().Format(time.RFC3339)
+	}
+	return note
+}
+
+func (conf *apiConfig) chirpActivity(typ string, c database.Chirp) (activitypub.Activity, error) {
+	var object any = conf.chirpNote(c)
+	if typ == "Delete" {
+		object = activitypub.Tombstone{ID: conf.noteURL(c.ID), Type: "Tombstone"}
+	}
+	raw, err := json.Marshal(object)
+	if err != nil {
+		return activitypub.Activity{}, err
+	}
+	return activitypub.Activity{
+		Context:   activitypub.Context,
+		ID:        conf.noteURL(c.ID) + "#" + strings.ToLower(typ),
+		Type:      typ,
+		Actor:     conf.actorURL(c.UserID.UUID),
+		Object:    raw,
+		Published: time.Now().UTC().Format(time.RFC3339),
+		To:        []string{activitypub.Public},
+		Cc:        []string{conf.actorURL(c.UserID.UUID) + "/followers"},
+	}, nil
+}
+
+// localID extracts the UUID from one of our own object URLs.
+func (conf *apiConfig) localID(objectURL, prefix string) (uuid.UUID, bool) {
+	rest, ok := strings.CutPrefix(objectURL, conf.baseURL+prefix)
+	if !ok {
+		return uuid.Nil, false
+	}
+	id, err := uuid.Parse(rest)
+	return id, err == nil
+}
+
+func (conf *apiConfig) federatedUser(w http.ResponseWriter, r *http.Request) (database.User, bool) {
+	if !conf.federating() {
+		respondWithError(w, 404, "Federation is disabled")
+		return database.User{}, false
+	}
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, 404, "User not found")
+		return database.User{}, false
+	}
+	user, err := conf.dbQueries.GetUserByID(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, 404, "User not found")
+		return database.User{}, false
+	}
+	return user, true
+}
+
+func respondWithActivity(w http.ResponseWriter, code int, contentType string, payload any) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		respondWithError(w, 500, "Failed to encode response")
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(code)
+	_, _ = w.Write(data)
+}
+
+func rawJSON(v any) json.RawMessage {
+	raw, _ := json.Marshal(v)
+	return raw
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/plusk0/webserver/internal/activitypub"
+	"github.com/plusk0/webserver/internal/database"
+	"github.com/plusk0/webserver/internal/realtime"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// peer is another server with a single actor, bob. Its inbox checks
+// signatures by fetching the signer, like any real server would.
+type peer struct {
+	t      *testing.T
+	srv    *httptest.Server
+	client *http.Client
+	key    *rsa.PrivateKey
+	pubPEM string
+
+	mu       sync.Mutex
+	received []activitypub.Activity
+}
+
+func newPeer(t *testing.T) *peer {
+	pubPEM, privPEM, err := activitypub.GenerateKey()
+	require.NoError(t, err)
+	key, err := activitypub.ParsePrivateKey(privPEM)
+	require.NoError(t, err)
+
+	p := &peer{t: t, client: activitypub.NewClient(true), key: key, pubPEM: pubPEM}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /users/bob", p.serveActor)
+	mux.HandleFunc("POST /users/bob/inbox", p.serveInbox)
+	p.srv = httptest.NewServer(mux)
+	t.Cleanup(p.srv.Close)
+	return p
+}
+
+func (p *peer) actorID() string { return p.srv.URL + "/users/bob" }
+
+func (p *peer) serveActor(w http.ResponseWriter, r *http.Request) {
+	respondWithActivity(w, 200, activitypub.ContentType, activitypub.Actor{
+		Context:           []string{activitypub.Context, activitypub.SecurityV1},
+		ID:                p.actorID(),
+		Type:              "Person",
+		PreferredUsername: "bob",
+		Inbox:             p.actorID() + "/inbox",
+		PublicKey:         activitypub.PublicKey{ID: p.actorID() + "#main-key", Owner: p.actorID(), PublicKeyPem: p.pubPEM},
+	})
+}
+
+func (p *peer) serveInbox(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	var activity activitypub.Activity
+	if err := json.Unmarshal(body, &activity); err != nil {
+		w.WriteHeader(400)
+		return
+	}
+	err := activitypub.VerifyActor(r, body, activity.Actor, func(bool) (string, error) {
+		signer, err := activitypub.FetchActor(r.Context(), p.client, activity.Actor)
+		if err != nil {
+			return "", err
+		}
+		return signer.PublicKey.PublicKeyPem, nil
+	})
+	if err != nil {
+		w.WriteHeader(401)
+		return
+	}
+	p.mu.Lock()
+	p.received = append(p.received, activity)
+	p.mu.Unlock()
+	w.WriteHeader(202)
+}
+
+// send signs activity as bob and posts it to inbox.
+func (p *peer) send(inbox string, activity activitypub.Activity) error {
+	activity.Context = activitypub.Context
+	activity.Actor = p.actorID()
+	body, err := json.Marshal(activity)
+	require.NoError(p.t, err)
+	return activitypub.Post(context.Background(), p.client, inbox, body, p.actorID()+"#main-key", p.key)
+}
+
+func (p *peer) lastReceived() activitypub.Activity {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	require.NotEmpty(p.t, p.received, "Nothing was delivered to the peer")
+	return p.received[len(p.received)-1]
+}
+
+// TestFederationWithPeer runs chirpy's federation routes against another
+// server: it finds alice, follows, likes and posts to her inbox, and gets
+// her Accept and chirps delivered back.
+func TestFederationWithPeer(t *testing.T) {
+	db := &fakeDB{}
+	now := time.Now().UTC()
+	alice := database.User{ID: uuid.New(), CreatedAt: now, UpdatedAt: now, Email: "alice@example.com", Handle: "alice"}
+	chirp := database.Chirp{
+		ID:        uuid.New(),
+		CreatedAt: now,
+		UpdatedAt: now,
+		Body:      "Hello fediverse",
+		UserID:    uuid.NullUUID{UUID: alice.ID, Valid: true},
+		BodyHtml:  "<p>Hello fediverse</p>",
+	}
+	db.users = append(db.users, alice)
+	db.chirps = append(db.chirps, chirp)
+
+	conf := &apiConfig{
+		dbQueries:        db.queries(),
+		federationClient: activitypub.NewClient(true),
+		deliveryWake:     make(chan struct{}, 1),
+		hub:              realtime.NewHub(10),
+	}
+	mux := http.NewServeMux()
+	mux.Handle("GET /.well-known/webfinger", http.HandlerFunc(conf.webfingerHandlerFunc))
+	mux.Handle("GET /ap/users/{userID}", http.HandlerFunc(conf.actorHandlerFunc))
+	mux.Handle("GET /ap/users/{userID}/outbox", http.HandlerFunc(conf.outboxHandlerFunc))
+	mux.Handle("POST /ap/users/{userID}/inbox", http.HandlerFunc(conf.inboxHandlerFunc))
+	mux.Handle("POST /ap/inbox", http.HandlerFunc(conf.inboxHandlerFunc))
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	conf.baseURL = srv.URL
+	bob := newPeer(t)
+	ctx := context.Background()
+
+	// Bob finds alice through WebFinger
+	host := strings.TrimPrefix(srv.URL, "http://")
+	resp, err := http.Get(srv.URL + "/.well-known/webfinger?resource=acct:alice@" + host)
+	require.NoError(t, err)
+	var finger activitypub.WebFinger
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&finger))
+	resp.Body.Close()
+	aliceID := conf.actorURL(alice.ID)
+	require.NotEmpty(t, finger.Links)
+	assert.Equal(t, aliceID, finger.Links[0].Href)
+
+	// Bob follows alice, who notices and accepts
+	follow := activitypub.Activity{ID: bob.actorID() + "#follows/1", Type: "Follow", Object: rawJSON(aliceID)}
+	require.NoError(t, bob.send(aliceID+"/inbox", follow))
+	assert.Len(t, db.followers, 1)
+	conf.runDeliveries(ctx)
+	accept := bob.lastReceived()
+	assert.Equal(t, "Accept", accept.Type)
+	assert.Equal(t, aliceID, accept.Actor)
+	assert.Equal(t, follow.ID, activitypub.ObjectID(accept.Object))
+
+	// Bob posts, then deletes, a note
+	note := activitypub.Note{ID: bob.actorID() + "/notes/1", Type: "Note", AttributedTo: bob.actorID(), Content: "<p>Hi alice</p>"}
+	require.NoError(t, bob.send(srv.URL+"/ap/inbox", activitypub.Activity{Type: "Create", Object: rawJSON(note)}))
+	require.Len(t, db.remoteNotes, 1)
+	assert.Equal(t, note.ID, db.remoteNotes[0].ID)
+
+	// Bob likes alice's chirp
+	like := activitypub.Activity{ID: bob.actorID() + "#likes/1", Type: "Like", Object: rawJSON(conf.noteURL(chirp.ID))}
+	require.NoError(t, bob.send(srv.URL+"/ap/inbox", like))
+	require.Len(t, db.remoteLikes, 1)
+	assert.Equal(t, chirp.ID, db.remoteLikes[0].ChirpID)
+
+	require.NoError(t, bob.send(srv.URL+"/ap/inbox", activitypub.Activity{Type: "Delete", Object: rawJSON(note.ID)}))
+	assert.Empty(t, db.remoteNotes)
+
+	// Alice hears about the follow and the like
+	var types []string
+	for _, n := range db.notifications {
+		assert.Equal(t, alice.ID, n.UserID)
+		assert.Equal(t, bob.actorID(), n.RemoteActorID.String)
+		types = append(types, n.Type)
+	}
+	assert.Equal(t, []string{notificationFollow, notificationLike}, types)
+
+	// Alice's outbox lists her chirp
+	resp, err = http.Get(aliceID + "/outbox")
+	require.NoError(t, err)
+	var outbox struct {
+		TotalItems   int                    `json:"totalItems"`
+		OrderedItems []activitypub.Activity `json:"orderedItems"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&outbox))
+	resp.Body.Close()
+	require.Equal(t, 1, outbox.TotalItems)
+	assert.Equal(t, conf.noteURL(chirp.ID), activitypub.ObjectID(outbox.OrderedItems[0].Object))
+
+	// Alice's chirp is delivered to her new follower
+	conf.federateChirp(ctx, "Create", chirp)
+	conf.runDeliveries(ctx)
+	create := bob.lastReceived()
+	assert.Equal(t, "Create", create.Type)
+	assert.Equal(t, conf.noteURL(chirp.ID), activitypub.ObjectID(create.Object))
+
+	for _, d := range db.deliveries {
+		assert.Equal(t, "delivered", d.Status, "Delivery to %s: %s", d.Inbox, d.LastError)
+	}
+	assert.Empty(t, db.unsupported)
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/plusk0/webserver/internal/database"
+)
+
+const feedChirpLimit = 50
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomEntry struct {
+	ID        string      `xml:"id"`
+	Title     string      `xml:"title"`
+	Published string      `xml:"published"`
+	Updated   string      `xml:"updated"`
+	Link      atomLink    `xml:"link"`
+	Author    *atomAuthor `xml:"author,omitempty"`
+	Content   atomContent `xml:"content"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+	URI  string `xml:"uri,omitempty"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	GUID        rssGUID `xml:"guid"`
+	Title       string  `xml:"title"`
+	Link        string  `xml:"link"`
+	Description string  `xml:"description"`
+	PubDate     string  `xml:"pubDate"`
+}
+
+type rssGUID struct {
+	IsPermaLink string `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+// feed is what both formats are built from.
+type feed struct {
+	title   string
+	link    string
+	self    string
+	updated time.Time
+	chirps  []database.Chirp
+	authors map[uuid.UUID]*ChirpAuthor
+}
+
+// The feed routes take the file name as a wildcard, {feed}, because
+// literal feed.atom and feed.rss segments would overlap other /api/users
+// routes and ServeMux refuses ambiguous patterns.
+func (conf *apiConfig) userFeedHandlerFunc(w http.ResponseWriter, r *http.Request) {
+	if !isFeedName(r.PathValue("feed")) {
+		respondWithError(w, 404, "Not found")
+		return
+	}
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, 404, "User not found")
+		return
+	}
+	user, err := conf.dbQueries.GetUserByID(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, 404, "User not found")
+		return
+	}
+	filter := chirpFilter{AuthorID: uuid.NullUUID{UUID: userID, Valid: true}}
+	f := feed{
+		title:   "Chirps from " + displayName(user) + " (@" + user.Handle + ")",
+		link:    "/u/" + userID.String(),
+		updated: user.UpdatedAt,
+	}
+	conf.serveFeed(w, r, filter, f)
+}
+
+func (conf *apiConfig) hashtagFeedHandlerFunc(w http.ResponseWriter, r *http.Request) {
+	if !isFeedName(r.PathValue("feed")) {
+		respondWithError(w, 404, "Not found")
+		return
+	}
+	tag := strings.TrimPrefix(r.PathValue("tag"), "#")
+	if tag == "" {
+		respondWithError(w, 404, "Hashtag not found")
+		return
+	}
+	filter := chirpFilter{Hashtag: tag}
+	f := feed{
+		title: "Chirps tagged #" + tag,
+		link:  "/api/chirps?hashtag=" + url.QueryEscape(tag),
+	}
+	conf.serveFeed(w, r, filter, f)
+}
+
+// serveFeed lists chirps the same way GET /api/chirps does, newest first,
+// and writes them as Atom or RSS depending on the requested file name.
+func (conf *apiConfig) serveFeed(w http.ResponseWriter, r *http.Request, filter chirpFilter, f feed) {
+	var err error
+	filter.HideSensitive, err = conf.hideSensitive(r)
+	if err != nil {
+		respondWithError(w, 400, "Invalid hide_sensitive value")
+		return
+	}
+	filter.Descending = true
+	filter.Limit = feedChirpLimit
+	f.chirps, err = conf.listChirps(r.Context(), filter)
+	if err != nil {
+		respondWithError(w, 500, "Failed to get Chirps")
+		return
+	}
+	for _, c := range f.chirps {
+		if c.UpdatedAt.After(f.updated) {
+			f.updated = c.UpdatedAt
+		}
+	}
+	withAuthors := mapSlice(f.chirps, dbChirpToJSON)
+	if err := conf.withAuthors(r.Context(), withAuthors); err != nil {
+		respondWithError(w, 500, "Failed to get authors")
+		return
+	}
+	f.authors = map[uuid.UUID]*ChirpAuthor{}
+	for _, c := range withAuthors {
+		if c.Author != nil {
+			f.authors[c.UserID] = c.Author
+		}
+	}
+	f.updated = f.updated.UTC().Truncate(time.Second)
+	f.link = conf.absoluteURL(r, f.link)
+	f.self = conf.absoluteURL(r, r.URL.Path)
+
+	var contentType string
+	var doc any
+	if r.PathValue("feed") == "feed.rss" {
+		contentType, doc = "application/rss+xml; charset=utf-8", conf.rss(r, f)
+	} else {
+		contentType, doc = "application/atom+xml; charset=utf-8", conf.atom(r, f)
+	}
+
+	etag := feedETag(contentType, f)
+	w.Header().Set("ETag", etag)
+	// A logged-in reader's settings decide which chirps are left out
+	w.Header().Set("Vary", "Authorization")
+	if !f.updated.IsZero() {
+		w.Header().Set("Last-Modified", f.updated.Format(http.TimeFormat))
+	}
+	if notModified(r, etag, f.updated) {
+		w.WriteHeader(304)
+		return
+	}
+
+	out, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		respondWithError(w, 500, "Failed to build feed")
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	cacheControl := "public"
+	if r.Header.Get("Authorization") != "" {
+		cacheControl = "private"
+	}
+	w.Header().Set("Cache-Control", fmt.Sprintf("%s, max-age=%d", cacheControl, pageMaxAge))
+	w.WriteHeader(200)
+	_, _ = w.Write([]byte(xml.Header))
+	_, _ = w.Write(out)
+}
+
+func (conf *apiConfig) atom(r *http.Request, f feed) atomFeed {
+	doc := atomFeed{
+		ID:      f.self,
+		Title:   f.title,
+		Updated: f.updated.Format(time.RFC3339),
+		Links: []atomLink{
+			{Href: f.link, Rel: "alternate", Type: "text/html"},
+			{Href: f.self, Rel: "self", Type: "application/atom+xml"},
+		},
+		Entries: []atomEntry{},
+	}
+	for _, c := range f.chirps {
+		entry := atomEntry{
+			ID:        chirpGUID(c),
+			Title:     chirpDescription(c),
+			Published: c.CreatedAt.UTC().Format(time.RFC3339),
+			Updated:   c.UpdatedAt.UTC().Format(time.RFC3339),
+			Link:      atomLink{Href: conf.absoluteURL(r, "/c/"+c.ID.String()), Rel: "alternate", Type: "text/html"},
+			Content:   atomContent{Type: "html", Body: dbChirpToJSON(c).BodyHTML},
+		}
+		if author := f.authors[c.UserID.UUID]; c.UserID.Valid && author != nil {
+			name := author.DisplayName
+			if name == "" {
+				name = "@" + author.Handle
+			}
+			entry.Author = &atomAuthor{Name: name, URI: conf.absoluteURL(r, "/u/"+author.ID.String())}
+		} else {
+			entry.Author = &atomAuthor{Name: "Deleted user"}
+		}
+		doc.Entries = append(doc.Entries, entry)
+	}
+	return doc
+}
+
+func (conf *apiConfig) rss(r *http.Request, f feed) rssFeed {
+	doc := rssFeed{
+		Version: "2.0",
+		Channel: rssChannel{
+			Title:       f.title,
+			Link:        f.link,
+			Description: f.title + " on Chirpy.",
+		},
+	}
+	if !f.updated.IsZero() {
+		doc.Channel.LastBuildDate = f.updated.Format(time.RFC1123Z)
+	}
+	for _, c := range f.chirps {
+		doc.Channel.Items = append(doc.Channel.Items, rssItem{
+			GUID:        rssGUID{IsPermaLink: "false", Value: chirpGUID(c)},
+			Title:       chirpDescription(c),
+			Link:        conf.absoluteURL(r, "/c/"+c.ID.String()),
+			Description: dbChirpToJSON(c).BodyHTML,
+			PubDate:     c.CreatedAt.UTC().Format(time.RFC1123Z),
+		})
+	}
+	return doc
+}
+
+func isFeedName(name string) bool {
+	return name == "feed.atom" || name == "feed.rss"
+}
+
+// chirpGUID only depends on the chirp's ID, so entries keep their identity
+// when the site moves to another host.
+func chirpGUID(c database.Chirp) string {
+	return "urn:uuid:" + c.ID.String()
+}
+
+// feedETag changes whenever an entry is added, removed or edited, or one
+// of its authors is renamed.
+func feedETag(contentType string, f feed) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n%d\n", contentType, f.self, f.updated.Unix())
+	for _, c := range f.chirps {
+		fmt.Fprintf(h, "%s %d\n", c.ID, c.UpdatedAt.UnixNano())
+		// Authors can rename themselves without touching their chirps
+		if a := f.authors[c.UserID.UUID]; a != nil {
+			fmt.Fprintf(h, "%s %s\n", a.Handle, a.DisplayName)
+		}
+	}
+	return `"` + hex.EncodeToString(h.Sum(nil))[:32] + `"`
+}
+
+// notModified follows RFC 9110: If-None-Match takes precedence, and
+// If-Modified-Since is only checked when it's absent.
+func notModified(r *http.Request, etag string, modified time.Time) bool {
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		for _, tag := range strings.Split(match, ",") {
+			tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+			if tag == etag || tag == "*" {
+				return true
+			}
+		}
+		return false
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	return err == nil && !modified.IsZero() && !modified.After(since)
+}
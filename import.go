@@ -0,0 +1,337 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/plusk0/webserver/internal/auth"
+	"github.com/plusk0/webserver/internal/database"
+	"github.com/plusk0/webserver/internal/richtext"
+)
+
+const (
+	importMaxBytes      = 10 << 20
+	importProgressEvery = 25
+)
+
+var importFormats = map[string]string{
+	"application/json": "json",
+	"text/csv":         "csv",
+	"application/zip":  "zip",
+}
+
+// importRow holds a row as uploaded; runImport validates it.
+type importRow struct {
+	row            int
+	createdAt      string
+	body           string
+	contentWarning string
+	sensitive      string
+}
+
+// importChirpsHandlerFunc accepts a JSON array or CSV file of chirps, or a
+// whole takeout archive, and queues it for import. The format comes from
+// the Content-Type header or a ?format= parameter.
+func (conf *apiConfig) importChirpsHandlerFunc(w http.ResponseWriter, r *http.Request) {
+	tk, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	userID, err := conf.validateToken(r.Context(), tk, "chirps:write")
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	if !conf.requireVerified(w, r, userID) {
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		format = importFormats[mediaType]
+	}
+	if format != "json" && format != "csv" && format != "zip" {
+		respondWithError(w, 415, "Upload must be JSON, CSV or a takeout zip")
+		return
+	}
+
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, importMaxBytes))
+	if err != nil {
+		respondWithError(w, 413, "Upload is too large")
+		return
+	}
+	defer r.Body.Close()
+
+	params := database.CreateImportJobParams{UserID: userID, Format: format}
+	job, err := conf.dbQueries.CreateImportJob(r.Context(), params)
+	if err != nil {
+		respondWithError(w, 500, "Failed to queue import")
+		return
+	}
+	if err := os.MkdirAll(conf.importDir, 0o700); err == nil {
+		err = os.WriteFile(conf.importPath(job.ID), data, 0o600)
+	}
+	if err != nil {
+		_ = conf.dbQueries.FinishImportJob(r.Context(), database.FinishImportJobParams{ID: job.ID, Status: "failed"})
+		respondWithError(w, 500, "Failed to queue import")
+		return
+	}
+	go func() { conf.importQueue <- job.ID }()
+	respondWithJSON(w, 202, dbImportJobToJSON(job, nil))
+}
+
+func (conf *apiConfig) importStatusHandlerFunc(w http.ResponseWriter, r *http.Request) {
+	tk, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	userID, err := conf.validateToken(r.Context(), tk, "chirps:write")
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	jobID, err := uuid.Parse(r.PathValue("jobID"))
+	if err != nil {
+		respondWithError(w, 404, "Import not found")
+		return
+	}
+	job, err := conf.dbQueries.GetImportJob(r.Context(), jobID)
+	if err != nil || job.UserID != userID {
+		respondWithError(w, 404, "Import not found")
+		return
+	}
+	rowErrors, err := conf.dbQueries.GetImportErrors(r.Context(), jobID)
+	if err != nil {
+		respondWithError(w, 500, "Failed to get import")
+		return
+	}
+	respondWithJSON(w, 200, dbImportJobToJSON(job, rowErrors))
+}
+
+func (conf *apiConfig) runImportWorker() {
+	jobs, err := conf.dbQueries.GetUnfinishedImportJobs(context.Background())
+	if err != nil {
+		fmt.Printf("Failed to load unfinished imports: %v\n", err)
+	}
+	go func() {
+		for _, job := range jobs {
+			conf.importQueue <- job.ID
+		}
+	}()
+	for jobID := range conf.importQueue {
+		conf.runImport(jobID)
+	}
+}
+
+// runImport creates a chirp for every valid row, keeping its original
+// timestamp. A row matching an existing chirp of the same author, time and
+// body is skipped, so re-running an import never duplicates anything.
+func (conf *apiConfig) runImport(jobID uuid.UUID) {
+	ctx := context.Background()
+	job, err := conf.dbQueries.GetImportJob(ctx, jobID)
+	if err != nil {
+		fmt.Printf("Failed to load import %s: %v\n", jobID, err)
+		return
+	}
+	finish := func(status string) {
+		params := database.FinishImportJobParams{ID: jobID, Status: status}
+		if err := conf.dbQueries.FinishImportJob(ctx, params); err != nil {
+			fmt.Printf("Failed to finish import %s: %v\n", jobID, err)
+		}
+		_ = os.Remove(conf.importPath(jobID))
+	}
+	rowError := func(row int, text string) {
+		params := database.CreateImportErrorParams{JobID: jobID, Row: int32(row), Message: text}
+		if err := conf.dbQueries.CreateImportError(ctx, params); err != nil {
+			fmt.Printf("Failed to record import error: %v\n", err)
+		}
+	}
+
+	data, err := os.ReadFile(conf.importPath(jobID))
+	if err != nil {
+		rowError(0, "Upload is missing")
+		finish("failed")
+		return
+	}
+	rows, err := parseImport(job.Format, data)
+	if err != nil {
+		rowError(0, err.Error())
+		finish("failed")
+		return
+	}
+	err = conf.dbQueries.StartImportJob(ctx, database.StartImportJobParams{ID: jobID, Total: int32(len(rows))})
+	if err != nil {
+		fmt.Printf("Failed to start import %s: %v\n", jobID, err)
+		return
+	}
+
+	author := uuid.NullUUID{UUID: job.UserID, Valid: true}
+	progress := database.UpdateImportJobProgressParams{ID: jobID}
+	for i, row := range rows {
+		progress.Processed++
+		createdAt, err := time.Parse(time.RFC3339, row.createdAt)
+		switch {
+		case err != nil:
+			rowError(row.row, "Invalid created_at timestamp")
+		case row.body == "":
+			rowError(row.row, "Chirp is empty")
+		case len(row.body) > chirpMaxRawLength || richtext.VisibleLength(row.body) > chirpMaxLength:
+			rowError(row.row, "Chirp is too long")
+		case len(row.contentWarning) > contentWarningMaxLength:
+			rowError(row.row, "Content warning is too long")
+		case row.sensitive != "" && !validBool(row.sensitive):
+			rowError(row.row, "Invalid sensitive flag")
+		default:
+			// Postgres keeps microseconds, so match what a previous run stored
+			createdAt = createdAt.UTC().Truncate(time.Microsecond)
+			body := cleanChirpBody(row.body)
+			exists, err := conf.dbQueries.ChirpExists(ctx, database.ChirpExistsParams{UserID: author, CreatedAt: createdAt, Body: body})
+			if err != nil {
+				rowError(row.row, "Failed to import chirp")
+				break
+			}
+			if exists {
+				progress.Skipped++
+				break
+			}
+			sensitive, _ := strconv.ParseBool(row.sensitive)
+			params := database.CreateChirpAtParams{
+				CreatedAt:      createdAt,
+				Body:           body,
+				UserID:         author,
+				ContentWarning: row.contentWarning,
+				Sensitive:      sensitive || row.contentWarning != "",
+				BodyHtml:       conf.renderChirpBody(ctx, body),
+			}
+			_, err = conf.dbQueries.CreateChirpAt(ctx, params)
+			if err != nil {
+				rowError(row.row, "Failed to import chirp")
+				break
+			}
+			progress.Imported++
+		}
+		if (i+1)%importProgressEvery == 0 || i == len(rows)-1 {
+			if err := conf.dbQueries.UpdateImportJobProgress(ctx, progress); err != nil {
+				fmt.Printf("Failed to update import %s: %v\n", jobID, err)
+			}
+		}
+	}
+	finish("done")
+}
+
+func parseImport(format string, data []byte) ([]importRow, error) {
+	switch format {
+	case "json":
+		var records []struct {
+			CreatedAt      string `json:"created_at"`
+			Body           string `json:"body"`
+			ContentWarning string `json:"content_warning"`
+			Sensitive      bool   `json:"sensitive"`
+		}
+		if err := json.Unmarshal(data, &records); err != nil {
+			return nil, errors.New("JSON upload must be an array of chirps")
+		}
+		rows := make([]importRow, 0, len(records))
+		for i, rec := range records {
+			rows = append(rows, importRow{
+				row:            i + 1,
+				createdAt:      rec.CreatedAt,
+				body:           rec.Body,
+				contentWarning: rec.ContentWarning,
+				sensitive:      strconv.FormatBool(rec.Sensitive),
+			})
+		}
+		return rows, nil
+
+	case "csv":
+		records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+		if err != nil || len(records) == 0 {
+			return nil, errors.New("CSV upload could not be parsed")
+		}
+		columns := map[string]int{}
+		for i, name := range records[0] {
+			columns[name] = i
+		}
+		createdAtCol, ok1 := columns["created_at"]
+		bodyCol, ok2 := columns["body"]
+		if !ok1 || !ok2 {
+			return nil, errors.New("CSV upload needs created_at and body columns")
+		}
+		// content_warning and sensitive are optional
+		cwCol, hasCW := columns["content_warning"]
+		sensitiveCol, hasSensitive := columns["sensitive"]
+		rows := make([]importRow, 0, len(records)-1)
+		for i, rec := range records[1:] {
+			row := importRow{row: i + 1, createdAt: rec[createdAtCol], body: rec[bodyCol]}
+			if hasCW {
+				row.contentWarning = rec[cwCol]
+			}
+			if hasSensitive {
+				row.sensitive = rec[sensitiveCol]
+			}
+			rows = append(rows, row)
+		}
+		return rows, nil
+
+	case "zip":
+		// A takeout archive; the chirps live in chirps.json
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return nil, errors.New("Zip upload could not be opened")
+		}
+		f, err := zr.Open("chirps.json")
+		if err != nil {
+			return nil, errors.New("Zip upload has no chirps.json")
+		}
+		defer f.Close()
+		inner, err := io.ReadAll(io.LimitReader(f, importMaxBytes))
+		if err != nil {
+			return nil, errors.New("Zip upload could not be read")
+		}
+		return parseImport("json", inner)
+	}
+	return nil, fmt.Errorf("unknown import format %q", format)
+}
+
+func validBool(s string) bool {
+	_, err := strconv.ParseBool(s)
+	return err == nil
+}
+
+func (conf *apiConfig) importPath(jobID uuid.UUID) string {
+	return filepath.Join(conf.importDir, jobID.String())
+}
+
+func dbImportJobToJSON(db database.ImportJob, rowErrors []database.ImportError) ImportJob {
+	job := ImportJob{
+		ID:        db.ID,
+		CreatedAt: db.CreatedAt,
+		Format:    db.Format,
+		Status:    db.Status,
+		Total:     db.Total,
+		Processed: db.Processed,
+		Imported:  db.Imported,
+		Skipped:   db.Skipped,
+		Errors:    []ImportRowError{},
+	}
+	for _, e := range rowErrors {
+		job.Errors = append(job.Errors, ImportRowError{e.Row, e.Message})
+	}
+	return job
+}
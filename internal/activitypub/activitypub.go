@@ -0,0 +1,149 @@
+// Package activitypub holds the protocol pieces Chirpy needs to federate:
+// ActivityStreams documents, actor keys, HTTP Signatures and a small client
+// for fetching actors and posting to remote inboxes.
+//
+// Storage and routing stay with the caller; nothing in here touches the
+// database.
+package activitypub
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+const (
+	ContentType = "application/activity+json"
+	Context     = "https://www.w3.org/ns/activitystreams"
+	Public      = "https://www.w3.org/ns/activitystreams#Public"
+	SecurityV1  = "https://w3id.org/security/v1"
+)
+
+type Actor struct {
+	Context           any        `json:"@context,omitempty"`
+	ID                string     `json:"id"`
+	Type              string     `json:"type"`
+	PreferredUsername string     `json:"preferredUsername"`
+	Name              string     `json:"name,omitempty"`
+	Summary           string     `json:"summary,omitempty"`
+	Icon              *Image     `json:"icon,omitempty"`
+	URL               string     `json:"url,omitempty"`
+	Inbox             string     `json:"inbox"`
+	Outbox            string     `json:"outbox,omitempty"`
+	Followers         string     `json:"followers,omitempty"`
+	Endpoints         *Endpoints `json:"endpoints,omitempty"`
+	PublicKey         PublicKey  `json:"publicKey"`
+}
+
+type Image struct {
+	Type string `json:"type"`
+	URL  string `json:"url"`
+}
+
+type Endpoints struct {
+	SharedInbox string `json:"sharedInbox,omitempty"`
+}
+
+type PublicKey struct {
+	ID           string `json:"id"`
+	Owner        string `json:"owner"`
+	PublicKeyPem string `json:"publicKeyPem"`
+}
+
+// DeliveryInbox is where activities for this actor should be sent,
+// preferring the shared inbox so one post reaches every follower on a
+// server.
+func (a *Actor) DeliveryInbox() string {
+	if a.Endpoints != nil && a.Endpoints.SharedInbox != "" {
+		return a.Endpoints.SharedInbox
+	}
+	return a.Inbox
+}
+
+// Activity keeps its object raw, since it may be a bare ID or a whole
+// embedded object depending on the activity and the sending server.
+type Activity struct {
+	Context   any             `json:"@context,omitempty"`
+	ID        string          `json:"id"`
+	Type      string          `json:"type"`
+	Actor     string          `json:"actor"`
+	Object    json.RawMessage `json:"object,omitempty"`
+	Published string          `json:"published,omitempty"`
+	To        []string        `json:"to,omitempty"`
+	Cc        []string        `json:"cc,omitempty"`
+}
+
+type Note struct {
+	Context      any      `json:"@context,omitempty"`
+	ID           string   `json:"id"`
+	Type         string   `json:"type"`
+	AttributedTo string   `json:"attributedTo"`
+	Content      string   `json:"content"`
+	Summary      string   `json:"summary,omitempty"`
+	Sensitive    bool     `json:"sensitive"`
+	InReplyTo    string   `json:"inReplyTo,omitempty"`
+	URL          string   `json:"url,omitempty"`
+	Published    string   `json:"published,omitempty"`
+	Updated      string   `json:"updated,omitempty"`
+	To           []string `json:"to,omitempty"`
+	Cc           []string `json:"cc,omitempty"`
+}
+
+type Tombstone struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+}
+
+type OrderedCollection struct {
+	Context      any    `json:"@context,omitempty"`
+	ID           string `json:"id"`
+	Type         string `json:"type"`
+	TotalItems   int    `json:"totalItems"`
+	OrderedItems any    `json:"orderedItems,omitempty"`
+}
+
+// WebFinger is a JRD document as served from /.well-known/webfinger.
+type WebFinger struct {
+	Subject string          `json:"subject"`
+	Aliases []string        `json:"aliases,omitempty"`
+	Links   []WebFingerLink `json:"links"`
+}
+
+type WebFingerLink struct {
+	Rel  string `json:"rel"`
+	Type string `json:"type,omitempty"`
+	Href string `json:"href"`
+}
+
+// ObjectID returns the ID of an activity's object, whether it was sent as
+// a plain string or embedded.
+func ObjectID(raw json.RawMessage) string {
+	var id string
+	if err := json.Unmarshal(raw, &id); err == nil {
+		return id
+	}
+	var obj struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(raw, &obj); err == nil {
+		return obj.ID
+	}
+	return ""
+}
+
+// ObjectType returns the type of an embedded object, or "" for a bare ID.
+func ObjectType(raw json.RawMessage) string {
+	var obj struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(raw, &obj); err != nil {
+		return ""
+	}
+	return obj.Type
+}
+
+// ActorID strips the fragment from a key ID, which is how servers usually
+// derive one from the other (https://host/users/alice#main-key).
+func ActorID(keyID string) string {
+	id, _, _ := strings.Cut(keyID, "#")
+	return id
+}
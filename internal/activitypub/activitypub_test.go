@@ -0,0 +1,290 @@
+package activitypub
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// instance is a minimal ActivityPub server with a single actor. Its inbox
+// checks deliveries with VerifyActor and talks to other instances through
+// NewClient, as the server's own inbox does, caching the keys it fetches.
+type instance struct {
+	t      *testing.T
+	srv    *httptest.Server
+	client *http.Client
+	key    *rsa.PrivateKey
+	pubPEM string
+
+	mu       sync.Mutex
+	received []Activity
+	failNext []int
+	keys     map[string]string
+	fetches  int
+}
+
+func newInstance(t *testing.T) *instance {
+	pubPEM, privPEM, err := GenerateKey()
+	require.NoError(t, err)
+	key, err := ParsePrivateKey(privPEM)
+	require.NoError(t, err)
+
+	in := &instance{t: t, client: NewClient(true), key: key, pubPEM: pubPEM, keys: map[string]string{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /users/alice", in.serveActor)
+	mux.HandleFunc("POST /users/alice/inbox", in.serveInbox)
+	in.srv = httptest.NewServer(mux)
+	t.Cleanup(in.srv.Close)
+	return in
+}
+
+func (in *instance) actorID() string { return in.srv.URL + "/users/alice" }
+func (in *instance) keyID() string   { return in.actorID() + "#main-key" }
+
+// rotateKey replaces the actor's key, as a server does after a leak.
+func (in *instance) rotateKey() {
+	pubPEM, privPEM, err := GenerateKey()
+	require.NoError(in.t, err)
+	key, err := ParsePrivateKey(privPEM)
+	require.NoError(in.t, err)
+	in.mu.Lock()
+	in.key, in.pubPEM = key, pubPEM
+	in.mu.Unlock()
+}
+
+func (in *instance) serveActor(w http.ResponseWriter, r *http.Request) {
+	in.mu.Lock()
+	pubPEM := in.pubPEM
+	in.mu.Unlock()
+	actor := Actor{
+		Context:           []string{Context, SecurityV1},
+		ID:                in.actorID(),
+		Type:              "Person",
+		PreferredUsername: "alice",
+		Inbox:             in.actorID() + "/inbox",
+		PublicKey:         PublicKey{ID: in.keyID(), Owner: in.actorID(), PublicKeyPem: pubPEM},
+	}
+	w.Header().Set("Content-Type", ContentType)
+	_ = json.NewEncoder(w).Encode(actor)
+}
+
+func (in *instance) serveInbox(w http.ResponseWriter, r *http.Request) {
+	in.mu.Lock()
+	if len(in.failNext) > 0 {
+		code := in.failNext[0]
+		in.failNext = in.failNext[1:]
+		in.mu.Unlock()
+		w.WriteHeader(code)
+		return
+	}
+	in.mu.Unlock()
+
+	body, _ := io.ReadAll(r.Body)
+	var activity Activity
+	if err := json.Unmarshal(body, &activity); err != nil || activity.Actor == "" {
+		w.WriteHeader(400)
+		return
+	}
+	err := VerifyActor(r, body, activity.Actor, func(fresh bool) (string, error) {
+		in.mu.Lock()
+		pem, ok := in.keys[activity.Actor]
+		in.mu.Unlock()
+		if ok && !fresh {
+			return pem, nil
+		}
+		signer, err := FetchActor(r.Context(), in.client, activity.Actor)
+		if err != nil {
+			return "", err
+		}
+		in.mu.Lock()
+		in.keys[activity.Actor] = signer.PublicKey.PublicKeyPem
+		in.fetches++
+		in.mu.Unlock()
+		return signer.PublicKey.PublicKeyPem, nil
+	})
+	if err != nil {
+		w.WriteHeader(401)
+		return
+	}
+	in.mu.Lock()
+	in.received = append(in.received, activity)
+	in.mu.Unlock()
+	w.WriteHeader(202)
+}
+
+func (in *instance) post(to *instance, a Activity) error {
+	a.Context = Context
+	a.Actor = in.actorID()
+	body, err := json.Marshal(a)
+	require.NoError(in.t, err)
+	in.mu.Lock()
+	key := in.key
+	in.mu.Unlock()
+	return Post(context.Background(), in.client, to.actorID()+"/inbox", body, in.keyID(), key)
+}
+
+func (in *instance) types() []string {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	var out []string
+	for _, a := range in.received {
+		out = append(out, a.Type)
+	}
+	return out
+}
+
+func TestFederationFlow(t *testing.T) {
+	local, peer := newInstance(t), newInstance(t)
+
+	// The peer follows us and we accept
+	follow := Activity{ID: peer.actorID() + "/follows/1", Type: "Follow", Object: json.RawMessage(`"` + local.actorID() + `"`)}
+	require.NoError(t, peer.post(local, follow))
+	followJSON, _ := json.Marshal(follow)
+	require.NoError(t, local.post(peer, Activity{ID: local.actorID() + "/accepts/1", Type: "Accept", Object: followJSON}))
+
+	// We publish a note, the peer likes it, then we delete it
+	note, _ := json.Marshal(Note{ID: local.srv.URL + "/notes/1", Type: "Note", AttributedTo: local.actorID(), Content: "<p>hi</p>", To: []string{Public}})
+	require.NoError(t, local.post(peer, Activity{ID: local.srv.URL + "/notes/1/activity", Type: "Create", Object: note}))
+	require.NoError(t, peer.post(local, Activity{ID: peer.actorID() + "/likes/1", Type: "Like", Object: json.RawMessage(`"` + local.srv.URL + `/notes/1"`)}))
+	tomb, _ := json.Marshal(Tombstone{ID: local.srv.URL + "/notes/1", Type: "Tombstone"})
+	require.NoError(t, local.post(peer, Activity{ID: local.srv.URL + "/notes/1#delete", Type: "Delete", Object: tomb}))
+
+	assert.Equal(t, []string{"Follow", "Like"}, local.types())
+	assert.Equal(t, []string{"Accept", "Create", "Delete"}, peer.types())
+
+	peer.mu.Lock()
+	defer peer.mu.Unlock()
+	created := peer.received[1]
+	assert.Equal(t, local.srv.URL+"/notes/1", ObjectID(created.Object))
+	assert.Equal(t, "Note", ObjectType(created.Object))
+	assert.Equal(t, follow.ID, ObjectID(peer.received[0].Object))
+}
+
+func TestInboxRejectsForgeries(t *testing.T) {
+	local, peer, mallory := newInstance(t), newInstance(t), newInstance(t)
+	inbox := local.actorID() + "/inbox"
+
+	// Signed by mallory but claiming to come from the peer
+	body, _ := json.Marshal(Activity{ID: "x", Type: "Follow", Actor: peer.actorID()})
+	err := Post(context.Background(), mallory.client, inbox, body, mallory.keyID(), mallory.key)
+	assert.Equal(t, 401, err.(*StatusError).Code)
+
+	// mallory's signature presented under the peer's key ID
+	err = Post(context.Background(), mallory.client, inbox, body, peer.keyID(), mallory.key)
+	assert.Equal(t, 401, err.(*StatusError).Code)
+
+	// Body swapped after signing
+	req, _ := http.NewRequest("POST", inbox, strings.NewReader(`{"type":"Delete","actor":"`+peer.actorID()+`"}`))
+	require.NoError(t, Sign(req, body, peer.keyID(), peer.key))
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, 401, resp.StatusCode)
+
+	// Replayed long after it was signed
+	req, _ = http.NewRequest("POST", inbox, strings.NewReader(string(body)))
+	req.Header.Set("Date", time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat))
+	require.NoError(t, Sign(req, body, peer.keyID(), peer.key))
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, 401, resp.StatusCode)
+
+	assert.Empty(t, local.types())
+}
+
+func TestInboxRefetchesRotatedKeys(t *testing.T) {
+	local, peer := newInstance(t), newInstance(t)
+
+	require.NoError(t, peer.post(local, Activity{ID: "a1", Type: "Create"}))
+	require.NoError(t, peer.post(local, Activity{ID: "a2", Type: "Create"}))
+	assert.Equal(t, 1, local.fetches, "A known actor's key should come from the cache")
+
+	peer.rotateKey()
+	require.NoError(t, peer.post(local, Activity{ID: "a3", Type: "Create"}))
+	assert.Equal(t, 2, local.fetches, "A signature the cached key rejects should refetch the actor")
+	assert.Equal(t, []string{"Create", "Create", "Create"}, local.types())
+}
+
+func TestDeliveryRetries(t *testing.T) {
+	local, peer := newInstance(t), newInstance(t)
+	peer.failNext = []int{503, 410}
+
+	err := local.post(peer, Activity{ID: "a1", Type: "Create"})
+	require.Error(t, err)
+	assert.False(t, Permanent(err))
+
+	err = local.post(peer, Activity{ID: "a1", Type: "Create"})
+	require.Error(t, err)
+	assert.True(t, Permanent(err))
+
+	require.NoError(t, local.post(peer, Activity{ID: "a1", Type: "Create"}))
+	assert.Equal(t, []string{"Create"}, peer.types())
+}
+
+func TestBackoff(t *testing.T) {
+	assert.Equal(t, 30*time.Second, Backoff(1))
+	assert.Equal(t, time.Minute, Backoff(2))
+	assert.Equal(t, 8*time.Minute, Backoff(5))
+	assert.Equal(t, 6*time.Hour, Backoff(20))
+}
+
+func TestObjectID(t *testing.T) {
+	assert.Equal(t, "https://a/1", ObjectID(json.RawMessage(`"https://a/1"`)))
+	assert.Equal(t, "https://a/1", ObjectID(json.RawMessage(`{"id":"https://a/1","type":"Note"}`)))
+	assert.Equal(t, "", ObjectType(json.RawMessage(`"https://a/1"`)))
+	assert.Equal(t, "https://a/users/x", ActorID("https://a/users/x#main-key"))
+}
+
+func TestClientRefusesInternalAddresses(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	t.Cleanup(srv.Close)
+
+	// Loopback is refused before the TLS handshake even starts
+	_, err := FetchActor(context.Background(), NewClient(false), srv.URL+"/users/alice")
+	assert.ErrorIs(t, err, ErrBlockedAddress)
+
+	// Plain http is refused without connecting anywhere
+	_, err = FetchActor(context.Background(), NewClient(false), "http://example.com/users/alice")
+	assert.ErrorContains(t, err, "only https")
+
+	for addr, public := range map[string]bool{
+		"93.184.216.34":   true,
+		"2606:4700::1":    true,
+		"10.1.2.3":        false,
+		"172.16.0.1":      false,
+		"192.168.1.1":     false,
+		"169.254.169.254": false,
+		"100.64.0.1":      false,
+		"0.0.0.0":         false,
+		"::1":             false,
+		"fe80::1":         false,
+		"fd00::1":         false,
+	} {
+		assert.Equal(t, public, publicAddr(netip.MustParseAddr(addr), false), addr)
+	}
+}
+
+func TestClientCapsRedirects(t *testing.T) {
+	var hops int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hops++
+		http.Redirect(w, r, "/again", http.StatusFound)
+	}))
+	t.Cleanup(srv.Close)
+
+	_, err := FetchActor(context.Background(), NewClient(true), srv.URL+"/users/alice")
+	assert.ErrorContains(t, err, "redirects")
+	assert.Equal(t, maxRedirects, hops)
+}
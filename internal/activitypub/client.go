@@ -0,0 +1,103 @@
+package activitypub
+
+import (
+	"bytes"
+	"context"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	// MaxAttempts is how often a delivery is tried before giving up.
+	MaxAttempts = 8
+
+	maxDocumentBytes = 1 << 20
+	firstRetry       = 30 * time.Second
+	maxRetry         = 6 * time.Hour
+)
+
+// StatusError is returned when a remote server answers with a non-2xx
+// status.
+type StatusError struct {
+	URL  string
+	Code int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s answered %d", e.URL, e.Code)
+}
+
+// Permanent reports whether retrying a failed delivery is pointless: the
+// remote rejected the request itself, rather than being down or busy.
+func Permanent(err error) bool {
+	se, ok := err.(*StatusError)
+	if !ok {
+		return false
+	}
+	return se.Code >= 400 && se.Code < 500 && se.Code != 408 && se.Code != 429
+}
+
+// Backoff is the wait before the next try after attempt failed: 30s,
+// doubling each time, capped at six hours.
+func Backoff(attempt int) time.Duration {
+	d := firstRetry
+	for i := 1; i < attempt && d < maxRetry; i++ {
+		d *= 2
+	}
+	return min(d, maxRetry)
+}
+
+// FetchActor GETs an actor document.
+func FetchActor(ctx context.Context, client *http.Client, id string) (*Actor, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", id, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", ContentType)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, &StatusError{id, resp.StatusCode}
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxDocumentBytes))
+	if err != nil {
+		return nil, err
+	}
+	var actor Actor
+	if err := json.Unmarshal(data, &actor); err != nil {
+		return nil, err
+	}
+	if actor.ID != id {
+		return nil, fmt.Errorf("actor document %s claims to be %s", id, actor.ID)
+	}
+	return &actor, nil
+}
+
+// Post delivers a signed activity to inbox.
+func Post(ctx context.Context, client *http.Client, inbox string, body []byte, keyID string, key *rsa.PrivateKey) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", inbox, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", ContentType)
+	if err := Sign(req, body, keyID, key); err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, maxDocumentBytes))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &StatusError{inbox, resp.StatusCode}
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package activitypub
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// MaxClockSkew is how far a signed request's Date may be from our clock.
+// It also bounds how long a captured request can be replayed.
+const MaxClockSkew = 5 * time.Minute
+
+var (
+	ErrNoSignature  = errors.New("request is not signed")
+	ErrBadSignature = errors.New("signature does not verify")
+)
+
+// Sign adds Date, Digest and Signature headers to r following the
+// draft-cavage HTTP Signatures scheme ActivityPub servers use. A Date
+// already set on r is kept. body must be what r will send, or nil for GET.
+func Sign(r *http.Request, body []byte, keyID string, key *rsa.PrivateKey) error {
+	if r.Header.Get("Date") == "" {
+		r.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	}
+	headers := []string{"(request-target)", "host", "date"}
+	if body != nil {
+		r.Header.Set("Digest", digest(body))
+		headers = append(headers, "digest")
+	}
+
+	hashed := sha256.Sum256([]byte(signingString(r, headers)))
+	sig, err := rsa.SignPKCS1v15(nil, key, crypto.SHA256, hashed[:])
+	if err != nil {
+		return err
+	}
+	r.Header.Set("Signature", fmt.Sprintf(`keyId="%s",algorithm="rsa-sha256",headers="%s",signature="%s"`,
+		keyID, strings.Join(headers, " "), base64.StdEncoding.EncodeToString(sig)))
+	return nil
+}
+
+// Verify checks r's signature with the key lookup returns for its keyId,
+// and returns that keyId. The signature must cover the request target,
+// host and date, plus the body digest whenever there is a body.
+func Verify(r *http.Request, body []byte, lookup func(keyID string) (*rsa.PublicKey, error)) (string, error) {
+	header := r.Header.Get("Signature")
+	if header == "" {
+		return "", ErrNoSignature
+	}
+	params := parseSignature(header)
+	keyID, sigB64 := params["keyId"], params["signature"]
+	if keyID == "" || sigB64 == "" {
+		return "", ErrBadSignature
+	}
+	if alg := params["algorithm"]; alg != "" && alg != "rsa-sha256" && alg != "hs2019" {
+		return keyID, fmt.Errorf("unsupported signature algorithm %q", alg)
+	}
+	headers := strings.Fields(params["headers"])
+	if len(headers) == 0 {
+		headers = []string{"date"}
+	}
+	required := []string{"(request-target)", "host", "date"}
+	if len(body) > 0 {
+		required = append(required, "digest")
+	}
+	for _, h := range required {
+		if !contains(headers, h) {
+			return keyID, fmt.Errorf("signature does not cover %s", h)
+		}
+	}
+
+	date, err := http.ParseTime(r.Header.Get("Date"))
+	if err != nil {
+		return keyID, errors.New("invalid Date header")
+	}
+	if skew := time.Since(date); skew > MaxClockSkew || skew < -MaxClockSkew {
+		return keyID, errors.New("Date header is too far from now")
+	}
+	if len(body) > 0 && r.Header.Get("Digest") != digest(body) {
+		return keyID, errors.New("body does not match Digest")
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(sigB64)
+	if err != nil {
+		return keyID, ErrBadSignature
+	}
+	key, err := lookup(keyID)
+	if err != nil {
+		return keyID, err
+	}
+	hashed := sha256.Sum256([]byte(signingString(r, headers)))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, hashed[:], sig); err != nil {
+		return keyID, ErrBadSignature
+	}
+	return keyID, nil
+}
+
+func signingString(r *http.Request, headers []string) string {
+	lines := make([]string, 0, len(headers))
+	for _, h := range headers {
+		var value string
+		switch h {
+		case "(request-target)":
+			value = strings.ToLower(r.Method) + " " + r.URL.RequestURI()
+		case "host":
+			value = r.Host
+			if value == "" {
+				value = r.URL.Host
+			}
+		default:
+			value = strings.Join(r.Header.Values(h), ", ")
+		}
+		lines = append(lines, h+": "+value)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func parseSignature(header string) map[string]string {
+	params := map[string]string{}
+	for _, part := range strings.Split(header, ",") {
+		k, v, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if ok {
+			params[k] = strings.Trim(v, `"`)
+		}
+	}
+	return params
+}
+
+func digest(body []byte) string {
+	sum := sha256.Sum256(body)
+	return "SHA-256=" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// VerifyActor checks that r is signed by a key of actorID. publicKey
+// returns the actor's key as PEM, from a cache unless fresh is set. The
+// cached key is tried first, then a fresh one in case the actor rotated
+// its key since.
+func VerifyActor(r *http.Request, body []byte, actorID string, publicKey func(fresh bool) (string, error)) error {
+	lookup := func(fresh bool) func(string) (*rsa.PublicKey, error) {
+		return func(keyID string) (*rsa.PublicKey, error) {
+			if ActorID(keyID) != actorID {
+				return nil, errors.New("key does not belong to actor")
+			}
+			pem, err := publicKey(fresh)
+			if err != nil {
+				return nil, err
+			}
+			return ParsePublicKey(pem)
+		}
+	}
+	_, err := Verify(r, body, lookup(false))
+	if err != nil && !errors.Is(err, ErrNoSignature) {
+		_, err = Verify(r, body, lookup(true))
+	}
+	return err
+}
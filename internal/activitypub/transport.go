@@ -0,0 +1,116 @@
+package activitypub
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"syscall"
+	"time"
+)
+
+const (
+	dialTimeout  = 5 * time.Second
+	fetchTimeout = 10 * time.Second
+	maxRedirects = 3
+)
+
+// ErrBlockedAddress is returned for a remote that resolves to an address
+// other servers have no business reaching, such as our own network.
+var ErrBlockedAddress = errors.New("address is not publicly routable")
+
+// Ranges IsPrivate and friends don't cover: carrier-grade NAT, "this
+// network", IETF protocol assignments and benchmarking.
+var reservedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+}
+
+// NewClient returns the HTTP client for talking to other servers. Actor
+// IDs and inboxes come from remote documents, so the client only speaks
+// https and refuses to connect to private, loopback and link-local
+// addresses. The check runs on the address actually dialed, which covers
+// redirects and DNS answers that change between lookups.
+//
+// allowLoopback lets tests and local development federate with servers on
+// localhost, over plain http as well.
+func NewClient(allowLoopback bool) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: dialTimeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip, err := netip.ParseAddr(host)
+			if err != nil {
+				return err
+			}
+			if !publicAddr(ip.Unmap(), allowLoopback) {
+				return fmt.Errorf("%s: %w", host, ErrBlockedAddress)
+			}
+			return nil
+		},
+	}
+	transport := &http.Transport{
+		// A proxy would do the dialing for us and skip the check above
+		Proxy:               nil,
+		DialContext:         dialer.DialContext,
+		ForceAttemptHTTP2:   true,
+		MaxIdleConns:        100,
+		IdleConnTimeout:     90 * time.Second,
+		TLSHandshakeTimeout: dialTimeout,
+	}
+	return &http.Client{
+		Timeout:   fetchTimeout,
+		Transport: &schemeGuard{next: transport, allowLoopback: allowLoopback},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxRedirects)
+			}
+			return nil
+		},
+	}
+}
+
+// schemeGuard refuses anything but https, including on redirects.
+type schemeGuard struct {
+	next          http.RoundTripper
+	allowLoopback bool
+}
+
+func (g *schemeGuard) RoundTrip(req *http.Request) (*http.Response, error) {
+	switch {
+	case req.URL.Scheme == "https":
+	case req.URL.Scheme == "http" && g.allowLoopback && loopbackHost(req.URL.Hostname()):
+	default:
+		return nil, fmt.Errorf("refusing %s: only https is allowed", req.URL.Redacted())
+	}
+	return g.next.RoundTrip(req)
+}
+
+func publicAddr(ip netip.Addr, allowLoopback bool) bool {
+	if ip.IsLoopback() {
+		return allowLoopback
+	}
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() {
+		return false
+	}
+	for _, p := range reservedPrefixes {
+		if p.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+func loopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip, err := netip.ParseAddr(host)
+	return err == nil && ip.IsLoopback()
+}
@@ -0,0 +1,84 @@
+// Package analytics batches chirp impressions in memory so serving a chirp
+// doesn't cost a database write.
+package analytics
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Key struct {
+	ChirpID uuid.UUID
+	Day     time.Time
+}
+
+type Counter struct {
+	mu     sync.Mutex
+	counts map[Key]int64
+	now    func() time.Time
+}
+
+func NewCounter() *Counter {
+	return &Counter{counts: make(map[Key]int64), now: time.Now}
+}
+
+// Record counts one view of every chirp in ids, bucketed by UTC day.
+func (c *Counter) Record(ids ...uuid.UUID) {
+	day := c.now().UTC().Truncate(24 * time.Hour)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, id := range ids {
+		c.counts[Key{id, day}]++
+	}
+}
+
+// Pending returns views of chirpID that haven't been flushed yet.
+func (c *Counter) Pending(chirpID uuid.UUID) map[time.Time]int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := map[time.Time]int64{}
+	for k, n := range c.counts {
+		if k.ChirpID == chirpID {
+			out[k.Day] += n
+		}
+	}
+	return out
+}
+
+// Days totals counts by calendar date. Days from Pending are in UTC while
+// lib/pq hands dates back in its own zero-offset zone, so the same day can
+// be two different time.Time map keys.
+type Days map[string]int64
+
+// Add counts n towards the UTC date of day.
+func (d Days) Add(day time.Time, n int64) {
+	d[day.UTC().Format(time.DateOnly)] += n
+}
+
+// Flush hands every pending count to write and forgets it. Counts write
+// fails on are kept for the next flush.
+func (c *Counter) Flush(write func(Key, int64) error) {
+	c.mu.Lock()
+	batch := c.counts
+	c.counts = make(map[Key]int64)
+	c.mu.Unlock()
+
+	for k, n := range batch {
+		if err := write(k, n); err != nil {
+			c.mu.Lock()
+			c.counts[k] += n
+			c.mu.Unlock()
+		}
+	}
+}
+
+// Run flushes every interval, forever.
+func (c *Counter) Run(interval time.Duration, write func(Key, int64) error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		c.Flush(write)
+	}
+}
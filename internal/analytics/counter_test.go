@@ -0,0 +1,66 @@
+package analytics
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCounterFlush(t *testing.T) {
+	c := NewCounter()
+	now := time.Date(2024, 5, 1, 23, 59, 0, 0, time.UTC)
+	c.now = func() time.Time { return now }
+
+	a, b := uuid.New(), uuid.New()
+	c.Record(a, b)
+	c.Record(a)
+	now = now.Add(2 * time.Minute)
+	c.Record(a)
+
+	day1 := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	day2 := time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC)
+	assert.Equal(t, map[time.Time]int64{day1: 2, day2: 1}, c.Pending(a))
+
+	written := map[Key]int64{}
+	c.Flush(func(k Key, n int64) error {
+		written[k] = n
+		return nil
+	})
+	assert.Equal(t, map[Key]int64{{a, day1}: 2, {a, day2}: 1, {b, day1}: 1}, written)
+	assert.Empty(t, c.Pending(a), "Flushed views should no longer be pending")
+}
+
+func TestCounterFlushFailureKeepsCounts(t *testing.T) {
+	c := NewCounter()
+	id := uuid.New()
+	c.Record(id, id)
+
+	c.Flush(func(Key, int64) error { return errors.New("db down") })
+	c.Record(id)
+
+	var total int64
+	for _, n := range c.Pending(id) {
+		total += n
+	}
+	assert.Equal(t, int64(3), total, "Failed writes should be retried on the next flush")
+}
+
+func TestDaysMergesPendingAndStored(t *testing.T) {
+	c := NewCounter()
+	c.now = func() time.Time { return time.Date(2024, 5, 1, 13, 0, 0, 0, time.UTC) }
+	id := uuid.New()
+	c.Record(id, id)
+
+	// What lib/pq returns for the same day read back from a date column
+	stored := time.Date(2024, 5, 1, 0, 0, 0, 0, time.FixedZone("", 0))
+
+	days := Days{}
+	for day, n := range c.Pending(id) {
+		days.Add(day, n)
+	}
+	days.Add(stored, 5)
+	assert.Equal(t, Days{"2024-05-01": 7}, days)
+}
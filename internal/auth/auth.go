@@ -2,6 +2,7 @@ package auth
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"errors"
 	"net/http"
@@ -13,7 +14,12 @@ import (
 	"github.com/google/uuid"
 )
 
+var ErrEmptyPassword = errors.New("password is empty")
+
 func HashPassword(password string) (string, error) {
+	if password == "" {
+		return "", ErrEmptyPassword
+	}
 	hash, err := argon2id.CreateHash(password, argon2id.DefaultParams)
 	if err != nil {
 		return "", err
@@ -33,6 +39,12 @@ func CheckPasswordHash(password, hash string) (bool, error) {
 }
 
 func MakeRefreshToken() (string, error) {
+	return MakeToken()
+}
+
+// MakeToken returns 256 random bits as hex, for tokens handed out in links
+// and emails.
+func MakeToken() (string, error) {
 	bytes := make([]byte, 32)
 	_, err := rand.Read(bytes)
 	if err != nil {
@@ -41,46 +53,110 @@ func MakeRefreshToken() (string, error) {
 	return hex.EncodeToString(bytes), nil
 }
 
-func MakeJWT(userID uuid.UUID, tokenSecret string) (string, error) {
-	claims := &jwt.RegisteredClaims{
-		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
-		IssuedAt:  jwt.NewNumericDate(time.Now()),
-		Issuer:    "chirpy",
-		Subject:   userID.String(),
-	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	signedToken, err := token.SignedString([]byte(tokenSecret))
+// PersonalAccessTokenPrefix starts every personal access token, so they
+// can be told apart from JWTs and spotted by secret scanners.
+const PersonalAccessTokenPrefix = "chirpy_pat_"
+
+func MakePersonalAccessToken() (string, error) {
+	token, err := MakeToken()
 	if err != nil {
 		return "", err
 	}
-	return signedToken, nil
+	return PersonalAccessTokenPrefix + token, nil
+}
+
+// HashToken is how single-use tokens are stored, so a leaked database
+// doesn't leak usable links.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
 }
 
-func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
-	claims := &jwt.RegisteredClaims{}
-
-	token, err := jwt.ParseWithClaims(
-		tokenString,
-		claims,
-		func(token *jwt.Token) (any, error) {
-			// Validate the signing method, apparently best practice
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, jwt.ErrSignatureInvalid
-			}
-			return []byte(tokenSecret), nil
+// AccessTokenTTL is how long a login's access tokens last.
+const AccessTokenTTL = time.Hour
+
+func MakeJWT(userID uuid.UUID, keys *Keyring) (string, error) {
+	return MakeSessionJWT(userID, uuid.Nil, keys)
+}
+
+// MakeSessionJWT issues an access token for a login session, so requests
+// can tell which of the user's sessions they come from.
+func MakeSessionJWT(userID, sessionID uuid.UUID, keys *Keyring) (string, error) {
+	claims := &accessClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(AccessTokenTTL)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			Issuer:    "chirpy",
+			Subject:   userID.String(),
 		},
-	)
+	}
+	if sessionID != uuid.Nil {
+		claims.SessionID = sessionID.String()
+	}
+	return keys.signToken(userID, claims)
+}
+
+func ValidateJWT(tokenString string, keys *Keyring) (uuid.UUID, error) {
+	userID, _, err := ValidateSessionJWT(tokenString, keys)
+	return userID, err
+}
+
+// ValidateSessionJWT is ValidateJWT that also returns the session the
+// token was issued for, or uuid.Nil for tokens that don't name one.
+func ValidateSessionJWT(tokenString string, keys *Keyring) (userID, sessionID uuid.UUID, err error) {
+	token, err := ParseAccessToken(tokenString, keys)
 	if err != nil {
-		return uuid.Nil, err
+		return uuid.Nil, uuid.Nil, err
 	}
-	if !token.Valid {
-		return uuid.Nil, jwt.ErrInvalidKey
+	return token.UserID, token.SessionID, nil
+}
+
+// AccessToken is what a first-party access token says about itself.
+type AccessToken struct {
+	ID        string
+	UserID    uuid.UUID
+	SessionID uuid.UUID
+	ExpiresAt time.Time
+}
+
+// ParseAccessToken validates a first-party access token like ValidateJWT,
+// returning the details needed to revoke it.
+func ParseAccessToken(tokenString string, keys *Keyring) (AccessToken, error) {
+	claims, err := parseJWT(tokenString, keys)
+	if err != nil {
+		return AccessToken{}, err
+	}
+	// Tokens issued to third-party apps only work where a scope allows
+	if claims.ClientID != "" {
+		return AccessToken{}, ErrInsufficientScope
 	}
-	userID, err := uuid.Parse(claims.Subject)
+	token := AccessToken{ID: claims.ID, ExpiresAt: claims.ExpiresAt.Time}
+	if token.UserID, err = uuid.Parse(claims.Subject); err != nil {
+		return AccessToken{}, err
+	}
+	if claims.SessionID == "" {
+		return token, nil
+	}
+	if token.SessionID, err = uuid.Parse(claims.SessionID); err != nil {
+		return AccessToken{}, err
+	}
+	return token, nil
+}
+
+func parseJWT(tokenString string, keys *Keyring) (*accessClaims, error) {
+	claims := &accessClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, keys.keyFunc, jwt.WithExpirationRequired())
 	if err != nil {
-		return uuid.Nil, err
+		return nil, err
+	}
+	if !token.Valid {
+		return nil, jwt.ErrInvalidKey
+	}
+	if err := keys.checkRevoked(claims); err != nil {
+		return nil, err
 	}
-	return userID, nil
+	return claims, nil
 }
 
 func GetBearerToken(headers http.Header) (string, error) {
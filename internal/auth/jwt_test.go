@@ -13,15 +13,15 @@ import (
 func TestMakeJWT(t *testing.T) {
 	// Setup
 	userID := uuid.New()
-	tokenSecret := "test-secret"
+	keys, _ := NewKeyring(HMACKey("test-secret"))
 
 	// Test valid JWT creation
-	tokenString, err := MakeJWT(userID, tokenSecret)
+	tokenString, err := MakeJWT(userID, keys)
 	assert.NoError(t, err, "MakeJWT should not return an error")
 	assert.NotEmpty(t, tokenString, "Token string should not be empty")
 
 	// Optionally, verify the token is valid
-	_, err = ValidateJWT(tokenString, tokenSecret)
+	_, err = ValidateJWT(tokenString, keys)
 	assert.NoError(t, err, "ValidateJWT should not return an error for a valid token")
 }
 
@@ -29,29 +29,36 @@ func TestValidateJWT(t *testing.T) {
 	// Setup
 	userID := uuid.New()
 	tokenSecret := "test-secret"
+	keys, _ := NewKeyring(HMACKey(tokenSecret))
 	expiresIn := time.Hour
 
 	// Generate a valid token
-	tokenString, err := MakeJWT(userID, tokenSecret)
+	tokenString, err := MakeJWT(userID, keys)
 	assert.NoError(t, err, "MakeJWT should not return an error")
 
 	// Test valid token
-	validatedUserID, err := ValidateJWT(tokenString, tokenSecret)
+	validatedUserID, err := ValidateJWT(tokenString, keys)
 	assert.NoError(t, err, "ValidateJWT should not return an error for a valid token")
 	assert.Equal(t, userID, validatedUserID, "Validated userID should match the original userID")
 
 	// Test invalid token (wrong secret)
-	_, err = ValidateJWT(tokenString, "wrong-secret")
+	wrongKeys, _ := NewKeyring(HMACKey("wrong-secret"))
+	_, err = ValidateJWT(tokenString, wrongKeys)
 	assert.Error(t, err, "ValidateJWT should return an error for an invalid token")
 
-	// Test expired token - depracated
-	expiredToken, err := MakeJWT(userID, tokenSecret)
-	assert.NoError(t, err, "MakeJWT should not return an error")
-	_, err = ValidateJWT(expiredToken, tokenSecret)
+	// Test expired token
+	expiredToken, err := keys.signToken(userID, &accessClaims{RegisteredClaims: jwt.RegisteredClaims{
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(-expiresIn)),
+		IssuedAt:  jwt.NewNumericDate(time.Now().Add(-2 * expiresIn)),
+		Issuer:    "chirpy",
+		Subject:   userID.String(),
+	}})
+	assert.NoError(t, err, "Signing should not return an error")
+	_, err = ValidateJWT(expiredToken, keys)
 	assert.Error(t, err, "ValidateJWT should return an error for an expired token")
 
 	// Test malformed token
-	_, err = ValidateJWT("malformed.token.string", tokenSecret)
+	_, err = ValidateJWT("malformed.token.string", keys)
 	assert.Error(t, err, "ValidateJWT should return an error for a malformed token")
 
 	// Test invalid UUID in Subject claim
@@ -67,10 +74,70 @@ func TestValidateJWT(t *testing.T) {
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	invalidUUIDToken, err := token.SignedString([]byte(tokenSecret)) // Fix: Convert tokenSecret to []byte
 	assert.NoError(t, err, "Signing should not return an error")
-	_, err = ValidateJWT(invalidUUIDToken, tokenSecret)
+	_, err = ValidateJWT(invalidUUIDToken, keys)
 	assert.Error(t, err, "ValidateJWT should return an error for an invalid UUID in Subject claim")
 }
 
+func TestSessionJWT(t *testing.T) {
+	userID, sessionID := uuid.New(), uuid.New()
+	keys, _ := NewKeyring(HMACKey("test-secret"))
+
+	tk, err := MakeSessionJWT(userID, sessionID, keys)
+	assert.NoError(t, err)
+	gotUser, gotSession, err := ValidateSessionJWT(tk, keys)
+	assert.NoError(t, err)
+	assert.Equal(t, userID, gotUser)
+	assert.Equal(t, sessionID, gotSession)
+
+	// Tokens from before sessions were tracked name none
+	tk, _ = MakeJWT(userID, keys)
+	_, gotSession, err = ValidateSessionJWT(tk, keys)
+	assert.NoError(t, err)
+	assert.Equal(t, uuid.Nil, gotSession)
+}
+
+type fakeRevocations struct {
+	denied   map[string]bool
+	versions map[uuid.UUID]int32
+}
+
+func (f *fakeRevocations) Revoked(id string) bool { return f.denied[id] }
+
+func (f *fakeRevocations) TokenVersion(userID uuid.UUID) (int32, error) {
+	return f.versions[userID], nil
+}
+
+func TestRevokedJWT(t *testing.T) {
+	userID, sessionID := uuid.New(), uuid.New()
+	keys, _ := NewKeyring(HMACKey("test-secret"))
+	revs := &fakeRevocations{denied: map[string]bool{}, versions: map[uuid.UUID]int32{userID: 2}}
+	keys.SetRevocations(revs)
+
+	tk, err := MakeSessionJWT(userID, sessionID, keys)
+	assert.NoError(t, err)
+	token, err := ParseAccessToken(tk, keys)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, token.ID, "Tokens should carry a jti")
+
+	revs.denied[token.ID] = true
+	_, err = ValidateJWT(tk, keys)
+	assert.ErrorIs(t, err, ErrTokenRevoked, "A denied jti should be rejected")
+
+	other, _ := MakeSessionJWT(userID, sessionID, keys)
+	_, err = ValidateJWT(other, keys)
+	assert.NoError(t, err)
+	revs.denied[sessionID.String()] = true
+	_, err = ValidateJWT(other, keys)
+	assert.ErrorIs(t, err, ErrTokenRevoked, "A denied session should reject all its tokens")
+
+	scoped, _ := MakeScopedJWT(userID, keys, "client", []string{"chirps:read"}, time.Hour)
+	_, err = ValidateJWTScope(scoped, keys, "chirps:read")
+	assert.NoError(t, err)
+	revs.versions[userID] = 3
+	_, err = ValidateJWTScope(scoped, keys, "chirps:read")
+	assert.ErrorIs(t, err, ErrTokenRevoked, "Tokens from before a version bump should be rejected")
+}
+
 func TestGetBearerToken(t *testing.T) {
 	// Test case 1: Valid Authorization header with Bearer token and single space
 	validHeaders1 := http.Header{}
@@ -0,0 +1,244 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+var (
+	ErrUnknownKey     = errors.New("token signed with an unknown key")
+	ErrNoSigningKey   = errors.New("keyring has no active signing key")
+	ErrUnsupportedKey = errors.New("unsupported key type")
+	ErrTokenRevoked   = errors.New("token has been revoked")
+)
+
+// minRSABits is the smallest RSA modulus accepted for signing or
+// verifying.
+const minRSABits = 2048
+
+// SigningKey is one key in a Keyring. Keys loaded from a public key only
+// can verify but never sign.
+type SigningKey struct {
+	ID     string
+	method jwt.SigningMethod
+	sign   any
+	verify any
+}
+
+// HMACKey wraps a shared secret as an HS256 key. Its ID is derived from
+// the secret, so tokens find the right one again after a restart without
+// the ID giving the secret away.
+func HMACKey(secret string) *SigningKey {
+	sum := sha256.Sum256([]byte("chirpy-kid:" + secret))
+	return &SigningKey{
+		ID:     "hs-" + hex.EncodeToString(sum[:6]),
+		method: jwt.SigningMethodHS256,
+		sign:   []byte(secret),
+		verify: []byte(secret),
+	}
+}
+
+// Ed25519Key signs with EdDSA.
+func Ed25519Key(id string, key ed25519.PrivateKey) *SigningKey {
+	return &SigningKey{id, jwt.SigningMethodEdDSA, key, key.Public()}
+}
+
+// RSAKey signs with RS256.
+func RSAKey(id string, key *rsa.PrivateKey) *SigningKey {
+	return &SigningKey{id, jwt.SigningMethodRS256, key, &key.PublicKey}
+}
+
+// ParsePEMKey reads an Ed25519 or RSA key. A private key (PKCS #8, or
+// PKCS #1 for RSA) can sign; a public key (PKIX) only verifies.
+func ParsePEMKey(id string, data []byte) (*SigningKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("key %s: no PEM data", id)
+	}
+	var key any
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("key %s: unexpected PEM block %q", id, block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("key %s: %w", id, err)
+	}
+
+	switch k := key.(type) {
+	case ed25519.PrivateKey:
+		return Ed25519Key(id, k), nil
+	case ed25519.PublicKey:
+		return &SigningKey{id, jwt.SigningMethodEdDSA, nil, k}, nil
+	case *rsa.PrivateKey:
+		if k.N.BitLen() < minRSABits {
+			return nil, fmt.Errorf("key %s: RSA keys must be at least %d bits", id, minRSABits)
+		}
+		return RSAKey(id, k), nil
+	case *rsa.PublicKey:
+		if k.N.BitLen() < minRSABits {
+			return nil, fmt.Errorf("key %s: RSA keys must be at least %d bits", id, minRSABits)
+		}
+		return &SigningKey{id, jwt.SigningMethodRS256, nil, k}, nil
+	}
+	return nil, fmt.Errorf("key %s: %w", id, ErrUnsupportedKey)
+}
+
+// Keyring holds the key new tokens are signed with and older ones still
+// accepted, so keys can be rotated without logging everyone out. Tokens
+// name their key with a kid header.
+type Keyring struct {
+	active      *SigningKey
+	keys        map[string]*SigningKey
+	revocations Revocations
+}
+
+// Revocations lets a Keyring turn away tokens revoked before they expire:
+// ones whose ID or session is denied, and ones issued before the user's
+// token version was last bumped.
+type Revocations interface {
+	Revoked(id string) bool
+	TokenVersion(userID uuid.UUID) (int32, error)
+}
+
+// SetRevocations has tokens signed from now on carry the user's token
+// version, and has validation check r. Call it before serving requests.
+func (k *Keyring) SetRevocations(r Revocations) {
+	k.revocations = r
+}
+
+// NewKeyring signs with active and verifies with it and the rest.
+func NewKeyring(active *SigningKey, verifyOnly ...*SigningKey) (*Keyring, error) {
+	if active == nil || active.sign == nil {
+		return nil, ErrNoSigningKey
+	}
+	k := &Keyring{active: active, keys: map[string]*SigningKey{}}
+	for _, key := range append([]*SigningKey{active}, verifyOnly...) {
+		if _, dup := k.keys[key.ID]; dup {
+			return nil, fmt.Errorf("duplicate key ID %q", key.ID)
+		}
+		k.keys[key.ID] = key
+	}
+	return k, nil
+}
+
+func (k *Keyring) signToken(userID uuid.UUID, claims *accessClaims) (string, error) {
+	if k.revocations != nil {
+		v, err := k.revocations.TokenVersion(userID)
+		if err != nil {
+			return "", err
+		}
+		claims.TokenVersion = v
+	}
+	token := jwt.NewWithClaims(k.active.method, claims)
+	token.Header["kid"] = k.active.ID
+	return token.SignedString(k.active.sign)
+}
+
+func (k *Keyring) checkRevoked(claims *accessClaims) error {
+	if k.revocations == nil {
+		return nil
+	}
+	if k.revocations.Revoked(claims.ID) || k.revocations.Revoked(claims.SessionID) {
+		return ErrTokenRevoked
+	}
+	userID, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return err
+	}
+	v, err := k.revocations.TokenVersion(userID)
+	if err != nil {
+		return err
+	}
+	if claims.TokenVersion < v {
+		return ErrTokenRevoked
+	}
+	return nil
+}
+
+// keyFunc finds the key a token names, making sure the token's algorithm
+// is the one that key is for; otherwise an RSA public key could be passed
+// off as an HMAC secret. Tokens from before key IDs were added have none
+// and are checked against the HMAC keys.
+func (k *Keyring) keyFunc(token *jwt.Token) (any, error) {
+	kid, ok := token.Header["kid"].(string)
+	if !ok {
+		var set jwt.VerificationKeySet
+		for _, key := range k.keys {
+			if key.method == jwt.SigningMethodHS256 && token.Method == jwt.SigningMethodHS256 {
+				set.Keys = append(set.Keys, key.verify)
+			}
+		}
+		if len(set.Keys) == 0 {
+			return nil, ErrUnknownKey
+		}
+		return set, nil
+	}
+	key, ok := k.keys[kid]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, jwt.ErrTokenSignatureInvalid
+	}
+	return key.verify, nil
+}
+
+// JWK is a public key as published in a JSON Web Key Set (RFC 7517).
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+}
+
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS lists the public halves of the asymmetric keys, so other services
+// can check Chirpy's tokens. HMAC secrets are never published.
+func (k *Keyring) JWKS() JWKSet {
+	set := JWKSet{Keys: []JWK{}}
+	for _, key := range k.keys {
+		b64 := base64.RawURLEncoding.EncodeToString
+		switch pub := key.verify.(type) {
+		case ed25519.PublicKey:
+			set.Keys = append(set.Keys, JWK{Kty: "OKP", Kid: key.ID, Use: "sig", Alg: "EdDSA", Crv: "Ed25519", X: b64(pub)})
+		case *rsa.PublicKey:
+			e := big.NewInt(int64(pub.E)).Bytes()
+			set.Keys = append(set.Keys, JWK{Kty: "RSA", Kid: key.ID, Use: "sig", Alg: "RS256", N: b64(pub.N.Bytes()), E: b64(e)})
+		}
+	}
+	// Active key first, the rest in a stable order
+	sort.Slice(set.Keys, func(i, j int) bool {
+		a, b := set.Keys[i].Kid, set.Keys[j].Kid
+		if (a == k.active.ID) != (b == k.active.ID) {
+			return a == k.active.ID
+		}
+		return a < b
+	})
+	return set
+}
@@ -0,0 +1,133 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyRotation(t *testing.T) {
+	userID := uuid.New()
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	oldKey, newKey := HMACKey("old-secret"), Ed25519Key("2026-10", edKey)
+
+	before, _ := NewKeyring(oldKey)
+	tk, err := MakeJWT(userID, before)
+	require.NoError(t, err)
+
+	// After rotating, tokens signed with the old key still work...
+	after, err := NewKeyring(newKey, oldKey)
+	require.NoError(t, err)
+	got, err := ValidateJWT(tk, after)
+	assert.NoError(t, err)
+	assert.Equal(t, userID, got)
+
+	// ...new ones are signed with the new key...
+	fresh, err := MakeJWT(userID, after)
+	require.NoError(t, err)
+	parsed, _, err := jwt.NewParser().ParseUnverified(fresh, &jwt.RegisteredClaims{})
+	require.NoError(t, err)
+	assert.Equal(t, "2026-10", parsed.Header["kid"])
+	assert.Equal(t, "EdDSA", parsed.Method.Alg())
+
+	// ...and once the old key is dropped its tokens stop working
+	dropped, _ := NewKeyring(newKey)
+	_, err = ValidateJWT(tk, dropped)
+	assert.ErrorIs(t, err, ErrUnknownKey)
+	_, err = ValidateJWT(fresh, dropped)
+	assert.NoError(t, err)
+}
+
+func TestTokensWithoutKeyID(t *testing.T) {
+	userID := uuid.New()
+	keys, _ := NewKeyring(HMACKey("test-secret"))
+
+	// Issued before key IDs, with the same secret
+	claims := jwt.RegisteredClaims{Subject: userID.String(), ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour))}
+	legacy, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("test-secret"))
+	got, err := ValidateJWT(legacy, keys)
+	assert.NoError(t, err)
+	assert.Equal(t, userID, got)
+
+	_, rsaKey := newRSAKey(t)
+	rsaOnly, _ := NewKeyring(RSAKey("rsa-1", rsaKey))
+	_, err = ValidateJWT(legacy, rsaOnly)
+	assert.Error(t, err)
+}
+
+func TestAlgorithmConfusion(t *testing.T) {
+	pemPub, rsaKey := newRSAKey(t)
+	keys, _ := NewKeyring(RSAKey("rsa-1", rsaKey))
+
+	// An HS256 token "signed" with the published public key, naming the
+	// RSA key
+	claims := jwt.RegisteredClaims{Subject: uuid.NewString(), ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour))}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	token.Header["kid"] = "rsa-1"
+	forged, _ := token.SignedString(pemPub)
+	_, err := ValidateJWT(forged, keys)
+	assert.Error(t, err)
+
+	unsigned := jwt.NewWithClaims(jwt.SigningMethodNone, claims)
+	unsigned.Header["kid"] = "rsa-1"
+	none, _ := unsigned.SignedString(jwt.UnsafeAllowNoneSignatureType)
+	_, err = ValidateJWT(none, keys)
+	assert.Error(t, err)
+}
+
+func TestParsePEMKey(t *testing.T) {
+	pemPub, rsaKey := newRSAKey(t)
+	der, _ := x509.MarshalPKCS8PrivateKey(rsaKey)
+	pemPriv := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+
+	signer, err := ParsePEMKey("rsa-1", pemPriv)
+	require.NoError(t, err)
+	verifier, err := ParsePEMKey("rsa-1", pemPub)
+	require.NoError(t, err)
+
+	// A public key can check tokens but can't be the one signing them
+	_, err = NewKeyring(verifier)
+	assert.ErrorIs(t, err, ErrNoSigningKey)
+
+	signing, _ := NewKeyring(signer)
+	checking, _ := NewKeyring(HMACKey("unrelated"), verifier)
+	tk, err := MakeJWT(uuid.New(), signing)
+	require.NoError(t, err)
+	_, err = ValidateJWT(tk, checking)
+	assert.NoError(t, err)
+
+	small, _ := rsa.GenerateKey(rand.Reader, 1024)
+	_, err = ParsePEMKey("small", pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(small)}))
+	assert.Error(t, err)
+	_, err = ParsePEMKey("junk", []byte("not a key"))
+	assert.Error(t, err)
+}
+
+func TestJWKS(t *testing.T) {
+	_, rsaKey := newRSAKey(t)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	keys, _ := NewKeyring(Ed25519Key("ed-2", edKey), HMACKey("secret"), RSAKey("rsa-1", rsaKey))
+
+	set := keys.JWKS()
+	require.Len(t, set.Keys, 2, "HMAC secrets must not be published")
+	assert.Equal(t, JWK{Kty: "OKP", Kid: "ed-2", Use: "sig", Alg: "EdDSA", Crv: "Ed25519", X: set.Keys[0].X}, set.Keys[0])
+	assert.Equal(t, "rsa-1", set.Keys[1].Kid)
+	assert.Equal(t, "AQAB", set.Keys[1].E)
+}
+
+func newRSAKey(t *testing.T) ([]byte, *rsa.PrivateKey) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), key
+}
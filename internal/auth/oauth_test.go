@@ -0,0 +1,50 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScopedJWT(t *testing.T) {
+	userID := uuid.New()
+	keys, _ := NewKeyring(HMACKey("test-secret"))
+
+	tk, err := MakeScopedJWT(userID, keys, "client-1", []string{"chirps:read", "chirps:write"}, time.Minute)
+	require.NoError(t, err)
+
+	got, err := ValidateJWTScope(tk, keys, "chirps:write")
+	assert.NoError(t, err)
+	assert.Equal(t, userID, got)
+
+	_, err = ValidateJWTScope(tk, keys, "profile:write")
+	assert.ErrorIs(t, err, ErrInsufficientScope)
+
+	// Endpoints that don't take scopes refuse app tokens outright
+	_, err = ValidateJWT(tk, keys)
+	assert.ErrorIs(t, err, ErrInsufficientScope)
+
+	// First-party tokens pass any scope check
+	first, err := MakeJWT(userID, keys)
+	require.NoError(t, err)
+	got, err = ValidateJWTScope(first, keys, "profile:write")
+	assert.NoError(t, err)
+	assert.Equal(t, userID, got)
+
+	expired, err := MakeScopedJWT(userID, keys, "client-1", []string{"chirps:read"}, -time.Minute)
+	require.NoError(t, err)
+	_, err = ValidateJWTScope(expired, keys, "chirps:read")
+	assert.Error(t, err)
+}
+
+func TestVerifyPKCE(t *testing.T) {
+	// RFC 7636 appendix B
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	challenge := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+	assert.True(t, VerifyPKCE(verifier, challenge))
+	assert.False(t, VerifyPKCE(verifier+"x", challenge))
+	assert.False(t, VerifyPKCE("short", challenge))
+}
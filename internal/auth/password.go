@@ -0,0 +1,76 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Password rules a PasswordPolicyError can list.
+const (
+	RuleMinLength     = "min_length"
+	RuleMaxBytes      = "max_bytes"
+	RuleContainsEmail = "contains_email"
+	RuleBreached      = "breached"
+)
+
+// PasswordPolicy is what a new password has to satisfy. Breached, if set,
+// reports whether a password is known from a breach.
+type PasswordPolicy struct {
+	MinLength int
+	MaxBytes  int
+	Breached  func(password string) bool
+}
+
+type PasswordRule struct {
+	Rule    string
+	Message string
+}
+
+// PasswordPolicyError lists every rule a password broke, so they can all
+// be fixed at once.
+type PasswordPolicyError struct {
+	Failed []PasswordRule
+}
+
+func (e *PasswordPolicyError) Error() string {
+	rules := make([]string, len(e.Failed))
+	for i, r := range e.Failed {
+		rules[i] = r.Rule
+	}
+	return "password breaks policy: " + strings.Join(rules, ", ")
+}
+
+// Check returns a *PasswordPolicyError if password can't be used for the
+// account with email.
+func (p PasswordPolicy) Check(password, email string) error {
+	var failed []PasswordRule
+	if utf8.RuneCountInString(password) < p.MinLength {
+		failed = append(failed, PasswordRule{RuleMinLength, fmt.Sprintf("Password must be at least %d characters", p.MinLength)})
+	}
+	if p.MaxBytes > 0 && len(password) > p.MaxBytes {
+		failed = append(failed, PasswordRule{RuleMaxBytes, fmt.Sprintf("Password must be at most %d bytes", p.MaxBytes)})
+	}
+	if containsEmail(password, email) {
+		failed = append(failed, PasswordRule{RuleContainsEmail, "Password must not contain your email address"})
+	}
+	if p.Breached != nil && password != "" && p.Breached(password) {
+		failed = append(failed, PasswordRule{RuleBreached, "Password appears in a known data breach; choose another"})
+	}
+	if len(failed) > 0 {
+		return &PasswordPolicyError{failed}
+	}
+	return nil
+}
+
+// containsEmail catches the address or its name part, which is the first
+// thing anyone guessing would try. Very short names are left alone, as
+// they'd rule out too much.
+func containsEmail(password, email string) bool {
+	password, email = strings.ToLower(password), strings.ToLower(strings.TrimSpace(email))
+	if email == "" {
+		return false
+	}
+	name, _, _ := strings.Cut(email, "@")
+	return strings.Contains(password, email) || (utf8.RuneCountInString(name) >= 3 && strings.Contains(password, name))
+}
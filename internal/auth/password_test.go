@@ -0,0 +1,48 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPasswordPolicy(t *testing.T) {
+	p := PasswordPolicy{
+		MinLength: 8,
+		MaxBytes:  64,
+		Breached:  func(pw string) bool { return pw == "password123" },
+	}
+	tests := []struct {
+		password string
+		email    string
+		want     []string
+	}{
+		{"a good long passphrase", "alice@example.com", nil},
+		{"", "alice@example.com", []string{RuleMinLength}},
+		{"short", "alice@example.com", []string{RuleMinLength}},
+		{"ünïcödé", "alice@example.com", []string{RuleMinLength}},
+		{"ünïcödé!", "alice@example.com", nil},
+		{strings.Repeat("x", 65), "alice@example.com", []string{RuleMaxBytes}},
+		{"my Alice@Example.com pw", "alice@example.com", []string{RuleContainsEmail}},
+		{"alice2024!!", "alice@example.com", []string{RuleContainsEmail}},
+		{"bobcat is fine", "bo@example.com", nil},
+		{"password123", "alice@example.com", []string{RuleBreached}},
+		{"alice", "alice@example.com", []string{RuleMinLength, RuleContainsEmail}},
+	}
+	for _, tt := range tests {
+		err := p.Check(tt.password, tt.email)
+		if tt.want == nil {
+			assert.NoError(t, err, tt.password)
+			continue
+		}
+		var policyErr *PasswordPolicyError
+		if assert.ErrorAs(t, err, &policyErr, tt.password) {
+			var rules []string
+			for _, r := range policyErr.Failed {
+				rules = append(rules, r.Rule)
+			}
+			assert.Equal(t, tt.want, rules, tt.password)
+		}
+	}
+}
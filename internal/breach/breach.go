@@ -0,0 +1,132 @@
+// Package breach checks passwords against a list of ones known from
+// breaches, held in a Bloom filter so even a large list fits in memory and
+// nothing is sent over the network. A filter can say a password is
+// breached when it isn't, at a chosen rate, but never the other way round.
+package breach
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+)
+
+// DefaultFalsePositiveRate costs about 14 bits per password in the list.
+const DefaultFalsePositiveRate = 0.001
+
+type Filter struct {
+	bits []uint64
+	m    uint64
+	k    uint64
+}
+
+// NewFilter sizes a filter for n passwords at false positive rate p.
+func NewFilter(n int, p float64) *Filter {
+	n = max(n, 1)
+	m := uint64(math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2)))
+	m = max(m, 64)
+	k := uint64(math.Round(float64(m) / float64(n) * math.Ln2))
+	return &Filter{bits: make([]uint64, (m+63)/64), m: m, k: max(k, 1)}
+}
+
+// Entries are SHA-1 hashes, which are already evenly spread, so the bit
+// positions come straight from them by double hashing.
+func (f *Filter) positions(sum [sha1.Size]byte, fn func(uint64) bool) bool {
+	h1 := binary.LittleEndian.Uint64(sum[0:8])
+	h2 := binary.LittleEndian.Uint64(sum[8:16]) | 1
+	for i := range f.k {
+		if !fn((h1 + i*h2) % f.m) {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *Filter) addSum(sum [sha1.Size]byte) {
+	f.positions(sum, func(pos uint64) bool {
+		f.bits[pos/64] |= 1 << (pos % 64)
+		return true
+	})
+}
+
+func (f *Filter) Add(password string) {
+	f.addSum(sha1.Sum([]byte(password)))
+}
+
+// Contains reports whether password is probably in the list.
+func (f *Filter) Contains(password string) bool {
+	return f.positions(sha1.Sum([]byte(password)), func(pos uint64) bool {
+		return f.bits[pos/64]&(1<<(pos%64)) != 0
+	})
+}
+
+// parseLine reads a line of a password list: either a plain password, or
+// a SHA-1 hash in hex as in the Have I Been Pwned downloads, optionally
+// followed by ":count".
+func parseLine(line string) (sum [sha1.Size]byte, ok bool) {
+	line = strings.TrimRight(line, "\r")
+	if line == "" {
+		return sum, false
+	}
+	if h, _, _ := strings.Cut(line, ":"); len(h) == hex.EncodedLen(sha1.Size) {
+		if _, err := hex.Decode(sum[:], []byte(h)); err == nil {
+			return sum, true
+		}
+	}
+	return sha1.Sum([]byte(line)), true
+}
+
+// Load builds a filter from a password list, one entry per line. It reads
+// the list twice, first to size the filter.
+func Load(r io.ReadSeeker, p float64) (*Filter, error) {
+	n := 0
+	err := eachLine(r, func(string) { n++ })
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, errors.New("password list is empty")
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	f := NewFilter(n, p)
+	err = eachLine(r, func(line string) {
+		if sum, ok := parseLine(line); ok {
+			f.addSum(sum)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func LoadFile(path string, p float64) (*Filter, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	f, err := Load(file, p)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return f, nil
+}
+
+func eachLine(r io.Reader, fn func(string)) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if line := scanner.Text(); strings.TrimSpace(line) != "" {
+			fn(line)
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,53 @@
+package breach
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoad(t *testing.T) {
+	list := strings.Join([]string{
+		"password",
+		"hunter2",
+		"",
+		// "letmein" as in a Have I Been Pwned download
+		"B7A875FC1EA228B9061041B7CEC4BD3C52AB3CE3:1234",
+		// and without a count, lower case
+		"5baa61e4c9b93f3f0682250b6cf8331b7ee68fd8",
+	}, "\n")
+	f, err := Load(strings.NewReader(list), DefaultFalsePositiveRate)
+	require.NoError(t, err)
+
+	for _, pw := range []string{"password", "hunter2", "letmein"} {
+		assert.True(t, f.Contains(pw), pw)
+	}
+	assert.False(t, f.Contains("correct horse battery staple"))
+	assert.False(t, f.Contains("Password"), "Matching is case-sensitive")
+}
+
+func TestLoadEmpty(t *testing.T) {
+	_, err := Load(strings.NewReader("\n\n"), DefaultFalsePositiveRate)
+	assert.Error(t, err)
+}
+
+func TestFalsePositiveRate(t *testing.T) {
+	const n = 10000
+	f := NewFilter(n, 0.01)
+	for i := range n {
+		f.Add(fmt.Sprintf("breached-%d", i))
+	}
+	for i := range n {
+		require.True(t, f.Contains(fmt.Sprintf("breached-%d", i)), "No false negatives")
+	}
+	hits := 0
+	for i := range n {
+		if f.Contains(fmt.Sprintf("fine-%d", i)) {
+			hits++
+		}
+	}
+	assert.Less(t, hits, n*3/100, "False positives should be near the chosen rate")
+}
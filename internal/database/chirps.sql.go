@@ -7,29 +7,73 @@ package database
 
 import (
 	"context"
+	"time"
 
 	"github.com/google/uuid"
 )
 
+const anonymizeUserChirps = `-- name: AnonymizeUserChirps :execrows
+UPDATE chirps SET user_id = NULL, updated_at = NOW() WHERE user_id = $1
+`
+
+func (q *Queries) AnonymizeUserChirps(ctx context.Context, userID uuid.NullUUID) (int64, error) {
+	result, err := q.db.ExecContext(ctx, anonymizeUserChirps, userID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const chirpExists = `-- name: ChirpExists :one
+SELECT EXISTS (
+    SELECT 1 FROM chirps WHERE user_id = $1 AND created_at = $2 AND body = $3
+)
+`
+
+type ChirpExistsParams struct {
+	UserID    uuid.NullUUID
+	CreatedAt time.Time
+	Body      string
+}
+
+func (q *Queries) ChirpExists(ctx context.Context, arg ChirpExistsParams) (bool, error) {
+	row := q.db.QueryRowContext(ctx, chirpExists, arg.UserID, arg.CreatedAt, arg.Body)
+	var exists bool
+	err := row.Scan(&exists)
+	return exists, err
+}
+
 const createChirp = `-- name: CreateChirp :one
-INSERT INTO chirps (id, created_at, updated_at, body, user_id)
+INSERT INTO chirps (id, created_at, updated_at, body, user_id, content_warning, sensitive, body_html)
 VALUES (
     gen_random_uuid(),
     NOW(),
     NOW(),
     $1,
-    $2
+    $2,
+    $3,
+    $4,
+    $5
 )
-RETURNING id, created_at, updated_at, body, user_id
+RETURNING id, created_at, updated_at, body, user_id, content_warning, sensitive, body_html, sensitive_by_moderator
 `
 
 type CreateChirpParams struct {
-	Body   string
-	UserID uuid.UUID
+	Body           string
+	UserID         uuid.NullUUID
+	ContentWarning string
+	Sensitive      bool
+	BodyHtml       string
 }
 
 func (q *Queries) CreateChirp(ctx context.Context, arg CreateChirpParams) (Chirp, error) {
-	row := q.db.QueryRowContext(ctx, createChirp, arg.Body, arg.UserID)
+	row := q.db.QueryRowContext(ctx, createChirp,
+		arg.Body,
+		arg.UserID,
+		arg.ContentWarning,
+		arg.Sensitive,
+		arg.BodyHtml,
+	)
 	var i Chirp
 	err := row.Scan(
 		&i.ID,
@@ -37,12 +81,64 @@ func (q *Queries) CreateChirp(ctx context.Context, arg CreateChirpParams) (Chirp
 		&i.UpdatedAt,
 		&i.Body,
 		&i.UserID,
+		&i.ContentWarning,
+		&i.Sensitive,
+		&i.BodyHtml,
+		&i.SensitiveByModerator,
+	)
+	return i, err
+}
+
+const createChirpAt = `-- name: CreateChirpAt :one
+INSERT INTO chirps (id, created_at, updated_at, body, user_id, content_warning, sensitive, body_html)
+VALUES (
+    gen_random_uuid(),
+    $1,
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    $6
+)
+RETURNING id, created_at, updated_at, body, user_id, content_warning, sensitive, body_html, sensitive_by_moderator
+`
+
+type CreateChirpAtParams struct {
+	CreatedAt      time.Time
+	Body           string
+	UserID         uuid.NullUUID
+	ContentWarning string
+	Sensitive      bool
+	BodyHtml       string
+}
+
+func (q *Queries) CreateChirpAt(ctx context.Context, arg CreateChirpAtParams) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, createChirpAt,
+		arg.CreatedAt,
+		arg.Body,
+		arg.UserID,
+		arg.ContentWarning,
+		arg.Sensitive,
+		arg.BodyHtml,
+	)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+		&i.ContentWarning,
+		&i.Sensitive,
+		&i.BodyHtml,
+		&i.SensitiveByModerator,
 	)
 	return i, err
 }
 
 const deleteChirp = `-- name: DeleteChirp :one
-DELETE FROM chirps WHERE id = $1 RETURNING id, created_at, updated_at, body, user_id
+DELETE FROM chirps WHERE id = $1 RETURNING id, created_at, updated_at, body, user_id, content_warning, sensitive, body_html, sensitive_by_moderator
 `
 
 func (q *Queries) DeleteChirp(ctx context.Context, id uuid.UUID) (Chirp, error) {
@@ -54,12 +150,28 @@ func (q *Queries) DeleteChirp(ctx context.Context, id uuid.UUID) (Chirp, error)
 		&i.UpdatedAt,
 		&i.Body,
 		&i.UserID,
+		&i.ContentWarning,
+		&i.Sensitive,
+		&i.BodyHtml,
+		&i.SensitiveByModerator,
 	)
 	return i, err
 }
 
+const deleteUserChirps = `-- name: DeleteUserChirps :execrows
+DELETE FROM chirps WHERE user_id = $1
+`
+
+func (q *Queries) DeleteUserChirps(ctx context.Context, userID uuid.NullUUID) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteUserChirps, userID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
 const getChirp = `-- name: GetChirp :one
-SELECT id, created_at, updated_at, body, user_id FROM chirps WHERE id = $1
+SELECT id, created_at, updated_at, body, user_id, content_warning, sensitive, body_html, sensitive_by_moderator FROM chirps WHERE id = $1
 `
 
 func (q *Queries) GetChirp(ctx context.Context, id uuid.UUID) (Chirp, error) {
@@ -71,12 +183,16 @@ func (q *Queries) GetChirp(ctx context.Context, id uuid.UUID) (Chirp, error) {
 		&i.UpdatedAt,
 		&i.Body,
 		&i.UserID,
+		&i.ContentWarning,
+		&i.Sensitive,
+		&i.BodyHtml,
+		&i.SensitiveByModerator,
 	)
 	return i, err
 }
 
 const getChirps = `-- name: GetChirps :many
-SELECT id, created_at, updated_at, body, user_id FROM chirps ORDER BY created_at ASC
+SELECT id, created_at, updated_at, body, user_id, content_warning, sensitive, body_html, sensitive_by_moderator FROM chirps ORDER BY created_at ASC
 `
 
 func (q *Queries) GetChirps(ctx context.Context) ([]Chirp, error) {
@@ -94,6 +210,47 @@ func (q *Queries) GetChirps(ctx context.Context) ([]Chirp, error) {
 			&i.UpdatedAt,
 			&i.Body,
 			&i.UserID,
+			&i.ContentWarning,
+			&i.Sensitive,
+			&i.BodyHtml,
+			&i.SensitiveByModerator,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getChirpsByAuthor = `-- name: GetChirpsByAuthor :many
+SELECT id, created_at, updated_at, body, user_id, content_warning, sensitive, body_html, sensitive_by_moderator FROM chirps WHERE user_id = $1 ORDER BY created_at ASC
+`
+
+func (q *Queries) GetChirpsByAuthor(ctx context.Context, userID uuid.NullUUID) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, getChirpsByAuthor, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Chirp
+	for rows.Next() {
+		var i Chirp
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.ContentWarning,
+			&i.Sensitive,
+			&i.BodyHtml,
+			&i.SensitiveByModerator,
 		); err != nil {
 			return nil, err
 		}
@@ -109,7 +266,7 @@ func (q *Queries) GetChirps(ctx context.Context) ([]Chirp, error) {
 }
 
 const resetChirps = `-- name: ResetChirps :many
-DELETE FROM chirps RETURNING id, created_at, updated_at, body, user_id
+DELETE FROM chirps RETURNING id, created_at, updated_at, body, user_id, content_warning, sensitive, body_html, sensitive_by_moderator
 `
 
 func (q *Queries) ResetChirps(ctx context.Context) ([]Chirp, error) {
@@ -127,6 +284,10 @@ func (q *Queries) ResetChirps(ctx context.Context) ([]Chirp, error) {
 			&i.UpdatedAt,
 			&i.Body,
 			&i.UserID,
+			&i.ContentWarning,
+			&i.Sensitive,
+			&i.BodyHtml,
+			&i.SensitiveByModerator,
 		); err != nil {
 			return nil, err
 		}
@@ -140,3 +301,33 @@ func (q *Queries) ResetChirps(ctx context.Context) ([]Chirp, error) {
 	}
 	return items, nil
 }
+
+const setChirpSensitive = `-- name: SetChirpSensitive :one
+UPDATE chirps
+SET sensitive = $2, sensitive_by_moderator = $3, updated_at = NOW()
+WHERE id = $1
+RETURNING id, created_at, updated_at, body, user_id, content_warning, sensitive, body_html, sensitive_by_moderator
+`
+
+type SetChirpSensitiveParams struct {
+	ID                   uuid.UUID
+	Sensitive            bool
+	SensitiveByModerator bool
+}
+
+func (q *Queries) SetChirpSensitive(ctx context.Context, arg SetChirpSensitiveParams) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, setChirpSensitive, arg.ID, arg.Sensitive, arg.SensitiveByModerator)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+		&i.ContentWarning,
+		&i.Sensitive,
+		&i.BodyHtml,
+		&i.SensitiveByModerator,
+	)
+	return i, err
+}
@@ -11,28 +11,316 @@ import (
 	"github.com/google/uuid"
 )
 
-type Chirp struct {
+type ActorKey struct {
+	UserID     uuid.UUID
+	CreatedAt  time.Time
+	PublicKey  string
+	PrivateKey string
+}
+
+type AuditLog struct {
 	ID        uuid.UUID
 	CreatedAt time.Time
+	Event     string
+	UserID    uuid.NullUUID
+	Details   string
+}
+
+type Chirp struct {
+	ID                   uuid.UUID
+	CreatedAt            time.Time
+	UpdatedAt            time.Time
+	Body                 string
+	UserID               uuid.NullUUID
+	ContentWarning       string
+	Sensitive            bool
+	BodyHtml             string
+	SensitiveByModerator bool
+}
+
+type ChirpView struct {
+	ChirpID uuid.UUID
+	Day     time.Time
+	Views   int64
+}
+
+type Delivery struct {
+	ID            uuid.UUID
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+	UserID        uuid.UUID
+	Inbox         string
+	Body          string
+	Status        string
+	Attempts      int32
+	NextAttemptAt time.Time
+	LastError     string
+	DeliveredAt   sql.NullTime
+}
+
+type EmailVerification struct {
+	TokenHash string
+	UserID    uuid.UUID
+	Email     string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	UsedAt    sql.NullTime
+}
+
+type ExportJob struct {
+	ID         uuid.UUID
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	UserID     uuid.UUID
+	Status     string
+	Error      string
+	FinishedAt sql.NullTime
+	ExpiresAt  sql.NullTime
+}
+
+type Follower struct {
+	UserID    uuid.UUID
+	ActorID   string
+	CreatedAt time.Time
+}
+
+type ImportError struct {
+	ID      uuid.UUID
+	JobID   uuid.UUID
+	Row     int32
+	Message string
+}
+
+type ImportJob struct {
+	ID         uuid.UUID
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	UserID     uuid.UUID
+	Format     string
+	Status     string
+	Total      int32
+	Processed  int32
+	Imported   int32
+	Skipped    int32
+	FinishedAt sql.NullTime
+}
+
+type LoginChallenge struct {
+	TokenHash string
+	UserID    uuid.UUID
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	Attempts  int32
+}
+
+type LoginFailure struct {
+	Key           string
+	Failures      int32
+	FirstFailedAt time.Time
+	LastFailedAt  time.Time
+	LockedUntil   sql.NullTime
+}
+
+type Message struct {
+	ID          uuid.UUID
+	Seq         int64
+	CreatedAt   time.Time
+	SenderID    uuid.UUID
+	RecipientID uuid.UUID
+	Body        string
+	ReadAt      sql.NullTime
+}
+
+type Notification struct {
+	ID            uuid.UUID
+	CreatedAt     time.Time
+	UserID        uuid.UUID
+	ActorID       uuid.NullUUID
+	Type          string
+	ChirpID       uuid.NullUUID
+	ReadAt        sql.NullTime
+	RemoteActorID sql.NullString
+}
+
+type OauthClient struct {
+	ID         uuid.UUID
+	OwnerID    uuid.UUID
+	Name       string
+	SecretHash string
+	CreatedAt  time.Time
+}
+
+type OauthCode struct {
+	CodeHash      string
+	UserID        uuid.UUID
+	ClientID      uuid.UUID
+	RedirectUri   string
+	Scope         string
+	CodeChallenge string
+	CreatedAt     time.Time
+	ExpiresAt     time.Time
+	UsedAt        sql.NullTime
+}
+
+type OauthGrant struct {
+	UserID    uuid.UUID
+	ClientID  uuid.UUID
+	Scope     string
+	CreatedAt time.Time
 	UpdatedAt time.Time
-	Body      string
+}
+
+type OauthRedirectUri struct {
+	ClientID uuid.UUID
+	Uri      string
+}
+
+type OauthRefreshToken struct {
+	TokenHash string
+	UserID    uuid.UUID
+	ClientID  uuid.UUID
+	Scope     string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	RevokedAt sql.NullTime
+}
+
+type OidcLogin struct {
+	StateHash    string
+	Nonce        string
+	CodeVerifier string
+	CreatedAt    time.Time
+	ExpiresAt    time.Time
+}
+
+type Passkey struct {
+	ID         string
+	UserID     uuid.UUID
+	Name       string
+	PublicKey  []byte
+	SignCount  int64
+	CreatedAt  time.Time
+	LastUsedAt sql.NullTime
+}
+
+type PasswordReset struct {
+	TokenHash string
 	UserID    uuid.UUID
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	UsedAt    sql.NullTime
+}
+
+type PersonalAccessToken struct {
+	ID         uuid.UUID
+	UserID     uuid.UUID
+	Name       string
+	TokenHash  string
+	Scope      string
+	CreatedAt  time.Time
+	ExpiresAt  sql.NullTime
+	LastUsedAt sql.NullTime
+	RevokedAt  sql.NullTime
+}
+
+type RecoveryCode struct {
+	CodeHash  string
+	UserID    uuid.UUID
+	CreatedAt time.Time
+	UsedAt    sql.NullTime
 }
 
 type RefreshToken struct {
-	Token     string
+	TokenHash string
 	CreatedAt time.Time
 	UpdatedAt time.Time
 	UserID    uuid.UUID
 	ExpiresAt time.Time
 	RevokedAt sql.NullTime
+	FamilyID  uuid.UUID
+	RotatedAt sql.NullTime
 }
 
-type User struct {
-	ID          uuid.UUID
+type RemoteActor struct {
+	ID          string
 	CreatedAt   time.Time
 	UpdatedAt   time.Time
+	Username    string
+	Inbox       string
+	SharedInbox string
+	PublicKey   string
+}
+
+type RemoteLike struct {
+	ActorID   string
+	ChirpID   uuid.UUID
+	CreatedAt time.Time
+}
+
+type RemoteNote struct {
+	ID        string
+	CreatedAt time.Time
+	ActorID   string
+	Content   string
+	InReplyTo string
+	Published time.Time
+}
+
+type RevokedAccessToken struct {
+	ID        string
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
+
+type Session struct {
+	ID         uuid.UUID
+	UserID     uuid.UUID
+	UserAgent  string
+	Ip         string
+	Device     string
+	CreatedAt  time.Time
+	LastUsedAt time.Time
+}
+
+type TotpCredential struct {
+	UserID      uuid.UUID
+	Secret      string
+	CreatedAt   time.Time
+	ConfirmedAt sql.NullTime
+	LastStep    int64
+}
+
+type User struct {
+	ID              uuid.UUID
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+	Email           string
+	Password        string
+	IsChirpyRed     bool
+	IsModerator     bool
+	HideSensitive   bool
+	Handle          string
+	DisplayName     string
+	Bio             string
+	AvatarUrl       string
+	EmailVerifiedAt sql.NullTime
+	TokenVersion    int32
+	SuspendedAt     sql.NullTime
+}
+
+type UserIdentity struct {
+	Issuer      string
+	Subject     string
+	UserID      uuid.UUID
 	Email       string
-	Password    string
-	IsChirpyRed bool
+	CreatedAt   time.Time
+	LastLoginAt time.Time
+}
+
+type WebauthnChallenge struct {
+	Challenge string
+	Ceremony  string
+	UserID    uuid.NullUUID
+	CreatedAt time.Time
+	ExpiresAt time.Time
 }
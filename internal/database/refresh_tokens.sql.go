@@ -13,73 +13,107 @@ import (
 )
 
 const createToken = `-- name: CreateToken :one
-INSERT INTO refresh_tokens (token, created_at, updated_at, user_id, expires_at)
+INSERT INTO refresh_tokens (token_hash, created_at, updated_at, user_id, family_id, expires_at)
 VALUES (
     $1,
     NOW(),
     NOW(),
     $2,
-    $3
+    $3,
+    $4
 )
-RETURNING token, created_at, updated_at, user_id, expires_at, revoked_at
+RETURNING token_hash, created_at, updated_at, user_id, expires_at, revoked_at, family_id, rotated_at
 `
 
 type CreateTokenParams struct {
-	Token     string
+	TokenHash string
 	UserID    uuid.UUID
+	FamilyID  uuid.UUID
 	ExpiresAt time.Time
 }
 
 func (q *Queries) CreateToken(ctx context.Context, arg CreateTokenParams) (RefreshToken, error) {
-	row := q.db.QueryRowContext(ctx, createToken, arg.Token, arg.UserID, arg.ExpiresAt)
+	row := q.db.QueryRowContext(ctx, createToken,
+		arg.TokenHash,
+		arg.UserID,
+		arg.FamilyID,
+		arg.ExpiresAt,
+	)
 	var i RefreshToken
 	err := row.Scan(
-		&i.Token,
+		&i.TokenHash,
 		&i.CreatedAt,
 		&i.UpdatedAt,
 		&i.UserID,
 		&i.ExpiresAt,
 		&i.RevokedAt,
+		&i.FamilyID,
+		&i.RotatedAt,
 	)
 	return i, err
 }
 
 const getToken = `-- name: GetToken :one
-SELECT token, created_at, updated_at, user_id, expires_at, revoked_at FROM refresh_tokens WHERE token = $1
+SELECT token_hash, created_at, updated_at, user_id, expires_at, revoked_at, family_id, rotated_at FROM refresh_tokens WHERE token_hash = $1
 `
 
-func (q *Queries) GetToken(ctx context.Context, token string) (RefreshToken, error) {
-	row := q.db.QueryRowContext(ctx, getToken, token)
+func (q *Queries) GetToken(ctx context.Context, tokenHash string) (RefreshToken, error) {
+	row := q.db.QueryRowContext(ctx, getToken, tokenHash)
 	var i RefreshToken
 	err := row.Scan(
-		&i.Token,
+		&i.TokenHash,
 		&i.CreatedAt,
 		&i.UpdatedAt,
 		&i.UserID,
 		&i.ExpiresAt,
 		&i.RevokedAt,
+		&i.FamilyID,
+		&i.RotatedAt,
 	)
 	return i, err
 }
 
-const revokeToken = `-- name: RevokeToken :one
-UPDATE refresh_tokens 
-SET 
+const revokeTokenFamily = `-- name: RevokeTokenFamily :execrows
+UPDATE refresh_tokens
+SET
   revoked_at = NOW(),
   updated_at = NOW()
-WHERE token = $1 RETURNING token, created_at, updated_at, user_id, expires_at, revoked_at
+WHERE family_id = $1 AND revoked_at IS NULL
 `
 
-func (q *Queries) RevokeToken(ctx context.Context, token string) (RefreshToken, error) {
-	row := q.db.QueryRowContext(ctx, revokeToken, token)
-	var i RefreshToken
-	err := row.Scan(
-		&i.Token,
-		&i.CreatedAt,
-		&i.UpdatedAt,
-		&i.UserID,
-		&i.ExpiresAt,
-		&i.RevokedAt,
-	)
-	return i, err
+func (q *Queries) RevokeTokenFamily(ctx context.Context, familyID uuid.UUID) (int64, error) {
+	result, err := q.db.ExecContext(ctx, revokeTokenFamily, familyID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const revokeUserTokens = `-- name: RevokeUserTokens :exec
+UPDATE refresh_tokens
+SET
+  revoked_at = NOW(),
+  updated_at = NOW()
+WHERE user_id = $1 AND revoked_at IS NULL
+`
+
+func (q *Queries) RevokeUserTokens(ctx context.Context, userID uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, revokeUserTokens, userID)
+	return err
+}
+
+const rotateToken = `-- name: RotateToken :execrows
+UPDATE refresh_tokens
+SET
+  rotated_at = NOW(),
+  updated_at = NOW()
+WHERE token_hash = $1 AND rotated_at IS NULL AND revoked_at IS NULL
+`
+
+func (q *Queries) RotateToken(ctx context.Context, tokenHash string) (int64, error) {
+	result, err := q.db.ExecContext(ctx, rotateToken, tokenHash)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
 }
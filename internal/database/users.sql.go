@@ -7,31 +7,34 @@ package database
 
 import (
 	"context"
+	"database/sql"
 	"time"
 
 	"github.com/google/uuid"
 )
 
 const createUser = `-- name: CreateUser :one
-INSERT INTO users (id, created_at, updated_at, email, password, is_chirpy_red)
+INSERT INTO users (id, created_at, updated_at, email, password, is_chirpy_red, handle)
 VALUES (
     gen_random_uuid(),
     NOW(),
     NOW(),
     $1,
     $2,
-    false
+    false,
+    $3
 )
-RETURNING id, created_at, updated_at, email, password, is_chirpy_red
+RETURNING id, created_at, updated_at, email, password, is_chirpy_red, is_moderator, hide_sensitive, handle, display_name, bio, avatar_url, email_verified_at, token_version, suspended_at
 `
 
 type CreateUserParams struct {
 	Email    string
 	Password string
+	Handle   string
 }
 
 func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, error) {
-	row := q.db.QueryRowContext(ctx, createUser, arg.Email, arg.Password)
+	row := q.db.QueryRowContext(ctx, createUser, arg.Email, arg.Password, arg.Handle)
 	var i User
 	err := row.Scan(
 		&i.ID,
@@ -40,12 +43,30 @@ func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, e
 		&i.Email,
 		&i.Password,
 		&i.IsChirpyRed,
+		&i.IsModerator,
+		&i.HideSensitive,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.AvatarUrl,
+		&i.EmailVerifiedAt,
+		&i.TokenVersion,
+		&i.SuspendedAt,
 	)
 	return i, err
 }
 
+const deleteUser = `-- name: DeleteUser :exec
+DELETE FROM users WHERE id = $1
+`
+
+func (q *Queries) DeleteUser(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, deleteUser, id)
+	return err
+}
+
 const getUser = `-- name: GetUser :one
-SELECT id, created_at, updated_at, email, password, is_chirpy_red FROM users WHERE $1 = email
+SELECT id, created_at, updated_at, email, password, is_chirpy_red, is_moderator, hide_sensitive, handle, display_name, bio, avatar_url, email_verified_at, token_version, suspended_at FROM users WHERE $1 = email
 `
 
 func (q *Queries) GetUser(ctx context.Context, email string) (User, error) {
@@ -58,12 +79,75 @@ func (q *Queries) GetUser(ctx context.Context, email string) (User, error) {
 		&i.Email,
 		&i.Password,
 		&i.IsChirpyRed,
+		&i.IsModerator,
+		&i.HideSensitive,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.AvatarUrl,
+		&i.EmailVerifiedAt,
+		&i.TokenVersion,
+		&i.SuspendedAt,
+	)
+	return i, err
+}
+
+const getUserByHandle = `-- name: GetUserByHandle :one
+SELECT id, created_at, updated_at, email, password, is_chirpy_red, is_moderator, hide_sensitive, handle, display_name, bio, avatar_url, email_verified_at, token_version, suspended_at FROM users WHERE lower(handle) = lower($1)
+`
+
+func (q *Queries) GetUserByHandle(ctx context.Context, handle string) (User, error) {
+	row := q.db.QueryRowContext(ctx, getUserByHandle, handle)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.Password,
+		&i.IsChirpyRed,
+		&i.IsModerator,
+		&i.HideSensitive,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.AvatarUrl,
+		&i.EmailVerifiedAt,
+		&i.TokenVersion,
+		&i.SuspendedAt,
+	)
+	return i, err
+}
+
+const getUserByID = `-- name: GetUserByID :one
+SELECT id, created_at, updated_at, email, password, is_chirpy_red, is_moderator, hide_sensitive, handle, display_name, bio, avatar_url, email_verified_at, token_version, suspended_at FROM users WHERE id = $1
+`
+
+func (q *Queries) GetUserByID(ctx context.Context, id uuid.UUID) (User, error) {
+	row := q.db.QueryRowContext(ctx, getUserByID, id)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.Password,
+		&i.IsChirpyRed,
+		&i.IsModerator,
+		&i.HideSensitive,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.AvatarUrl,
+		&i.EmailVerifiedAt,
+		&i.TokenVersion,
+		&i.SuspendedAt,
 	)
 	return i, err
 }
 
 const getUsers = `-- name: GetUsers :many
-SELECT id, created_at, updated_at, email, password, is_chirpy_red FROM users
+SELECT id, created_at, updated_at, email, password, is_chirpy_red, is_moderator, hide_sensitive, handle, display_name, bio, avatar_url, email_verified_at, token_version, suspended_at FROM users
 `
 
 func (q *Queries) GetUsers(ctx context.Context) ([]User, error) {
@@ -82,6 +166,15 @@ func (q *Queries) GetUsers(ctx context.Context) ([]User, error) {
 			&i.Email,
 			&i.Password,
 			&i.IsChirpyRed,
+			&i.IsModerator,
+			&i.HideSensitive,
+			&i.Handle,
+			&i.DisplayName,
+			&i.Bio,
+			&i.AvatarUrl,
+			&i.EmailVerifiedAt,
+			&i.TokenVersion,
+			&i.SuspendedAt,
 		); err != nil {
 			return nil, err
 		}
@@ -97,7 +190,7 @@ func (q *Queries) GetUsers(ctx context.Context) ([]User, error) {
 }
 
 const resetUsers = `-- name: ResetUsers :many
-DELETE FROM users RETURNING id, created_at, updated_at, email, password, is_chirpy_red
+DELETE FROM users RETURNING id, created_at, updated_at, email, password, is_chirpy_red, is_moderator, hide_sensitive, handle, display_name, bio, avatar_url, email_verified_at, token_version, suspended_at
 `
 
 func (q *Queries) ResetUsers(ctx context.Context) ([]User, error) {
@@ -117,6 +210,15 @@ func (q *Queries) ResetUsers(ctx context.Context) ([]User, error) {
 			&i.Email,
 			&i.Password,
 			&i.IsChirpyRed,
+			&i.IsModerator,
+			&i.HideSensitive,
+			&i.Handle,
+			&i.DisplayName,
+			&i.Bio,
+			&i.AvatarUrl,
+			&i.EmailVerifiedAt,
+			&i.TokenVersion,
+			&i.SuspendedAt,
 		); err != nil {
 			return nil, err
 		}
@@ -131,13 +233,40 @@ func (q *Queries) ResetUsers(ctx context.Context) ([]User, error) {
 	return items, nil
 }
 
+const suspendUser = `-- name: SuspendUser :execrows
+UPDATE users SET suspended_at = NOW(), updated_at = NOW()
+WHERE id = $1 AND suspended_at IS NULL
+`
+
+func (q *Queries) SuspendUser(ctx context.Context, id uuid.UUID) (int64, error) {
+	result, err := q.db.ExecContext(ctx, suspendUser, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const unsuspendUser = `-- name: UnsuspendUser :execrows
+UPDATE users SET suspended_at = NULL, updated_at = NOW()
+WHERE id = $1 AND suspended_at IS NOT NULL
+`
+
+func (q *Queries) UnsuspendUser(ctx context.Context, id uuid.UUID) (int64, error) {
+	result, err := q.db.ExecContext(ctx, unsuspendUser, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
 const updateUser = `-- name: UpdateUser :one
 UPDATE users SET 
 email = $2,
 password = $3,
+email_verified_at = CASE WHEN email = $2 THEN email_verified_at ELSE NULL END,
 updated_at = NOW()
 WHERE id = $1
-RETURNING id, created_at, updated_at, email, is_chirpy_red
+RETURNING id, created_at, updated_at, email, is_chirpy_red, handle, email_verified_at
 `
 
 type UpdateUserParams struct {
@@ -147,11 +276,13 @@ type UpdateUserParams struct {
 }
 
 type UpdateUserRow struct {
-	ID          uuid.UUID
-	CreatedAt   time.Time
-	UpdatedAt   time.Time
-	Email       string
-	IsChirpyRed bool
+	ID              uuid.UUID
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+	Email           string
+	IsChirpyRed     bool
+	Handle          string
+	EmailVerifiedAt sql.NullTime
 }
 
 func (q *Queries) UpdateUser(ctx context.Context, arg UpdateUserParams) (UpdateUserRow, error) {
@@ -163,6 +294,87 @@ func (q *Queries) UpdateUser(ctx context.Context, arg UpdateUserParams) (UpdateU
 		&i.UpdatedAt,
 		&i.Email,
 		&i.IsChirpyRed,
+		&i.Handle,
+		&i.EmailVerifiedAt,
+	)
+	return i, err
+}
+
+const updateUserPassword = `-- name: UpdateUserPassword :exec
+UPDATE users
+SET password = $2, updated_at = NOW()
+WHERE id = $1
+`
+
+type UpdateUserPasswordParams struct {
+	ID       uuid.UUID
+	Password string
+}
+
+func (q *Queries) UpdateUserPassword(ctx context.Context, arg UpdateUserPasswordParams) error {
+	_, err := q.db.ExecContext(ctx, updateUserPassword, arg.ID, arg.Password)
+	return err
+}
+
+const updateUserPreferences = `-- name: UpdateUserPreferences :exec
+UPDATE users SET hide_sensitive = $2, updated_at = NOW() WHERE id = $1
+`
+
+type UpdateUserPreferencesParams struct {
+	ID            uuid.UUID
+	HideSensitive bool
+}
+
+func (q *Queries) UpdateUserPreferences(ctx context.Context, arg UpdateUserPreferencesParams) error {
+	_, err := q.db.ExecContext(ctx, updateUserPreferences, arg.ID, arg.HideSensitive)
+	return err
+}
+
+const updateUserProfile = `-- name: UpdateUserProfile :one
+UPDATE users
+SET
+  handle = $2,
+  display_name = $3,
+  bio = $4,
+  avatar_url = $5,
+  updated_at = NOW()
+WHERE id = $1
+RETURNING id, created_at, updated_at, email, password, is_chirpy_red, is_moderator, hide_sensitive, handle, display_name, bio, avatar_url, email_verified_at, token_version, suspended_at
+`
+
+type UpdateUserProfileParams struct {
+	ID          uuid.UUID
+	Handle      string
+	DisplayName string
+	Bio         string
+	AvatarUrl   string
+}
+
+func (q *Queries) UpdateUserProfile(ctx context.Context, arg UpdateUserProfileParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, updateUserProfile,
+		arg.ID,
+		arg.Handle,
+		arg.DisplayName,
+		arg.Bio,
+		arg.AvatarUrl,
+	)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.Password,
+		&i.IsChirpyRed,
+		&i.IsModerator,
+		&i.HideSensitive,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.AvatarUrl,
+		&i.EmailVerifiedAt,
+		&i.TokenVersion,
+		&i.SuspendedAt,
 	)
 	return i, err
 }
@@ -176,3 +388,20 @@ func (q *Queries) UpgradeUser(ctx context.Context, id uuid.UUID) (uuid.UUID, err
 	err := row.Scan(&id)
 	return id, err
 }
+
+const verifyUserEmail = `-- name: VerifyUserEmail :execrows
+UPDATE users SET email_verified_at = NOW(), updated_at = NOW() WHERE id = $1 AND email = $2
+`
+
+type VerifyUserEmailParams struct {
+	ID    uuid.UUID
+	Email string
+}
+
+func (q *Queries) VerifyUserEmail(ctx context.Context, arg VerifyUserEmailParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, verifyUserEmail, arg.ID, arg.Email)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
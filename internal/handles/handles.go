@@ -0,0 +1,66 @@
+// Package handles decides which @handles users may pick. Handles are
+// unique regardless of case; the spelling a user chose is kept for display.
+package handles
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+const (
+	MinLength = 3
+	MaxLength = 30
+
+	// generatedPrefix marks handles handed out to accounts that never
+	// picked one. Nobody may choose a handle with it.
+	generatedPrefix = "user_"
+)
+
+var (
+	ErrLength   = errors.New("handle must be 3 to 30 characters")
+	ErrChars    = errors.New("handle must start with a letter and contain only letters, digits and underscores")
+	ErrReserved = errors.New("handle is reserved")
+
+	pattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*$`)
+)
+
+// reserved names collide with routes, staff roles or words that would
+// let someone impersonate the site.
+var reserved = map[string]bool{
+	"about": true, "admin": true, "administrator": true, "ap": true,
+	"api": true, "app": true, "chirpy": true, "everyone": true,
+	"help": true, "here": true, "login": true, "logout": true,
+	"me": true, "mod": true, "moderator": true, "null": true,
+	"official": true, "root": true, "security": true, "settings": true,
+	"signup": true, "staff": true, "support": true, "system": true,
+	"undefined": true,
+}
+
+// Validate reports why h can't be chosen as a handle, or nil.
+func Validate(h string) error {
+	if len(h) < MinLength || len(h) > MaxLength {
+		return ErrLength
+	}
+	if !pattern.MatchString(h) {
+		return ErrChars
+	}
+	lower := Normalize(h)
+	if reserved[lower] || strings.HasPrefix(lower, generatedPrefix) {
+		return ErrReserved
+	}
+	return nil
+}
+
+// Normalize is the form handles are compared in.
+func Normalize(h string) string {
+	return strings.ToLower(strings.TrimPrefix(h, "@"))
+}
+
+// Generated returns a placeholder handle for an account that hasn't
+// picked one.
+func Generated() string {
+	return generatedPrefix + strings.ReplaceAll(uuid.NewString(), "-", "")[:12]
+}
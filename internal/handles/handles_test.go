@@ -0,0 +1,42 @@
+package handles
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		handle string
+		want   error
+	}{
+		{"alice", nil},
+		{"Bob_99", nil},
+		{"ab", ErrLength},
+		{"a123456789012345678901234567890", ErrLength},
+		{"9lives", ErrChars},
+		{"_alice", ErrChars},
+		{"al-ice", ErrChars},
+		{"älice", ErrChars},
+		{"Admin", ErrReserved},
+		{"SUPPORT", ErrReserved},
+		{"user_1234", ErrReserved},
+	}
+	for _, tt := range tests {
+		t.Run(tt.handle, func(t *testing.T) {
+			assert.Equal(t, tt.want, Validate(tt.handle))
+		})
+	}
+}
+
+func TestGenerated(t *testing.T) {
+	h := Generated()
+	assert.Len(t, h, len("user_")+12)
+	assert.Equal(t, ErrReserved, Validate(h))
+	assert.NotEqual(t, h, Generated())
+}
+
+func TestNormalize(t *testing.T) {
+	assert.Equal(t, "alice", Normalize("@Alice"))
+}
@@ -0,0 +1,96 @@
+// Package lockout decides when login attempts are allowed after failed
+// ones. Storing the failures is up to the caller.
+package lockout
+
+import "time"
+
+// Policy slows down password guessing. The first FreeFailures attempts in
+// a Window cost nothing; after that each failure doubles the wait before
+// the next attempt, from BaseDelay up to MaxDelay. MaxFailures locks the
+// account for Lockout. IPMaxFailures turns away an address that's trying
+// many accounts.
+type Policy struct {
+	FreeFailures  int
+	MaxFailures   int
+	IPMaxFailures int
+	Window        time.Duration
+	Lockout       time.Duration
+	BaseDelay     time.Duration
+	MaxDelay      time.Duration
+}
+
+func DefaultPolicy() Policy {
+	return Policy{
+		FreeFailures:  3,
+		MaxFailures:   10,
+		IPMaxFailures: 100,
+		Window:        15 * time.Minute,
+		Lockout:       15 * time.Minute,
+		BaseDelay:     time.Second,
+		MaxDelay:      30 * time.Second,
+	}
+}
+
+// Record is the failures counted against one account or address.
+type Record struct {
+	Failures      int
+	FirstFailedAt time.Time
+	LastFailedAt  time.Time
+	LockedUntil   time.Time
+}
+
+func (p Policy) expired(r Record, now time.Time) bool {
+	return now.Sub(r.FirstFailedAt) >= p.Window
+}
+
+// Delay is how long to wait after the failures-th failure.
+func (p Policy) Delay(failures int) time.Duration {
+	n := failures - p.FreeFailures
+	if n <= 0 {
+		return 0
+	}
+	delay := p.BaseDelay
+	for i := 1; i < n && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, p.MaxDelay)
+}
+
+// RetryAfter is how long until an account may try again, or 0 if it can
+// now.
+func (p Policy) RetryAfter(r Record, now time.Time) time.Duration {
+	if now.Before(r.LockedUntil) {
+		return r.LockedUntil.Sub(now)
+	}
+	if p.expired(r, now) {
+		return 0
+	}
+	return max(r.LastFailedAt.Add(p.Delay(r.Failures)).Sub(now), 0)
+}
+
+// Admit reports whether an attempt on an account may go ahead, given r
+// with that attempt already counted. Counting before checking means
+// attempts racing each other can't all get in under MaxFailures.
+func (p Policy) Admit(r Record, now time.Time) bool {
+	return !now.Before(r.LockedUntil) && r.Failures <= p.MaxFailures
+}
+
+// IPAdmit is Admit for an address.
+func (p Policy) IPAdmit(r Record) bool {
+	return r.Failures <= p.IPMaxFailures
+}
+
+// ShouldLock reports whether an account with r's failures gets locked.
+func (p Policy) ShouldLock(r Record) bool {
+	return r.Failures >= p.MaxFailures
+}
+
+// IPRetryAfter is RetryAfter for an address. Addresses aren't slowed down
+// gradually, since many people can share one; they're only stopped once
+// they reach IPMaxFailures, until the window is over.
+func (p Policy) IPRetryAfter(r Record, now time.Time) time.Duration {
+	if p.expired(r, now) || r.Failures < p.IPMaxFailures {
+		return 0
+	}
+	return r.FirstFailedAt.Add(p.Window).Sub(now)
+}
@@ -0,0 +1,73 @@
+package lockout
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDelay(t *testing.T) {
+	p := DefaultPolicy()
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{3, 0},
+		{4, time.Second},
+		{5, 2 * time.Second},
+		{8, 16 * time.Second},
+		{9, 30 * time.Second},
+		{1000, 30 * time.Second},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, p.Delay(tt.failures), "failures=%d", tt.failures)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	p := DefaultPolicy()
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	assert.Zero(t, p.RetryAfter(Record{}, now), "No failures, no wait")
+
+	r := Record{Failures: 5, FirstFailedAt: now.Add(-time.Minute), LastFailedAt: now.Add(-time.Second)}
+	assert.Equal(t, time.Second, p.RetryAfter(r, now))
+	assert.Zero(t, p.RetryAfter(r, now.Add(time.Second)))
+
+	r.FirstFailedAt = now.Add(-p.Window)
+	assert.Zero(t, p.RetryAfter(r, now), "Failures outside the window are forgotten")
+
+	locked := Record{LockedUntil: now.Add(10 * time.Minute)}
+	assert.Equal(t, 10*time.Minute, p.RetryAfter(locked, now))
+	assert.Zero(t, p.RetryAfter(locked, now.Add(10*time.Minute)))
+
+	assert.False(t, p.ShouldLock(Record{Failures: 9}))
+	assert.True(t, p.ShouldLock(Record{Failures: 10}))
+}
+
+func TestIPRetryAfter(t *testing.T) {
+	p := DefaultPolicy()
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	r := Record{Failures: 99, FirstFailedAt: now.Add(-time.Minute), LastFailedAt: now}
+	assert.Zero(t, p.IPRetryAfter(r, now), "Below the limit addresses aren't slowed")
+	r.Failures = 100
+	assert.Equal(t, p.Window-time.Minute, p.IPRetryAfter(r, now))
+	assert.Zero(t, p.IPRetryAfter(r, now.Add(p.Window)))
+}
+
+func TestAdmit(t *testing.T) {
+	p := DefaultPolicy()
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	assert.True(t, p.Admit(Record{Failures: p.MaxFailures}, now), "The last allowed attempt gets in")
+	assert.False(t, p.Admit(Record{Failures: p.MaxFailures + 1}, now), "Attempts past the limit are turned away")
+	assert.False(t, p.Admit(Record{Failures: 1, LockedUntil: now.Add(time.Minute)}, now),
+		"An attempt counted after a lock is turned away")
+	assert.True(t, p.Admit(Record{Failures: 1, LockedUntil: now}, now))
+
+	assert.True(t, p.IPAdmit(Record{Failures: p.IPMaxFailures}))
+	assert.False(t, p.IPAdmit(Record{Failures: p.IPMaxFailures + 1}))
+}
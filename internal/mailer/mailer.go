@@ -0,0 +1,116 @@
+// Package mailer sends plain-text email. SMTP delivers for real; Outbox
+// writes each message to a directory so development needs no mail server.
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+var ErrHeaderInjection = errors.New("header values must not contain line breaks")
+
+// SMTP sends through a relay. Username may be empty for relays that don't
+// need authentication.
+type SMTP struct {
+	Addr     string
+	Username string
+	Password string
+	From     string
+}
+
+func (m *SMTP) Send(ctx context.Context, msg Message) error {
+	data, err := Format(m.From, msg, time.Now())
+	if err != nil {
+		return err
+	}
+	var auth smtp.Auth
+	if m.Username != "" {
+		host, _, _ := strings.Cut(m.Addr, ":")
+		auth = smtp.PlainAuth("", m.Username, m.Password, host)
+	}
+	done := make(chan error, 1)
+	go func() { done <- smtp.SendMail(m.Addr, auth, m.From, []string{msg.To}, data) }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Outbox writes every message to Dir as an .eml file.
+type Outbox struct {
+	Dir  string
+	From string
+}
+
+func (m *Outbox) Send(ctx context.Context, msg Message) error {
+	now := time.Now()
+	data, err := Format(m.From, msg, now)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(m.Dir, 0o700); err != nil {
+		return err
+	}
+	name := now.UTC().Format("20060102T150405.000000000Z") + "-" + randomHex(4) + ".eml"
+	return os.WriteFile(filepath.Join(m.Dir, name), data, 0o600)
+}
+
+// Format renders msg as an RFC 5322 message.
+func Format(from string, msg Message, now time.Time) ([]byte, error) {
+	for _, v := range []string{from, msg.To, msg.Subject} {
+		if strings.ContainsAny(v, "\r\n") {
+			return nil, ErrHeaderInjection
+		}
+	}
+	if _, err := mail.ParseAddress(msg.To); err != nil {
+		return nil, fmt.Errorf("invalid recipient: %w", err)
+	}
+	domain := "localhost"
+	if addr, err := mail.ParseAddress(from); err == nil {
+		if _, d, ok := strings.Cut(addr.Address, "@"); ok {
+			domain = d
+		}
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	fmt.Fprintf(&b, "Message-ID: <%s@%s>\r\n", randomHex(16), domain)
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(strings.ReplaceAll(msg.Body, "\r\n", "\n"), "\n", "\r\n"))
+	return b.Bytes(), nil
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
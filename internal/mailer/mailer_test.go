@@ -0,0 +1,51 @@
+package mailer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormat(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	msg := Message{To: "bob@example.com", Subject: "Héllo", Body: "line one\nline two"}
+	data, err := Format("Chirpy <no-reply@chirpy.test>", msg, now)
+	require.NoError(t, err)
+
+	s := string(data)
+	assert.Contains(t, s, "From: Chirpy <no-reply@chirpy.test>\r\n")
+	assert.Contains(t, s, "To: bob@example.com\r\n")
+	assert.Contains(t, s, "Subject: =?utf-8?q?H=C3=A9llo?=\r\n")
+	assert.Contains(t, s, "Date: Wed, 01 May 2024 12:00:00 +0000\r\n")
+	assert.Contains(t, s, "@chirpy.test>\r\n")
+	assert.True(t, strings.HasSuffix(s, "\r\n\r\nline one\r\nline two"))
+}
+
+func TestFormatRejectsInjection(t *testing.T) {
+	_, err := Format("a@b.c", Message{To: "bob@example.com\r\nBcc: eve@example.com", Subject: "x"}, time.Now())
+	assert.Equal(t, ErrHeaderInjection, err)
+	_, err = Format("a@b.c", Message{To: "bob@example.com", Subject: "x\nBcc: eve@example.com"}, time.Now())
+	assert.Equal(t, ErrHeaderInjection, err)
+	_, err = Format("a@b.c", Message{To: "not an address", Subject: "x"}, time.Now())
+	assert.Error(t, err)
+}
+
+func TestOutbox(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "outbox")
+	m := &Outbox{Dir: dir, From: "no-reply@chirpy.test"}
+	require.NoError(t, m.Send(context.Background(), Message{To: "bob@example.com", Subject: "One", Body: "1"}))
+	require.NoError(t, m.Send(context.Background(), Message{To: "bob@example.com", Subject: "Two", Body: "2"}))
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	data, err := os.ReadFile(filepath.Join(dir, entries[0].Name()))
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "Subject: One")
+}
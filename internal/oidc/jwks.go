@@ -0,0 +1,96 @@
+package oidc
+
+import (
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+)
+
+// jwkSet is a JSON Web Key Set (RFC 7517) as providers publish them.
+type jwkSet struct {
+	Keys []jwk `json:"keys"`
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// signingKeys returns the usable signing keys by key ID. Keys meant for
+// encryption, and ones that can't be parsed, are skipped rather than
+// failing the whole set.
+func (s jwkSet) signingKeys() map[string]any {
+	keys := make(map[string]any, len(s.Keys))
+	for _, k := range s.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		if key := k.publicKey(); key != nil {
+			keys[k.Kid] = key
+		}
+	}
+	return keys
+}
+
+func (k jwk) publicKey() any {
+	switch k.Kty {
+	case "RSA":
+		n, e := decodeInt(k.N), decodeInt(k.E)
+		if n == nil || e == nil || n.BitLen() < 2048 || !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+			return nil
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}
+
+	case "EC":
+		var curve elliptic.Curve
+		var check ecdh.Curve
+		switch k.Crv {
+		case "P-256":
+			curve, check = elliptic.P256(), ecdh.P256()
+		case "P-384":
+			curve, check = elliptic.P384(), ecdh.P384()
+		case "P-521":
+			curve, check = elliptic.P521(), ecdh.P521()
+		default:
+			return nil
+		}
+		x, y := decodeInt(k.X), decodeInt(k.Y)
+		size := (curve.Params().BitSize + 7) / 8
+		if x == nil || y == nil || x.BitLen() > size*8 || y.BitLen() > size*8 {
+			return nil
+		}
+		// Let crypto/ecdh reject points that aren't on the curve
+		point := append([]byte{4}, x.FillBytes(make([]byte, size))...)
+		point = append(point, y.FillBytes(make([]byte, size))...)
+		if _, err := check.NewPublicKey(point); err != nil {
+			return nil
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+
+	case "OKP":
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if k.Crv != "Ed25519" || err != nil || len(x) != ed25519.PublicKeySize {
+			return nil
+		}
+		return ed25519.PublicKey(x)
+	}
+	return nil
+}
+
+func decodeInt(s string) *big.Int {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil
+	}
+	return new(big.Int).SetBytes(b)
+}
@@ -0,0 +1,319 @@
+// Package oidc signs people in with an external OpenID Connect provider:
+// discovery, the authorization code flow with PKCE, and ID token checks
+// against the provider's published keys. Keeping track of state between
+// the redirect and the callback is left to the caller.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Scopes are requested on every login. email is what accounts are linked
+// by.
+var Scopes = []string{"openid", "email", "profile"}
+
+var (
+	ErrDiscovery = errors.New("provider discovery failed")
+	ErrExchange  = errors.New("code exchange failed")
+	ErrIDToken   = errors.New("invalid ID token")
+)
+
+// signingMethods are the ID token algorithms accepted when the provider
+// doesn't say which it uses. "none" and the HMAC family never are; Chirpy
+// has no way to check an HMAC keyed with its client secret against a key
+// set, and nothing unsigned is trusted.
+var signingMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+
+// keyRefreshInterval limits how often an unknown key ID makes the key set
+// be fetched again, so forged tokens can't be used to hammer the provider.
+const keyRefreshInterval = time.Minute
+
+// Provider is one identity provider Chirpy is registered with. Discovery
+// and key fetching happen on first use, so a provider that's down when the
+// server starts doesn't stop it starting.
+type Provider struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Client       *http.Client
+
+	mu          sync.Mutex
+	meta        *metadata
+	keys        map[string]any
+	keysFetched time.Time
+}
+
+type metadata struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	JWKSURI               string   `json:"jwks_uri"`
+	SigningAlgs           []string `json:"id_token_signing_alg_values_supported"`
+}
+
+// Claims are the parts of a verified ID token Chirpy uses.
+type Claims struct {
+	Issuer        string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// NewVerifier returns a PKCE code verifier and its S256 challenge.
+func NewVerifier() (verifier, challenge string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	verifier = base64.RawURLEncoding.EncodeToString(b)
+	sum := sha256.Sum256([]byte(verifier))
+	return verifier, base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// AuthCodeURL is where to send the browser to log in. state and nonce
+// should be fresh random values; challenge comes from NewVerifier.
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, challenge string) (string, error) {
+	meta, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	u, err := url.Parse(meta.AuthorizationEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("%w: bad authorization endpoint", ErrDiscovery)
+	}
+	q := u.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", p.ClientID)
+	q.Set("redirect_uri", p.RedirectURL)
+	q.Set("scope", strings.Join(Scopes, " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", challenge)
+	q.Set("code_challenge_method", "S256")
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// Exchange trades the code the provider sent back for an ID token and
+// verifies it. nonce is the one sent with the authorization request.
+func (p *Provider) Exchange(ctx context.Context, code, verifier, nonce string) (Claims, error) {
+	meta, err := p.discover(ctx)
+	if err != nil {
+		return Claims{}, err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.RedirectURL},
+		"code_verifier": {verifier},
+		"client_id":     {p.ClientID},
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", meta.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Claims{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.ClientSecret != "" {
+		// RFC 6749 wants both halves form-encoded before they're joined
+		req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+	}
+	resp, err := p.client().Do(req)
+	if err != nil {
+		return Claims{}, fmt.Errorf("%w: %v", ErrExchange, err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body); err != nil {
+		return Claims{}, fmt.Errorf("%w: status %d", ErrExchange, resp.StatusCode)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return Claims{}, fmt.Errorf("%w: %s %s", ErrExchange, body.Error, body.ErrorDescription)
+	}
+	if body.IDToken == "" {
+		return Claims{}, fmt.Errorf("%w: no ID token in response", ErrExchange)
+	}
+	return p.Verify(ctx, body.IDToken, nonce)
+}
+
+// idTokenClaims covers the standard claims Chirpy checks. email_verified
+// is a boolean by the spec, but some providers send it as a string.
+type idTokenClaims struct {
+	jwt.RegisteredClaims
+	Nonce         string       `json:"nonce"`
+	AuthorizedFor string       `json:"azp"`
+	Email         string       `json:"email"`
+	EmailVerified flexibleBool `json:"email_verified"`
+	Name          string       `json:"name"`
+}
+
+type flexibleBool bool
+
+func (b *flexibleBool) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		v, err := strconv.ParseBool(s)
+		*b = flexibleBool(v && err == nil)
+		return nil
+	}
+	var v bool
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*b = flexibleBool(v)
+	return nil
+}
+
+// Verify checks an ID token's signature, issuer, audience, expiry and
+// nonce (OpenID Connect Core 3.1.3.7).
+func (p *Provider) Verify(ctx context.Context, raw, nonce string) (Claims, error) {
+	meta, err := p.discover(ctx)
+	if err != nil {
+		return Claims{}, err
+	}
+	methods := signingMethods
+	if len(meta.SigningAlgs) > 0 {
+		methods = slices.DeleteFunc(slices.Clone(meta.SigningAlgs), func(alg string) bool {
+			return !slices.Contains(signingMethods, alg)
+		})
+	}
+
+	var claims idTokenClaims
+	_, err = jwt.ParseWithClaims(raw, &claims, func(t *jwt.Token) (any, error) {
+		kid, _ := t.Header["kid"].(string)
+		return p.key(ctx, kid)
+	},
+		jwt.WithValidMethods(methods),
+		jwt.WithIssuer(p.Issuer),
+		jwt.WithAudience(p.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return Claims{}, fmt.Errorf("%w: %v", ErrIDToken, err)
+	}
+	if claims.Subject == "" {
+		return Claims{}, fmt.Errorf("%w: no subject", ErrIDToken)
+	}
+	// A token meant for several clients has to name Chirpy as the one it
+	// was issued to
+	if len(claims.Audience) > 1 && claims.AuthorizedFor != p.ClientID {
+		return Claims{}, fmt.Errorf("%w: issued to another client", ErrIDToken)
+	}
+	if nonce == "" || claims.Nonce != nonce {
+		return Claims{}, fmt.Errorf("%w: nonce mismatch", ErrIDToken)
+	}
+	return Claims{
+		Issuer:        claims.Issuer,
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: bool(claims.EmailVerified),
+		Name:          claims.Name,
+	}, nil
+}
+
+func (p *Provider) client() *http.Client {
+	if p.Client != nil {
+		return p.Client
+	}
+	return http.DefaultClient
+}
+
+// discover fetches the provider's metadata once. The issuer it reports has
+// to be exactly the one configured, or tokens could be accepted from
+// somewhere else.
+func (p *Provider) discover(ctx context.Context) (*metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.meta != nil {
+		return p.meta, nil
+	}
+	var meta metadata
+	if err := p.getJSON(ctx, strings.TrimRight(p.Issuer, "/")+"/.well-known/openid-configuration", &meta); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDiscovery, err)
+	}
+	if meta.Issuer != p.Issuer {
+		return nil, fmt.Errorf("%w: provider calls itself %q", ErrDiscovery, meta.Issuer)
+	}
+	if meta.AuthorizationEndpoint == "" || meta.TokenEndpoint == "" || meta.JWKSURI == "" {
+		return nil, fmt.Errorf("%w: missing endpoints", ErrDiscovery)
+	}
+	p.meta = &meta
+	return p.meta, nil
+}
+
+// key finds the provider's signing key with kid, fetching the key set
+// again if it isn't known; providers rotate keys without warning.
+func (p *Provider) key(ctx context.Context, kid string) (any, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if key, ok := p.lookupKey(kid); ok {
+		return key, nil
+	}
+	if time.Since(p.keysFetched) < keyRefreshInterval {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+	var set jwkSet
+	if err := p.getJSON(ctx, p.meta.JWKSURI, &set); err != nil {
+		return nil, fmt.Errorf("fetching keys: %v", err)
+	}
+	p.keys = set.signingKeys()
+	p.keysFetched = time.Now()
+	if key, ok := p.lookupKey(kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown key %q", kid)
+}
+
+// lookupKey allows a token without a key ID only when the provider has a
+// single key, as the spec does.
+func (p *Provider) lookupKey(kid string) (any, bool) {
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key, true
+		}
+	}
+	key, ok := p.keys[kid]
+	return key, ok
+}
+
+func (p *Provider) getJSON(ctx context.Context, target string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", target, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := p.client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: status %d", target, resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
@@ -0,0 +1,316 @@
+package oidc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mockIdP is a small OpenID provider: discovery, a key set, an authorize
+// endpoint that logs in whoever asks, and a token endpoint that checks
+// PKCE and the client secret.
+type mockIdP struct {
+	*httptest.Server
+	clientID string
+	secret   string
+
+	mu     sync.Mutex
+	kid    string
+	key    *ecdsa.PrivateKey
+	codes  map[string]pendingCode
+	user   jwt.MapClaims
+	issuer string // what discovery reports, if not the server's own URL
+}
+
+type pendingCode struct {
+	challenge, nonce, redirectURI string
+}
+
+func newMockIdP(t *testing.T) *mockIdP {
+	idp := &mockIdP{clientID: "chirpy", secret: "s3cret", codes: map[string]pendingCode{}}
+	idp.rotate(t)
+	idp.user = jwt.MapClaims{"sub": "alice-123", "email": "alice@corp.test", "email_verified": true, "name": "Alice"}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		issuer := idp.URL
+		if idp.issuer != "" {
+			issuer = idp.issuer
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"issuer":                                issuer,
+			"authorization_endpoint":                idp.URL + "/authorize",
+			"token_endpoint":                        idp.URL + "/token",
+			"jwks_uri":                              idp.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"ES256"},
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		idp.mu.Lock()
+		defer idp.mu.Unlock()
+		pub := idp.key.PublicKey
+		_ = json.NewEncoder(w).Encode(map[string]any{"keys": []any{
+			map[string]string{"kty": "RSA", "use": "enc", "kid": "enc", "n": "AQAB", "e": "AQAB"},
+			map[string]string{
+				"kty": "EC", "use": "sig", "kid": idp.kid, "crv": "P-256",
+				"x": b64(pub.X.FillBytes(make([]byte, 32))),
+				"y": b64(pub.Y.FillBytes(make([]byte, 32))),
+			},
+		}})
+	})
+	mux.HandleFunc("GET /authorize", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("client_id") != idp.clientID || q.Get("code_challenge_method") != "S256" || q.Get("response_type") != "code" {
+			http.Error(w, "bad request", 400)
+			return
+		}
+		code := rand.Text()
+		idp.mu.Lock()
+		idp.codes[code] = pendingCode{q.Get("code_challenge"), q.Get("nonce"), q.Get("redirect_uri")}
+		idp.mu.Unlock()
+		target := q.Get("redirect_uri") + "?" + url.Values{"code": {code}, "state": {q.Get("state")}}.Encode()
+		http.Redirect(w, r, target, http.StatusFound)
+	})
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		id, secret, _ := r.BasicAuth()
+		idp.mu.Lock()
+		pending, ok := idp.codes[r.FormValue("code")]
+		delete(idp.codes, r.FormValue("code"))
+		idp.mu.Unlock()
+		sum := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+		switch {
+		case id != idp.clientID || secret != idp.secret:
+			w.WriteHeader(401)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+			return
+		case !ok || pending.redirectURI != r.FormValue("redirect_uri") || b64(sum[:]) != pending.challenge:
+			w.WriteHeader(400)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		claims := jwt.MapClaims{"nonce": pending.nonce}
+		_ = json.NewEncoder(w).Encode(map[string]string{"id_token": idp.sign(t, claims), "token_type": "Bearer", "access_token": "x"})
+	})
+	idp.Server = httptest.NewServer(mux)
+	t.Cleanup(idp.Close)
+	return idp
+}
+
+func (idp *mockIdP) rotate(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	idp.key, idp.kid = key, rand.Text()
+}
+
+// sign issues an ID token for the current user with the defaults a real
+// provider would set. extra overrides any of them, and a nil value drops
+// the claim.
+func (idp *mockIdP) sign(t *testing.T, extra jwt.MapClaims) string {
+	now := time.Now()
+	claims := jwt.MapClaims{"iss": idp.URL, "aud": idp.clientID, "iat": now.Unix(), "exp": now.Add(5 * time.Minute).Unix()}
+	for k, v := range idp.user {
+		claims[k] = v
+	}
+	for k, v := range extra {
+		if v == nil {
+			delete(claims, k)
+		} else {
+			claims[k] = v
+		}
+	}
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	token.Header["kid"] = idp.kid
+	signed, err := token.SignedString(idp.key)
+	require.NoError(t, err)
+	return signed
+}
+
+func (idp *mockIdP) provider() *Provider {
+	return &Provider{
+		Issuer:       idp.URL,
+		ClientID:     idp.clientID,
+		ClientSecret: idp.secret,
+		RedirectURL:  "https://chirpy.test/api/login/oidc/callback",
+		Client:       idp.Client(),
+	}
+}
+
+// login drives the browser's part: follow the authorization URL and pick
+// the code out of the redirect back.
+func login(t *testing.T, idp *mockIdP, p *Provider, state, nonce, challenge string) string {
+	target, err := p.AuthCodeURL(context.Background(), state, nonce, challenge)
+	require.NoError(t, err)
+	client := idp.Client()
+	client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	resp, err := client.Get(target)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+	back, err := url.Parse(resp.Header.Get("Location"))
+	require.NoError(t, err)
+	assert.Equal(t, state, back.Query().Get("state"))
+	return back.Query().Get("code")
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func TestLoginFlow(t *testing.T) {
+	idp := newMockIdP(t)
+	p := idp.provider()
+	verifier, challenge, err := NewVerifier()
+	require.NoError(t, err)
+
+	code := login(t, idp, p, "state-1", "nonce-1", challenge)
+	claims, err := p.Exchange(context.Background(), code, verifier, "nonce-1")
+	require.NoError(t, err)
+	assert.Equal(t, Claims{Issuer: idp.URL, Subject: "alice-123", Email: "alice@corp.test", EmailVerified: true, Name: "Alice"}, claims)
+
+	// Codes work once
+	_, err = p.Exchange(context.Background(), code, verifier, "nonce-1")
+	assert.ErrorIs(t, err, ErrExchange)
+}
+
+func TestExchangeRejected(t *testing.T) {
+	idp := newMockIdP(t)
+	p := idp.provider()
+	verifier, challenge, _ := NewVerifier()
+
+	// Someone who intercepted the code doesn't have the verifier
+	code := login(t, idp, p, "s", "n", challenge)
+	other, _, _ := NewVerifier()
+	_, err := p.Exchange(context.Background(), code, other, "n")
+	assert.ErrorIs(t, err, ErrExchange)
+
+	// The nonce has to be the one this login sent
+	code = login(t, idp, p, "s", "n", challenge)
+	_, err = p.Exchange(context.Background(), code, verifier, "another")
+	assert.ErrorIs(t, err, ErrIDToken)
+
+	p.ClientSecret = "wrong"
+	code = login(t, idp, p, "s", "n", challenge)
+	_, err = p.Exchange(context.Background(), code, verifier, "n")
+	assert.ErrorIs(t, err, ErrExchange)
+}
+
+func TestVerifyRejects(t *testing.T) {
+	idp := newMockIdP(t)
+	p := idp.provider()
+	ctx := context.Background()
+
+	_, err := p.Verify(ctx, idp.sign(t, jwt.MapClaims{"nonce": "n"}), "n")
+	require.NoError(t, err)
+
+	for name, extra := range map[string]jwt.MapClaims{
+		"wrong audience":        {"aud": "someone-else"},
+		"wrong issuer":          {"iss": "https://evil.test"},
+		"expired":               {"exp": time.Now().Add(-time.Hour).Unix()},
+		"no expiry":             {"exp": nil},
+		"issued in the future":  {"iat": time.Now().Add(time.Hour).Unix()},
+		"shared without azp":    {"aud": []string{"chirpy", "other"}},
+		"shared with other azp": {"aud": []string{"chirpy", "other"}, "azp": "other"},
+		"no subject":            {"sub": ""},
+		"no nonce":              {"nonce": nil},
+	} {
+		if _, ok := extra["nonce"]; !ok {
+			extra["nonce"] = "n"
+		}
+		_, err := p.Verify(ctx, idp.sign(t, extra), "n")
+		assert.ErrorIs(t, err, ErrIDToken, name)
+	}
+
+	// Several audiences are fine when Chirpy is the authorized party
+	_, err = p.Verify(ctx, idp.sign(t, jwt.MapClaims{"nonce": "n", "aud": []string{"chirpy", "other"}, "azp": "chirpy"}), "n")
+	assert.NoError(t, err)
+
+	// Unsigned tokens, and ones "signed" with the client secret
+	claims := jwt.MapClaims{"iss": idp.URL, "aud": "chirpy", "sub": "x", "nonce": "n", "exp": time.Now().Add(time.Hour).Unix()}
+	none, _ := jwt.NewWithClaims(jwt.SigningMethodNone, claims).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	_, err = p.Verify(ctx, none, "n")
+	assert.ErrorIs(t, err, ErrIDToken)
+	hs, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(idp.secret))
+	_, err = p.Verify(ctx, hs, "n")
+	assert.ErrorIs(t, err, ErrIDToken)
+
+	// A key the provider never published
+	stranger, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	forged := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	forged.Header["kid"] = idp.kid
+	signed, _ := forged.SignedString(stranger)
+	_, err = p.Verify(ctx, signed, "n")
+	assert.ErrorIs(t, err, ErrIDToken)
+}
+
+func TestKeyRotation(t *testing.T) {
+	idp := newMockIdP(t)
+	p := idp.provider()
+	ctx := context.Background()
+
+	_, err := p.Verify(ctx, idp.sign(t, jwt.MapClaims{"nonce": "n"}), "n")
+	require.NoError(t, err)
+
+	// A new key isn't fetched again straight away...
+	idp.rotate(t)
+	_, err = p.Verify(ctx, idp.sign(t, jwt.MapClaims{"nonce": "n"}), "n")
+	assert.ErrorIs(t, err, ErrIDToken)
+
+	// ...but is once the refresh interval has passed
+	p.keysFetched = time.Now().Add(-keyRefreshInterval)
+	_, err = p.Verify(ctx, idp.sign(t, jwt.MapClaims{"nonce": "n"}), "n")
+	assert.NoError(t, err)
+}
+
+func TestDiscoveryIssuerMismatch(t *testing.T) {
+	idp := newMockIdP(t)
+	idp.issuer = "https://login.evil.test"
+	_, err := idp.provider().AuthCodeURL(context.Background(), "s", "n", "c")
+	assert.ErrorIs(t, err, ErrDiscovery)
+}
+
+func TestEmailVerifiedAsString(t *testing.T) {
+	idp := newMockIdP(t)
+	p := idp.provider()
+	for raw, want := range map[any]bool{"true": true, "false": false, "yes": false, true: true} {
+		claims, err := p.Verify(context.Background(), idp.sign(t, jwt.MapClaims{"nonce": "n", "email_verified": raw}), "n")
+		require.NoError(t, err, fmt.Sprint(raw))
+		assert.Equal(t, want, claims.EmailVerified, fmt.Sprint(raw))
+	}
+}
+
+func TestJWKParsing(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	set := jwkSet{Keys: []jwk{
+		{Kty: "RSA", Kid: "rsa", N: b64(rsaKey.N.Bytes()), E: b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+		{Kty: "RSA", Kid: "short", N: b64(make([]byte, 64)), E: "AQAB"},
+		{Kty: "EC", Kid: "off-curve", Crv: "P-256", X: b64(make([]byte, 32)), Y: b64(make([]byte, 32))},
+		{Kty: "OKP", Kid: "ed", Crv: "Ed25519", X: b64(make([]byte, 32))},
+	}}
+	keys := set.signingKeys()
+	assert.Contains(t, keys, "rsa")
+	assert.Contains(t, keys, "ed")
+	assert.NotContains(t, keys, "short")
+	assert.NotContains(t, keys, "off-curve")
+}
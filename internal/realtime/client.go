@@ -0,0 +1,151 @@
+package realtime
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	writeWait      = 10 * time.Second
+	pongWait       = 60 * time.Second
+	pingPeriod     = (pongWait * 9) / 10
+	maxMessageSize = 4096
+	sendBuffer     = 64
+)
+
+type Client struct {
+	UserID uuid.UUID
+	hub    *Hub
+	conn   *websocket.Conn
+
+	send      chan Envelope
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func NewClient(hub *Hub, userID uuid.UUID, conn *websocket.Conn) *Client {
+	return &Client{
+		UserID: userID,
+		hub:    hub,
+		conn:   conn,
+		send:   make(chan Envelope, sendBuffer),
+		done:   make(chan struct{}),
+	}
+}
+
+// Send queues env for delivery. A client whose buffer is full is too slow to
+// keep up and gets disconnected; it can resync once it reconnects.
+func (c *Client) Send(env Envelope) bool {
+	if c.isClosed() {
+		return false
+	}
+	select {
+	case c.send <- env:
+		return true
+	default:
+		c.close()
+		return false
+	}
+}
+
+// SendWait is Send for bulk sends such as a resync: it waits up to
+// writeWait for room in the buffer before giving up on the client.
+func (c *Client) SendWait(env Envelope) bool {
+	if c.isClosed() {
+		return false
+	}
+	timer := time.NewTimer(writeWait)
+	defer timer.Stop()
+	select {
+	case c.send <- env:
+		return true
+	case <-c.done:
+		return false
+	case <-timer.C:
+		c.close()
+		return false
+	}
+}
+
+func (c *Client) SendError(text string) {
+	env, err := NewEnvelope("error", 0, map[string]string{"error": text})
+	if err != nil {
+		return
+	}
+	c.Send(env)
+}
+
+// close stops the write pump. send is never closed, so a Send racing the
+// close can't panic.
+func (c *Client) close() {
+	c.closeOnce.Do(func() { close(c.done) })
+}
+
+func (c *Client) isClosed() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Run pumps frames until the connection drops, passing every envelope the
+// client sends to handle. replay, if set, runs alongside once the write pump
+// is up, so it can queue more than the buffer holds with SendWait. The
+// client is unregistered from its hub on return.
+func (c *Client) Run(replay func(*Client), handle func(*Client, Envelope)) {
+	defer c.hub.Unregister(c)
+	go c.writePump()
+	if replay != nil {
+		go replay(c)
+	}
+
+	c.conn.SetReadLimit(maxMessageSize)
+	_ = c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var env Envelope
+		if err := json.Unmarshal(data, &env); err != nil {
+			c.SendError("Invalid envelope")
+			continue
+		}
+		handle(c, env)
+	}
+}
+
+func (c *Client) writePump() {
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+	for {
+		select {
+		case <-c.done:
+			_ = c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			_ = c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+			return
+		case env := <-c.send:
+			_ = c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteJSON(env); err != nil {
+				return
+			}
+		case <-ticker.C:
+			_ = c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package realtime
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHubConnectionCap(t *testing.T) {
+	hub := NewHub(2)
+	userID := uuid.New()
+
+	first := NewClient(hub, userID, nil)
+	second := NewClient(hub, userID, nil)
+	assert.NoError(t, hub.Register(first))
+	assert.NoError(t, hub.Register(second))
+	assert.True(t, hub.Full(userID), "Hub should be full after reaching the cap")
+
+	third := NewClient(hub, userID, nil)
+	assert.ErrorIs(t, hub.Register(third), ErrTooManyConnections)
+
+	// Another user is unaffected by the first user's cap
+	assert.NoError(t, hub.Register(NewClient(hub, uuid.New(), nil)))
+
+	hub.Unregister(first)
+	assert.False(t, hub.Full(userID), "Unregistering should free a slot")
+	assert.NoError(t, hub.Register(third))
+}
+
+func TestHubSend(t *testing.T) {
+	hub := NewHub(5)
+	userID := uuid.New()
+	a := NewClient(hub, userID, nil)
+	b := NewClient(hub, userID, nil)
+	other := NewClient(hub, uuid.New(), nil)
+	for _, c := range []*Client{a, b, other} {
+		assert.NoError(t, hub.Register(c))
+	}
+
+	env, err := NewEnvelope("typing", 0, map[string]string{"user_id": "x"})
+	assert.NoError(t, err)
+	hub.Send(userID, env)
+
+	assert.Len(t, a.send, 1, "Every connection of the user should get the envelope")
+	assert.Len(t, b.send, 1, "Every connection of the user should get the envelope")
+	assert.Len(t, other.send, 0, "Other users should not get the envelope")
+
+	// Sending after unregistering must not panic on the closed channel
+	hub.Unregister(a)
+	assert.False(t, a.Send(env))
+}
+
+func TestClientSendWait(t *testing.T) {
+	c := NewClient(NewHub(1), uuid.New(), nil)
+	env := Envelope{Type: "message"}
+	for range sendBuffer {
+		assert.True(t, c.Send(env))
+	}
+
+	// A full buffer holds SendWait until the pump frees a slot
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		<-c.send
+	}()
+	assert.True(t, c.SendWait(env))
+
+	// Send gives up on the same slow client at once
+	assert.False(t, c.Send(env))
+	assert.False(t, c.SendWait(env), "A closed client should not be waited on")
+}
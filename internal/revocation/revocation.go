@@ -0,0 +1,107 @@
+// Package revocation keeps the access token denylist and users' token
+// versions in memory, so checking a token doesn't cost a query on every
+// request. The database stays the source of truth; this is a cache of it.
+package revocation
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type version struct {
+	value   int32
+	fetched time.Time
+}
+
+// List answers whether an access token has been revoked. Denied IDs are
+// added by whoever revokes them, here or by syncing from the database.
+// Token versions are loaded on first use and again once older than the
+// TTL, which bounds how long another instance's change goes unseen.
+type List struct {
+	mu       sync.RWMutex
+	denied   map[string]time.Time
+	versions map[uuid.UUID]version
+	load     func(uuid.UUID) (int32, error)
+	ttl      time.Duration
+	now      func() time.Time
+}
+
+func NewList(load func(uuid.UUID) (int32, error), ttl time.Duration) *List {
+	return &List{
+		denied:   make(map[string]time.Time),
+		versions: make(map[uuid.UUID]version),
+		load:     load,
+		ttl:      ttl,
+		now:      time.Now,
+	}
+}
+
+// Deny turns away tokens with id until they would have expired anyway.
+func (l *List) Deny(id string, until time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if until.After(l.denied[id]) {
+		l.denied[id] = until
+	}
+}
+
+func (l *List) Revoked(id string) bool {
+	if id == "" {
+		return false
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	until, ok := l.denied[id]
+	return ok && l.now().Before(until)
+}
+
+// SetTokenVersion records a version just written to the database.
+func (l *List) SetTokenVersion(userID uuid.UUID, v int32) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.versions[userID] = version{v, l.now()}
+}
+
+// Forget drops a cached version, e.g. for a deleted user, so the next
+// check goes to the database.
+func (l *List) Forget(userID uuid.UUID) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.versions, userID)
+}
+
+// TokenVersion is the user's current token version. Failed loads aren't
+// cached.
+func (l *List) TokenVersion(userID uuid.UUID) (int32, error) {
+	l.mu.RLock()
+	v, ok := l.versions[userID]
+	l.mu.RUnlock()
+	if ok && l.now().Sub(v.fetched) < l.ttl {
+		return v.value, nil
+	}
+	value, err := l.load(userID)
+	if err != nil {
+		return 0, err
+	}
+	l.SetTokenVersion(userID, value)
+	return value, nil
+}
+
+// Prune forgets denials that have run out and versions past their TTL.
+func (l *List) Prune() {
+	now := l.now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for id, until := range l.denied {
+		if !now.Before(until) {
+			delete(l.denied, id)
+		}
+	}
+	for userID, v := range l.versions {
+		if now.Sub(v.fetched) >= l.ttl {
+			delete(l.versions, userID)
+		}
+	}
+}
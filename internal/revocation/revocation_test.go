@@ -0,0 +1,68 @@
+package revocation
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeny(t *testing.T) {
+	l := NewList(nil, time.Minute)
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	l.now = func() time.Time { return now }
+
+	l.Deny("a", now.Add(time.Hour))
+	l.Deny("a", now.Add(time.Minute))
+	assert.True(t, l.Revoked("a"))
+	assert.False(t, l.Revoked("b"))
+	assert.False(t, l.Revoked(""), "Tokens without an ID can't be denied")
+
+	now = now.Add(30 * time.Minute)
+	assert.True(t, l.Revoked("a"), "An earlier expiry shouldn't shorten a denial")
+	now = now.Add(30 * time.Minute)
+	assert.False(t, l.Revoked("a"))
+
+	l.Prune()
+	assert.Empty(t, l.denied)
+}
+
+func TestTokenVersion(t *testing.T) {
+	loads := 0
+	stored := int32(3)
+	var failing error
+	l := NewList(func(uuid.UUID) (int32, error) {
+		loads++
+		return stored, failing
+	}, time.Minute)
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	l.now = func() time.Time { return now }
+	user := uuid.New()
+
+	v, err := l.TokenVersion(user)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(3), v)
+	stored = 4
+	v, _ = l.TokenVersion(user)
+	assert.Equal(t, int32(3), v, "Should be served from the cache")
+	assert.Equal(t, 1, loads)
+
+	l.SetTokenVersion(user, 5)
+	v, _ = l.TokenVersion(user)
+	assert.Equal(t, int32(5), v)
+
+	now = now.Add(time.Minute)
+	v, _ = l.TokenVersion(user)
+	assert.Equal(t, int32(4), v, "Should reload once the TTL is up")
+	assert.Equal(t, 2, loads)
+
+	failing = errors.New("db down")
+	l.Forget(user)
+	_, err = l.TokenVersion(user)
+	assert.Error(t, err)
+	_, err = l.TokenVersion(user)
+	assert.Error(t, err, "Failures shouldn't be cached")
+	assert.Equal(t, 4, loads)
+}
@@ -0,0 +1,252 @@
+// Package richtext renders the small markdown subset chirps support:
+// **bold**, *italics* or _italics_, `inline code` and [links](https://...),
+// plus automatic links for bare URLs, @mentions and #hashtags.
+package richtext
+
+import (
+	"html"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Links resolves mentions and hashtags to URLs. A nil func, or one that
+// returns "", leaves the token as plain text.
+type Links struct {
+	Mention func(name string) string
+	Hashtag func(tag string) string
+}
+
+type kind int
+
+const (
+	textNode kind = iota
+	strongNode
+	emNode
+	codeNode
+	linkNode
+	mentionNode
+	hashtagNode
+)
+
+type node struct {
+	kind     kind
+	text     string
+	href     string
+	children []node
+}
+
+// A mention is an @ followed by a handle, e.g. "@alice". One followed by
+// another @ is part of an email address and doesn't count.
+var (
+	mentionPattern = regexp.MustCompile(`^@([A-Za-z][A-Za-z0-9_]{2,29})\b`)
+	hashtagPattern = regexp.MustCompile(`^#([A-Za-z0-9_]*[A-Za-z][A-Za-z0-9_]*)`)
+	urlPattern     = regexp.MustCompile(`^https?://[^\s<>"]+`)
+	linkPattern    = regexp.MustCompile(`^\[([^\[\]]+)\]\((https?://[^\s()<>"]+)\)`)
+)
+
+// Render returns body as sanitized HTML.
+func Render(body string, links Links) string {
+	var b strings.Builder
+	for _, n := range parse(body, true) {
+		renderNode(&b, n, links)
+	}
+	return Sanitize(b.String())
+}
+
+// VisibleLength counts the characters a reader sees once markup is gone.
+func VisibleLength(body string) int {
+	n := 0
+	for _, nd := range parse(body, true) {
+		n += utf8.RuneCountInString(visibleText(nd))
+	}
+	return n
+}
+
+func Mentions(body string) []string {
+	return collect(parse(body, true), mentionNode)
+}
+
+func Hashtags(body string) []string {
+	return collect(parse(body, true), hashtagNode)
+}
+
+func collect(nodes []node, k kind) []string {
+	seen := map[string]bool{}
+	var out []string
+	var walk func([]node)
+	walk = func(nodes []node) {
+		for _, n := range nodes {
+			if n.kind == k && !seen[strings.ToLower(n.text)] {
+				seen[strings.ToLower(n.text)] = true
+				out = append(out, n.text)
+			}
+			walk(n.children)
+		}
+	}
+	walk(nodes)
+	return out
+}
+
+// parse splits s into nodes. Emphasis may nest inside links and vice versa,
+// but links never nest in links.
+func parse(s string, allowLinks bool) []node {
+	var nodes []node
+	var text strings.Builder
+	flush := func() {
+		if text.Len() > 0 {
+			nodes = append(nodes, node{kind: textNode, text: text.String()})
+			text.Reset()
+		}
+	}
+
+	for i := 0; i < len(s); {
+		rest := s[i:]
+		atWordStart := i == 0 || !isWordChar(lastRune(s[:i]))
+
+		switch {
+		case rest[0] == '`':
+			if end := strings.IndexByte(rest[1:], '`'); end > 0 {
+				flush()
+				nodes = append(nodes, node{kind: codeNode, text: rest[1 : end+1]})
+				i += end + 2
+				continue
+			}
+		case strings.HasPrefix(rest, "**"):
+			if end := strings.Index(rest[2:], "**"); end > 0 {
+				flush()
+				nodes = append(nodes, node{kind: strongNode, children: parse(rest[2:end+2], allowLinks)})
+				i += end + 4
+				continue
+			}
+		case (rest[0] == '*' || rest[0] == '_') && atWordStart:
+			if end := closingEmphasis(rest[1:], rest[0]); end > 0 {
+				flush()
+				nodes = append(nodes, node{kind: emNode, children: parse(rest[1:end+1], allowLinks)})
+				i += end + 2
+				continue
+			}
+		case rest[0] == '[' && allowLinks:
+			if m := linkPattern.FindStringSubmatch(rest); m != nil {
+				flush()
+				nodes = append(nodes, node{kind: linkNode, href: m[2], children: parse(m[1], false)})
+				i += len(m[0])
+				continue
+			}
+		case rest[0] == 'h' && allowLinks && atWordStart:
+			if m := urlPattern.FindString(rest); m != "" {
+				m = strings.TrimRight(m, ".,!?;:)'")
+				flush()
+				nodes = append(nodes, node{kind: linkNode, href: m, children: []node{{kind: textNode, text: m}}})
+				i += len(m)
+				continue
+			}
+		case rest[0] == '@' && atWordStart:
+			if m := mentionPattern.FindStringSubmatch(rest); m != nil && !strings.HasPrefix(rest[len(m[0]):], "@") {
+				flush()
+				nodes = append(nodes, node{kind: mentionNode, text: m[1]})
+				i += len(m[0])
+				continue
+			}
+		case rest[0] == '#' && atWordStart:
+			if m := hashtagPattern.FindStringSubmatch(rest); m != nil {
+				flush()
+				nodes = append(nodes, node{kind: hashtagNode, text: m[1]})
+				i += len(m[0])
+				continue
+			}
+		}
+		r, size := utf8.DecodeRuneInString(rest)
+		text.WriteRune(r)
+		i += size
+	}
+	flush()
+	return nodes
+}
+
+// closingEmphasis finds the delimiter closing an emphasis span, which must
+// not be followed by a word character, so snake_case stays plain text.
+func closingEmphasis(s string, delim byte) int {
+	for i := 1; i < len(s); i++ {
+		if s[i] != delim || s[i-1] == ' ' {
+			continue
+		}
+		if i+1 < len(s) {
+			if r, _ := utf8.DecodeRuneInString(s[i+1:]); isWordChar(r) {
+				continue
+			}
+		}
+		return i
+	}
+	return -1
+}
+
+func isWordChar(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
+
+func lastRune(s string) rune {
+	r, _ := utf8.DecodeLastRuneInString(s)
+	return r
+}
+
+func visibleText(n node) string {
+	switch n.kind {
+	case textNode, codeNode:
+		return n.text
+	case mentionNode:
+		return "@" + n.text
+	case hashtagNode:
+		return "#" + n.text
+	}
+	var b strings.Builder
+	for _, c := range n.children {
+		b.WriteString(visibleText(c))
+	}
+	return b.String()
+}
+
+func renderNode(b *strings.Builder, n node, links Links) {
+	switch n.kind {
+	case textNode:
+		b.WriteString(html.EscapeString(n.text))
+	case codeNode:
+		b.WriteString("<code>" + html.EscapeString(n.text) + "</code>")
+	case strongNode, emNode:
+		tag := "strong"
+		if n.kind == emNode {
+			tag = "em"
+		}
+		b.WriteString("<" + tag + ">")
+		for _, c := range n.children {
+			renderNode(b, c, links)
+		}
+		b.WriteString("</" + tag + ">")
+	case linkNode:
+		b.WriteString(`<a href="` + html.EscapeString(n.href) + `" rel="nofollow noopener">`)
+		for _, c := range n.children {
+			renderNode(b, c, links)
+		}
+		b.WriteString("</a>")
+	case mentionNode:
+		renderTag(b, "mention", "@"+n.text, resolve(links.Mention, n.text))
+	case hashtagNode:
+		renderTag(b, "hashtag", "#"+n.text, resolve(links.Hashtag, n.text))
+	}
+}
+
+func renderTag(b *strings.Builder, class, text, href string) {
+	if href == "" {
+		b.WriteString(html.EscapeString(text))
+		return
+	}
+	b.WriteString(`<a href="` + html.EscapeString(href) + `" class="` + class + `">` + html.EscapeString(text) + "</a>")
+}
+
+func resolve(f func(string) string, s string) string {
+	if f == nil {
+		return ""
+	}
+	return f(s)
+}
@@ -0,0 +1,79 @@
+package richtext
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRender(t *testing.T) {
+	links := Links{
+		Mention: func(name string) string {
+			if name == "bob" {
+				return "/u/bob"
+			}
+			return ""
+		},
+		Hashtag: func(tag string) string { return "/tags/" + tag },
+	}
+	cases := []struct {
+		name, in, want string
+	}{
+		{"plain", "hello world", "hello world"},
+		{"bold", "so **very** nice", "so <strong>very</strong> nice"},
+		{"italics", "*a* and _b_", "<em>a</em> and <em>b</em>"},
+		{"snake case stays plain", "use snake_case_names", "use snake_case_names"},
+		{"code is literal", "run `**x** <y>`", "run <code>**x** &lt;y&gt;</code>"},
+		{"link", "[docs](https://example.com/a)", `<a href="https://example.com/a" rel="nofollow noopener">docs</a>`},
+		{"bold link text", "[**go**](https://go.dev)", `<a href="https://go.dev" rel="nofollow noopener"><strong>go</strong></a>`},
+		{"bare url", "see https://example.com.", `see <a href="https://example.com" rel="nofollow noopener">https://example.com</a>.`},
+		{"mention", "hi @bob!", `hi <a href="/u/bob" class="mention">@bob</a>!`},
+		{"unknown mention", "hi @eve", "hi @eve"},
+		{"email is not a mention", "mail bob@example.com", "mail bob@example.com"},
+		{"old email mention stays text", "hi @bob@example.com", "hi @bob@example.com"},
+		{"hashtag", "#golang rocks", `<a href="/tags/golang" class="hashtag">#golang</a> rocks`},
+		{"number is not a hashtag", "issue #42", "issue #42"},
+		{"html is escaped", `<script>alert("x")</script>`, "&lt;script&gt;alert(&#34;x&#34;)&lt;/script&gt;"},
+		{"javascript links stay text", "[x](javascript:alert(1))", "[x](javascript:alert(1))"},
+		{"unclosed bold", "**oops", "**oops"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.want, Render(c.in, links))
+		})
+	}
+}
+
+func TestVisibleLength(t *testing.T) {
+	assert.Equal(t, 5, VisibleLength("hello"))
+	assert.Equal(t, 5, VisibleLength("**hello**"))
+	assert.Equal(t, 4, VisibleLength("[docs](https://example.com/a/very/long/path)"))
+	assert.Equal(t, 3, VisibleLength("héé"), "Length should count characters, not bytes")
+}
+
+func TestMentionsAndHashtags(t *testing.T) {
+	body := "@ann and @Bob love #Go and #go, not #1 or @x or @carol@y.io"
+	assert.Equal(t, []string{"ann", "Bob"}, Mentions(body))
+	assert.Equal(t, []string{"Go"}, Hashtags(body))
+}
+
+func TestSanitize(t *testing.T) {
+	cases := []struct {
+		name, in, want string
+	}{
+		{"allowed tags kept", "<strong>a</strong><em>b</em>", "<strong>a</strong><em>b</em>"},
+		{"unknown tags dropped", "<div><b>x</b></div>", "x"},
+		{"script dropped", "<script>alert(1)</script>", "alert(1)"},
+		{"event handlers dropped", `<a href="/x" onclick="evil()">x</a>`, `<a href="/x">x</a>`},
+		{"javascript urls dropped", `<a href="javascript:alert(1)">x</a>`, "<a>x</a>"},
+		{"protocol relative dropped", `<a href="//evil.com">x</a>`, "<a>x</a>"},
+		{"unknown class dropped", `<a class="evil">x</a>`, "<a>x</a>"},
+		{"unclosed tags closed", "<strong><em>x", "<strong><em>x</em></strong>"},
+		{"stray close ignored", "x</strong>", "x"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.want, Sanitize(c.in))
+		})
+	}
+}
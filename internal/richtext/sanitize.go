@@ -0,0 +1,90 @@
+package richtext
+
+import (
+	"html"
+	"net/url"
+	"strings"
+
+	nethtml "golang.org/x/net/html"
+)
+
+// allowed maps every permitted tag to its permitted attributes. Anything
+// else is dropped, keeping only its escaped text.
+var allowed = map[string]map[string]bool{
+	"strong": {},
+	"em":     {},
+	"code":   {},
+	"a":      {"href": true, "rel": true, "class": true},
+}
+
+var allowedClasses = map[string]bool{"mention": true, "hashtag": true}
+
+// Sanitize rewrites s so that only allowlisted tags and attributes remain.
+// Links must be http(s) or site-relative, and every open tag is closed.
+func Sanitize(s string) string {
+	var b strings.Builder
+	var open []string
+	z := nethtml.NewTokenizer(strings.NewReader(s))
+	for {
+		tt := z.Next()
+		switch tt {
+		case nethtml.ErrorToken:
+			for i := len(open) - 1; i >= 0; i-- {
+				b.WriteString("</" + open[i] + ">")
+			}
+			return b.String()
+
+		case nethtml.TextToken:
+			b.WriteString(html.EscapeString(string(z.Text())))
+
+		case nethtml.StartTagToken:
+			tok := z.Token()
+			attrs, ok := allowed[tok.Data]
+			if !ok {
+				continue
+			}
+			b.WriteString("<" + tok.Data)
+			for _, a := range tok.Attr {
+				if !attrs[a.Key] || !safeAttr(a.Key, a.Val) {
+					continue
+				}
+				b.WriteString(" " + a.Key + `="` + html.EscapeString(a.Val) + `"`)
+			}
+			b.WriteString(">")
+			open = append(open, tok.Data)
+
+		case nethtml.EndTagToken:
+			tok := z.Token()
+			// Only close what is actually open, innermost first
+			for i := len(open) - 1; i >= 0; i-- {
+				if open[i] != tok.Data {
+					continue
+				}
+				for j := len(open) - 1; j >= i; j-- {
+					b.WriteString("</" + open[j] + ">")
+				}
+				open = open[:i]
+				break
+			}
+		}
+	}
+}
+
+func safeAttr(key, val string) bool {
+	switch key {
+	case "href":
+		u, err := url.Parse(val)
+		if err != nil {
+			return false
+		}
+		if u.Scheme == "" {
+			return strings.HasPrefix(val, "/") && !strings.HasPrefix(val, "//")
+		}
+		return u.Scheme == "http" || u.Scheme == "https"
+	case "rel":
+		return val == "nofollow noopener"
+	case "class":
+		return allowedClasses[val]
+	}
+	return false
+}
@@ -0,0 +1,106 @@
+// Package takeout writes personal data exports as zip archives holding
+// every dataset both as JSON and as CSV.
+package takeout
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"time"
+)
+
+type Archive struct {
+	zw *zip.Writer
+}
+
+func NewArchive(w io.Writer) *Archive {
+	return &Archive{zw: zip.NewWriter(w)}
+}
+
+// Add writes name.json and name.csv. records is a struct or a slice of
+// structs; the CSV columns are the fields' json names.
+func (a *Archive) Add(name string, records any) error {
+	f, err := a.zw.Create(name + ".json")
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(records); err != nil {
+		return fmt.Errorf("failed to write %s.json: %v", name, err)
+	}
+
+	f, err = a.zw.Create(name + ".csv")
+	if err != nil {
+		return err
+	}
+	if err := WriteCSV(f, records); err != nil {
+		return fmt.Errorf("failed to write %s.csv: %v", name, err)
+	}
+	return nil
+}
+
+func (a *Archive) Close() error {
+	return a.zw.Close()
+}
+
+func WriteCSV(w io.Writer, records any) error {
+	v := reflect.ValueOf(records)
+	if v.Kind() != reflect.Slice {
+		s := reflect.MakeSlice(reflect.SliceOf(v.Type()), 1, 1)
+		s.Index(0).Set(v)
+		v = s
+	}
+	elem := v.Type().Elem()
+	if elem.Kind() != reflect.Struct {
+		return fmt.Errorf("cannot write %s as CSV", elem)
+	}
+
+	var header []string
+	var fields []int
+	for i := 0; i < elem.NumField(); i++ {
+		f := elem.Field(i)
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if !f.IsExported() || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		header = append(header, name)
+		fields = append(fields, i)
+	}
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	for i := 0; i < v.Len(); i++ {
+		row := make([]string, len(fields))
+		for j, field := range fields {
+			row[j] = formatValue(v.Index(i).Field(field))
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func formatValue(v reflect.Value) string {
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return ""
+		}
+		v = v.Elem()
+	}
+	if t, ok := v.Interface().(time.Time); ok {
+		return t.UTC().Format(time.RFC3339)
+	}
+	return fmt.Sprint(v.Interface())
+}
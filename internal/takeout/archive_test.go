@@ -0,0 +1,58 @@
+package takeout
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type record struct {
+	ID      int        `json:"id"`
+	Body    string     `json:"body"`
+	At      time.Time  `json:"created_at"`
+	ReadAt  *time.Time `json:"read_at,omitempty"`
+	private string
+}
+
+func TestWriteCSV(t *testing.T) {
+	at := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	var buf bytes.Buffer
+	err := WriteCSV(&buf, []record{{1, "hello, world", at, nil, "x"}, {2, "bye", at, &at, ""}})
+	assert.NoError(t, err)
+	assert.Equal(t, "id,body,created_at,read_at\n"+
+		"1,\"hello, world\",2024-01-02T03:04:05Z,\n"+
+		"2,bye,2024-01-02T03:04:05Z,2024-01-02T03:04:05Z\n", buf.String())
+
+	// A single struct is written as a one-row table
+	buf.Reset()
+	assert.NoError(t, WriteCSV(&buf, record{ID: 3, At: at}))
+	assert.Equal(t, 2, strings.Count(buf.String(), "\n"))
+
+	assert.Error(t, WriteCSV(&buf, []string{"not", "structs"}))
+}
+
+func TestArchive(t *testing.T) {
+	var buf bytes.Buffer
+	a := NewArchive(&buf)
+	assert.NoError(t, a.Add("chirps", []record{{ID: 1, Body: "hi"}}))
+	assert.NoError(t, a.Close())
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	assert.NoError(t, err)
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	assert.Equal(t, []string{"chirps.json", "chirps.csv"}, names)
+
+	f, err := zr.File[0].Open()
+	assert.NoError(t, err)
+	data, err := io.ReadAll(f)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), `"body": "hi"`)
+}
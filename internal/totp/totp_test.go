@@ -0,0 +1,70 @@
+package totp
+
+import (
+	"encoding/base32"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rfcSecret is the SHA-1 key from the RFC 6238 test vectors.
+var rfcSecret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+func TestCodeRFCVectors(t *testing.T) {
+	// The RFC lists eight digit codes; six digits are their last six
+	cases := map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	}
+	for unix, want := range cases {
+		got, err := Code(rfcSecret, Step(time.Unix(unix, 0)))
+		require.NoError(t, err)
+		assert.Equal(t, want, got, "time %d", unix)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+
+	step, ok := Validate(rfcSecret, "050471", now)
+	assert.True(t, ok)
+	assert.Equal(t, Step(now), step)
+
+	// The previous step is still accepted
+	prev, _ := Code(rfcSecret, Step(now)-1)
+	step, ok = Validate(rfcSecret, prev, now)
+	assert.True(t, ok)
+	assert.Equal(t, Step(now)-1, step)
+
+	old, _ := Code(rfcSecret, Step(now)-3)
+	_, ok = Validate(rfcSecret, old, now)
+	assert.False(t, ok)
+
+	_, ok = Validate(rfcSecret, "050 471", now)
+	assert.True(t, ok)
+	_, ok = Validate(rfcSecret, "50471", now)
+	assert.False(t, ok)
+	_, ok = Validate("not base32!", "050471", now)
+	assert.False(t, ok)
+}
+
+func TestGenerateSecretAndURI(t *testing.T) {
+	secret, err := GenerateSecret()
+	require.NoError(t, err)
+	assert.Len(t, secret, 32)
+
+	u, err := url.Parse(URI("Chirpy", "bob@example.com", secret))
+	require.NoError(t, err)
+	assert.Equal(t, "otpauth", u.Scheme)
+	assert.Equal(t, "totp", u.Host)
+	assert.Equal(t, "/Chirpy:bob@example.com", u.Path)
+	assert.Equal(t, secret, u.Query().Get("secret"))
+	assert.Equal(t, "Chirpy", u.Query().Get("issuer"))
+}
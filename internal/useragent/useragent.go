@@ -0,0 +1,63 @@
+// Package useragent turns User-Agent headers into short device labels such
+// as "Firefox on Windows", for showing people where they're logged in. It
+// recognises the common browsers and systems and doesn't try to be exact.
+package useragent
+
+import "strings"
+
+// Unknown is the label for anything not recognised.
+const Unknown = "Unknown device"
+
+// Checked in order: many browsers mention the ones they're built on, so
+// the more specific names come first.
+var browsers = []struct{ token, name string }{
+	{"Edg/", "Edge"},
+	{"EdgA/", "Edge"},
+	{"OPR/", "Opera"},
+	{"SamsungBrowser/", "Samsung Internet"},
+	{"Firefox/", "Firefox"},
+	{"FxiOS/", "Firefox"},
+	{"CriOS/", "Chrome"},
+	{"Chrome/", "Chrome"},
+	{"Safari/", "Safari"},
+	{"curl/", "curl"},
+	{"python-requests/", "Python"},
+	{"Go-http-client/", "Go"},
+}
+
+var systems = []struct{ token, name string }{
+	{"iPhone", "iPhone"},
+	{"iPad", "iPad"},
+	{"Android", "Android"},
+	{"CrOS", "ChromeOS"},
+	{"Windows", "Windows"},
+	{"Mac OS X", "macOS"},
+	{"Macintosh", "macOS"},
+	{"Linux", "Linux"},
+}
+
+// Describe labels a User-Agent header.
+func Describe(ua string) string {
+	var browser, system string
+	for _, b := range browsers {
+		if strings.Contains(ua, b.token) {
+			browser = b.name
+			break
+		}
+	}
+	for _, s := range systems {
+		if strings.Contains(ua, s.token) {
+			system = s.name
+			break
+		}
+	}
+	switch {
+	case browser != "" && system != "":
+		return browser + " on " + system
+	case browser != "":
+		return browser
+	case system != "":
+		return system
+	}
+	return Unknown
+}
@@ -0,0 +1,147 @@
+package webauthn
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// Authenticators speak CBOR (RFC 8949). Only the definite-length subset
+// they actually send is decoded: integers, byte and text strings, arrays,
+// maps, tags (which are skipped) and the simple values false, true and
+// null.
+
+var errCBOR = errors.New("malformed CBOR")
+
+const cborMaxDepth = 16
+
+// decodeCBOR decodes the first item in data and returns what follows it.
+// Maps come back as map[any]any keyed by int64 or string.
+func decodeCBOR(data []byte) (any, []byte, error) {
+	d := cborDecoder{data: data}
+	v, err := d.value(0)
+	if err != nil {
+		return nil, nil, err
+	}
+	return v, data[d.off:], nil
+}
+
+type cborDecoder struct {
+	data []byte
+	off  int
+}
+
+func (d *cborDecoder) head() (major byte, arg uint64, err error) {
+	if d.off >= len(d.data) {
+		return 0, 0, errCBOR
+	}
+	b := d.data[d.off]
+	d.off++
+	major, info := b>>5, b&0x1f
+	size := 0
+	switch {
+	case info < 24:
+		return major, uint64(info), nil
+	case info == 24:
+		size = 1
+	case info == 25:
+		size = 2
+	case info == 26:
+		size = 4
+	case info == 27:
+		size = 8
+	default:
+		// Indefinite lengths and reserved values
+		return 0, 0, errCBOR
+	}
+	if len(d.data)-d.off < size {
+		return 0, 0, errCBOR
+	}
+	var buf [8]byte
+	copy(buf[8-size:], d.data[d.off:d.off+size])
+	d.off += size
+	return major, binary.BigEndian.Uint64(buf[:]), nil
+}
+
+func (d *cborDecoder) value(depth int) (any, error) {
+	if depth > cborMaxDepth {
+		return nil, errCBOR
+	}
+	// Floats have no place in anything WebAuthn sends
+	if d.off < len(d.data) && d.data[d.off]>>5 == 7 && d.data[d.off]&0x1f >= 24 {
+		return nil, errCBOR
+	}
+	major, arg, err := d.head()
+	if err != nil {
+		return nil, err
+	}
+	switch major {
+	case 0:
+		if arg > math.MaxInt64 {
+			return nil, errCBOR
+		}
+		return int64(arg), nil
+	case 1:
+		if arg > math.MaxInt64 {
+			return nil, errCBOR
+		}
+		return -1 - int64(arg), nil
+	case 2, 3:
+		if arg > uint64(len(d.data)-d.off) {
+			return nil, errCBOR
+		}
+		b := d.data[d.off : d.off+int(arg)]
+		d.off += int(arg)
+		if major == 3 {
+			return string(b), nil
+		}
+		return append([]byte(nil), b...), nil
+	case 4:
+		// Every item takes at least a byte, which bounds the allocation
+		if arg > uint64(len(d.data)-d.off) {
+			return nil, errCBOR
+		}
+		items := make([]any, arg)
+		for i := range items {
+			if items[i], err = d.value(depth + 1); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	case 5:
+		if arg > uint64(len(d.data)-d.off)/2 {
+			return nil, errCBOR
+		}
+		m := make(map[any]any, arg)
+		for range arg {
+			k, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			switch k.(type) {
+			case int64, string:
+			default:
+				return nil, errCBOR
+			}
+			if _, dup := m[k]; dup {
+				return nil, errCBOR
+			}
+			if m[k], err = d.value(depth + 1); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	case 6:
+		return d.value(depth + 1)
+	default:
+		switch arg {
+		case 20:
+			return false, nil
+		case 21:
+			return true, nil
+		case 22, 23:
+			return nil, nil
+		}
+		return nil, errCBOR
+	}
+}
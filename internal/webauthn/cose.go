@@ -0,0 +1,104 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// COSE algorithm identifiers (RFC 9053) offered to authenticators, in order
+// of preference.
+const (
+	AlgES256 = -7
+	AlgEdDSA = -8
+	AlgRS256 = -257
+)
+
+var ErrUnsupportedKey = errors.New("unsupported credential public key")
+
+// parseCOSEKey reads a COSE_Key as stored in attested credential data.
+func parseCOSEKey(raw []byte) (int64, crypto.PublicKey, error) {
+	v, rest, err := decodeCBOR(raw)
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(rest) != 0 {
+		return 0, nil, errCBOR
+	}
+	m, ok := v.(map[any]any)
+	if !ok {
+		return 0, nil, ErrUnsupportedKey
+	}
+	kty, _ := m[int64(1)].(int64)
+	alg, _ := m[int64(3)].(int64)
+
+	switch {
+	case kty == 2 && alg == AlgES256:
+		crv, _ := m[int64(-1)].(int64)
+		x, _ := m[int64(-2)].([]byte)
+		y, _ := m[int64(-3)].([]byte)
+		if crv != 1 || len(x) != 32 || len(y) != 32 {
+			return 0, nil, ErrUnsupportedKey
+		}
+		// Let crypto/ecdh reject points that aren't on the curve
+		point := append(append([]byte{4}, x...), y...)
+		if _, err := ecdh.P256().NewPublicKey(point); err != nil {
+			return 0, nil, ErrUnsupportedKey
+		}
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		return alg, pub, nil
+
+	case kty == 1 && alg == AlgEdDSA:
+		crv, _ := m[int64(-1)].(int64)
+		x, _ := m[int64(-2)].([]byte)
+		if crv != 6 || len(x) != ed25519.PublicKeySize {
+			return 0, nil, ErrUnsupportedKey
+		}
+		return alg, ed25519.PublicKey(x), nil
+
+	case kty == 3 && alg == AlgRS256:
+		n, _ := m[int64(-1)].([]byte)
+		e, _ := m[int64(-2)].([]byte)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return 0, nil, ErrUnsupportedKey
+		}
+		exp := int(new(big.Int).SetBytes(e).Int64())
+		if exp < 3 || exp%2 == 0 {
+			return 0, nil, ErrUnsupportedKey
+		}
+		return alg, &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: exp}, nil
+	}
+	return 0, nil, fmt.Errorf("%w: kty %d alg %d", ErrUnsupportedKey, kty, alg)
+}
+
+// verifySignature checks sig over data with a COSE_Key.
+func verifySignature(coseKey, data, sig []byte) error {
+	alg, pub, err := parseCOSEKey(coseKey)
+	if err != nil {
+		return err
+	}
+	switch alg {
+	case AlgES256:
+		sum := sha256.Sum256(data)
+		if !ecdsa.VerifyASN1(pub.(*ecdsa.PublicKey), sum[:], sig) {
+			return ErrBadSignature
+		}
+	case AlgEdDSA:
+		if !ed25519.Verify(pub.(ed25519.PublicKey), data, sig) {
+			return ErrBadSignature
+		}
+	case AlgRS256:
+		sum := sha256.Sum256(data)
+		if rsa.VerifyPKCS1v15(pub.(*rsa.PublicKey), crypto.SHA256, sum[:], sig) != nil {
+			return ErrBadSignature
+		}
+	}
+	return nil
+}
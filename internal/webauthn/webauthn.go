@@ -0,0 +1,343 @@
+// Package webauthn verifies passkey registrations and logins (WebAuthn
+// Level 2). It checks what the browser and authenticator signed; storing
+// challenges and credentials is left to the caller.
+//
+// Attestation statements aren't checked. Chirpy asks for "none" and
+// doesn't restrict which authenticators people use, so there's nothing an
+// attestation would change.
+package webauthn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Timeout is how long browsers are told to wait for the user, and how
+// long a challenge should stay usable.
+const Timeout = 5 * time.Minute
+
+// Authenticator data flags
+const (
+	FlagUserPresent  = 0x01
+	FlagUserVerified = 0x04
+	FlagAttestedData = 0x40
+	FlagExtensions   = 0x80
+)
+
+var (
+	ErrClientData    = errors.New("client data doesn't match the ceremony")
+	ErrAuthData      = errors.New("malformed authenticator data")
+	ErrRelyingParty  = errors.New("credential is for a different relying party")
+	ErrNotPresent    = errors.New("user presence was not confirmed")
+	ErrBadSignature  = errors.New("invalid assertion signature")
+	ErrCloned        = errors.New("signature counter went backwards; the authenticator may be cloned")
+	ErrNoCredentials = errors.New("attestation carries no credential")
+)
+
+// RelyingParty is this server as authenticators see it. ID is a domain
+// (no scheme or port) and Origin the exact origin pages are served from.
+type RelyingParty struct {
+	ID     string
+	Name   string
+	Origin string
+}
+
+// URLBytes is binary data carried as unpadded base64url, the encoding
+// WebAuthn's JSON forms use throughout.
+type URLBytes []byte
+
+func (b URLBytes) MarshalJSON() ([]byte, error) {
+	return json.Marshal(base64.RawURLEncoding.EncodeToString(b))
+}
+
+func (b *URLBytes) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return err
+	}
+	*b = raw
+	return nil
+}
+
+// NewChallenge returns a fresh random challenge, base64url encoded as it
+// will come back in client data.
+func NewChallenge() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+type RPEntity struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type UserEntity struct {
+	ID          URLBytes `json:"id"`
+	Name        string   `json:"name"`
+	DisplayName string   `json:"displayName"`
+}
+
+type CredentialParameter struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+type CredentialDescriptor struct {
+	Type string   `json:"type"`
+	ID   URLBytes `json:"id"`
+}
+
+type AuthenticatorSelection struct {
+	ResidentKey      string `json:"residentKey"`
+	UserVerification string `json:"userVerification"`
+}
+
+// CreationOptions is the JSON form of PublicKeyCredentialCreationOptions,
+// ready for PublicKeyCredential.parseCreationOptionsFromJSON.
+type CreationOptions struct {
+	Challenge              string                 `json:"challenge"`
+	RP                     RPEntity               `json:"rp"`
+	User                   UserEntity             `json:"user"`
+	PubKeyCredParams       []CredentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int64                  `json:"timeout"`
+	Attestation            string                 `json:"attestation"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection AuthenticatorSelection `json:"authenticatorSelection"`
+}
+
+// RequestOptions is the JSON form of PublicKeyCredentialRequestOptions.
+type RequestOptions struct {
+	Challenge        string                 `json:"challenge"`
+	RPID             string                 `json:"rpId"`
+	Timeout          int64                  `json:"timeout"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+// CreationOptions asks for a discoverable credential, so it can later be
+// used without typing an email address. exclude lists credentials the user
+// already has, which stops an authenticator registering twice.
+func (rp *RelyingParty) CreationOptions(challenge string, user UserEntity, exclude [][]byte) CreationOptions {
+	opts := CreationOptions{
+		Challenge: challenge,
+		RP:        RPEntity{rp.ID, rp.Name},
+		User:      user,
+		PubKeyCredParams: []CredentialParameter{
+			{"public-key", AlgES256},
+			{"public-key", AlgEdDSA},
+			{"public-key", AlgRS256},
+		},
+		Timeout:                Timeout.Milliseconds(),
+		Attestation:            "none",
+		ExcludeCredentials:     descriptors(exclude),
+		AuthenticatorSelection: AuthenticatorSelection{"preferred", "preferred"},
+	}
+	return opts
+}
+
+// RequestOptions starts a login. With no allowed credentials the browser
+// offers whichever passkeys it holds for this site.
+func (rp *RelyingParty) RequestOptions(challenge string, allow [][]byte) RequestOptions {
+	return RequestOptions{
+		Challenge:        challenge,
+		RPID:             rp.ID,
+		Timeout:          Timeout.Milliseconds(),
+		AllowCredentials: descriptors(allow),
+		UserVerification: "preferred",
+	}
+}
+
+func descriptors(ids [][]byte) []CredentialDescriptor {
+	out := make([]CredentialDescriptor, 0, len(ids))
+	for _, id := range ids {
+		out = append(out, CredentialDescriptor{"public-key", id})
+	}
+	return out
+}
+
+// ClientData is the part of collectedClientData that gets checked.
+type ClientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+}
+
+// ParseClientData decodes clientDataJSON, mainly so the caller can find
+// the challenge it issued before verifying anything else.
+func ParseClientData(raw []byte) (ClientData, error) {
+	var cd ClientData
+	if err := json.Unmarshal(raw, &cd); err != nil {
+		return ClientData{}, ErrClientData
+	}
+	return cd, nil
+}
+
+// AuthenticatorData is the authenticator's signed statement. Credential
+// fields are set only during registration.
+type AuthenticatorData struct {
+	RPIDHash     []byte
+	Flags        byte
+	SignCount    uint32
+	CredentialID []byte
+	PublicKey    []byte
+}
+
+func ParseAuthenticatorData(raw []byte) (AuthenticatorData, error) {
+	if len(raw) < 37 {
+		return AuthenticatorData{}, ErrAuthData
+	}
+	ad := AuthenticatorData{
+		RPIDHash:  raw[:32],
+		Flags:     raw[32],
+		SignCount: binary.BigEndian.Uint32(raw[33:37]),
+	}
+	rest := raw[37:]
+	if ad.Flags&FlagAttestedData != 0 {
+		// AAGUID, then a length-prefixed credential ID, then its key
+		if len(rest) < 18 {
+			return AuthenticatorData{}, ErrAuthData
+		}
+		n := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if n == 0 || n > 1023 || len(rest) < n {
+			return AuthenticatorData{}, ErrAuthData
+		}
+		ad.CredentialID = rest[:n]
+		rest = rest[n:]
+		_, after, err := decodeCBOR(rest)
+		if err != nil {
+			return AuthenticatorData{}, ErrAuthData
+		}
+		ad.PublicKey = rest[:len(rest)-len(after)]
+		rest = after
+	}
+	if ad.Flags&FlagExtensions != 0 {
+		_, after, err := decodeCBOR(rest)
+		if err != nil {
+			return AuthenticatorData{}, ErrAuthData
+		}
+		rest = after
+	}
+	if len(rest) != 0 {
+		return AuthenticatorData{}, ErrAuthData
+	}
+	return ad, nil
+}
+
+// Credential is what should be stored for a newly registered passkey.
+type Credential struct {
+	ID           []byte
+	PublicKey    []byte
+	SignCount    uint32
+	UserVerified bool
+}
+
+// VerifyRegistration checks the response to CreationOptions issued with
+// challenge.
+func (rp *RelyingParty) VerifyRegistration(clientDataJSON, attestationObject []byte, challenge string) (Credential, error) {
+	if err := rp.checkClientData(clientDataJSON, "webauthn.create", challenge); err != nil {
+		return Credential{}, err
+	}
+	v, rest, err := decodeCBOR(attestationObject)
+	if err != nil || len(rest) != 0 {
+		return Credential{}, ErrAuthData
+	}
+	obj, ok := v.(map[any]any)
+	if !ok {
+		return Credential{}, ErrAuthData
+	}
+	rawAuth, ok := obj["authData"].([]byte)
+	if !ok {
+		return Credential{}, ErrAuthData
+	}
+	ad, err := rp.checkAuthData(rawAuth)
+	if err != nil {
+		return Credential{}, err
+	}
+	if ad.Flags&FlagAttestedData == 0 {
+		return Credential{}, ErrNoCredentials
+	}
+	if _, _, err := parseCOSEKey(ad.PublicKey); err != nil {
+		return Credential{}, err
+	}
+	return Credential{
+		ID:           ad.CredentialID,
+		PublicKey:    ad.PublicKey,
+		SignCount:    ad.SignCount,
+		UserVerified: ad.Flags&FlagUserVerified != 0,
+	}, nil
+}
+
+// Assertion is a verified login.
+type Assertion struct {
+	SignCount    uint32
+	UserVerified bool
+}
+
+// VerifyAssertion checks a login response against the stored credential.
+// storedCount is the last counter seen for it; an authenticator whose
+// counter doesn't move forward may have been copied, so it's refused.
+// Authenticators that don't keep a counter always report zero.
+func (rp *RelyingParty) VerifyAssertion(clientDataJSON, authenticatorData, signature []byte, challenge string, publicKey []byte, storedCount uint32) (Assertion, error) {
+	if err := rp.checkClientData(clientDataJSON, "webauthn.get", challenge); err != nil {
+		return Assertion{}, err
+	}
+	ad, err := rp.checkAuthData(authenticatorData)
+	if err != nil {
+		return Assertion{}, err
+	}
+	sum := sha256.Sum256(clientDataJSON)
+	signed := append(append([]byte(nil), authenticatorData...), sum[:]...)
+	if err := verifySignature(publicKey, signed, signature); err != nil {
+		return Assertion{}, err
+	}
+	if (ad.SignCount != 0 || storedCount != 0) && ad.SignCount <= storedCount {
+		return Assertion{}, ErrCloned
+	}
+	return Assertion{ad.SignCount, ad.Flags&FlagUserVerified != 0}, nil
+}
+
+func (rp *RelyingParty) checkClientData(raw []byte, typ, challenge string) error {
+	cd, err := ParseClientData(raw)
+	if err != nil {
+		return err
+	}
+	if cd.Type != typ || cd.Origin != rp.Origin || cd.CrossOrigin {
+		return ErrClientData
+	}
+	if subtle.ConstantTimeCompare([]byte(cd.Challenge), []byte(challenge)) != 1 {
+		return ErrClientData
+	}
+	return nil
+}
+
+func (rp *RelyingParty) checkAuthData(raw []byte) (AuthenticatorData, error) {
+	ad, err := ParseAuthenticatorData(raw)
+	if err != nil {
+		return AuthenticatorData{}, err
+	}
+	want := sha256.Sum256([]byte(rp.ID))
+	if !bytes.Equal(ad.RPIDHash, want[:]) {
+		return AuthenticatorData{}, ErrRelyingParty
+	}
+	if ad.Flags&FlagUserPresent == 0 {
+		return AuthenticatorData{}, ErrNotPresent
+	}
+	return ad, nil
+}
@@ -0,0 +1,268 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var rp = &RelyingParty{ID: "chirpy.test", Name: "Chirpy", Origin: "https://chirpy.test"}
+
+// softAuthenticator plays the part of a security key or platform
+// authenticator, the way a browser would drive one.
+type softAuthenticator struct {
+	rpID    string
+	origin  string
+	credID  []byte
+	signer  crypto.Signer
+	counter uint32
+	noCount bool
+}
+
+func newSoftAuthenticator(t *testing.T, alg int) *softAuthenticator {
+	a := &softAuthenticator{rpID: rp.ID, origin: rp.Origin, credID: make([]byte, 16)}
+	_, _ = rand.Read(a.credID)
+	switch alg {
+	case AlgES256:
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+		a.signer = key
+	case AlgEdDSA:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		require.NoError(t, err)
+		a.signer = key
+	}
+	return a
+}
+
+func (a *softAuthenticator) coseKey() []byte {
+	switch pub := a.signer.Public().(type) {
+	case *ecdsa.PublicKey:
+		x, y := make([]byte, 32), make([]byte, 32)
+		pub.X.FillBytes(x)
+		pub.Y.FillBytes(y)
+		return encodeCBOR(map[any]any{int64(1): int64(2), int64(3): int64(AlgES256), int64(-1): int64(1), int64(-2): x, int64(-3): y})
+	case ed25519.PublicKey:
+		return encodeCBOR(map[any]any{int64(1): int64(1), int64(3): int64(AlgEdDSA), int64(-1): int64(6), int64(-2): []byte(pub)})
+	}
+	panic("unknown key")
+}
+
+func (a *softAuthenticator) authData(attested bool) []byte {
+	if !a.noCount {
+		a.counter++
+	}
+	hash := sha256.Sum256([]byte(a.rpID))
+	out := append([]byte(nil), hash[:]...)
+	flags := byte(FlagUserPresent | FlagUserVerified)
+	if attested {
+		flags |= FlagAttestedData
+	}
+	out = append(out, flags)
+	out = binary.BigEndian.AppendUint32(out, a.counter)
+	if attested {
+		out = append(out, make([]byte, 16)...)
+		out = binary.BigEndian.AppendUint16(out, uint16(len(a.credID)))
+		out = append(out, a.credID...)
+		out = append(out, a.coseKey()...)
+	}
+	return out
+}
+
+func (a *softAuthenticator) clientData(typ, challenge string) []byte {
+	data, _ := json.Marshal(ClientData{Type: typ, Challenge: challenge, Origin: a.origin})
+	return data
+}
+
+func (a *softAuthenticator) create(challenge string) (clientData, attestation []byte) {
+	obj := map[any]any{"fmt": "none", "attStmt": map[any]any{}, "authData": a.authData(true)}
+	return a.clientData("webauthn.create", challenge), encodeCBOR(obj)
+}
+
+func (a *softAuthenticator) get(t *testing.T, challenge string) (clientData, authData, sig []byte) {
+	clientData = a.clientData("webauthn.get", challenge)
+	authData = a.authData(false)
+	sum := sha256.Sum256(clientData)
+	msg := append(append([]byte(nil), authData...), sum[:]...)
+	var err error
+	if _, ok := a.signer.(ed25519.PrivateKey); ok {
+		sig, err = a.signer.Sign(rand.Reader, msg, crypto.Hash(0))
+	} else {
+		digest := sha256.Sum256(msg)
+		sig, err = a.signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	}
+	require.NoError(t, err)
+	return clientData, authData, sig
+}
+
+func TestRegisterAndLogin(t *testing.T) {
+	for _, alg := range []int{AlgES256, AlgEdDSA} {
+		a := newSoftAuthenticator(t, alg)
+
+		challenge, err := NewChallenge()
+		require.NoError(t, err)
+		cd, att := a.create(challenge)
+		cred, err := rp.VerifyRegistration(cd, att, challenge)
+		require.NoError(t, err, "alg %d", alg)
+		assert.Equal(t, a.credID, cred.ID)
+		assert.Equal(t, uint32(1), cred.SignCount)
+		assert.True(t, cred.UserVerified)
+
+		challenge, _ = NewChallenge()
+		cd, ad, sig := a.get(t, challenge)
+		got, err := rp.VerifyAssertion(cd, ad, sig, challenge, cred.PublicKey, cred.SignCount)
+		require.NoError(t, err, "alg %d", alg)
+		assert.Equal(t, uint32(2), got.SignCount)
+	}
+}
+
+func TestRegistrationRejected(t *testing.T) {
+	a := newSoftAuthenticator(t, AlgES256)
+	challenge, _ := NewChallenge()
+
+	cd, att := a.create(challenge)
+	_, err := rp.VerifyRegistration(cd, att, "some-other-challenge")
+	assert.ErrorIs(t, err, ErrClientData)
+
+	a.origin = "https://evil.test"
+	cd, att = a.create(challenge)
+	_, err = rp.VerifyRegistration(cd, att, challenge)
+	assert.ErrorIs(t, err, ErrClientData)
+
+	a.origin, a.rpID = rp.Origin, "evil.test"
+	cd, att = a.create(challenge)
+	_, err = rp.VerifyRegistration(cd, att, challenge)
+	assert.ErrorIs(t, err, ErrRelyingParty)
+
+	// A login response can't be passed off as a registration
+	a.rpID = rp.ID
+	_, att = a.create(challenge)
+	_, err = rp.VerifyRegistration(a.clientData("webauthn.get", challenge), att, challenge)
+	assert.ErrorIs(t, err, ErrClientData)
+}
+
+func TestAssertionRejected(t *testing.T) {
+	a := newSoftAuthenticator(t, AlgES256)
+	challenge, _ := NewChallenge()
+	cd, att := a.create(challenge)
+	cred, err := rp.VerifyRegistration(cd, att, challenge)
+	require.NoError(t, err)
+
+	cd, ad, sig := a.get(t, challenge)
+	sig[len(sig)-1] ^= 1
+	_, err = rp.VerifyAssertion(cd, ad, sig, challenge, cred.PublicKey, cred.SignCount)
+	assert.Error(t, err)
+
+	// Another key can't answer for this credential
+	other := newSoftAuthenticator(t, AlgES256)
+	cd, ad, sig = other.get(t, challenge)
+	_, err = rp.VerifyAssertion(cd, ad, sig, challenge, cred.PublicKey, 0)
+	assert.ErrorIs(t, err, ErrBadSignature)
+}
+
+func TestCloneDetection(t *testing.T) {
+	a := newSoftAuthenticator(t, AlgES256)
+	challenge, _ := NewChallenge()
+	cd, att := a.create(challenge)
+	cred, err := rp.VerifyRegistration(cd, att, challenge)
+	require.NoError(t, err)
+
+	// A copy of the key carries on from the counter it was copied at, so
+	// one of the two eventually reports a count the server has seen
+	clone := *a
+	cd, ad, sig := a.get(t, challenge)
+	got, err := rp.VerifyAssertion(cd, ad, sig, challenge, cred.PublicKey, cred.SignCount)
+	require.NoError(t, err)
+	cd, ad, sig = clone.get(t, challenge)
+	_, err = rp.VerifyAssertion(cd, ad, sig, challenge, cred.PublicKey, got.SignCount)
+	assert.ErrorIs(t, err, ErrCloned)
+
+	// Authenticators without a counter report zero every time
+	counterless := newSoftAuthenticator(t, AlgEdDSA)
+	counterless.noCount = true
+	cd, att = counterless.create(challenge)
+	cred, err = rp.VerifyRegistration(cd, att, challenge)
+	require.NoError(t, err)
+	for range 2 {
+		cd, ad, sig = counterless.get(t, challenge)
+		_, err = rp.VerifyAssertion(cd, ad, sig, challenge, cred.PublicKey, cred.SignCount)
+		assert.NoError(t, err)
+	}
+}
+
+func TestDecodeCBORRejectsMalformed(t *testing.T) {
+	for _, data := range [][]byte{
+		{},
+		{0x5f},                         // indefinite byte string
+		{0x5a, 0xff, 0xff, 0xff},       // length past the end
+		{0xa1, 0x01},                   // map missing its value
+		{0xa2, 0x01, 0x01, 0x01, 0x02}, // duplicate key
+		{0xfa, 0, 0, 0, 20},            // a float, not false
+	} {
+		_, _, err := decodeCBOR(data)
+		assert.Error(t, err, "% x", data)
+	}
+}
+
+func TestURLBytes(t *testing.T) {
+	var b URLBytes
+	require.NoError(t, json.Unmarshal([]byte(`"AQID_w"`), &b))
+	assert.Equal(t, URLBytes{1, 2, 3, 0xff}, b)
+	require.NoError(t, json.Unmarshal([]byte(`"AQID_w=="`), &b))
+	assert.Equal(t, URLBytes{1, 2, 3, 0xff}, b)
+	out, _ := json.Marshal(b)
+	assert.Equal(t, `"AQID_w"`, string(out))
+}
+
+// encodeCBOR is just enough of an encoder to build what authenticators
+// send. Map keys are sorted so output is deterministic.
+func encodeCBOR(v any) []byte {
+	head := func(major byte, n uint64) []byte {
+		switch {
+		case n < 24:
+			return []byte{major<<5 | byte(n)}
+		case n < 1<<8:
+			return []byte{major<<5 | 24, byte(n)}
+		case n < 1<<16:
+			return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(n))
+		default:
+			return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(n))
+		}
+	}
+	switch v := v.(type) {
+	case int64:
+		if v < 0 {
+			return head(1, uint64(-1-v))
+		}
+		return head(0, uint64(v))
+	case []byte:
+		return append(head(2, uint64(len(v))), v...)
+	case string:
+		return append(head(3, uint64(len(v))), v...)
+	case map[any]any:
+		keys := make([][]byte, 0, len(v))
+		vals := map[string][]byte{}
+		for k, val := range v {
+			kb := encodeCBOR(k)
+			keys = append(keys, kb)
+			vals[string(kb)] = encodeCBOR(val)
+		}
+		sort.Slice(keys, func(i, j int) bool { return string(keys[i]) < string(keys[j]) })
+		out := head(5, uint64(len(v)))
+		for _, k := range keys {
+			out = append(append(out, k...), vals[string(k)]...)
+		}
+		return out
+	}
+	panic("unsupported type")
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/plusk0/webserver/internal/auth"
+)
+
+const jwksMaxAge = 300
+
+// newKeyring loads the JWT keys. JWT is the HMAC secret tokens have always
+// been signed with, and JWT_PREVIOUS lists retired secrets, comma
+// separated, that are still accepted. JWT_KEY_DIR holds Ed25519 or RSA
+// keys as <kid>.pem, and JWT_ACTIVE_KEY names the one to sign with; left
+// empty, the JWT secret keeps signing. Once an asymmetric key is active,
+// JWT can be unset so HMAC tokens are no longer accepted at all.
+func newKeyring() (*auth.Keyring, error) {
+	var keys []*auth.SigningKey
+	secret := os.Getenv("JWT")
+	if secret != "" {
+		keys = append(keys, auth.HMACKey(secret))
+	}
+	for _, old := range strings.Split(os.Getenv("JWT_PREVIOUS"), ",") {
+		if old = strings.TrimSpace(old); old != "" {
+			keys = append(keys, auth.HMACKey(old))
+		}
+	}
+	if dir := os.Getenv("JWT_KEY_DIR"); dir != "" {
+		paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range paths {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			key, err := auth.ParsePEMKey(strings.TrimSuffix(filepath.Base(path), ".pem"), data)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, key)
+		}
+	}
+
+	var active *auth.SigningKey
+	if id := os.Getenv("JWT_ACTIVE_KEY"); id != "" {
+		for _, key := range keys {
+			if key.ID == id {
+				active = key
+			}
+		}
+		if active == nil {
+			return nil, fmt.Errorf("JWT_ACTIVE_KEY %q isn't in JWT_KEY_DIR", id)
+		}
+	} else if secret != "" {
+		active = keys[0]
+	} else {
+		return nil, errors.New("JWT must be set unless JWT_ACTIVE_KEY names a key")
+	}
+	var rest []*auth.SigningKey
+	for _, key := range keys {
+		if key != active {
+			rest = append(rest, key)
+		}
+	}
+	return auth.NewKeyring(active, rest...)
+}
+
+// jwksHandlerFunc publishes the public keys tokens may be signed with.
+// Caches are kept short so a newly added key is picked up before it's
+// made active.
+func (conf *apiConfig) jwksHandlerFunc(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", jwksMaxAge))
+	respondWithJSON(w, 200, conf.jwtKeys.JWKS())
+}
+
+// newLinkSecret loads the secret download links are signed with. It's
+// separate from the JWT keys so those can move to an asymmetric key
+// without leaving the old HMAC secret around; setups from before
+// LINK_SECRET keep using JWT.
+func newLinkSecret() (string, error) {
+	if secret := os.Getenv("LINK_SECRET"); secret != "" {
+		return secret, nil
+	}
+	if secret := os.Getenv("JWT"); secret != "" {
+		return secret, nil
+	}
+	return "", errors.New("LINK_SECRET must be set")
+}
@@ -0,0 +1,418 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/plusk0/webserver/internal/auth"
+	"github.com/plusk0/webserver/internal/database"
+	"github.com/plusk0/webserver/internal/lockout"
+	"github.com/plusk0/webserver/internal/mailer"
+)
+
+const loginFailurePruneInterval = time.Hour
+
+var errLoginFailed = errors.New("wrong email or password")
+
+// loginLockedError is returned while an account or address has to wait
+// before trying again. It reads the same whether or not the account
+// exists.
+type loginLockedError struct {
+	retryAfter time.Duration
+}
+
+func (e *loginLockedError) Error() string {
+	return "too many failed logins"
+}
+
+// setRetryAfter sets the Retry-After header in whole seconds, rounded up.
+func (e *loginLockedError) setRetryAfter(w http.ResponseWriter) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(e.retryAfter.Seconds()))))
+}
+
+// dummyPasswordHash is checked against when there's no such user, so a
+// wrong email takes as long as a wrong password.
+var dummyPasswordHash = sync.OnceValue(func() string {
+	hash, err := auth.HashPassword("chirpy-dummy-password")
+	if err != nil {
+		panic(err)
+	}
+	return hash
+})
+
+// newLoginPolicy reads the lockout thresholds: LOGIN_FREE_FAILURES,
+// LOGIN_MAX_FAILURES and LOGIN_IP_MAX_FAILURES as counts, and
+// LOGIN_FAILURE_WINDOW and LOGIN_LOCKOUT as durations such as "15m".
+// Anything unset keeps its default.
+func newLoginPolicy() (lockout.Policy, error) {
+	p := lockout.DefaultPolicy()
+	counts := []struct {
+		env string
+		v   *int
+	}{
+		{"LOGIN_FREE_FAILURES", &p.FreeFailures},
+		{"LOGIN_MAX_FAILURES", &p.MaxFailures},
+		{"LOGIN_IP_MAX_FAILURES", &p.IPMaxFailures},
+	}
+	for _, c := range counts {
+		if s := os.Getenv(c.env); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 0 {
+				return p, fmt.Errorf("%s must be a whole number", c.env)
+			}
+			*c.v = n
+		}
+	}
+	durations := []struct {
+		env string
+		v   *time.Duration
+	}{
+		{"LOGIN_FAILURE_WINDOW", &p.Window},
+		{"LOGIN_LOCKOUT", &p.Lockout},
+	}
+	for _, d := range durations {
+		if s := os.Getenv(d.env); s != "" {
+			v, err := time.ParseDuration(s)
+			if err != nil || v <= 0 {
+				return p, fmt.Errorf("%s must be a duration such as 15m", d.env)
+			}
+			*d.v = v
+		}
+	}
+	if p.MaxFailures <= p.FreeFailures {
+		return p, errors.New("LOGIN_MAX_FAILURES must be more than LOGIN_FREE_FAILURES")
+	}
+	return p, nil
+}
+
+// newTrustedProxies reads TRUSTED_PROXIES, the addresses or CIDR ranges
+// of reverse proxies whose X-Forwarded-For can be believed.
+func newTrustedProxies() ([]netip.Prefix, error) {
+	var out []netip.Prefix
+	for _, s := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if addr, err := netip.ParseAddr(s); err == nil {
+			out = append(out, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("TRUSTED_PROXIES: %q isn't an address or CIDR range", s)
+		}
+		out = append(out, prefix.Masked())
+	}
+	return out, nil
+}
+
+func (conf *apiConfig) trustedProxy(addr netip.Addr) bool {
+	for _, p := range conf.trustedProxies {
+		if p.Contains(addr.Unmap()) {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteIP is the address a request came from, as recorded for sessions
+// and counted against by failed logins. The client can't choose it:
+// X-Forwarded-For only counts when the request came through a trusted
+// proxy, and then only the entry that proxy added.
+func (conf *apiConfig) remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return host
+	}
+	fwd := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(fwd) - 1; i >= 0 && conf.trustedProxy(addr); i-- {
+		next, err := netip.ParseAddr(strings.TrimSpace(fwd[i]))
+		if err != nil {
+			break
+		}
+		addr = next
+	}
+	return addr.Unmap().String()
+}
+
+func loginAccountKey(email string) string {
+	return "email:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func loginIPKey(ip string) string {
+	return "ip:" + ip
+}
+
+func (conf *apiConfig) loginFailure(ctx context.Context, key string) (lockout.Record, error) {
+	f, err := conf.dbQueries.GetLoginFailure(ctx, key)
+	if errors.Is(err, sql.ErrNoRows) {
+		return lockout.Record{}, nil
+	}
+	if err != nil {
+		return lockout.Record{}, err
+	}
+	return dbLoginFailureToRecord(f), nil
+}
+
+// checkLogin checks an email and password, counting failures against both
+// the account and the address they came from. It returns errLoginFailed
+// for a wrong email or password, or a *loginLockedError while attempts
+// are being held off. The right password for a suspended account gets
+// errAccountSuspended.
+func (conf *apiConfig) checkLogin(ctx context.Context, r *http.Request, email, password string) (database.User, error) {
+	accountKey, ipKey := loginAccountKey(email), loginIPKey(conf.remoteIP(r))
+	account, err := conf.loginFailure(ctx, accountKey)
+	if err != nil {
+		return database.User{}, err
+	}
+	ip, err := conf.loginFailure(ctx, ipKey)
+	if err != nil {
+		return database.User{}, err
+	}
+	now := time.Now()
+	if wait := max(conf.loginPolicy.RetryAfter(account, now), conf.loginPolicy.IPRetryAfter(ip, now)); wait > 0 {
+		return database.User{}, &loginLockedError{wait}
+	}
+
+	// Every attempt counts as a failure until the password checks out.
+	// Counting first, and going by the count that comes back, stops
+	// concurrent attempts from all getting in under the limit.
+	windowStart := now.Add(-conf.loginPolicy.Window)
+	ipF, err := conf.dbQueries.RecordLoginFailure(ctx, database.RecordLoginFailureParams{Key: ipKey, FirstFailedAt: windowStart})
+	if err != nil {
+		return database.User{}, err
+	}
+	if counted := dbLoginFailureToRecord(ipF); !conf.loginPolicy.IPAdmit(counted) {
+		return database.User{}, &loginLockedError{conf.loginPolicy.IPRetryAfter(counted, now)}
+	}
+	accountF, err := conf.dbQueries.RecordLoginFailure(ctx, database.RecordLoginFailureParams{Key: accountKey, FirstFailedAt: windowStart})
+	if err != nil {
+		return database.User{}, err
+	}
+	if counted := dbLoginFailureToRecord(accountF); !conf.loginPolicy.Admit(counted, now) {
+		wait := conf.loginPolicy.Lockout
+		if now.Before(counted.LockedUntil) {
+			wait = counted.LockedUntil.Sub(now)
+		}
+		return database.User{}, &loginLockedError{wait}
+	}
+
+	user, err := conf.dbQueries.GetUser(ctx, email)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return database.User{}, err
+	}
+	exists := err == nil
+	hash := user.Password
+	if !exists {
+		hash = dummyPasswordHash()
+	}
+	valid, err := auth.CheckPasswordHash(password, hash)
+	if err == nil && valid && exists {
+		// With two-factor on the login isn't over yet. The count carries
+		// on into checkSecondFactorLogin, so knowing the password doesn't
+		// reset the guesses at the code.
+		twoFactor, err := conf.twoFactorEnabled(ctx, user.ID)
+		if err != nil {
+			return database.User{}, err
+		}
+		if !twoFactor {
+			if _, err := conf.dbQueries.ClearLoginFailures(ctx, accountKey); err != nil {
+				return database.User{}, err
+			}
+		}
+		// The address isn't cleared, or one good account would let it
+		// keep guessing at others
+		if err := conf.dbQueries.ForgetLoginAttempt(ctx, ipKey); err != nil {
+			return database.User{}, err
+		}
+		if user.SuspendedAt.Valid {
+			return database.User{}, errAccountSuspended
+		}
+		return user, nil
+	}
+
+	if conf.loginPolicy.ShouldLock(dbLoginFailureToRecord(accountF)) {
+		var lockedUser *database.User
+		if exists {
+			lockedUser = &user
+		}
+		if err := conf.lockLogin(ctx, accountKey, lockedUser, int(accountF.Failures), false); err != nil {
+			return database.User{}, err
+		}
+	}
+	return database.User{}, errLoginFailed
+}
+
+// checkSecondFactorLogin checks the code given to finish user's login.
+// Wrong codes count against the account just like wrong passwords, so
+// logging in again for a fresh challenge doesn't buy more guesses. It
+// returns errLoginFailed for a wrong code, or a *loginLockedError while
+// attempts are being held off.
+func (conf *apiConfig) checkSecondFactorLogin(ctx context.Context, user database.User, req twoFactorReq) error {
+	accountKey := loginAccountKey(user.Email)
+	account, err := conf.loginFailure(ctx, accountKey)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	if wait := conf.loginPolicy.RetryAfter(account, now); wait > 0 {
+		return &loginLockedError{wait}
+	}
+
+	// Counted first for the same reason as in checkLogin
+	params := database.RecordLoginFailureParams{Key: accountKey, FirstFailedAt: now.Add(-conf.loginPolicy.Window)}
+	f, err := conf.dbQueries.RecordLoginFailure(ctx, params)
+	if err != nil {
+		return err
+	}
+	counted := dbLoginFailureToRecord(f)
+	if !conf.loginPolicy.Admit(counted, now) {
+		wait := conf.loginPolicy.Lockout
+		if now.Before(counted.LockedUntil) {
+			wait = counted.LockedUntil.Sub(now)
+		}
+		return &loginLockedError{wait}
+	}
+
+	ok, err := checkSecondFactor(ctx, conf.dbQueries, user.ID, req)
+	if err != nil {
+		return err
+	}
+	if ok {
+		_, err := conf.dbQueries.ClearLoginFailures(ctx, accountKey)
+		return err
+	}
+	if conf.loginPolicy.ShouldLock(counted) {
+		if err := conf.lockLogin(ctx, accountKey, &user, int(f.Failures), true); err != nil {
+			return err
+		}
+	}
+	return errLoginFailed
+}
+
+// lockLogin locks accountKey for the policy's lockout. If it belongs to a
+// real user, that's audited and they're told by email. secondFactor says
+// the password was right and the codes were wrong, which the user needs
+// to hear about differently.
+func (conf *apiConfig) lockLogin(ctx context.Context, accountKey string, user *database.User, failures int, secondFactor bool) error {
+	until := time.Now().Add(conf.loginPolicy.Lockout)
+	params := database.LockLoginParams{Key: accountKey, LockedUntil: sql.NullTime{Time: until, Valid: true}}
+	if err := conf.dbQueries.LockLogin(ctx, params); err != nil {
+		return err
+	}
+	if user == nil {
+		return nil
+	}
+	details := map[string]any{"failures": failures, "locked_until": until, "second_factor": secondFactor}
+	if err := audit(ctx, conf.dbQueries, auditAccountLocked, user.ID, details); err != nil {
+		return err
+	}
+	body := fmt.Sprintf("Someone got your Chirpy password wrong %d times in a row, so logging in is "+
+		"locked for %s.\n\n"+
+		"If that was you, wait and try again, or reset your password. If it wasn't, your password "+
+		"is still safe, but consider changing it.\n", failures, conf.loginPolicy.Lockout)
+	if secondFactor {
+		body = fmt.Sprintf("Someone entered your Chirpy password correctly but got your two-factor code "+
+			"wrong %d times in a row, so logging in is locked for %s.\n\n"+
+			"If that wasn't you, someone knows your password. Change it as soon as you can.\n",
+			failures, conf.loginPolicy.Lockout)
+	}
+	err := conf.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Your Chirpy account was locked",
+		Body:    body,
+	})
+	if err != nil {
+		fmt.Printf("Failed to send lockout email: %v\n", err)
+	}
+	return nil
+}
+
+func (conf *apiConfig) pruneLoginFailures() {
+	for {
+		cutoff := time.Now().Add(-conf.loginPolicy.Window)
+		if err := conf.dbQueries.DeleteStaleLoginFailures(context.Background(), cutoff); err != nil {
+			fmt.Printf("Failed to prune login failures: %v\n", err)
+		}
+		time.Sleep(loginFailurePruneInterval)
+	}
+}
+
+// unlockUserHandlerFunc lets a moderator lift a lockout before it runs
+// out.
+func (conf *apiConfig) unlockUserHandlerFunc(w http.ResponseWriter, r *http.Request) {
+	tk, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	modID, err := auth.ValidateJWT(tk, conf.jwtKeys)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	mod, err := conf.dbQueries.GetUserByID(r.Context(), modID)
+	if err != nil || !mod.IsModerator {
+		respondWithError(w, 403, "User not Authorized")
+		return
+	}
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, 404, "User not found")
+		return
+	}
+	user, err := conf.dbQueries.GetUserByID(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, 404, "User not found")
+		return
+	}
+
+	tx, err := conf.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, 500, "Failed to unlock user")
+		return
+	}
+	defer tx.Rollback()
+	q := conf.dbQueries.WithTx(tx)
+
+	n, err := q.ClearLoginFailures(r.Context(), loginAccountKey(user.Email))
+	if err != nil {
+		respondWithError(w, 500, "Failed to unlock user")
+		return
+	}
+	if err := audit(r.Context(), q, auditAccountUnlocked, user.ID, map[string]any{"by": modID, "had_failures": n > 0}); err != nil {
+		respondWithError(w, 500, "Failed to unlock user")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		respondWithError(w, 500, "Failed to unlock user")
+		return
+	}
+	w.WriteHeader(204)
+}
+
+func dbLoginFailureToRecord(db database.LoginFailure) lockout.Record {
+	return lockout.Record{
+		Failures:      int(db.Failures),
+		FirstFailedAt: db.FirstFailedAt,
+		LastFailedAt:  db.LastFailedAt,
+		LockedUntil:   db.LockedUntil.Time,
+	}
+}
@@ -5,10 +5,18 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 
+	"github.com/google/uuid"
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
+	"github.com/plusk0/webserver/internal/activitypub"
+	"github.com/plusk0/webserver/internal/analytics"
 	"github.com/plusk0/webserver/internal/database"
+	"github.com/plusk0/webserver/internal/realtime"
+	"github.com/plusk0/webserver/internal/revocation"
 )
 
 func main() {
@@ -23,11 +31,102 @@ func main() {
 	if err != nil {
 		log.Fatal("Failed to open DB")
 	}
+	apiConf.db = db
 	apiConf.dbQueries = database.New(db)
 	apiConf.platform = os.Getenv("PLATFORM")
-	apiConf.JWTKey = os.Getenv("JWT")
+	apiConf.jwtKeys, err = newKeyring()
+	if err != nil {
+		log.Fatal(err)
+	}
+	apiConf.linkSecret, err = newLinkSecret()
+	if err != nil {
+		log.Fatal(err)
+	}
+	apiConf.revoked = revocation.NewList(apiConf.loadTokenVersion, tokenVersionTTL)
+	apiConf.jwtKeys.SetRevocations(apiConf.revoked)
+	go apiConf.syncRevocations()
+
+	apiConf.loginPolicy, err = newLoginPolicy()
+	if err != nil {
+		log.Fatal(err)
+	}
+	apiConf.trustedProxies, err = newTrustedProxies()
+	if err != nil {
+		log.Fatal(err)
+	}
+	go apiConf.pruneLoginFailures()
+
+	apiConf.passwordPolicy, err = newPasswordPolicy()
+	if err != nil {
+		log.Fatal(err)
+	}
 	apiConf.PolkaKey = os.Getenv("POLKA_KEY")
 
+	maxConns := 5
+	if v := os.Getenv("WS_MAX_CONNS"); v != "" {
+		maxConns, err = strconv.Atoi(v)
+		if err != nil {
+			log.Fatal("Failed to parse WS_MAX_CONNS")
+		}
+	}
+	apiConf.hub = realtime.NewHub(maxConns)
+
+	apiConf.chirpDeletionPolicy = os.Getenv("CHIRP_DELETION_POLICY")
+	switch apiConf.chirpDeletionPolicy {
+	case "":
+		apiConf.chirpDeletionPolicy = "delete"
+	case "delete", "anonymize":
+	default:
+		log.Fatal("CHIRP_DELETION_POLICY must be delete or anonymize")
+	}
+
+	apiConf.exportDir = os.Getenv("EXPORT_DIR")
+	if apiConf.exportDir == "" {
+		// Keep archives out of the directory the file server exposes
+		apiConf.exportDir = filepath.Join(os.TempDir(), "chirpy-exports")
+	}
+	apiConf.exportQueue = make(chan uuid.UUID)
+	go apiConf.runExportWorker()
+	go apiConf.sweepExports()
+
+	apiConf.importDir = os.Getenv("IMPORT_DIR")
+	if apiConf.importDir == "" {
+		apiConf.importDir = filepath.Join(os.TempDir(), "chirpy-imports")
+	}
+	apiConf.importQueue = make(chan uuid.UUID)
+	go apiConf.runImportWorker()
+
+	apiConf.views = analytics.NewCounter()
+	go apiConf.flushViews()
+
+	apiConf.pages = loadPages("templates")
+	apiConf.baseURL = strings.TrimRight(os.Getenv("BASE_URL"), "/")
+	if apiConf.baseURL == "" {
+		log.Println("BASE_URL is not set: federation is off and verification emails won't be sent")
+	}
+
+	apiConf.mailer, err = newMailer()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	apiConf.webauthn, err = newRelyingParty(apiConf.baseURL)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	apiConf.oidc, err = newOIDCProvider(apiConf.baseURL)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Local peers are only reachable in development
+	apiConf.federationClient = activitypub.NewClient(apiConf.platform == "dev")
+	apiConf.deliveryWake = make(chan struct{}, 1)
+	if apiConf.federating() {
+		go apiConf.runDeliveryWorker()
+	}
+
 	port := ":8080"
 
 	mux := http.NewServeMux()
@@ -35,19 +134,85 @@ func main() {
 	mux.Handle("GET /api/healthz", http.HandlerFunc(healthHandlerFunc))
 	mux.Handle("POST /api/chirps", http.HandlerFunc(apiConf.validateHandlerFunc))
 	mux.Handle("GET /api/chirps", http.HandlerFunc(apiConf.getChirpsHandlerFunc))
+	mux.Handle("POST /api/chirps/import", http.HandlerFunc(apiConf.importChirpsHandlerFunc))
+	mux.Handle("GET /api/imports/{jobID}", http.HandlerFunc(apiConf.importStatusHandlerFunc))
 	mux.Handle("GET /api/chirps/{chirpID}", http.HandlerFunc(apiConf.getChirpHandlerFunc))
+	mux.Handle("GET /api/chirps/{chirpID}/stats", http.HandlerFunc(apiConf.chirpStatsHandlerFunc))
+	mux.Handle("PUT /api/chirps/{chirpID}/sensitive", http.HandlerFunc(apiConf.chirpSensitiveHandlerFunc))
 	mux.Handle("DELETE /api/chirps/{chirpID}", http.HandlerFunc(apiConf.deleteChirpHandlerFunc))
 
 	mux.Handle("POST /api/users", http.HandlerFunc(apiConf.usersHandlerFunc))
 	mux.Handle("PUT /api/users", http.HandlerFunc(apiConf.userUpdateHandlerFunc))
+	mux.Handle("DELETE /api/users", http.HandlerFunc(apiConf.userDeleteHandlerFunc))
+	mux.Handle("PUT /api/users/preferences", http.HandlerFunc(apiConf.userPreferencesHandlerFunc))
+	mux.Handle("POST /api/password/forgot", http.HandlerFunc(apiConf.forgotPasswordHandlerFunc))
+	mux.Handle("POST /api/password/reset", http.HandlerFunc(apiConf.resetPasswordHandlerFunc))
+	mux.Handle("GET /api/users/verify", http.HandlerFunc(apiConf.verifyEmailHandlerFunc))
+	mux.Handle("POST /api/users/verify/resend", http.HandlerFunc(apiConf.resendVerificationHandlerFunc))
+	mux.Handle("POST /api/users/2fa", http.HandlerFunc(apiConf.enrollTwoFactorHandlerFunc))
+	mux.Handle("POST /api/users/2fa/confirm", http.HandlerFunc(apiConf.confirmTwoFactorHandlerFunc))
+	mux.Handle("DELETE /api/users/2fa", http.HandlerFunc(apiConf.disableTwoFactorHandlerFunc))
+	mux.Handle("POST /api/users/passkeys/options", http.HandlerFunc(apiConf.passkeyOptionsHandlerFunc))
+	mux.Handle("POST /api/users/passkeys", http.HandlerFunc(apiConf.createPasskeyHandlerFunc))
+	mux.Handle("GET /api/users/passkeys", http.HandlerFunc(apiConf.getPasskeysHandlerFunc))
+	mux.Handle("DELETE /api/users/passkeys/{passkeyID}", http.HandlerFunc(apiConf.deletePasskeyHandlerFunc))
+	mux.Handle("POST /api/users/tokens", http.HandlerFunc(apiConf.createAccessTokenHandlerFunc))
+	mux.Handle("GET /api/users/tokens", http.HandlerFunc(apiConf.getAccessTokensHandlerFunc))
+	mux.Handle("DELETE /api/users/tokens/{tokenID}", http.HandlerFunc(apiConf.revokeAccessTokenHandlerFunc))
+	mux.Handle("GET /api/users/identities", http.HandlerFunc(apiConf.getIdentitiesHandlerFunc))
+	mux.Handle("PUT /api/users/profile", http.HandlerFunc(apiConf.updateProfileHandlerFunc))
+	mux.Handle("GET /api/users/{userID}", http.HandlerFunc(apiConf.getProfileHandlerFunc))
+	mux.Handle("GET /api/users/by-handle/{handle}", http.HandlerFunc(apiConf.getProfileByHandleHandlerFunc))
+	mux.Handle("GET /api/users/{userID}/{feed}", http.HandlerFunc(apiConf.userFeedHandlerFunc))
+	mux.Handle("GET /api/hashtags/{tag}/{feed}", http.HandlerFunc(apiConf.hashtagFeedHandlerFunc))
+	mux.Handle("POST /api/users/export", http.HandlerFunc(apiConf.exportHandlerFunc))
+	mux.Handle("GET /api/users/export/{jobID}", http.HandlerFunc(apiConf.exportStatusHandlerFunc))
+	mux.Handle("GET /api/exports/{jobID}/download", http.HandlerFunc(apiConf.exportDownloadHandlerFunc))
 	mux.Handle("POST /api/login", http.HandlerFunc(apiConf.loginHandlerFunc))
+	mux.Handle("POST /api/login/2fa", http.HandlerFunc(apiConf.loginTwoFactorHandlerFunc))
+	mux.Handle("POST /api/login/passkey/options", http.HandlerFunc(apiConf.passkeyLoginOptionsHandlerFunc))
+	mux.Handle("POST /api/login/passkey", http.HandlerFunc(apiConf.passkeyLoginHandlerFunc))
+	mux.Handle("GET /api/sessions", http.HandlerFunc(apiConf.getSessionsHandlerFunc))
+	mux.Handle("DELETE /api/sessions/{sessionID}", http.HandlerFunc(apiConf.revokeSessionHandlerFunc))
+	mux.Handle("POST /api/sessions/revoke-others", http.HandlerFunc(apiConf.revokeOtherSessionsHandlerFunc))
+	mux.Handle("GET /api/login/oidc", http.HandlerFunc(apiConf.oidcLoginHandlerFunc))
+	mux.Handle("GET /api/login/oidc/callback", http.HandlerFunc(apiConf.oidcCallbackHandlerFunc))
+	mux.Handle("GET /oauth/authorize", http.HandlerFunc(apiConf.authorizeHandlerFunc))
+	mux.Handle("POST /oauth/authorize", http.HandlerFunc(apiConf.authorizeSubmitHandlerFunc))
+	mux.Handle("POST /oauth/token", http.HandlerFunc(apiConf.oauthTokenHandlerFunc))
+	mux.Handle("POST /api/oauth/clients", http.HandlerFunc(apiConf.createOAuthClientHandlerFunc))
+	mux.Handle("GET /api/oauth/clients", http.HandlerFunc(apiConf.getOAuthClientsHandlerFunc))
+	mux.Handle("DELETE /api/oauth/clients/{clientID}", http.HandlerFunc(apiConf.deleteOAuthClientHandlerFunc))
+	mux.Handle("GET /api/oauth/authorizations", http.HandlerFunc(apiConf.getAuthorizationsHandlerFunc))
+	mux.Handle("DELETE /api/oauth/authorizations/{clientID}", http.HandlerFunc(apiConf.revokeAuthorizationHandlerFunc))
 	mux.Handle("POST /api/refresh", http.HandlerFunc(apiConf.refreshHandlerFunc))
 	mux.Handle("POST /api/revoke", http.HandlerFunc(apiConf.revokeHandlerFunc))
+	mux.Handle("POST /api/logout", http.HandlerFunc(apiConf.logoutHandlerFunc))
+
+	mux.Handle("GET /api/notifications", http.HandlerFunc(apiConf.getNotificationsHandlerFunc))
+	mux.Handle("POST /api/notifications/read", http.HandlerFunc(apiConf.readNotificationsHandlerFunc))
+
+	mux.Handle("GET /api/ws", http.HandlerFunc(apiConf.wsHandlerFunc))
 
 	mux.Handle("POST /api/polka/webhooks", http.HandlerFunc(apiConf.webhookHandlerFunc))
 
+	mux.Handle("GET /.well-known/jwks.json", http.HandlerFunc(apiConf.jwksHandlerFunc))
+	mux.Handle("GET /.well-known/webfinger", http.HandlerFunc(apiConf.webfingerHandlerFunc))
+	mux.Handle("GET /ap/users/{userID}", http.HandlerFunc(apiConf.actorHandlerFunc))
+	mux.Handle("GET /ap/users/{userID}/outbox", http.HandlerFunc(apiConf.outboxHandlerFunc))
+	mux.Handle("GET /ap/users/{userID}/followers", http.HandlerFunc(apiConf.followersHandlerFunc))
+	mux.Handle("POST /ap/users/{userID}/inbox", http.HandlerFunc(apiConf.inboxHandlerFunc))
+	mux.Handle("POST /ap/inbox", http.HandlerFunc(apiConf.inboxHandlerFunc))
+	mux.Handle("GET /ap/chirps/{chirpID}", http.HandlerFunc(apiConf.noteHandlerFunc))
+
+	mux.Handle("GET /u/{userID}", http.HandlerFunc(apiConf.profilePageHandlerFunc))
+	mux.Handle("GET /c/{chirpID}", http.HandlerFunc(apiConf.chirpPageHandlerFunc))
+
 	mux.Handle("/app/", http.StripPrefix("/app", apiConf.middlewareMetricsInc(fileServer)))
 
+	mux.Handle("POST /api/admin/users/{userID}/unlock", http.HandlerFunc(apiConf.unlockUserHandlerFunc))
+	mux.Handle("POST /api/admin/users/{userID}/suspend", http.HandlerFunc(apiConf.suspendUserHandlerFunc))
+	mux.Handle("POST /api/admin/users/{userID}/unsuspend", http.HandlerFunc(apiConf.unsuspendUserHandlerFunc))
 	mux.Handle("GET /admin/metrics", http.HandlerFunc(apiConf.metricsHandler))
 	mux.Handle("POST /admin/reset", http.HandlerFunc(apiConf.metricsResetHandler))
 
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/plusk0/webserver/internal/auth"
+	"github.com/plusk0/webserver/internal/database"
+	"github.com/plusk0/webserver/internal/realtime"
+	"github.com/plusk0/webserver/internal/richtext"
+)
+
+const (
+	notificationMention = "mention"
+	notificationLike    = "like"
+	notificationReply   = "reply"
+	notificationRechirp = "rechirp"
+	notificationFollow  = "follow"
+)
+
+// notificationVerbs doubles as the list of types the inbox can be filtered by.
+var notificationVerbs = map[string]string{
+	notificationMention: "mentioned you",
+	notificationLike:    "liked your chirp",
+	notificationReply:   "replied to your chirp",
+	notificationRechirp: "rechirped your chirp",
+	notificationFollow:  "followed you",
+}
+
+func (conf *apiConfig) notifyMentions(ctx context.Context, chirp database.Chirp) {
+	for _, handle := range richtext.Mentions(chirp.Body) {
+		user, err := conf.dbQueries.GetUserByHandle(ctx, handle)
+		if err != nil {
+			continue
+		}
+		conf.notify(ctx, user.ID, chirp.UserID.UUID, notificationMention, uuid.NullUUID{UUID: chirp.ID, Valid: true})
+	}
+}
+
+// notify stores a notification and pushes it to any open WebSocket of the
+// recipient. Acting on your own content never notifies you.
+func (conf *apiConfig) notify(ctx context.Context, userID, actorID uuid.UUID, typ string, chirpID uuid.NullUUID) {
+	if userID == actorID {
+		return
+	}
+	conf.createNotification(ctx, database.CreateNotificationParams{
+		UserID:  userID,
+		ActorID: uuid.NullUUID{UUID: actorID, Valid: true},
+		Type:    typ,
+		ChirpID: chirpID,
+	})
+}
+
+// notifyRemote is notify for an actor on another server.
+func (conf *apiConfig) notifyRemote(ctx context.Context, userID uuid.UUID, actorID string, typ string, chirpID uuid.NullUUID) {
+	conf.createNotification(ctx, database.CreateNotificationParams{
+		UserID:        userID,
+		RemoteActorID: sql.NullString{String: actorID, Valid: true},
+		Type:          typ,
+		ChirpID:       chirpID,
+	})
+}
+
+func (conf *apiConfig) createNotification(ctx context.Context, params database.CreateNotificationParams) {
+	n, err := conf.dbQueries.CreateNotification(ctx, params)
+	if err != nil {
+		fmt.Printf("Failed to create notification: %v\n", err)
+		return
+	}
+	env, err := realtime.NewEnvelope("notification", 0, dbNotificationToJSON(n))
+	if err != nil {
+		return
+	}
+	conf.hub.Send(params.UserID, env)
+}
+
+func (conf *apiConfig) getNotificationsHandlerFunc(w http.ResponseWriter, r *http.Request) {
+	tk, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	userID, err := conf.validateToken(r.Context(), tk, "notifications")
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+
+	typ := r.URL.Query().Get("type")
+	if _, ok := notificationVerbs[typ]; typ != "" && !ok {
+		respondWithError(w, 400, "Unknown notification type")
+		return
+	}
+
+	params := database.GetNotificationsParams{UserID: userID, Type: typ}
+	notifications, err := conf.dbQueries.GetNotifications(r.Context(), params)
+	if err != nil {
+		respondWithError(w, 500, "Failed to get notifications")
+		return
+	}
+	unread, err := conf.dbQueries.CountUnreadNotifications(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, 500, "Failed to get notifications")
+		return
+	}
+	respondWithJSON(w, 200, NotificationInbox{unread, groupNotifications(notifications)})
+}
+
+func (conf *apiConfig) readNotificationsHandlerFunc(w http.ResponseWriter, r *http.Request) {
+	tk, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	userID, err := conf.validateToken(r.Context(), tk, "notifications")
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+
+	// An empty body marks the whole inbox as read
+	var req notificationReadReq
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		respondWithError(w, 400, "Something went wrong")
+		return
+	}
+	defer r.Body.Close()
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &req); err != nil {
+			respondWithError(w, 400, "Something went wrong")
+			return
+		}
+	}
+	if _, ok := notificationVerbs[req.Type]; req.Type != "" && !ok {
+		respondWithError(w, 400, "Unknown notification type")
+		return
+	}
+
+	params := database.MarkNotificationsReadParams{UserID: userID, Type: req.Type}
+	if req.ChirpID != nil {
+		params.ChirpID = uuid.NullUUID{UUID: *req.ChirpID, Valid: true}
+	}
+	if err := conf.dbQueries.MarkNotificationsRead(r.Context(), params); err != nil {
+		respondWithError(w, 500, "Failed to mark notifications as read")
+		return
+	}
+	w.WriteHeader(204)
+}
+
+// groupNotifications folds notifications of the same type on the same chirp
+// into one entry, so fifty likes show up as a single "50 people liked your
+// chirp". Input must be newest first; groups keep that order.
+func groupNotifications(notifications []database.Notification) []NotificationGroup {
+	type groupKey struct {
+		typ     string
+		chirpID uuid.NullUUID
+	}
+	index := map[groupKey]int{}
+	// Local actors are keyed by their UUID, remote ones by their actor ID
+	seenActors := map[groupKey]map[string]bool{}
+	groups := []NotificationGroup{}
+	for _, n := range notifications {
+		key := groupKey{n.Type, n.ChirpID}
+		i, ok := index[key]
+		if !ok {
+			group := NotificationGroup{Type: n.Type, ActorIDs: []uuid.UUID{}, LatestAt: n.CreatedAt}
+			if n.ChirpID.Valid {
+				group.ChirpID = &n.ChirpID.UUID
+			}
+			groups = append(groups, group)
+			i = len(groups) - 1
+			index[key] = i
+			seenActors[key] = map[string]bool{}
+		}
+		group := &groups[i]
+		if !n.ReadAt.Valid {
+			group.Unread = true
+		}
+		switch {
+		case n.ActorID.Valid && !seenActors[key][n.ActorID.UUID.String()]:
+			seenActors[key][n.ActorID.UUID.String()] = true
+			group.ActorIDs = append(group.ActorIDs, n.ActorID.UUID)
+		case n.RemoteActorID.Valid && !seenActors[key][n.RemoteActorID.String]:
+			seenActors[key][n.RemoteActorID.String] = true
+			group.RemoteActorIDs = append(group.RemoteActorIDs, n.RemoteActorID.String)
+		}
+	}
+	for i := range groups {
+		groups[i].Count = len(groups[i].ActorIDs) + len(groups[i].RemoteActorIDs)
+		groups[i].Summary = notificationSummary(groups[i].Type, groups[i].Count)
+	}
+	return groups
+}
+
+func notificationSummary(typ string, count int) string {
+	if count == 1 {
+		return "Someone " + notificationVerbs[typ]
+	}
+	return fmt.Sprintf("%d people %s", count, notificationVerbs[typ])
+}
+
+func dbNotificationToJSON(db database.Notification) Notification {
+	n := Notification{
+		ID:        db.ID,
+		CreatedAt: db.CreatedAt,
+		Type:      db.Type,
+	}
+	if db.ActorID.Valid {
+		n.ActorID = &db.ActorID.UUID
+	}
+	if db.RemoteActorID.Valid {
+		n.RemoteActorID = db.RemoteActorID.String
+	}
+	if db.ChirpID.Valid {
+		n.ChirpID = &db.ChirpID.UUID
+	}
+	return n
+}
@@ -0,0 +1,652 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/plusk0/webserver/internal/auth"
+	"github.com/plusk0/webserver/internal/database"
+)
+
+const (
+	oauthCodeTTL         = time.Minute
+	oauthAccessTokenTTL  = 15 * time.Minute
+	oauthRefreshTokenTTL = 30 * 24 * time.Hour
+	oauthClientNameMax   = 50
+	oauthRedirectURIsMax = 10
+	oauthRedirectURIMax  = 500
+)
+
+// apiScopes are what third-party apps can ask for and personal access
+// tokens can be given, in the order the consent screen lists them.
+// Endpoints opt in with conf.validateToken; everything else stays
+// first-party only.
+var apiScopes = []struct{ Name, Description string }{
+	{"chirps:read", "See your chirp stats and content preferences"},
+	{"chirps:write", "Post and delete chirps for you"},
+	{"profile:write", "Change your profile"},
+	{"notifications", "Read your notifications and mark them as read"},
+	{"messages", "Read and send your direct messages, and get your notifications as they happen"},
+}
+
+// oauthError is an error from RFC 6749 section 4.1.2.1 or 5.2.
+type oauthError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+func (e *oauthError) Error() string {
+	return e.Code + ": " + e.Description
+}
+
+// authorizeRequest is a checked authorization request. Until client is
+// set the redirect URI hasn't been verified and mustn't be redirected to.
+type authorizeRequest struct {
+	client        *database.OauthClient
+	RedirectURI   string
+	State         string
+	Scopes        []string
+	CodeChallenge string
+}
+
+// checkAuthorizeRequest validates the parameters of an authorization
+// request, from the query string or the consent form.
+func (conf *apiConfig) checkAuthorizeRequest(ctx context.Context, v url.Values) (authorizeRequest, error) {
+	req := authorizeRequest{RedirectURI: v.Get("redirect_uri"), State: v.Get("state")}
+
+	clientID, err := uuid.Parse(v.Get("client_id"))
+	if err != nil {
+		return req, errors.New("the app isn't registered with Chirpy")
+	}
+	client, err := conf.dbQueries.GetOAuthClient(ctx, clientID)
+	if err != nil {
+		return req, errors.New("the app isn't registered with Chirpy")
+	}
+	uris, err := conf.dbQueries.GetOAuthRedirectURIs(ctx, clientID)
+	if err != nil || !slices.Contains(uris, req.RedirectURI) {
+		return req, errors.New("the app asked to send you to an address it hasn't registered")
+	}
+	req.client = &client
+
+	if v.Get("response_type") != "code" {
+		return req, &oauthError{"unsupported_response_type", "only the code flow is supported"}
+	}
+	req.CodeChallenge = v.Get("code_challenge")
+	if req.CodeChallenge == "" || v.Get("code_challenge_method") != "S256" {
+		return req, &oauthError{"invalid_request", "PKCE with S256 is required"}
+	}
+	req.Scopes, err = parseScopes(v.Get("scope"))
+	if err != nil {
+		return req, err
+	}
+	return req, nil
+}
+
+// authorizeHandlerFunc shows the consent screen.
+func (conf *apiConfig) authorizeHandlerFunc(w http.ResponseWriter, r *http.Request) {
+	req, err := conf.checkAuthorizeRequest(r.Context(), r.URL.Query())
+	if err != nil {
+		conf.authorizeFailed(w, r, req, err)
+		return
+	}
+	conf.renderConsent(w, r, req, r.URL.Query(), 200, "", "")
+}
+
+// authorizeSubmitHandlerFunc handles the consent form. Chirpy has no
+// browser sessions, so the form asks the user to sign in as they approve.
+func (conf *apiConfig) authorizeSubmitHandlerFunc(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form", 400)
+		return
+	}
+	form := r.PostForm
+	req, err := conf.checkAuthorizeRequest(r.Context(), form)
+	if err != nil {
+		conf.authorizeFailed(w, r, req, err)
+		return
+	}
+	if form.Get("action") != "allow" {
+		conf.authorizeFailed(w, r, req, &oauthError{"access_denied", "the user declined"})
+		return
+	}
+
+	email := strings.TrimSpace(form.Get("email"))
+	user, err := conf.checkLogin(r.Context(), r, email, form.Get("password"))
+	var locked *loginLockedError
+	if errors.As(err, &locked) {
+		locked.setRetryAfter(w)
+		conf.renderConsent(w, r, req, form, 429, email, "Too many failed attempts. Try again later.")
+		return
+	}
+	if errors.Is(err, errLoginFailed) {
+		conf.renderConsent(w, r, req, form, 401, email, "Wrong email or password.")
+		return
+	}
+	if errors.Is(err, errAccountSuspended) {
+		conf.renderConsent(w, r, req, form, 403, email, "This account is suspended.")
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to authorize", 500)
+		return
+	}
+	twoFactor, err := conf.twoFactorEnabled(r.Context(), user.ID)
+	if err != nil {
+		http.Error(w, "Failed to authorize", 500)
+		return
+	}
+	if twoFactor {
+		code := form.Get("code")
+		factor := twoFactorReq{Code: code}
+		if len(code) > 6 {
+			factor = twoFactorReq{RecoveryCode: code}
+		}
+		err := conf.checkSecondFactorLogin(r.Context(), user, factor)
+		if errors.As(err, &locked) {
+			locked.setRetryAfter(w)
+			conf.renderConsent(w, r, req, form, 429, email, "Too many failed attempts. Try again later.")
+			return
+		}
+		if errors.Is(err, errLoginFailed) {
+			conf.renderConsent(w, r, req, form, 401, email, "Enter a current code from your authenticator app.")
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to authorize", 500)
+			return
+		}
+	}
+
+	code, err := auth.MakeToken()
+	if err != nil {
+		http.Error(w, "Failed to authorize", 500)
+		return
+	}
+	tx, err := conf.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		http.Error(w, "Failed to authorize", 500)
+		return
+	}
+	defer tx.Rollback()
+	q := conf.dbQueries.WithTx(tx)
+
+	scope := strings.Join(req.Scopes, " ")
+	grant := database.UpsertOAuthGrantParams{UserID: user.ID, ClientID: req.client.ID, Scope: scope}
+	if err := q.UpsertOAuthGrant(r.Context(), grant); err != nil {
+		http.Error(w, "Failed to authorize", 500)
+		return
+	}
+	params := database.CreateOAuthCodeParams{
+		CodeHash:      auth.HashToken(code),
+		UserID:        user.ID,
+		ClientID:      req.client.ID,
+		RedirectUri:   req.RedirectURI,
+		Scope:         scope,
+		CodeChallenge: req.CodeChallenge,
+		ExpiresAt:     time.Now().Add(oauthCodeTTL),
+	}
+	if err := q.CreateOAuthCode(r.Context(), params); err != nil {
+		http.Error(w, "Failed to authorize", 500)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to authorize", 500)
+		return
+	}
+	redirectWithParams(w, r, req.RedirectURI, url.Values{"code": {code}, "state": {req.State}})
+}
+
+// authorizeFailed sends protocol errors back to the app, but only once the
+// redirect URI is known to belong to it. Anything earlier is shown to the
+// user instead, so Chirpy can't be used as an open redirect.
+func (conf *apiConfig) authorizeFailed(w http.ResponseWriter, r *http.Request, req authorizeRequest, err error) {
+	var oe *oauthError
+	if req.client != nil && errors.As(err, &oe) {
+		params := url.Values{"error": {oe.Code}, "error_description": {oe.Description}}
+		if req.State != "" {
+			params.Set("state", req.State)
+		}
+		redirectWithParams(w, r, req.RedirectURI, params)
+		return
+	}
+	conf.renderConsent(w, r, authorizeRequest{}, nil, 400, "", "Can't continue: "+err.Error()+".")
+}
+
+func (conf *apiConfig) renderConsent(w http.ResponseWriter, r *http.Request, req authorizeRequest, params url.Values, code int, email, errText string) {
+	view := consentView{Email: email, Error: errText}
+	if req.client != nil {
+		view.ClientName = req.client.Name
+		for _, s := range apiScopes {
+			if slices.Contains(req.Scopes, s.Name) {
+				view.Scopes = append(view.Scopes, s.Description)
+			}
+		}
+		for _, k := range []string{"response_type", "client_id", "redirect_uri", "scope", "state", "code_challenge", "code_challenge_method"} {
+			view.Params = append(view.Params, formField{k, params.Get(k)})
+		}
+		if u, err := url.Parse(req.RedirectURI); err == nil {
+			view.RedirectHost = u.Host
+		}
+	}
+	page := pageData{
+		Title:   "Authorize app",
+		Type:    "website",
+		Image:   conf.absoluteURL(r, "/app/assets/logo.png"),
+		Private: true,
+		Consent: view,
+	}
+	// The form takes a password; don't let another site frame it
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "frame-ancestors 'none'")
+	conf.renderPage(w, r, "authorize", code, time.Time{}, page)
+}
+
+// oauthTokenHandlerFunc is the token endpoint: it exchanges authorization
+// codes and refresh tokens for access tokens.
+func (conf *apiConfig) oauthTokenHandlerFunc(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		respondWithOAuthError(w, 400, &oauthError{"invalid_request", "body must be form encoded"})
+		return
+	}
+	form := r.PostForm
+	client, err := conf.authenticateClient(r)
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Basic realm="chirpy"`)
+		respondWithOAuthError(w, 401, &oauthError{"invalid_client", "client authentication failed"})
+		return
+	}
+
+	var userID uuid.UUID
+	var scope string
+	switch form.Get("grant_type") {
+	case "authorization_code":
+		code, err := conf.dbQueries.UseOAuthCode(r.Context(), auth.HashToken(form.Get("code")))
+		if err != nil || code.ClientID != client.ID || code.RedirectUri != form.Get("redirect_uri") {
+			respondWithOAuthError(w, 400, &oauthError{"invalid_grant", "invalid or expired code"})
+			return
+		}
+		if !auth.VerifyPKCE(form.Get("code_verifier"), code.CodeChallenge) {
+			respondWithOAuthError(w, 400, &oauthError{"invalid_grant", "code verifier doesn't match"})
+			return
+		}
+		userID, scope = code.UserID, code.Scope
+
+	case "refresh_token":
+		// Refresh tokens are single use; each refresh hands out a new one
+		old, err := conf.dbQueries.UseOAuthRefreshToken(r.Context(), auth.HashToken(form.Get("refresh_token")))
+		if err != nil || old.ClientID != client.ID {
+			respondWithOAuthError(w, 400, &oauthError{"invalid_grant", "invalid or expired refresh token"})
+			return
+		}
+		userID, scope = old.UserID, old.Scope
+		if s := form.Get("scope"); s != "" {
+			narrowed, err := parseScopes(s)
+			if err != nil {
+				respondWithOAuthError(w, 400, err.(*oauthError))
+				return
+			}
+			for _, n := range narrowed {
+				if !slices.Contains(strings.Fields(old.Scope), n) {
+					respondWithOAuthError(w, 400, &oauthError{"invalid_scope", "scope exceeds the original grant"})
+					return
+				}
+			}
+			scope = strings.Join(narrowed, " ")
+		}
+
+	default:
+		respondWithOAuthError(w, 400, &oauthError{"unsupported_grant_type", ""})
+		return
+	}
+
+	// Grants made before a suspension mustn't outlive it
+	err = conf.checkNotSuspended(r.Context(), userID)
+	if errors.Is(err, errAccountSuspended) {
+		respondWithOAuthError(w, 400, &oauthError{"invalid_grant", "account is suspended"})
+		return
+	}
+	if err != nil {
+		respondWithOAuthError(w, 500, &oauthError{"server_error", ""})
+		return
+	}
+
+	scopes := strings.Fields(scope)
+	access, err := auth.MakeScopedJWT(userID, conf.jwtKeys, client.ID.String(), scopes, oauthAccessTokenTTL)
+	if err != nil {
+		respondWithOAuthError(w, 500, &oauthError{"server_error", ""})
+		return
+	}
+	refresh, err := auth.MakeRefreshToken()
+	if err != nil {
+		respondWithOAuthError(w, 500, &oauthError{"server_error", ""})
+		return
+	}
+	params := database.CreateOAuthRefreshTokenParams{
+		TokenHash: auth.HashToken(refresh),
+		UserID:    userID,
+		ClientID:  client.ID,
+		Scope:     scope,
+		ExpiresAt: time.Now().Add(oauthRefreshTokenTTL),
+	}
+	// Fails if the user revoked the app in the meantime
+	if err := conf.dbQueries.CreateOAuthRefreshToken(r.Context(), params); err != nil {
+		respondWithOAuthError(w, 400, &oauthError{"invalid_grant", "authorization was revoked"})
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	respondWithJSON(w, 200, OAuthToken{
+		AccessToken:  access,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(oauthAccessTokenTTL.Seconds()),
+		RefreshToken: refresh,
+		Scope:        scope,
+	})
+}
+
+// authenticateClient identifies the app calling the token endpoint, by
+// HTTP Basic or form parameters. Public clients have no secret to send.
+func (conf *apiConfig) authenticateClient(r *http.Request) (database.OauthClient, error) {
+	id, secret, ok := r.BasicAuth()
+	if !ok {
+		id, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	clientID, err := uuid.Parse(id)
+	if err != nil {
+		return database.OauthClient{}, err
+	}
+	client, err := conf.dbQueries.GetOAuthClient(r.Context(), clientID)
+	if err != nil {
+		return database.OauthClient{}, err
+	}
+	if client.SecretHash == "" {
+		if secret != "" {
+			return database.OauthClient{}, errors.New("public client sent a secret")
+		}
+		return client, nil
+	}
+	if subtle.ConstantTimeCompare([]byte(auth.HashToken(secret)), []byte(client.SecretHash)) != 1 {
+		return database.OauthClient{}, errors.New("wrong client secret")
+	}
+	return client, nil
+}
+
+// createOAuthClientHandlerFunc registers an app. The secret of a
+// confidential client is shown only in this response.
+func (conf *apiConfig) createOAuthClientHandlerFunc(w http.ResponseWriter, r *http.Request) {
+	tk, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	userID, err := auth.ValidateJWT(tk, conf.jwtKeys)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	var req oauthClientReq
+	if err := readJSON(r, &req); err != nil {
+		respondWithError(w, 400, "Something went wrong")
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" || utf8.RuneCountInString(name) > oauthClientNameMax {
+		respondWithError(w, 400, "Name must be 1 to 50 characters")
+		return
+	}
+	if len(req.RedirectURIs) == 0 || len(req.RedirectURIs) > oauthRedirectURIsMax {
+		respondWithError(w, 400, "Register between 1 and 10 redirect URIs")
+		return
+	}
+	for _, u := range req.RedirectURIs {
+		if !validRedirectURI(u) {
+			respondWithError(w, 400, "Redirect URIs must be https, or http on localhost, without a fragment")
+			return
+		}
+	}
+
+	var secret, secretHash string
+	if req.Confidential {
+		if secret, err = auth.MakeToken(); err != nil {
+			respondWithError(w, 500, "Failed to register app")
+			return
+		}
+		secretHash = auth.HashToken(secret)
+	}
+
+	tx, err := conf.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, 500, "Failed to register app")
+		return
+	}
+	defer tx.Rollback()
+	q := conf.dbQueries.WithTx(tx)
+
+	client, err := q.CreateOAuthClient(r.Context(), database.CreateOAuthClientParams{OwnerID: userID, Name: name, SecretHash: secretHash})
+	if err != nil {
+		respondWithError(w, 500, "Failed to register app")
+		return
+	}
+	for _, u := range req.RedirectURIs {
+		err := q.AddOAuthRedirectURI(r.Context(), database.AddOAuthRedirectURIParams{ClientID: client.ID, Uri: u})
+		if isUniqueViolation(err) {
+			continue
+		}
+		if err != nil {
+			respondWithError(w, 500, "Failed to register app")
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		respondWithError(w, 500, "Failed to register app")
+		return
+	}
+	uris, err := conf.dbQueries.GetOAuthRedirectURIs(r.Context(), client.ID)
+	if err != nil {
+		respondWithError(w, 500, "Failed to register app")
+		return
+	}
+	out := dbOAuthClientToJSON(client, uris)
+	out.ClientSecret = secret
+	respondWithJSON(w, 201, out)
+}
+
+func (conf *apiConfig) getOAuthClientsHandlerFunc(w http.ResponseWriter, r *http.Request) {
+	tk, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	userID, err := auth.ValidateJWT(tk, conf.jwtKeys)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	clients, err := conf.dbQueries.GetUserOAuthClients(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, 500, "Failed to list apps")
+		return
+	}
+	out := make([]OAuthClient, 0, len(clients))
+	for _, c := range clients {
+		uris, err := conf.dbQueries.GetOAuthRedirectURIs(r.Context(), c.ID)
+		if err != nil {
+			respondWithError(w, 500, "Failed to list apps")
+			return
+		}
+		out = append(out, dbOAuthClientToJSON(c, uris))
+	}
+	respondWithJSON(w, 200, out)
+}
+
+func (conf *apiConfig) deleteOAuthClientHandlerFunc(w http.ResponseWriter, r *http.Request) {
+	tk, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	userID, err := auth.ValidateJWT(tk, conf.jwtKeys)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	clientID, err := uuid.Parse(r.PathValue("clientID"))
+	if err != nil {
+		respondWithError(w, 404, "App not found")
+		return
+	}
+	n, err := conf.dbQueries.DeleteOAuthClient(r.Context(), database.DeleteOAuthClientParams{ID: clientID, OwnerID: userID})
+	if err != nil {
+		respondWithError(w, 500, "Failed to delete app")
+		return
+	}
+	if n == 0 {
+		respondWithError(w, 404, "App not found")
+		return
+	}
+	w.WriteHeader(204)
+}
+
+// getAuthorizationsHandlerFunc lists the apps the user has let in.
+func (conf *apiConfig) getAuthorizationsHandlerFunc(w http.ResponseWriter, r *http.Request) {
+	tk, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	userID, err := auth.ValidateJWT(tk, conf.jwtKeys)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	grants, err := conf.dbQueries.GetUserOAuthGrants(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, 500, "Failed to list authorized apps")
+		return
+	}
+	out := make([]AuthorizedApp, 0, len(grants))
+	for _, g := range grants {
+		client, err := conf.dbQueries.GetOAuthClient(r.Context(), g.ClientID)
+		if err != nil {
+			respondWithError(w, 500, "Failed to list authorized apps")
+			return
+		}
+		out = append(out, AuthorizedApp{client.ID, client.Name, strings.Fields(g.Scope), g.CreatedAt, g.UpdatedAt})
+	}
+	respondWithJSON(w, 200, out)
+}
+
+// revokeAuthorizationHandlerFunc removes an app's access. Its refresh
+// tokens go with the grant; access tokens already issued run out within
+// oauthAccessTokenTTL.
+func (conf *apiConfig) revokeAuthorizationHandlerFunc(w http.ResponseWriter, r *http.Request) {
+	tk, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	userID, err := auth.ValidateJWT(tk, conf.jwtKeys)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	clientID, err := uuid.Parse(r.PathValue("clientID"))
+	if err != nil {
+		respondWithError(w, 404, "Authorization not found")
+		return
+	}
+	n, err := conf.dbQueries.DeleteOAuthGrant(r.Context(), database.DeleteOAuthGrantParams{UserID: userID, ClientID: clientID})
+	if err != nil {
+		respondWithError(w, 500, "Failed to revoke app")
+		return
+	}
+	if n == 0 {
+		respondWithError(w, 404, "Authorization not found")
+		return
+	}
+	w.WriteHeader(204)
+}
+
+// parseScopes checks a space-separated scope parameter. Duplicates are
+// dropped and the result follows apiScopes order.
+func parseScopes(s string) ([]string, error) {
+	requested := strings.Fields(s)
+	if len(requested) == 0 {
+		return nil, &oauthError{"invalid_scope", "no scope requested"}
+	}
+	var out []string
+	for _, known := range apiScopes {
+		if slices.Contains(requested, known.Name) {
+			out = append(out, known.Name)
+		}
+	}
+	for _, name := range requested {
+		if !slices.Contains(out, name) {
+			return nil, &oauthError{"invalid_scope", "unknown scope " + name}
+		}
+	}
+	return out, nil
+}
+
+// validRedirectURI allows https anywhere and plain http only on loopback,
+// for native apps listening locally (RFC 8252).
+func validRedirectURI(s string) bool {
+	if len(s) > oauthRedirectURIMax {
+		return false
+	}
+	u, err := url.Parse(s)
+	if err != nil || u.Host == "" || u.Fragment != "" || u.User != nil {
+		return false
+	}
+	switch u.Scheme {
+	case "https":
+		return true
+	case "http":
+		host := u.Hostname()
+		return host == "localhost" || host == "127.0.0.1" || host == "::1"
+	}
+	return false
+}
+
+// redirectWithParams sends the browser back to an app, keeping any query
+// the registered URI already had.
+func redirectWithParams(w http.ResponseWriter, r *http.Request, target string, params url.Values) {
+	u, err := url.Parse(target)
+	if err != nil {
+		http.Error(w, "Invalid redirect URI", 400)
+		return
+	}
+	q := u.Query()
+	for k, vs := range params {
+		for _, v := range vs {
+			if v != "" {
+				q.Set(k, v)
+			}
+		}
+	}
+	u.RawQuery = q.Encode()
+	http.Redirect(w, r, u.String(), http.StatusSeeOther)
+}
+
+func respondWithOAuthError(w http.ResponseWriter, code int, e *oauthError) {
+	w.Header().Set("Cache-Control", "no-store")
+	respondWithJSON(w, code, e)
+}
+
+func dbOAuthClientToJSON(db database.OauthClient, uris []string) OAuthClient {
+	return OAuthClient{
+		ClientID:     db.ID,
+		Name:         db.Name,
+		RedirectURIs: uris,
+		Confidential: db.SecretHash != "",
+		CreatedAt:    db.CreatedAt,
+	}
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/plusk0/webserver/internal/auth"
+	"github.com/plusk0/webserver/internal/database"
+	"github.com/plusk0/webserver/internal/handles"
+	"github.com/plusk0/webserver/internal/oidc"
+)
+
+const (
+	oidcLoginTTL    = 10 * time.Minute
+	oidcStateCookie = "chirpy_oidc_state"
+	oidcCallback    = "/api/login/oidc/callback"
+)
+
+var (
+	errProviderEmailUnverified = errors.New("identity provider hasn't verified the email address")
+	errAccountEmailUnverified  = errors.New("existing account's email address isn't verified")
+)
+
+// newOIDCProvider configures company sign-in from OIDC_ISSUER,
+// OIDC_CLIENT_ID and OIDC_CLIENT_SECRET. The callback is under BASE_URL
+// unless OIDC_REDIRECT_URL says otherwise. Without an issuer it stays off.
+func newOIDCProvider(baseURL string) (*oidc.Provider, error) {
+	issuer := os.Getenv("OIDC_ISSUER")
+	if issuer == "" {
+		return nil, nil
+	}
+	clientID := os.Getenv("OIDC_CLIENT_ID")
+	if clientID == "" {
+		return nil, errors.New("OIDC_CLIENT_ID must be set with OIDC_ISSUER")
+	}
+	redirect := os.Getenv("OIDC_REDIRECT_URL")
+	if redirect == "" && baseURL != "" {
+		redirect = baseURL + oidcCallback
+	}
+	if u, err := url.Parse(redirect); redirect == "" || err != nil || u.Host == "" {
+		return nil, errors.New("OIDC_REDIRECT_URL or BASE_URL must be set with OIDC_ISSUER")
+	}
+	return &oidc.Provider{
+		Issuer:       issuer,
+		ClientID:     clientID,
+		ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+		RedirectURL:  redirect,
+		Client:       &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// oidcLoginHandlerFunc sends the browser to the identity provider. The
+// state is also set in a cookie, so a login started in one browser can't
+// be finished in another.
+func (conf *apiConfig) oidcLoginHandlerFunc(w http.ResponseWriter, r *http.Request) {
+	if conf.oidc == nil {
+		respondWithError(w, 404, "Single sign-on is not enabled")
+		return
+	}
+	if err := conf.dbQueries.DeleteExpiredOIDCLogins(r.Context()); err != nil {
+		respondWithError(w, 500, "Failed to start login")
+		return
+	}
+	state, err := auth.MakeToken()
+	if err != nil {
+		respondWithError(w, 500, "Failed to start login")
+		return
+	}
+	nonce, err := auth.MakeToken()
+	if err != nil {
+		respondWithError(w, 500, "Failed to start login")
+		return
+	}
+	verifier, challenge, err := oidc.NewVerifier()
+	if err != nil {
+		respondWithError(w, 500, "Failed to start login")
+		return
+	}
+	target, err := conf.oidc.AuthCodeURL(r.Context(), state, nonce, challenge)
+	if err != nil {
+		fmt.Printf("OIDC discovery failed: %v\n", err)
+		respondWithError(w, 502, "Identity provider is unavailable")
+		return
+	}
+	params := database.CreateOIDCLoginParams{
+		StateHash:    auth.HashToken(state),
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		ExpiresAt:    time.Now().Add(oidcLoginTTL),
+	}
+	if err := conf.dbQueries.CreateOIDCLogin(r.Context(), params); err != nil {
+		respondWithError(w, 500, "Failed to start login")
+		return
+	}
+	conf.setOIDCStateCookie(w, state, int(oidcLoginTTL.Seconds()))
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+// oidcCallbackHandlerFunc is where the provider sends the browser back. It
+// answers with the same tokens a password login gets.
+func (conf *apiConfig) oidcCallbackHandlerFunc(w http.ResponseWriter, r *http.Request) {
+	if conf.oidc == nil {
+		respondWithError(w, 404, "Single sign-on is not enabled")
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	query := r.URL.Query()
+	state := query.Get("state")
+	cookie, err := r.Cookie(oidcStateCookie)
+	conf.setOIDCStateCookie(w, "", -1)
+	if err != nil || state == "" || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+		respondWithError(w, 400, "Login expired, please start again")
+		return
+	}
+	login, err := conf.dbQueries.UseOIDCLogin(r.Context(), auth.HashToken(state))
+	if err != nil {
+		respondWithError(w, 400, "Login expired, please start again")
+		return
+	}
+	if query.Get("error") != "" {
+		respondWithError(w, 401, "Login was cancelled or refused by the identity provider")
+		return
+	}
+
+	claims, err := conf.oidc.Exchange(r.Context(), query.Get("code"), login.CodeVerifier, login.Nonce)
+	if err != nil {
+		fmt.Printf("OIDC login failed: %v\n", err)
+		respondWithError(w, 401, "Identity provider login failed")
+		return
+	}
+	user, err := conf.oidcUser(r.Context(), claims)
+	if errors.Is(err, errProviderEmailUnverified) {
+		respondWithError(w, 403, "Your identity provider hasn't verified your email address")
+		return
+	}
+	if errors.Is(err, errAccountEmailUnverified) {
+		respondWithError(w, 409, "An account with this email exists but its address isn't verified; verify it or log in with your password first")
+		return
+	}
+	if err != nil {
+		respondWithError(w, 500, "Failed to log in")
+		return
+	}
+
+	// Accounts that turned on two-factor keep it, whichever way they log in
+	twoFactor, err := conf.twoFactorEnabled(r.Context(), user.ID)
+	if err != nil {
+		respondWithError(w, 500, "Failed to log in")
+		return
+	}
+	if twoFactor {
+		challenge, err := conf.startLoginChallenge(r.Context(), user.ID)
+		if err != nil {
+			respondWithError(w, 500, "Failed to log in")
+			return
+		}
+		respondWithJSON(w, 200, challenge)
+		return
+	}
+	conf.completeLogin(w, r, user)
+}
+
+// oidcUser finds the account for a provider identity, linking or creating
+// one the first time it's seen. Linking goes by email, and only when both
+// the provider and Chirpy have verified the address: otherwise someone
+// could sign up with a colleague's address ahead of time and be handed
+// their company login.
+func (conf *apiConfig) oidcUser(ctx context.Context, claims oidc.Claims) (database.User, error) {
+	identity, err := conf.dbQueries.GetUserIdentity(ctx, database.GetUserIdentityParams{Issuer: claims.Issuer, Subject: claims.Subject})
+	if err == nil {
+		params := database.TouchUserIdentityParams{Issuer: identity.Issuer, Subject: identity.Subject, Email: claims.Email}
+		if err := conf.dbQueries.TouchUserIdentity(ctx, params); err != nil {
+			return database.User{}, err
+		}
+		return conf.dbQueries.GetUserByID(ctx, identity.UserID)
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return database.User{}, err
+	}
+	if !claims.EmailVerified || !validEmail(claims.Email) {
+		return database.User{}, errProviderEmailUnverified
+	}
+
+	tx, err := conf.db.BeginTx(ctx, nil)
+	if err != nil {
+		return database.User{}, err
+	}
+	defer tx.Rollback()
+	q := conf.dbQueries.WithTx(tx)
+
+	user, err := q.GetUser(ctx, claims.Email)
+	created := errors.Is(err, sql.ErrNoRows)
+	switch {
+	case created:
+		if user, err = createSSOUser(ctx, q, claims.Email); err != nil {
+			return database.User{}, err
+		}
+	case err != nil:
+		return database.User{}, err
+	case !user.EmailVerifiedAt.Valid:
+		return database.User{}, errAccountEmailUnverified
+	}
+
+	params := database.CreateUserIdentityParams{Issuer: claims.Issuer, Subject: claims.Subject, UserID: user.ID, Email: claims.Email}
+	if err := q.CreateUserIdentity(ctx, params); err != nil {
+		return database.User{}, err
+	}
+	details := map[string]any{"issuer": claims.Issuer, "subject": claims.Subject, "new_account": created}
+	if err := audit(ctx, q, auditIdentityLinked, user.ID, details); err != nil {
+		return database.User{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return database.User{}, err
+	}
+	return user, nil
+}
+
+// createSSOUser makes an account for someone who has only ever signed in
+// through the provider. The password is random and never shown; a reset
+// sets a real one if they want it.
+func createSSOUser(ctx context.Context, q *database.Queries, email string) (database.User, error) {
+	password, err := auth.MakeToken()
+	if err != nil {
+		return database.User{}, err
+	}
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		return database.User{}, err
+	}
+	user, err := q.CreateUser(ctx, database.CreateUserParams{Email: email, Password: hash, Handle: handles.Generated()})
+	if err != nil {
+		return database.User{}, err
+	}
+	if _, err := q.VerifyUserEmail(ctx, database.VerifyUserEmailParams{ID: user.ID, Email: email}); err != nil {
+		return database.User{}, err
+	}
+	return q.GetUserByID(ctx, user.ID)
+}
+
+// getIdentitiesHandlerFunc lists the provider accounts linked to the
+// signed-in user.
+func (conf *apiConfig) getIdentitiesHandlerFunc(w http.ResponseWriter, r *http.Request) {
+	tk, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	userID, err := auth.ValidateJWT(tk, conf.jwtKeys)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	identities, err := conf.dbQueries.GetUserIdentities(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, 500, "Failed to get identities")
+		return
+	}
+	out := make([]Identity, 0, len(identities))
+	for _, id := range identities {
+		out = append(out, Identity{id.Issuer, id.Email, id.CreatedAt, id.LastLoginAt})
+	}
+	respondWithJSON(w, 200, out)
+}
+
+func (conf *apiConfig) setOIDCStateCookie(w http.ResponseWriter, value string, maxAge int) {
+	// Lax, because the provider sends the browser back with a top-level
+	// cross-site GET
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    value,
+		Path:     "/api/login/oidc",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(conf.oidc.RedirectURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/plusk0/webserver/internal/database"
+)
+
+const (
+	pageMaxAge     = 60
+	pageChirpLimit = 50
+	pageDescLength = 200
+)
+
+// loadPages parses one template set per page, each combining the shared
+// layout with the page's own "content" block.
+func loadPages(dir string) map[string]*template.Template {
+	pages := map[string]*template.Template{}
+	for _, name := range []string{"chirp", "profile", "404", "authorize"} {
+		tmpl, err := template.ParseFiles(filepath.Join(dir, "layout.html"), filepath.Join(dir, name+".html"))
+		if err != nil {
+			log.Fatalf("Failed to parse %s template: %v", name, err)
+		}
+		pages[name] = tmpl
+	}
+	return pages
+}
+
+func (conf *apiConfig) chirpPageHandlerFunc(w http.ResponseWriter, r *http.Request) {
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		conf.renderNotFound(w, r, "That chirp doesn't exist.")
+		return
+	}
+	chirp, err := conf.dbQueries.GetChirp(r.Context(), chirpID)
+	if err != nil {
+		conf.renderNotFound(w, r, "That chirp doesn't exist.")
+		return
+	}
+	conf.views.Record(chirp.ID)
+
+	var author *database.User
+	title := "Chirp"
+	if chirp.UserID.Valid {
+		user, err := conf.dbQueries.GetUserByID(r.Context(), chirp.UserID.UUID)
+		if err == nil {
+			author = &user
+			title = displayName(user) + " on Chirpy"
+		}
+	}
+	view := conf.chirpView(r, chirp, author)
+	page := pageData{
+		Title:       title,
+		Description: chirpDescription(chirp),
+		Type:        "article",
+		URL:         view.URL,
+		Image:       conf.absoluteURL(r, "/app/assets/logo.png"),
+		Chirp:       view,
+	}
+	conf.renderPage(w, r, "chirp", 200, chirp.UpdatedAt, page)
+}
+
+func (conf *apiConfig) profilePageHandlerFunc(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		conf.renderNotFound(w, r, "That user doesn't exist.")
+		return
+	}
+	user, err := conf.dbQueries.GetUserByID(r.Context(), userID)
+	if err != nil {
+		conf.renderNotFound(w, r, "That user doesn't exist.")
+		return
+	}
+	filter := chirpFilter{
+		AuthorID:   uuid.NullUUID{UUID: userID, Valid: true},
+		Descending: true,
+		Limit:      pageChirpLimit,
+	}
+	chirps, err := conf.listChirps(r.Context(), filter)
+	if err != nil {
+		http.Error(w, "Failed to load chirps", 500)
+		return
+	}
+
+	lastModified := user.UpdatedAt
+	views := make([]chirpView, 0, len(chirps))
+	for _, c := range chirps {
+		views = append(views, conf.chirpView(r, c, &user))
+		if c.UpdatedAt.After(lastModified) {
+			lastModified = c.UpdatedAt
+		}
+	}
+
+	profile := profileView{
+		Name:        displayName(user),
+		Handle:      user.Handle,
+		Bio:         user.Bio,
+		AvatarURL:   user.AvatarUrl,
+		CreatedAt:   user.CreatedAt,
+		IsChirpyRed: user.IsChirpyRed,
+	}
+	description := user.Bio
+	if description == "" {
+		description = "Recent chirps from " + profile.Name + " on Chirpy."
+	}
+	image := user.AvatarUrl
+	if image == "" {
+		image = conf.absoluteURL(r, "/app/assets/logo.png")
+	}
+	page := pageData{
+		Title:       profile.Name + " (@" + user.Handle + ")",
+		Description: description,
+		Type:        "profile",
+		URL:         conf.absoluteURL(r, "/u/"+user.ID.String()),
+		Image:       image,
+		Profile:     profile,
+		Chirps:      views,
+	}
+	conf.renderPage(w, r, "profile", 200, lastModified, page)
+}
+
+func (conf *apiConfig) renderNotFound(w http.ResponseWriter, r *http.Request, text string) {
+	page := pageData{
+		Title:       "Not found",
+		Description: text,
+		Type:        "website",
+		Image:       conf.absoluteURL(r, "/app/assets/logo.png"),
+	}
+	conf.renderPage(w, r, "404", 404, time.Time{}, page)
+}
+
+// renderPage writes a page with caching headers. Public pages carry an
+// ETag of their rendered content and answer conditional requests with 304
+// Not Modified.
+func (conf *apiConfig) renderPage(w http.ResponseWriter, r *http.Request, name string, code int, modified time.Time, data pageData) {
+	var buf bytes.Buffer
+	if err := conf.pages[name].ExecuteTemplate(&buf, "layout", data); err != nil {
+		fmt.Printf("Failed to render %s page: %v\n", name, err)
+		http.Error(w, "Failed to render page", 500)
+		return
+	}
+
+	if code == 200 && !data.Private {
+		// Last-Modified alone misses a deleted chirp, so the ETag covers
+		// exactly what was rendered
+		sum := sha256.Sum256(buf.Bytes())
+		etag := `"` + hex.EncodeToString(sum[:])[:32] + `"`
+		w.Header().Set("ETag", etag)
+		if !modified.IsZero() {
+			modified = modified.UTC().Truncate(time.Second)
+			w.Header().Set("Last-Modified", modified.Format(http.TimeFormat))
+		}
+		if notModified(r, etag, modified) {
+			w.WriteHeader(304)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if data.Private {
+		w.Header().Set("Cache-Control", "no-store")
+	} else if code == 200 {
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", pageMaxAge))
+	} else {
+		w.Header().Set("Cache-Control", "no-cache")
+	}
+	w.WriteHeader(code)
+	_, _ = w.Write(buf.Bytes())
+}
+
+// chirpView prepares c for a template. author may be nil for anonymized
+// chirps.
+func (conf *apiConfig) chirpView(r *http.Request, c database.Chirp, author *database.User) chirpView {
+	view := chirpView{
+		URL:            conf.absoluteURL(r, "/c/"+c.ID.String()),
+		BodyHTML:       template.HTML(dbChirpToJSON(c).BodyHTML),
+		ContentWarning: c.ContentWarning,
+		Sensitive:      c.Sensitive,
+		CreatedAt:      c.CreatedAt,
+	}
+	if author != nil {
+		view.AuthorURL = "/u/" + author.ID.String()
+		view.AuthorName = displayName(*author)
+		view.AuthorHandle = author.Handle
+	}
+	return view
+}
+
+// chirpDescription is the preview text for link unfurls. Sensitive chirps
+// only show their warning, so the content stays hidden until clicked.
+func chirpDescription(c database.Chirp) string {
+	if c.ContentWarning != "" {
+		return "Content warning: " + c.ContentWarning
+	}
+	if c.Sensitive {
+		return "This chirp contains sensitive content."
+	}
+	desc := strings.Join(strings.Fields(c.Body), " ")
+	if utf8.RuneCountInString(desc) > pageDescLength {
+		desc = string([]rune(desc)[:pageDescLength-1]) + "…"
+	}
+	return desc
+}
+
+// absoluteURL builds a link for previews, which need full URLs. BASE_URL
+// wins over the request's own host when the server sits behind a proxy.
+// The host is client supplied, so emails use BASE_URL alone.
+func (conf *apiConfig) absoluteURL(r *http.Request, path string) string {
+	if conf.baseURL != "" {
+		return conf.baseURL + path
+	}
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + path
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/plusk0/webserver/internal/auth"
+	"github.com/plusk0/webserver/internal/database"
+	"github.com/plusk0/webserver/internal/webauthn"
+)
+
+const passkeyNameMaxLength = 50
+
+// newRelyingParty configures passkeys from WEBAUTHN_ORIGIN, falling back to
+// BASE_URL. Without either there's no origin to check against, so passkeys
+// stay off.
+func newRelyingParty(baseURL string) (*webauthn.RelyingParty, error) {
+	origin := strings.TrimRight(os.Getenv("WEBAUTHN_ORIGIN"), "/")
+	if origin == "" {
+		origin = baseURL
+	}
+	if origin == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid WebAuthn origin %q", origin)
+	}
+	id := os.Getenv("WEBAUTHN_RP_ID")
+	if id == "" {
+		id = u.Hostname()
+	}
+	return &webauthn.RelyingParty{ID: id, Name: "Chirpy", Origin: u.Scheme + "://" + u.Host}, nil
+}
+
+// passkeyOptionsHandlerFunc starts registering a passkey for the signed-in
+// user.
+func (conf *apiConfig) passkeyOptionsHandlerFunc(w http.ResponseWriter, r *http.Request) {
+	if conf.webauthn == nil {
+		respondWithError(w, 404, "Passkeys are not enabled")
+		return
+	}
+	tk, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	userID, err := auth.ValidateJWT(tk, conf.jwtKeys)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	user, err := conf.dbQueries.GetUserByID(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, 404, "User not found")
+		return
+	}
+	passkeys, err := conf.dbQueries.GetUserPasskeys(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, 500, "Failed to start registration")
+		return
+	}
+	var exclude [][]byte
+	for _, pk := range passkeys {
+		if id, err := base64.RawURLEncoding.DecodeString(pk.ID); err == nil {
+			exclude = append(exclude, id)
+		}
+	}
+
+	challenge, err := conf.newWebAuthnChallenge(r.Context(), "register", userID)
+	if err != nil {
+		respondWithError(w, 500, "Failed to start registration")
+		return
+	}
+	entity := webauthn.UserEntity{ID: userID[:], Name: user.Email, DisplayName: displayName(user)}
+	respondWithJSON(w, 200, conf.webauthn.CreationOptions(challenge, entity, exclude))
+}
+
+// createPasskeyHandlerFunc finishes a registration started with
+// passkeyOptionsHandlerFunc.
+func (conf *apiConfig) createPasskeyHandlerFunc(w http.ResponseWriter, r *http.Request) {
+	if conf.webauthn == nil {
+		respondWithError(w, 404, "Passkeys are not enabled")
+		return
+	}
+	tk, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	userID, err := auth.ValidateJWT(tk, conf.jwtKeys)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	var req passkeyReq
+	if err := readJSON(r, &req); err != nil {
+		respondWithError(w, 400, "Something went wrong")
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		name = "Passkey"
+	}
+	if utf8.RuneCountInString(name) > passkeyNameMaxLength {
+		respondWithError(w, 400, "Name is too long")
+		return
+	}
+
+	resp := req.Credential.Response
+	cd, err := webauthn.ParseClientData(resp.ClientDataJSON)
+	if err != nil {
+		respondWithError(w, 400, "Invalid credential")
+		return
+	}
+	challenge, err := conf.dbQueries.UseWebAuthnChallenge(r.Context(), database.UseWebAuthnChallengeParams{Challenge: cd.Challenge, Ceremony: "register"})
+	if err != nil || challenge.UserID.UUID != userID {
+		respondWithError(w, 400, "Invalid or expired challenge")
+		return
+	}
+	cred, err := conf.webauthn.VerifyRegistration(resp.ClientDataJSON, resp.AttestationObject, challenge.Challenge)
+	if err != nil {
+		respondWithError(w, 400, "Invalid credential")
+		return
+	}
+
+	params := database.CreatePasskeyParams{
+		ID:        base64.RawURLEncoding.EncodeToString(cred.ID),
+		UserID:    userID,
+		Name:      name,
+		PublicKey: cred.PublicKey,
+		SignCount: int64(cred.SignCount),
+	}
+	if err := conf.dbQueries.CreatePasskey(r.Context(), params); err != nil {
+		if isUniqueViolation(err) {
+			respondWithError(w, 409, "Passkey is already registered")
+			return
+		}
+		respondWithError(w, 500, "Failed to save passkey")
+		return
+	}
+	if err := audit(r.Context(), conf.dbQueries, auditPasskeyAdded, userID, map[string]any{"passkey_id": params.ID}); err != nil {
+		fmt.Printf("Failed to audit passkey registration: %v\n", err)
+	}
+	pk, err := conf.dbQueries.GetPasskey(r.Context(), params.ID)
+	if err != nil {
+		respondWithError(w, 500, "Failed to save passkey")
+		return
+	}
+	respondWithJSON(w, 201, dbPasskeyToJSON(pk))
+}
+
+func (conf *apiConfig) getPasskeysHandlerFunc(w http.ResponseWriter, r *http.Request) {
+	tk, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	userID, err := auth.ValidateJWT(tk, conf.jwtKeys)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	passkeys, err := conf.dbQueries.GetUserPasskeys(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, 500, "Failed to list passkeys")
+		return
+	}
+	out := make([]Passkey, 0, len(passkeys))
+	for _, pk := range passkeys {
+		out = append(out, dbPasskeyToJSON(pk))
+	}
+	respondWithJSON(w, 200, out)
+}
+
+func (conf *apiConfig) deletePasskeyHandlerFunc(w http.ResponseWriter, r *http.Request) {
+	tk, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	userID, err := auth.ValidateJWT(tk, conf.jwtKeys)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	params := database.DeletePasskeyParams{ID: r.PathValue("passkeyID"), UserID: userID}
+	n, err := conf.dbQueries.DeletePasskey(r.Context(), params)
+	if err != nil {
+		respondWithError(w, 500, "Failed to delete passkey")
+		return
+	}
+	if n == 0 {
+		respondWithError(w, 404, "Passkey not found")
+		return
+	}
+	if err := audit(r.Context(), conf.dbQueries, auditPasskeyRemoved, userID, map[string]any{"passkey_id": params.ID}); err != nil {
+		fmt.Printf("Failed to audit passkey removal: %v\n", err)
+	}
+	w.WriteHeader(204)
+}
+
+// passkeyLoginOptionsHandlerFunc starts a passkey login. No account is
+// named; the browser offers whichever passkeys it has for this site.
+func (conf *apiConfig) passkeyLoginOptionsHandlerFunc(w http.ResponseWriter, r *http.Request) {
+	if conf.webauthn == nil {
+		respondWithError(w, 404, "Passkeys are not enabled")
+		return
+	}
+	challenge, err := conf.newWebAuthnChallenge(r.Context(), "login", uuid.Nil)
+	if err != nil {
+		respondWithError(w, 500, "Failed to start login")
+		return
+	}
+	respondWithJSON(w, 200, conf.webauthn.RequestOptions(challenge, nil))
+}
+
+// passkeyLoginHandlerFunc logs in with a passkey assertion, answering with
+// the same tokens a password login gets.
+func (conf *apiConfig) passkeyLoginHandlerFunc(w http.ResponseWriter, r *http.Request) {
+	if conf.webauthn == nil {
+		respondWithError(w, 404, "Passkeys are not enabled")
+		return
+	}
+	var cred publicKeyCredential
+	if err := readJSON(r, &cred); err != nil {
+		respondWithError(w, 400, "Something went wrong")
+		return
+	}
+	resp := cred.Response
+	cd, err := webauthn.ParseClientData(resp.ClientDataJSON)
+	if err != nil {
+		respondWithError(w, 401, "Invalid passkey")
+		return
+	}
+	challenge, err := conf.dbQueries.UseWebAuthnChallenge(r.Context(), database.UseWebAuthnChallengeParams{Challenge: cd.Challenge, Ceremony: "login"})
+	if err != nil {
+		respondWithError(w, 401, "Invalid or expired challenge")
+		return
+	}
+	pk, err := conf.dbQueries.GetPasskey(r.Context(), cred.ID)
+	if err != nil {
+		respondWithError(w, 401, "Invalid passkey")
+		return
+	}
+	// The user handle is optional, but if sent it has to agree
+	if len(resp.UserHandle) > 0 && string(resp.UserHandle) != string(pk.UserID[:]) {
+		respondWithError(w, 401, "Invalid passkey")
+		return
+	}
+
+	assertion, err := conf.webauthn.VerifyAssertion(resp.ClientDataJSON, resp.AuthenticatorData, resp.Signature, challenge.Challenge, pk.PublicKey, uint32(pk.SignCount))
+	if errors.Is(err, webauthn.ErrCloned) {
+		details := map[string]any{"passkey_id": pk.ID, "stored_count": pk.SignCount}
+		if err := audit(r.Context(), conf.dbQueries, auditPasskeyCloned, pk.UserID, details); err != nil {
+			fmt.Printf("Failed to audit cloned passkey: %v\n", err)
+		}
+		respondWithError(w, 401, "Passkey rejected, it may have been copied")
+		return
+	}
+	if err != nil {
+		respondWithError(w, 401, "Invalid passkey")
+		return
+	}
+	params := database.UsePasskeyParams{ID: pk.ID, SignCount: int64(assertion.SignCount)}
+	if err := conf.dbQueries.UsePasskey(r.Context(), params); err != nil {
+		respondWithError(w, 500, "Failed to log in")
+		return
+	}
+
+	user, err := conf.dbQueries.GetUserByID(r.Context(), pk.UserID)
+	if err != nil {
+		respondWithError(w, 401, "Invalid passkey")
+		return
+	}
+	// A passkey unlocked with a PIN or biometric is two factors on its
+	// own. One that only proved someone touched it isn't, for accounts
+	// that asked for two.
+	if !assertion.UserVerified {
+		twoFactor, err := conf.twoFactorEnabled(r.Context(), user.ID)
+		if err != nil {
+			respondWithError(w, 500, "Failed to log in")
+			return
+		}
+		if twoFactor {
+			challenge, err := conf.startLoginChallenge(r.Context(), user.ID)
+			if err != nil {
+				respondWithError(w, 500, "Failed to log in")
+				return
+			}
+			respondWithJSON(w, 200, challenge)
+			return
+		}
+	}
+	conf.completeLogin(w, r, user)
+}
+
+// newWebAuthnChallenge records a challenge for one ceremony. Login
+// challenges aren't tied to a user; registration ones are.
+func (conf *apiConfig) newWebAuthnChallenge(ctx context.Context, ceremony string, userID uuid.UUID) (string, error) {
+	if err := conf.dbQueries.DeleteExpiredWebAuthnChallenges(ctx); err != nil {
+		return "", err
+	}
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		return "", err
+	}
+	params := database.CreateWebAuthnChallengeParams{
+		Challenge: challenge,
+		Ceremony:  ceremony,
+		UserID:    uuid.NullUUID{UUID: userID, Valid: userID != uuid.Nil},
+		ExpiresAt: time.Now().Add(webauthn.Timeout),
+	}
+	if err := conf.dbQueries.CreateWebAuthnChallenge(ctx, params); err != nil {
+		return "", err
+	}
+	return challenge, nil
+}
+
+func dbPasskeyToJSON(db database.Passkey) Passkey {
+	pk := Passkey{db.ID, db.Name, db.CreatedAt, nil}
+	if db.LastUsedAt.Valid {
+		pk.LastUsedAt = &db.LastUsedAt.Time
+	}
+	return pk
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/plusk0/webserver/internal/auth"
+	"github.com/plusk0/webserver/internal/database"
+	"github.com/plusk0/webserver/internal/mailer"
+)
+
+const (
+	passwordResetTTL    = time.Hour
+	passwordResetHourly = 3
+)
+
+// forgotPasswordHandlerFunc emails a reset code. It answers the same way
+// whether or not the address belongs to an account, and does the lookup
+// and sending after responding so timing doesn't tell either.
+func (conf *apiConfig) forgotPasswordHandlerFunc(w http.ResponseWriter, r *http.Request) {
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		respondWithError(w, 400, "Something went wrong")
+		return
+	}
+	defer r.Body.Close()
+	var req forgotPasswordReq
+	if err := json.Unmarshal(data, &req); err != nil {
+		respondWithError(w, 400, "Something went wrong")
+		return
+	}
+	email := strings.TrimSpace(req.Email)
+	if !validEmail(email) {
+		respondWithError(w, 400, "Invalid email address")
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		if err := conf.sendPasswordReset(ctx, email); err != nil {
+			fmt.Printf("Failed to send password reset: %v\n", err)
+		}
+	}()
+	respondWithJSON(w, 202, map[string]string{
+		"message": "If that address has an account, a reset code is on its way",
+	})
+}
+
+// sendPasswordReset mails a single-use code to the account registered
+// with email, if any. Only the code's hash is stored.
+func (conf *apiConfig) sendPasswordReset(ctx context.Context, email string) error {
+	user, err := conf.dbQueries.GetUser(ctx, email)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	params := database.CountPasswordResetsSinceParams{UserID: user.ID, CreatedAt: time.Now().Add(-time.Hour)}
+	sent, err := conf.dbQueries.CountPasswordResetsSince(ctx, params)
+	if err != nil {
+		return err
+	}
+	if sent >= passwordResetHourly {
+		return nil
+	}
+
+	token, err := auth.MakeToken()
+	if err != nil {
+		return err
+	}
+	err = conf.dbQueries.CreatePasswordReset(ctx, database.CreatePasswordResetParams{
+		TokenHash: auth.HashToken(token),
+		UserID:    user.ID,
+		ExpiresAt: time.Now().Add(passwordResetTTL),
+	})
+	if err != nil {
+		return err
+	}
+	return conf.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your Chirpy password",
+		Body: fmt.Sprintf("Someone asked to reset the password for your Chirpy account.\n\n"+
+			"Your reset code is:\n\n%s\n\n"+
+			"It works once and expires in an hour. If you didn't ask for this, ignore this email; "+
+			"your password hasn't changed.\n", token),
+	})
+}
+
+// resetPasswordHandlerFunc sets a new password using an emailed code and
+// signs the account out everywhere.
+func (conf *apiConfig) resetPasswordHandlerFunc(w http.ResponseWriter, r *http.Request) {
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		respondWithError(w, 400, "Something went wrong")
+		return
+	}
+	defer r.Body.Close()
+	var req resetPasswordReq
+	if err := json.Unmarshal(data, &req); err != nil {
+		respondWithError(w, 400, "Something went wrong")
+		return
+	}
+	if req.Token == "" {
+		respondWithError(w, 400, "Missing token")
+		return
+	}
+
+	tx, err := conf.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, 500, "Failed to reset password")
+		return
+	}
+	defer tx.Rollback()
+	q := conf.dbQueries.WithTx(tx)
+
+	reset, err := q.UsePasswordReset(r.Context(), auth.HashToken(req.Token))
+	if err != nil {
+		respondWithError(w, 400, "Invalid or expired token")
+		return
+	}
+	user, err := q.GetUserByID(r.Context(), reset.UserID)
+	if err != nil {
+		respondWithError(w, 500, "Failed to reset password")
+		return
+	}
+	// Rolling back leaves the code usable for another try
+	if !conf.checkNewPassword(w, req.Password, user.Email) {
+		return
+	}
+	hash, err := auth.HashPassword(req.Password)
+	if err != nil {
+		respondWithError(w, 500, "Failed to reset password")
+		return
+	}
+	params := database.UpdateUserPasswordParams{ID: reset.UserID, Password: hash}
+	if err := q.UpdateUserPassword(r.Context(), params); err != nil {
+		respondWithError(w, 500, "Failed to reset password")
+		return
+	}
+	// Any other code that was sent is now stale
+	if err := q.ExpireUserPasswordResets(r.Context(), reset.UserID); err != nil {
+		respondWithError(w, 500, "Failed to reset password")
+		return
+	}
+	version, err := revokeUserAccess(r.Context(), q, reset.UserID)
+	if err != nil {
+		respondWithError(w, 500, "Failed to reset password")
+		return
+	}
+	// Proving access to the mailbox also lifts a lockout
+	if _, err := q.ClearLoginFailures(r.Context(), loginAccountKey(user.Email)); err != nil {
+		respondWithError(w, 500, "Failed to reset password")
+		return
+	}
+	if err := audit(r.Context(), q, auditPasswordReset, reset.UserID, map[string]any{}); err != nil {
+		respondWithError(w, 500, "Failed to reset password")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		respondWithError(w, 500, "Failed to reset password")
+		return
+	}
+	conf.revoked.SetTokenVersion(reset.UserID, version)
+	w.WriteHeader(204)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/plusk0/webserver/internal/auth"
+	"github.com/plusk0/webserver/internal/breach"
+)
+
+const (
+	passwordMinLength = 8
+	// Generous for passphrases, but bounded so hashing stays cheap
+	passwordMaxBytes = 256
+)
+
+// newPasswordPolicy reads PASSWORD_MIN_LENGTH and PASSWORD_MAX_BYTES.
+// BREACHED_PASSWORDS names a list of breached passwords, one per line,
+// either plain or as SHA-1 hashes like the Have I Been Pwned downloads;
+// it's loaded into memory at startup, so a trimmed list of the most
+// common ones starts much faster than the full set.
+func newPasswordPolicy() (auth.PasswordPolicy, error) {
+	p := auth.PasswordPolicy{MinLength: passwordMinLength, MaxBytes: passwordMaxBytes}
+	if s := os.Getenv("PASSWORD_MIN_LENGTH"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			return p, fmt.Errorf("PASSWORD_MIN_LENGTH must be a positive whole number")
+		}
+		p.MinLength = n
+	}
+	if s := os.Getenv("PASSWORD_MAX_BYTES"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < p.MinLength {
+			return p, fmt.Errorf("PASSWORD_MAX_BYTES must be a whole number no less than PASSWORD_MIN_LENGTH")
+		}
+		p.MaxBytes = n
+	}
+	if path := os.Getenv("BREACHED_PASSWORDS"); path != "" {
+		start := time.Now()
+		filter, err := breach.LoadFile(path, breach.DefaultFalsePositiveRate)
+		if err != nil {
+			return p, err
+		}
+		fmt.Printf("Loaded breached passwords in %v\n", time.Since(start).Round(time.Millisecond))
+		p.Breached = filter.Contains
+	}
+	return p, nil
+}
+
+// checkNewPassword answers 400 with the broken rules and returns false if
+// password can't be used for the account with email.
+func (conf *apiConfig) checkNewPassword(w http.ResponseWriter, password, email string) bool {
+	err := conf.passwordPolicy.Check(password, email)
+	if err == nil {
+		return true
+	}
+	out := PasswordRejected{Error: "Password doesn't meet the requirements"}
+	for _, r := range err.(*auth.PasswordPolicyError).Failed {
+		out.FailedRules = append(out.FailedRules, PasswordRule{r.Rule, r.Message})
+	}
+	respondWithJSON(w, 400, out)
+	return false
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/plusk0/webserver/internal/auth"
+	"github.com/plusk0/webserver/internal/database"
+	"github.com/plusk0/webserver/internal/handles"
+)
+
+const (
+	displayNameMaxLength = 50
+	bioMaxLength         = 160
+	avatarURLMaxLength   = 500
+)
+
+func (conf *apiConfig) getProfileHandlerFunc(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, 404, "User not found")
+		return
+	}
+	user, err := conf.dbQueries.GetUserByID(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, 404, "User not found")
+		return
+	}
+	respondWithJSON(w, 200, dbUserToProfile(user))
+}
+
+func (conf *apiConfig) getProfileByHandleHandlerFunc(w http.ResponseWriter, r *http.Request) {
+	user, err := conf.dbQueries.GetUserByHandle(r.Context(), handles.Normalize(r.PathValue("handle")))
+	if err != nil {
+		respondWithError(w, 404, "User not found")
+		return
+	}
+	respondWithJSON(w, 200, dbUserToProfile(user))
+}
+
+// updateProfileHandlerFunc changes any of handle, display name, bio and
+// avatar. Fields left out of the request keep their current value.
+func (conf *apiConfig) updateProfileHandlerFunc(w http.ResponseWriter, r *http.Request) {
+	tk, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	userID, err := conf.validateToken(r.Context(), tk, "profile:write")
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		respondWithError(w, 400, "Something went wrong")
+		return
+	}
+	defer r.Body.Close()
+	var req profileReq
+	if err := json.Unmarshal(data, &req); err != nil {
+		respondWithError(w, 400, "Something went wrong")
+		return
+	}
+
+	user, err := conf.dbQueries.GetUserByID(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, 404, "User not found")
+		return
+	}
+	params := database.UpdateUserProfileParams{
+		ID:          userID,
+		Handle:      user.Handle,
+		DisplayName: user.DisplayName,
+		Bio:         user.Bio,
+		AvatarUrl:   user.AvatarUrl,
+	}
+	if req.Handle != nil && strings.TrimPrefix(*req.Handle, "@") != user.Handle {
+		params.Handle = strings.TrimPrefix(*req.Handle, "@")
+		if err := handles.Validate(params.Handle); err != nil {
+			respondWithError(w, 400, err.Error())
+			return
+		}
+	}
+	if req.DisplayName != nil {
+		params.DisplayName = strings.TrimSpace(*req.DisplayName)
+		if utf8.RuneCountInString(params.DisplayName) > displayNameMaxLength {
+			respondWithError(w, 400, "Display name is too long")
+			return
+		}
+	}
+	if req.Bio != nil {
+		params.Bio = strings.TrimSpace(*req.Bio)
+		if utf8.RuneCountInString(params.Bio) > bioMaxLength {
+			respondWithError(w, 400, "Bio is too long")
+			return
+		}
+	}
+	if req.AvatarURL != nil {
+		params.AvatarUrl = strings.TrimSpace(*req.AvatarURL)
+		if !validAvatarURL(params.AvatarUrl) {
+			respondWithError(w, 400, "Avatar must be an https URL")
+			return
+		}
+	}
+
+	updated, err := conf.dbQueries.UpdateUserProfile(r.Context(), params)
+	if isUniqueViolation(err) {
+		respondWithError(w, 409, "Handle is taken")
+		return
+	}
+	if err != nil {
+		respondWithError(w, 500, "Failed to update profile")
+		return
+	}
+	conf.federateProfile(r.Context(), updated)
+	respondWithJSON(w, 200, dbUserToProfile(updated))
+}
+
+// validAvatarURL accepts "" to clear the avatar. Only https is allowed so
+// pages never load mixed content.
+func validAvatarURL(s string) bool {
+	if s == "" {
+		return true
+	}
+	if len(s) > avatarURLMaxLength {
+		return false
+	}
+	u, err := url.Parse(s)
+	return err == nil && u.Scheme == "https" && u.Host != ""
+}
+
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+// uniqueConstraint names the unique constraint err violated, if any.
+func uniqueConstraint(err error) string {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return pqErr.Constraint
+	}
+	return ""
+}
+
+func isForeignKeyViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23503"
+}
+
+// displayName is how a user is shown when a single name is needed.
+func displayName(user database.User) string {
+	if user.DisplayName != "" {
+		return user.DisplayName
+	}
+	return "@" + user.Handle
+}
+
+// withAuthors embeds an author summary in each chirp, looking every
+// author up once. Anonymized chirps are left without one.
+func (conf *apiConfig) withAuthors(ctx context.Context, chirps []Chirp) error {
+	authors := map[uuid.UUID]*ChirpAuthor{}
+	for i, c := range chirps {
+		if c.UserID == uuid.Nil {
+			continue
+		}
+		author, ok := authors[c.UserID]
+		if !ok {
+			user, err := conf.dbQueries.GetUserByID(ctx, c.UserID)
+			if err != nil {
+				return err
+			}
+			author = &ChirpAuthor{user.ID, user.Handle, user.DisplayName, user.AvatarUrl}
+			authors[c.UserID] = author
+		}
+		chirps[i].Author = author
+	}
+	return nil
+}
+
+func includeAuthor(r *http.Request) bool {
+	for _, v := range strings.Split(r.URL.Query().Get("include"), ",") {
+		if v == "author" {
+			return true
+		}
+	}
+	return false
+}
+
+func dbUserToProfile(db database.User) Profile {
+	return Profile{
+		db.ID,
+		db.Handle,
+		db.DisplayName,
+		db.Bio,
+		db.AvatarUrl,
+		db.CreatedAt,
+		db.IsChirpyRed,
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/plusk0/webserver/internal/auth"
+	"github.com/plusk0/webserver/internal/database"
+)
+
+const (
+	// How long a cached token version is trusted, and so how long a
+	// version bumped by another instance can go unnoticed here
+	tokenVersionTTL          = 30 * time.Second
+	revocationSyncInterval   = 5 * time.Second
+	revocationSyncOverlap    = time.Minute
+	tokenVersionQueryTimeout = 5 * time.Second
+)
+
+func (conf *apiConfig) loadTokenVersion(userID uuid.UUID) (int32, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), tokenVersionQueryTimeout)
+	defer cancel()
+	return conf.dbQueries.GetTokenVersion(ctx, userID)
+}
+
+// syncRevocations copies in denials made by other instances and clears
+// out expired ones. Each pass rereads a little of what it has already
+// seen, in case a transaction committed late.
+func (conf *apiConfig) syncRevocations() {
+	var since time.Time
+	for {
+		revoked, err := conf.dbQueries.GetRevokedAccessTokensSince(context.Background(), since.Add(-revocationSyncOverlap))
+		if err != nil {
+			fmt.Printf("Failed to sync revoked tokens: %v\n", err)
+		}
+		for _, t := range revoked {
+			conf.revoked.Deny(t.ID, t.ExpiresAt)
+			if t.CreatedAt.After(since) {
+				since = t.CreatedAt
+			}
+		}
+		if err := conf.dbQueries.DeleteExpiredRevokedAccessTokens(context.Background()); err != nil {
+			fmt.Printf("Failed to delete expired revoked tokens: %v\n", err)
+		}
+		conf.revoked.Prune()
+		time.Sleep(revocationSyncInterval)
+	}
+}
+
+// denyAccessToken stops id, an access token's jti or a session ID, from
+// being accepted until until.
+func (conf *apiConfig) denyAccessToken(ctx context.Context, q *database.Queries, id string, until time.Time) error {
+	if err := q.RevokeAccessToken(ctx, database.RevokeAccessTokenParams{ID: id, ExpiresAt: until}); err != nil {
+		return err
+	}
+	conf.revoked.Deny(id, until)
+	return nil
+}
+
+// denySessions stops the access tokens already issued to sessions, whose
+// refresh tokens are being revoked. sessionIDs may repeat.
+func (conf *apiConfig) denySessions(ctx context.Context, q *database.Queries, sessionIDs ...uuid.UUID) error {
+	until := time.Now().Add(auth.AccessTokenTTL)
+	seen := map[uuid.UUID]bool{}
+	for _, id := range sessionIDs {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		if err := conf.denyAccessToken(ctx, q, id.String(), until); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// revokeUserAccess logs a user out everywhere: refresh tokens, OAuth
+// refresh tokens and unused authorization codes, and personal access
+// tokens are revoked, and every access token issued so far stops working.
+// Apps keep the consent they were given, but only get back in once the
+// user authorizes them again. Call conf.revoked.SetTokenVersion with the
+// new version once q commits; for password changes, and anything else
+// that means the user's existing logins can't be trusted.
+func revokeUserAccess(ctx context.Context, q *database.Queries, userID uuid.UUID) (int32, error) {
+	if err := q.RevokeUserTokens(ctx, userID); err != nil {
+		return 0, err
+	}
+	if err := q.RevokeUserOAuthRefreshTokens(ctx, userID); err != nil {
+		return 0, err
+	}
+	if err := q.ExpireUserOAuthCodes(ctx, userID); err != nil {
+		return 0, err
+	}
+	if err := q.RevokeUserPersonalAccessTokens(ctx, userID); err != nil {
+		return 0, err
+	}
+	return q.BumpTokenVersion(ctx, userID)
+}
+
+// logoutHandlerFunc logs out the session the access token belongs to. The
+// token itself, and any other the session was given, stop working at once.
+func (conf *apiConfig) logoutHandlerFunc(w http.ResponseWriter, r *http.Request) {
+	tk, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	token, err := auth.ParseAccessToken(tk, conf.jwtKeys)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+
+	tx, err := conf.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, 500, "Failed to log out")
+		return
+	}
+	defer tx.Rollback()
+	q := conf.dbQueries.WithTx(tx)
+
+	if token.ID != "" {
+		if err := conf.denyAccessToken(r.Context(), q, token.ID, token.ExpiresAt); err != nil {
+			respondWithError(w, 500, "Failed to log out")
+			return
+		}
+	}
+	if token.SessionID != uuid.Nil {
+		params := database.RevokeSessionParams{FamilyID: token.SessionID, UserID: token.UserID}
+		if _, err := q.RevokeSession(r.Context(), params); err != nil {
+			respondWithError(w, 500, "Failed to log out")
+			return
+		}
+		if err := conf.denySessions(r.Context(), q, token.SessionID); err != nil {
+			respondWithError(w, 500, "Failed to log out")
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		respondWithError(w, 500, "Failed to log out")
+		return
+	}
+	w.WriteHeader(204)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/plusk0/webserver/internal/auth"
+	"github.com/plusk0/webserver/internal/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestRevokeUserAccess checks that a password change leaves nothing that
+// can log back in: sessions, apps and personal access tokens all go, and
+// other users keep theirs.
+func TestRevokeUserAccess(t *testing.T) {
+	db := &fakeDB{}
+	alice, bob := uuid.New(), uuid.New()
+	expires := time.Now().Add(time.Hour)
+	patOf := map[uuid.UUID]string{}
+	for _, id := range []uuid.UUID{alice, bob} {
+		pat, err := auth.MakePersonalAccessToken()
+		require.NoError(t, err)
+		patOf[id] = pat
+		db.users = append(db.users, database.User{ID: id, TokenVersion: 3})
+		db.refreshTokens = append(db.refreshTokens, database.RefreshToken{TokenHash: "session-" + id.String(), UserID: id, ExpiresAt: expires})
+		db.oauthRefreshTokens = append(db.oauthRefreshTokens, database.OauthRefreshToken{TokenHash: "app-" + id.String(), UserID: id, ExpiresAt: expires})
+		db.oauthCodes = append(db.oauthCodes, database.OauthCode{CodeHash: "code-" + id.String(), UserID: id, ExpiresAt: expires})
+		db.accessTokens = append(db.accessTokens, database.PersonalAccessToken{ID: uuid.New(), UserID: id, TokenHash: auth.HashToken(pat), Scope: "chirps:read"})
+	}
+	conf := &apiConfig{dbQueries: db.queries()}
+	ctx := context.Background()
+
+	version, err := revokeUserAccess(ctx, conf.dbQueries, alice)
+	require.NoError(t, err)
+	assert.Equal(t, int32(4), version)
+
+	for _, tk := range db.refreshTokens {
+		assert.Equal(t, tk.UserID == alice, tk.RevokedAt.Valid, "Refresh token of %s", tk.UserID)
+	}
+	for _, tk := range db.oauthRefreshTokens {
+		assert.Equal(t, tk.UserID == alice, tk.RevokedAt.Valid, "OAuth refresh token of %s", tk.UserID)
+	}
+	for _, code := range db.oauthCodes {
+		assert.Equal(t, code.UserID == alice, code.UsedAt.Valid, "Authorization code of %s", code.UserID)
+	}
+	for _, tk := range db.accessTokens {
+		assert.Equal(t, tk.UserID == alice, tk.RevokedAt.Valid, "Personal access token of %s", tk.UserID)
+	}
+
+	_, err = conf.validateToken(ctx, patOf[alice], "chirps:read")
+	assert.Error(t, err, "A revoked personal access token should be refused")
+	userID, err := conf.validateToken(ctx, patOf[bob], "chirps:read")
+	require.NoError(t, err)
+	assert.Equal(t, bob, userID)
+	assert.Empty(t, db.unsupported)
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/plusk0/webserver/internal/auth"
+	"github.com/plusk0/webserver/internal/database"
+	"github.com/plusk0/webserver/internal/useragent"
+)
+
+const userAgentMax = 500
+
+// startSession records a login from r and issues its first access and
+// refresh tokens.
+func (conf *apiConfig) startSession(ctx context.Context, r *http.Request, userID uuid.UUID) (tk, rTK string, err error) {
+	tx, err := conf.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", "", err
+	}
+	defer tx.Rollback()
+	q := conf.dbQueries.WithTx(tx)
+
+	ua := r.UserAgent()
+	if len(ua) > userAgentMax {
+		ua = ua[:userAgentMax]
+	}
+	params := database.CreateSessionParams{
+		ID:        uuid.New(),
+		UserID:    userID,
+		UserAgent: ua,
+		Ip:        conf.remoteIP(r),
+		Device:    useragent.Describe(ua),
+	}
+	if err := q.CreateSession(ctx, params); err != nil {
+		return "", "", err
+	}
+	if rTK, err = conf.issueRefreshToken(ctx, q, userID, params.ID); err != nil {
+		return "", "", err
+	}
+	if tk, err = auth.MakeSessionJWT(userID, params.ID, conf.jwtKeys); err != nil {
+		return "", "", err
+	}
+	return tk, rTK, tx.Commit()
+}
+
+// getSessionsHandlerFunc lists where the user is logged in, most recently
+// used first.
+func (conf *apiConfig) getSessionsHandlerFunc(w http.ResponseWriter, r *http.Request) {
+	tk, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	userID, current, err := auth.ValidateSessionJWT(tk, conf.jwtKeys)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	sessions, err := conf.dbQueries.GetUserSessions(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, 500, "Failed to get sessions")
+		return
+	}
+	out := make([]Session, 0, len(sessions))
+	for _, s := range sessions {
+		out = append(out, Session{s.ID, s.Device, s.UserAgent, s.Ip, s.CreatedAt, s.LastUsedAt, s.ID == current})
+	}
+	respondWithJSON(w, 200, out)
+}
+
+// revokeSessionHandlerFunc logs one session out. Its refresh and access
+// tokens stop working at once.
+func (conf *apiConfig) revokeSessionHandlerFunc(w http.ResponseWriter, r *http.Request) {
+	tk, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	userID, err := auth.ValidateJWT(tk, conf.jwtKeys)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	sessionID, err := uuid.Parse(r.PathValue("sessionID"))
+	if err != nil {
+		respondWithError(w, 404, "Session not found")
+		return
+	}
+
+	tx, err := conf.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, 500, "Failed to revoke session")
+		return
+	}
+	defer tx.Rollback()
+	q := conf.dbQueries.WithTx(tx)
+
+	n, err := q.RevokeSession(r.Context(), database.RevokeSessionParams{FamilyID: sessionID, UserID: userID})
+	if err != nil {
+		respondWithError(w, 500, "Failed to revoke session")
+		return
+	}
+	if n == 0 {
+		respondWithError(w, 404, "Session not found")
+		return
+	}
+	if err := conf.denySessions(r.Context(), q, sessionID); err != nil {
+		respondWithError(w, 500, "Failed to revoke session")
+		return
+	}
+	if err := audit(r.Context(), q, auditSessionRevoked, userID, map[string]any{"session_id": sessionID}); err != nil {
+		respondWithError(w, 500, "Failed to revoke session")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		respondWithError(w, 500, "Failed to revoke session")
+		return
+	}
+	w.WriteHeader(204)
+}
+
+// revokeOtherSessionsHandlerFunc logs out everywhere except the session
+// making the request.
+func (conf *apiConfig) revokeOtherSessionsHandlerFunc(w http.ResponseWriter, r *http.Request) {
+	tk, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	userID, current, err := auth.ValidateSessionJWT(tk, conf.jwtKeys)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	// Otherwise there'd be no session to keep
+	if current == uuid.Nil {
+		respondWithError(w, 400, "This token isn't tied to a session; log in again first")
+		return
+	}
+
+	tx, err := conf.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, 500, "Failed to revoke sessions")
+		return
+	}
+	defer tx.Rollback()
+	q := conf.dbQueries.WithTx(tx)
+
+	revoked, err := q.RevokeOtherSessions(r.Context(), database.RevokeOtherSessionsParams{UserID: userID, FamilyID: current})
+	if err != nil {
+		respondWithError(w, 500, "Failed to revoke sessions")
+		return
+	}
+	if err := conf.denySessions(r.Context(), q, revoked...); err != nil {
+		respondWithError(w, 500, "Failed to revoke sessions")
+		return
+	}
+	details := map[string]any{"kept_session_id": current, "revoked_tokens": len(revoked)}
+	if err := audit(r.Context(), q, auditSessionRevoked, userID, details); err != nil {
+		respondWithError(w, 500, "Failed to revoke sessions")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		respondWithError(w, 500, "Failed to revoke sessions")
+		return
+	}
+	w.WriteHeader(204)
+}
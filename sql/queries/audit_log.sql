@@ -0,0 +1,9 @@
+-- name: CreateAuditEntry :exec
+INSERT INTO audit_log (id, created_at, event, user_id, details)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    $1,
+    $2,
+    $3
+);
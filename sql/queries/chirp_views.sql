@@ -0,0 +1,7 @@
+-- name: AddChirpViews :exec
+INSERT INTO chirp_views (chirp_id, day, views)
+VALUES ($1, $2, $3)
+ON CONFLICT (chirp_id, day) DO UPDATE SET views = chirp_views.views + EXCLUDED.views;
+
+-- name: GetChirpViews :many
+SELECT * FROM chirp_views WHERE chirp_id = $1 ORDER BY day ASC;
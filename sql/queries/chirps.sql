@@ -1,11 +1,14 @@
 -- name: CreateChirp :one
-INSERT INTO chirps (id, created_at, updated_at, body, user_id)
+INSERT INTO chirps (id, created_at, updated_at, body, user_id, content_warning, sensitive, body_html)
 VALUES (
     gen_random_uuid(),
     NOW(),
     NOW(),
     $1,
-    $2
+    $2,
+    $3,
+    $4,
+    $5
 )
 RETURNING *;
 
@@ -20,3 +23,37 @@ DELETE FROM chirps RETURNING *;
 
 -- name: DeleteChirp :one
 DELETE FROM chirps WHERE id = $1 RETURNING *;
+
+-- name: DeleteUserChirps :execrows
+DELETE FROM chirps WHERE user_id = $1;
+
+-- name: AnonymizeUserChirps :execrows
+UPDATE chirps SET user_id = NULL, updated_at = NOW() WHERE user_id = $1;
+
+-- name: GetChirpsByAuthor :many
+SELECT * FROM chirps WHERE user_id = $1 ORDER BY created_at ASC;
+
+-- name: CreateChirpAt :one
+INSERT INTO chirps (id, created_at, updated_at, body, user_id, content_warning, sensitive, body_html)
+VALUES (
+    gen_random_uuid(),
+    $1,
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    $6
+)
+RETURNING *;
+
+-- name: ChirpExists :one
+SELECT EXISTS (
+    SELECT 1 FROM chirps WHERE user_id = $1 AND created_at = $2 AND body = $3
+);
+
+-- name: SetChirpSensitive :one
+UPDATE chirps
+SET sensitive = $2, sensitive_by_moderator = $3, updated_at = NOW()
+WHERE id = $1
+RETURNING *;
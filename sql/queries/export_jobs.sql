@@ -0,0 +1,41 @@
+-- name: CreateExportJob :one
+INSERT INTO export_jobs (id, created_at, updated_at, user_id, status)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    NOW(),
+    $1,
+    'pending'
+)
+RETURNING *;
+
+-- name: GetExportJob :one
+SELECT * FROM export_jobs WHERE id = $1;
+
+-- name: GetUserExportJobs :many
+SELECT * FROM export_jobs WHERE user_id = $1;
+
+-- name: GetUnfinishedExportJobs :many
+SELECT * FROM export_jobs WHERE status IN ('pending', 'running') ORDER BY created_at ASC;
+
+-- name: StartExportJob :exec
+UPDATE export_jobs SET status = 'running', updated_at = NOW() WHERE id = $1;
+
+-- name: FinishExportJob :exec
+UPDATE export_jobs
+SET
+  status = $2,
+  error = $3,
+  updated_at = NOW(),
+  finished_at = NOW(),
+  expires_at = $4
+WHERE id = $1;
+
+-- name: GetExpiredExportJobs :many
+SELECT * FROM export_jobs WHERE expires_at < NOW();
+
+-- name: DeleteExportJob :exec
+DELETE FROM export_jobs WHERE id = $1;
+
+-- name: GetLatestExportJob :one
+SELECT * FROM export_jobs WHERE user_id = $1 ORDER BY created_at DESC LIMIT 1;
@@ -0,0 +1,134 @@
+-- name: CreateActorKey :exec
+INSERT INTO actor_keys (user_id, created_at, public_key, private_key)
+VALUES (
+    $1,
+    NOW(),
+    $2,
+    $3
+)
+ON CONFLICT (user_id) DO NOTHING;
+
+-- name: GetActorKey :one
+SELECT * FROM actor_keys WHERE user_id = $1;
+
+-- name: UpsertRemoteActor :one
+INSERT INTO remote_actors (id, created_at, updated_at, username, inbox, shared_inbox, public_key)
+VALUES (
+    $1,
+    NOW(),
+    NOW(),
+    $2,
+    $3,
+    $4,
+    $5
+)
+ON CONFLICT (id) DO UPDATE
+SET
+  updated_at = NOW(),
+  username = EXCLUDED.username,
+  inbox = EXCLUDED.inbox,
+  shared_inbox = EXCLUDED.shared_inbox,
+  public_key = EXCLUDED.public_key
+RETURNING *;
+
+-- name: GetRemoteActor :one
+SELECT * FROM remote_actors WHERE id = $1;
+
+-- name: DeleteRemoteActor :exec
+DELETE FROM remote_actors WHERE id = $1;
+
+-- name: AddFollower :execrows
+INSERT INTO followers (user_id, actor_id, created_at)
+VALUES (
+    $1,
+    $2,
+    NOW()
+)
+ON CONFLICT DO NOTHING;
+
+-- name: RemoveFollower :exec
+DELETE FROM followers WHERE user_id = $1 AND actor_id = $2;
+
+-- name: CountFollowers :one
+SELECT COUNT(*) FROM followers WHERE user_id = $1;
+
+-- name: GetFollowerActors :many
+SELECT * FROM remote_actors
+WHERE id IN (SELECT actor_id FROM followers WHERE user_id = $1)
+ORDER BY id;
+
+-- name: GetUserFollowers :many
+SELECT * FROM followers WHERE user_id = $1 ORDER BY created_at ASC;
+
+-- name: GetRemoteLikesOfUser :many
+SELECT * FROM remote_likes
+WHERE chirp_id IN (SELECT id FROM chirps WHERE user_id = $1)
+ORDER BY created_at ASC;
+
+-- name: GetChirpRemoteLikes :many
+SELECT * FROM remote_likes WHERE chirp_id = $1 ORDER BY created_at ASC;
+
+-- name: CreateRemoteNote :exec
+INSERT INTO remote_notes (id, created_at, actor_id, content, in_reply_to, published)
+VALUES (
+    $1,
+    NOW(),
+    $2,
+    $3,
+    $4,
+    $5
+)
+ON CONFLICT (id) DO NOTHING;
+
+-- name: DeleteRemoteNote :execrows
+DELETE FROM remote_notes WHERE id = $1 AND actor_id = $2;
+
+-- name: AddRemoteLike :execrows
+INSERT INTO remote_likes (actor_id, chirp_id, created_at)
+VALUES (
+    $1,
+    $2,
+    NOW()
+)
+ON CONFLICT DO NOTHING;
+
+-- name: RemoveRemoteLike :exec
+DELETE FROM remote_likes WHERE actor_id = $1 AND chirp_id = $2;
+
+-- name: CreateDelivery :exec
+INSERT INTO deliveries (id, created_at, updated_at, user_id, inbox, body, status, next_attempt_at)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    NOW(),
+    $1,
+    $2,
+    $3,
+    'pending',
+    NOW()
+);
+
+-- name: GetDueDeliveries :many
+SELECT * FROM deliveries
+WHERE status = 'pending' AND next_attempt_at <= NOW()
+ORDER BY next_attempt_at ASC
+LIMIT $1;
+
+-- name: FinishDelivery :exec
+UPDATE deliveries
+SET
+  status = 'delivered',
+  attempts = attempts + 1,
+  updated_at = NOW(),
+  delivered_at = NOW()
+WHERE id = $1;
+
+-- name: RetryDelivery :exec
+UPDATE deliveries
+SET
+  status = $2,
+  attempts = attempts + 1,
+  last_error = $3,
+  next_attempt_at = $4,
+  updated_at = NOW()
+WHERE id = $1;
@@ -0,0 +1,44 @@
+-- name: CreateImportJob :one
+INSERT INTO import_jobs (id, created_at, updated_at, user_id, format, status)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    NOW(),
+    $1,
+    $2,
+    'pending'
+)
+RETURNING *;
+
+-- name: GetImportJob :one
+SELECT * FROM import_jobs WHERE id = $1;
+
+-- name: GetUnfinishedImportJobs :many
+SELECT * FROM import_jobs WHERE status IN ('pending', 'running') ORDER BY created_at ASC;
+
+-- name: StartImportJob :exec
+UPDATE import_jobs SET status = 'running', total = $2, updated_at = NOW() WHERE id = $1;
+
+-- name: UpdateImportJobProgress :exec
+UPDATE import_jobs
+SET
+  processed = $2,
+  imported = $3,
+  skipped = $4,
+  updated_at = NOW()
+WHERE id = $1;
+
+-- name: FinishImportJob :exec
+UPDATE import_jobs SET status = $2, updated_at = NOW(), finished_at = NOW() WHERE id = $1;
+
+-- name: CreateImportError :exec
+INSERT INTO import_errors (id, job_id, row, message)
+VALUES (
+    gen_random_uuid(),
+    $1,
+    $2,
+    $3
+);
+
+-- name: GetImportErrors :many
+SELECT * FROM import_errors WHERE job_id = $1 ORDER BY row ASC;
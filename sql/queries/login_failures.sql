@@ -0,0 +1,31 @@
+-- name: GetLoginFailure :one
+SELECT * FROM login_failures WHERE key = $1;
+
+-- name: RecordLoginFailure :one
+INSERT INTO login_failures (key, failures, first_failed_at, last_failed_at)
+VALUES (
+    $1,
+    1,
+    NOW(),
+    NOW()
+)
+ON CONFLICT (key) DO UPDATE SET
+  failures = CASE WHEN login_failures.first_failed_at < $2 THEN 1 ELSE login_failures.failures + 1 END,
+  first_failed_at = CASE WHEN login_failures.first_failed_at < $2 THEN NOW() ELSE login_failures.first_failed_at END,
+  last_failed_at = NOW()
+RETURNING *;
+
+-- name: LockLogin :exec
+UPDATE login_failures
+SET locked_until = $2, failures = 0, first_failed_at = NOW()
+WHERE key = $1;
+
+-- name: ForgetLoginAttempt :exec
+UPDATE login_failures SET failures = failures - 1 WHERE key = $1 AND failures > 0;
+
+-- name: ClearLoginFailures :execrows
+DELETE FROM login_failures WHERE key = $1;
+
+-- name: DeleteStaleLoginFailures :exec
+DELETE FROM login_failures
+WHERE last_failed_at < $1 AND (locked_until IS NULL OR locked_until < NOW());
@@ -0,0 +1,26 @@
+-- name: CreateMessage :one
+INSERT INTO messages (id, created_at, sender_id, recipient_id, body)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    $1,
+    $2,
+    $3
+)
+RETURNING *;
+
+-- name: GetMessagesSince :many
+SELECT * FROM messages
+WHERE (sender_id = @user_id OR recipient_id = @user_id) AND seq > @since
+ORDER BY seq ASC;
+
+-- name: GetMessagesSincePage :many
+SELECT * FROM messages
+WHERE (sender_id = @user_id OR recipient_id = @user_id) AND seq > @since
+ORDER BY seq ASC
+LIMIT @page_size;
+
+-- name: MarkMessageRead :one
+UPDATE messages SET read_at = NOW()
+WHERE id = $1 AND recipient_id = $2 AND read_at IS NULL
+RETURNING *;
@@ -0,0 +1,27 @@
+-- name: CreateNotification :one
+INSERT INTO notifications (id, created_at, user_id, actor_id, remote_actor_id, type, chirp_id)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    $1,
+    $2,
+    $3,
+    $4,
+    $5
+)
+RETURNING *;
+
+-- name: GetNotifications :many
+SELECT * FROM notifications
+WHERE user_id = @user_id AND (@type::text = '' OR type = @type)
+ORDER BY created_at DESC;
+
+-- name: CountUnreadNotifications :one
+SELECT COUNT(*) FROM notifications WHERE user_id = $1 AND read_at IS NULL;
+
+-- name: MarkNotificationsRead :exec
+UPDATE notifications SET read_at = NOW()
+WHERE user_id = @user_id
+  AND read_at IS NULL
+  AND (@type::text = '' OR type = @type)
+  AND (sqlc.narg(chirp_id)::uuid IS NULL OR chirp_id = sqlc.narg(chirp_id));
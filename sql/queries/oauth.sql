@@ -0,0 +1,93 @@
+-- name: CreateOAuthClient :one
+INSERT INTO oauth_clients (id, owner_id, name, secret_hash, created_at)
+VALUES (
+    gen_random_uuid(),
+    $1,
+    $2,
+    $3,
+    NOW()
+)
+RETURNING *;
+
+-- name: GetOAuthClient :one
+SELECT * FROM oauth_clients WHERE id = $1;
+
+-- name: GetUserOAuthClients :many
+SELECT * FROM oauth_clients WHERE owner_id = $1 ORDER BY created_at ASC;
+
+-- name: DeleteOAuthClient :execrows
+DELETE FROM oauth_clients WHERE id = $1 AND owner_id = $2;
+
+-- name: AddOAuthRedirectURI :exec
+INSERT INTO oauth_redirect_uris (client_id, uri)
+VALUES (
+    $1,
+    $2
+);
+
+-- name: GetOAuthRedirectURIs :many
+SELECT uri FROM oauth_redirect_uris WHERE client_id = $1 ORDER BY uri ASC;
+
+-- name: UpsertOAuthGrant :exec
+INSERT INTO oauth_grants (user_id, client_id, scope, created_at, updated_at)
+VALUES (
+    $1,
+    $2,
+    $3,
+    NOW(),
+    NOW()
+)
+ON CONFLICT (user_id, client_id) DO UPDATE
+SET scope = EXCLUDED.scope, updated_at = NOW();
+
+-- name: GetUserOAuthGrants :many
+SELECT * FROM oauth_grants WHERE user_id = $1 ORDER BY updated_at DESC;
+
+-- name: DeleteOAuthGrant :execrows
+DELETE FROM oauth_grants WHERE user_id = $1 AND client_id = $2;
+
+-- name: CreateOAuthCode :exec
+INSERT INTO oauth_codes (code_hash, user_id, client_id, redirect_uri, scope, code_challenge, created_at, expires_at)
+VALUES (
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    $6,
+    NOW(),
+    $7
+);
+
+-- name: UseOAuthCode :one
+UPDATE oauth_codes
+SET used_at = NOW()
+WHERE code_hash = $1 AND used_at IS NULL AND expires_at > NOW()
+RETURNING *;
+
+-- name: CreateOAuthRefreshToken :exec
+INSERT INTO oauth_refresh_tokens (token_hash, user_id, client_id, scope, created_at, expires_at)
+VALUES (
+    $1,
+    $2,
+    $3,
+    $4,
+    NOW(),
+    $5
+);
+
+-- name: UseOAuthRefreshToken :one
+UPDATE oauth_refresh_tokens
+SET revoked_at = NOW()
+WHERE token_hash = $1 AND revoked_at IS NULL AND expires_at > NOW()
+RETURNING *;
+
+-- name: RevokeUserOAuthRefreshTokens :exec
+UPDATE oauth_refresh_tokens
+SET revoked_at = NOW()
+WHERE user_id = $1 AND revoked_at IS NULL;
+
+-- name: ExpireUserOAuthCodes :exec
+UPDATE oauth_codes
+SET used_at = NOW()
+WHERE user_id = $1 AND used_at IS NULL;
@@ -0,0 +1,42 @@
+-- name: CreatePasskey :exec
+INSERT INTO passkeys (id, user_id, name, public_key, sign_count, created_at)
+VALUES (
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    NOW()
+);
+
+-- name: GetPasskey :one
+SELECT * FROM passkeys WHERE id = $1;
+
+-- name: GetUserPasskeys :many
+SELECT * FROM passkeys WHERE user_id = $1 ORDER BY created_at ASC;
+
+-- name: UsePasskey :exec
+UPDATE passkeys
+SET sign_count = $2, last_used_at = NOW()
+WHERE id = $1;
+
+-- name: DeletePasskey :execrows
+DELETE FROM passkeys WHERE id = $1 AND user_id = $2;
+
+-- name: CreateWebAuthnChallenge :exec
+INSERT INTO webauthn_challenges (challenge, ceremony, user_id, created_at, expires_at)
+VALUES (
+    $1,
+    $2,
+    $3,
+    NOW(),
+    $4
+);
+
+-- name: UseWebAuthnChallenge :one
+DELETE FROM webauthn_challenges
+WHERE challenge = $1 AND ceremony = $2 AND expires_at > NOW()
+RETURNING *;
+
+-- name: DeleteExpiredWebAuthnChallenges :exec
+DELETE FROM webauthn_challenges WHERE expires_at <= NOW();
@@ -0,0 +1,22 @@
+-- name: CreatePasswordReset :exec
+INSERT INTO password_resets (token_hash, user_id, created_at, expires_at)
+VALUES (
+    $1,
+    $2,
+    NOW(),
+    $3
+);
+
+-- name: UsePasswordReset :one
+UPDATE password_resets
+SET used_at = NOW()
+WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW()
+RETURNING *;
+
+-- name: ExpireUserPasswordResets :exec
+UPDATE password_resets
+SET used_at = NOW()
+WHERE user_id = $1 AND used_at IS NULL;
+
+-- name: CountPasswordResetsSince :one
+SELECT COUNT(*) FROM password_resets WHERE user_id = $1 AND created_at > $2;
@@ -0,0 +1,43 @@
+-- name: CreatePersonalAccessToken :one
+INSERT INTO personal_access_tokens (id, user_id, name, token_hash, scope, created_at, expires_at)
+VALUES (
+    gen_random_uuid(),
+    $1,
+    $2,
+    $3,
+    $4,
+    NOW(),
+    $5
+)
+RETURNING *;
+
+-- name: GetPersonalAccessToken :one
+SELECT * FROM personal_access_tokens
+WHERE token_hash = $1
+  AND revoked_at IS NULL
+  AND (expires_at IS NULL OR expires_at > NOW())
+  AND user_id NOT IN (SELECT id FROM users WHERE suspended_at IS NOT NULL);
+
+-- name: GetUserPersonalAccessTokens :many
+SELECT * FROM personal_access_tokens
+WHERE user_id = $1 AND revoked_at IS NULL
+ORDER BY created_at ASC;
+
+-- name: CountUserPersonalAccessTokens :one
+SELECT COUNT(*) FROM personal_access_tokens
+WHERE user_id = $1 AND revoked_at IS NULL;
+
+-- name: TouchPersonalAccessToken :exec
+UPDATE personal_access_tokens
+SET last_used_at = NOW()
+WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute');
+
+-- name: RevokePersonalAccessToken :execrows
+UPDATE personal_access_tokens
+SET revoked_at = NOW()
+WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL;
+
+-- name: RevokeUserPersonalAccessTokens :exec
+UPDATE personal_access_tokens
+SET revoked_at = NOW()
+WHERE user_id = $1 AND revoked_at IS NULL;
@@ -1,22 +1,35 @@
 -- name: CreateToken :one
-INSERT INTO refresh_tokens (token, created_at, updated_at, user_id, expires_at)
+INSERT INTO refresh_tokens (token_hash, created_at, updated_at, user_id, family_id, expires_at)
 VALUES (
     $1,
     NOW(),
     NOW(),
     $2,
-    $3
+    $3,
+    $4
 )
 RETURNING *;
 
 -- name: GetToken :one
-SELECT * FROM refresh_tokens WHERE token = $1;
+SELECT * FROM refresh_tokens WHERE token_hash = $1;
 
--- name: RevokeToken :one
-UPDATE refresh_tokens 
-SET 
-  revoked_at = NOW(),
+-- name: RotateToken :execrows
+UPDATE refresh_tokens
+SET
+  rotated_at = NOW(),
   updated_at = NOW()
-WHERE token = $1 RETURNING *;
+WHERE token_hash = $1 AND rotated_at IS NULL AND revoked_at IS NULL;
 
+-- name: RevokeTokenFamily :execrows
+UPDATE refresh_tokens
+SET
+  revoked_at = NOW(),
+  updated_at = NOW()
+WHERE family_id = $1 AND revoked_at IS NULL;
 
+-- name: RevokeUserTokens :exec
+UPDATE refresh_tokens
+SET
+  revoked_at = NOW(),
+  updated_at = NOW()
+WHERE user_id = $1 AND revoked_at IS NULL;
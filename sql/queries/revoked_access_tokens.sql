@@ -0,0 +1,25 @@
+-- name: RevokeAccessToken :exec
+INSERT INTO revoked_access_tokens (id, expires_at, created_at)
+VALUES (
+    $1,
+    $2,
+    NOW()
+)
+ON CONFLICT (id) DO UPDATE SET expires_at = GREATEST(revoked_access_tokens.expires_at, EXCLUDED.expires_at);
+
+-- name: GetRevokedAccessTokensSince :many
+SELECT * FROM revoked_access_tokens
+WHERE created_at > $1 AND expires_at > NOW()
+ORDER BY created_at;
+
+-- name: DeleteExpiredRevokedAccessTokens :exec
+DELETE FROM revoked_access_tokens WHERE expires_at <= NOW();
+
+-- name: GetTokenVersion :one
+SELECT token_version FROM users WHERE id = $1;
+
+-- name: BumpTokenVersion :one
+UPDATE users
+SET token_version = token_version + 1, updated_at = NOW()
+WHERE id = $1
+RETURNING token_version;
@@ -0,0 +1,45 @@
+-- name: CreateSession :exec
+INSERT INTO sessions (id, user_id, user_agent, ip, device, created_at, last_used_at)
+VALUES (
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    NOW(),
+    NOW()
+);
+
+-- name: TouchSession :exec
+UPDATE sessions
+SET ip = $2, last_used_at = NOW()
+WHERE id = $1;
+
+-- name: GetUserSessions :many
+SELECT * FROM sessions
+WHERE user_id = $1 AND EXISTS (
+  SELECT 1 FROM refresh_tokens
+  WHERE family_id = sessions.id
+    AND revoked_at IS NULL
+    AND rotated_at IS NULL
+    AND expires_at > NOW()
+)
+ORDER BY last_used_at DESC;
+
+-- name: RevokeSession :execrows
+UPDATE refresh_tokens
+SET
+  revoked_at = NOW(),
+  updated_at = NOW()
+WHERE family_id = $1 AND user_id = $2 AND revoked_at IS NULL;
+
+-- name: RevokeOtherSessions :many
+UPDATE refresh_tokens
+SET
+  revoked_at = NOW(),
+  updated_at = NOW()
+WHERE user_id = $1 AND family_id <> $2 AND revoked_at IS NULL
+RETURNING family_id;
+
+-- name: GetAllUserSessions :many
+SELECT * FROM sessions WHERE user_id = $1 ORDER BY created_at ASC;
@@ -0,0 +1,65 @@
+-- name: StartTOTPEnrollment :execrows
+INSERT INTO totp_credentials (user_id, secret, created_at, confirmed_at, last_step)
+VALUES (
+    $1,
+    $2,
+    NOW(),
+    NULL,
+    0
+)
+ON CONFLICT (user_id) DO UPDATE
+SET secret = EXCLUDED.secret, created_at = NOW(), last_step = 0
+WHERE totp_credentials.confirmed_at IS NULL;
+
+-- name: GetTOTPCredential :one
+SELECT * FROM totp_credentials WHERE user_id = $1;
+
+-- name: ConfirmTOTPCredential :execrows
+UPDATE totp_credentials
+SET confirmed_at = NOW(), last_step = $2
+WHERE user_id = $1 AND confirmed_at IS NULL;
+
+-- name: UseTOTPStep :execrows
+UPDATE totp_credentials
+SET last_step = $2
+WHERE user_id = $1 AND confirmed_at IS NOT NULL AND last_step < $2;
+
+-- name: DeleteTOTPCredential :exec
+DELETE FROM totp_credentials WHERE user_id = $1;
+
+-- name: CreateRecoveryCode :exec
+INSERT INTO recovery_codes (code_hash, user_id, created_at)
+VALUES (
+    $1,
+    $2,
+    NOW()
+);
+
+-- name: UseRecoveryCode :execrows
+UPDATE recovery_codes
+SET used_at = NOW()
+WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL;
+
+-- name: DeleteRecoveryCodes :exec
+DELETE FROM recovery_codes WHERE user_id = $1;
+
+-- name: CreateLoginChallenge :exec
+INSERT INTO login_challenges (token_hash, user_id, created_at, expires_at)
+VALUES (
+    $1,
+    $2,
+    NOW(),
+    $3
+);
+
+-- name: AttemptLoginChallenge :one
+UPDATE login_challenges
+SET attempts = attempts + 1
+WHERE token_hash = $1 AND expires_at > NOW()
+RETURNING *;
+
+-- name: DeleteLoginChallenge :exec
+DELETE FROM login_challenges WHERE token_hash = $1;
+
+-- name: DeleteExpiredLoginChallenges :exec
+DELETE FROM login_challenges WHERE expires_at <= NOW();
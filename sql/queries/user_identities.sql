@@ -0,0 +1,39 @@
+-- name: CreateOIDCLogin :exec
+INSERT INTO oidc_logins (state_hash, nonce, code_verifier, created_at, expires_at)
+VALUES (
+    $1,
+    $2,
+    $3,
+    NOW(),
+    $4
+);
+
+-- name: UseOIDCLogin :one
+DELETE FROM oidc_logins
+WHERE state_hash = $1 AND expires_at > NOW()
+RETURNING *;
+
+-- name: DeleteExpiredOIDCLogins :exec
+DELETE FROM oidc_logins WHERE expires_at <= NOW();
+
+-- name: GetUserIdentity :one
+SELECT * FROM user_identities WHERE issuer = $1 AND subject = $2;
+
+-- name: CreateUserIdentity :exec
+INSERT INTO user_identities (issuer, subject, user_id, email, created_at, last_login_at)
+VALUES (
+    $1,
+    $2,
+    $3,
+    $4,
+    NOW(),
+    NOW()
+);
+
+-- name: TouchUserIdentity :exec
+UPDATE user_identities
+SET email = $3, last_login_at = NOW()
+WHERE issuer = $1 AND subject = $2;
+
+-- name: GetUserIdentities :many
+SELECT * FROM user_identities WHERE user_id = $1 ORDER BY created_at ASC;
@@ -1,12 +1,13 @@
 -- name: CreateUser :one
-INSERT INTO users (id, created_at, updated_at, email, password, is_chirpy_red)
+INSERT INTO users (id, created_at, updated_at, email, password, is_chirpy_red, handle)
 VALUES (
     gen_random_uuid(),
     NOW(),
     NOW(),
     $1,
     $2,
-    false
+    false,
+    $3
 )
 RETURNING *;
 
@@ -20,9 +21,10 @@ SELECT * FROM users WHERE $1 = email;
 UPDATE users SET 
 email = $2,
 password = $3,
+email_verified_at = CASE WHEN email = $2 THEN email_verified_at ELSE NULL END,
 updated_at = NOW()
 WHERE id = $1
-RETURNING id, created_at, updated_at, email, is_chirpy_red;
+RETURNING id, created_at, updated_at, email, is_chirpy_red, handle, email_verified_at;
 
 -- name: ResetUsers :many
 DELETE FROM users RETURNING *;
@@ -30,3 +32,42 @@ DELETE FROM users RETURNING *;
 -- name: UpgradeUser :one
 UPDATE users SET is_chirpy_red = true WHERE id = $1 RETURNING id;
 
+
+-- name: GetUserByID :one
+SELECT * FROM users WHERE id = $1;
+
+-- name: DeleteUser :exec
+DELETE FROM users WHERE id = $1;
+
+-- name: UpdateUserPreferences :exec
+UPDATE users SET hide_sensitive = $2, updated_at = NOW() WHERE id = $1;
+
+-- name: GetUserByHandle :one
+SELECT * FROM users WHERE lower(handle) = lower(@handle);
+
+-- name: UpdateUserProfile :one
+UPDATE users
+SET
+  handle = $2,
+  display_name = $3,
+  bio = $4,
+  avatar_url = $5,
+  updated_at = NOW()
+WHERE id = $1
+RETURNING *;
+
+-- name: VerifyUserEmail :execrows
+UPDATE users SET email_verified_at = NOW(), updated_at = NOW() WHERE id = $1 AND email = $2;
+
+-- name: UpdateUserPassword :exec
+UPDATE users
+SET password = $2, updated_at = NOW()
+WHERE id = $1;
+
+-- name: SuspendUser :execrows
+UPDATE users SET suspended_at = NOW(), updated_at = NOW()
+WHERE id = $1 AND suspended_at IS NULL;
+
+-- name: UnsuspendUser :execrows
+UPDATE users SET suspended_at = NULL, updated_at = NOW()
+WHERE id = $1 AND suspended_at IS NOT NULL;
@@ -0,0 +1,21 @@
+-- +goose Up
+CREATE TABLE messages(
+  id UUID PRIMARY KEY,
+  seq BIGSERIAL UNIQUE NOT NULL,
+  created_at TIMESTAMP NOT NULL,
+  sender_id UUID NOT NULL,
+    CONSTRAINT fk_sender_id
+    FOREIGN KEY (sender_id)
+    REFERENCES users(id)
+    ON DELETE CASCADE,
+  recipient_id UUID NOT NULL,
+    CONSTRAINT fk_recipient_id
+    FOREIGN KEY (recipient_id)
+    REFERENCES users(id)
+    ON DELETE CASCADE,
+  body TEXT NOT NULL,
+  read_at TIMESTAMP
+);
+
+-- +goose Down
+DROP TABLE messages;
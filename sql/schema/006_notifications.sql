@@ -0,0 +1,27 @@
+-- +goose Up
+CREATE TABLE notifications(
+  id UUID PRIMARY KEY,
+  created_at TIMESTAMP NOT NULL,
+  user_id UUID NOT NULL,
+    CONSTRAINT fk_user_id
+    FOREIGN KEY (user_id)
+    REFERENCES users(id)
+    ON DELETE CASCADE,
+  actor_id UUID NOT NULL,
+    CONSTRAINT fk_actor_id
+    FOREIGN KEY (actor_id)
+    REFERENCES users(id)
+    ON DELETE CASCADE,
+  type TEXT NOT NULL,
+  chirp_id UUID,
+    CONSTRAINT fk_chirp_id
+    FOREIGN KEY (chirp_id)
+    REFERENCES chirps(id)
+    ON DELETE CASCADE,
+  read_at TIMESTAMP
+);
+
+CREATE INDEX notifications_user_id_idx ON notifications(user_id, created_at);
+
+-- +goose Down
+DROP TABLE notifications;
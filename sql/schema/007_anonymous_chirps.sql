@@ -0,0 +1,16 @@
+-- +goose Up
+ALTER TABLE chirps ALTER COLUMN user_id DROP NOT NULL;
+ALTER TABLE chirps DROP CONSTRAINT fk_user_id;
+ALTER TABLE chirps ADD CONSTRAINT fk_user_id
+  FOREIGN KEY (user_id)
+  REFERENCES users(id)
+  ON DELETE SET NULL;
+
+-- +goose Down
+DELETE FROM chirps WHERE user_id IS NULL;
+ALTER TABLE chirps DROP CONSTRAINT fk_user_id;
+ALTER TABLE chirps ADD CONSTRAINT fk_user_id
+  FOREIGN KEY (user_id)
+  REFERENCES users(id)
+  ON DELETE CASCADE;
+ALTER TABLE chirps ALTER COLUMN user_id SET NOT NULL;
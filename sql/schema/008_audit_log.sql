@@ -0,0 +1,11 @@
+-- +goose Up
+CREATE TABLE audit_log(
+  id UUID PRIMARY KEY,
+  created_at TIMESTAMP NOT NULL,
+  event TEXT NOT NULL,
+  user_id UUID,
+  details TEXT NOT NULL
+);
+
+-- +goose Down
+DROP TABLE audit_log;
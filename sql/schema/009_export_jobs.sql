@@ -0,0 +1,17 @@
+-- +goose Up
+CREATE TABLE export_jobs(
+  id UUID PRIMARY KEY,
+  created_at TIMESTAMP NOT NULL,
+  updated_at TIMESTAMP NOT NULL,
+  user_id UUID NOT NULL,
+    CONSTRAINT fk_user_id
+    FOREIGN KEY (user_id)
+    REFERENCES users(id)
+    ON DELETE CASCADE,
+  status TEXT NOT NULL,
+  error TEXT NOT NULL DEFAULT '',
+  finished_at TIMESTAMP
+);
+
+-- +goose Down
+DROP TABLE export_jobs;
@@ -0,0 +1,33 @@
+-- +goose Up
+CREATE TABLE import_jobs(
+  id UUID PRIMARY KEY,
+  created_at TIMESTAMP NOT NULL,
+  updated_at TIMESTAMP NOT NULL,
+  user_id UUID NOT NULL,
+    CONSTRAINT fk_user_id
+    FOREIGN KEY (user_id)
+    REFERENCES users(id)
+    ON DELETE CASCADE,
+  format TEXT NOT NULL,
+  status TEXT NOT NULL,
+  total INTEGER NOT NULL DEFAULT 0,
+  processed INTEGER NOT NULL DEFAULT 0,
+  imported INTEGER NOT NULL DEFAULT 0,
+  skipped INTEGER NOT NULL DEFAULT 0,
+  finished_at TIMESTAMP
+);
+
+CREATE TABLE import_errors(
+  id UUID PRIMARY KEY,
+  job_id UUID NOT NULL,
+    CONSTRAINT fk_job_id
+    FOREIGN KEY (job_id)
+    REFERENCES import_jobs(id)
+    ON DELETE CASCADE,
+  row INTEGER NOT NULL,
+  message TEXT NOT NULL
+);
+
+-- +goose Down
+DROP TABLE import_errors;
+DROP TABLE import_jobs;
@@ -0,0 +1,14 @@
+-- +goose Up
+CREATE TABLE chirp_views(
+  chirp_id UUID NOT NULL,
+    CONSTRAINT fk_chirp_id
+    FOREIGN KEY (chirp_id)
+    REFERENCES chirps(id)
+    ON DELETE CASCADE,
+  day DATE NOT NULL,
+  views BIGINT NOT NULL,
+  PRIMARY KEY (chirp_id, day)
+);
+
+-- +goose Down
+DROP TABLE chirp_views;
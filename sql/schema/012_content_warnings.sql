@@ -0,0 +1,11 @@
+-- +goose Up
+ALTER TABLE chirps ADD COLUMN content_warning TEXT NOT NULL DEFAULT '';
+ALTER TABLE chirps ADD COLUMN sensitive bool NOT NULL DEFAULT false;
+ALTER TABLE users ADD COLUMN is_moderator bool NOT NULL DEFAULT false;
+ALTER TABLE users ADD COLUMN hide_sensitive bool NOT NULL DEFAULT false;
+
+-- +goose Down
+ALTER TABLE users DROP COLUMN hide_sensitive;
+ALTER TABLE users DROP COLUMN is_moderator;
+ALTER TABLE chirps DROP COLUMN sensitive;
+ALTER TABLE chirps DROP COLUMN content_warning;
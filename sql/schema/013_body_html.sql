@@ -0,0 +1,5 @@
+-- +goose Up
+ALTER TABLE chirps ADD COLUMN body_html TEXT NOT NULL DEFAULT '';
+
+-- +goose Down
+ALTER TABLE chirps DROP COLUMN body_html;
@@ -0,0 +1,92 @@
+-- +goose Up
+CREATE TABLE actor_keys(
+  user_id UUID PRIMARY KEY,
+    CONSTRAINT fk_user_id
+    FOREIGN KEY (user_id)
+    REFERENCES users(id)
+    ON DELETE CASCADE,
+  created_at TIMESTAMP NOT NULL,
+  public_key TEXT NOT NULL,
+  private_key TEXT NOT NULL
+);
+
+CREATE TABLE remote_actors(
+  id TEXT PRIMARY KEY,
+  created_at TIMESTAMP NOT NULL,
+  updated_at TIMESTAMP NOT NULL,
+  username TEXT NOT NULL,
+  inbox TEXT NOT NULL,
+  shared_inbox TEXT NOT NULL DEFAULT '',
+  public_key TEXT NOT NULL
+);
+
+CREATE TABLE followers(
+  user_id UUID NOT NULL,
+    CONSTRAINT fk_user_id
+    FOREIGN KEY (user_id)
+    REFERENCES users(id)
+    ON DELETE CASCADE,
+  actor_id TEXT NOT NULL,
+    CONSTRAINT fk_actor_id
+    FOREIGN KEY (actor_id)
+    REFERENCES remote_actors(id)
+    ON DELETE CASCADE,
+  created_at TIMESTAMP NOT NULL,
+  PRIMARY KEY (user_id, actor_id)
+);
+
+CREATE TABLE remote_notes(
+  id TEXT PRIMARY KEY,
+  created_at TIMESTAMP NOT NULL,
+  actor_id TEXT NOT NULL,
+    CONSTRAINT fk_actor_id
+    FOREIGN KEY (actor_id)
+    REFERENCES remote_actors(id)
+    ON DELETE CASCADE,
+  content TEXT NOT NULL,
+  in_reply_to TEXT NOT NULL DEFAULT '',
+  published TIMESTAMP NOT NULL
+);
+
+CREATE TABLE remote_likes(
+  actor_id TEXT NOT NULL,
+    CONSTRAINT fk_actor_id
+    FOREIGN KEY (actor_id)
+    REFERENCES remote_actors(id)
+    ON DELETE CASCADE,
+  chirp_id UUID NOT NULL,
+    CONSTRAINT fk_chirp_id
+    FOREIGN KEY (chirp_id)
+    REFERENCES chirps(id)
+    ON DELETE CASCADE,
+  created_at TIMESTAMP NOT NULL,
+  PRIMARY KEY (actor_id, chirp_id)
+);
+
+CREATE TABLE deliveries(
+  id UUID PRIMARY KEY,
+  created_at TIMESTAMP NOT NULL,
+  updated_at TIMESTAMP NOT NULL,
+  user_id UUID NOT NULL,
+    CONSTRAINT fk_user_id
+    FOREIGN KEY (user_id)
+    REFERENCES users(id)
+    ON DELETE CASCADE,
+  inbox TEXT NOT NULL,
+  body TEXT NOT NULL,
+  status TEXT NOT NULL,
+  attempts INTEGER NOT NULL DEFAULT 0,
+  next_attempt_at TIMESTAMP NOT NULL,
+  last_error TEXT NOT NULL DEFAULT '',
+  delivered_at TIMESTAMP
+);
+
+CREATE INDEX deliveries_due ON deliveries (next_attempt_at) WHERE status = 'pending';
+
+-- +goose Down
+DROP TABLE deliveries;
+DROP TABLE remote_likes;
+DROP TABLE remote_notes;
+DROP TABLE followers;
+DROP TABLE remote_actors;
+DROP TABLE actor_keys;
@@ -0,0 +1,17 @@
+-- +goose Up
+ALTER TABLE users ADD COLUMN handle TEXT;
+ALTER TABLE users ADD COLUMN display_name TEXT NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN bio TEXT NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN avatar_url TEXT NOT NULL DEFAULT '';
+
+-- Existing accounts get the same kind of placeholder new ones do
+UPDATE users SET handle = 'user_' || substr(replace(id::text, '-', ''), 1, 12);
+ALTER TABLE users ALTER COLUMN handle SET NOT NULL;
+CREATE UNIQUE INDEX users_handle_key ON users (lower(handle));
+
+-- +goose Down
+DROP INDEX users_handle_key;
+ALTER TABLE users DROP COLUMN avatar_url;
+ALTER TABLE users DROP COLUMN bio;
+ALTER TABLE users DROP COLUMN display_name;
+ALTER TABLE users DROP COLUMN handle;
@@ -0,0 +1,22 @@
+-- +goose Up
+ALTER TABLE users ADD COLUMN email_verified_at TIMESTAMP;
+
+-- Accounts from before verification existed keep working
+UPDATE users SET email_verified_at = created_at;
+
+CREATE TABLE email_verifications(
+  token_hash TEXT PRIMARY KEY,
+  user_id UUID NOT NULL,
+    CONSTRAINT fk_user_id
+    FOREIGN KEY (user_id)
+    REFERENCES users(id)
+    ON DELETE CASCADE,
+  email TEXT NOT NULL,
+  created_at TIMESTAMP NOT NULL,
+  expires_at TIMESTAMP NOT NULL,
+  used_at TIMESTAMP
+);
+
+-- +goose Down
+DROP TABLE email_verifications;
+ALTER TABLE users DROP COLUMN email_verified_at;
@@ -0,0 +1,15 @@
+-- +goose Up
+CREATE TABLE password_resets(
+  token_hash TEXT PRIMARY KEY,
+  user_id UUID NOT NULL,
+    CONSTRAINT fk_user_id
+    FOREIGN KEY (user_id)
+    REFERENCES users(id)
+    ON DELETE CASCADE,
+  created_at TIMESTAMP NOT NULL,
+  expires_at TIMESTAMP NOT NULL,
+  used_at TIMESTAMP
+);
+
+-- +goose Down
+DROP TABLE password_resets;
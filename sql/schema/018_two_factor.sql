@@ -0,0 +1,43 @@
+-- +goose Up
+-- A row with no confirmed_at is an enrollment still waiting for its first
+-- code; it doesn't affect login.
+CREATE TABLE totp_credentials(
+  user_id UUID PRIMARY KEY,
+    CONSTRAINT fk_user_id
+    FOREIGN KEY (user_id)
+    REFERENCES users(id)
+    ON DELETE CASCADE,
+  secret TEXT NOT NULL,
+  created_at TIMESTAMP NOT NULL,
+  confirmed_at TIMESTAMP,
+  -- The last time step a code was accepted for, so codes can't be replayed
+  last_step BIGINT NOT NULL DEFAULT 0
+);
+
+CREATE TABLE recovery_codes(
+  code_hash TEXT PRIMARY KEY,
+  user_id UUID NOT NULL,
+    CONSTRAINT fk_user_id
+    FOREIGN KEY (user_id)
+    REFERENCES users(id)
+    ON DELETE CASCADE,
+  created_at TIMESTAMP NOT NULL,
+  used_at TIMESTAMP
+);
+
+CREATE TABLE login_challenges(
+  token_hash TEXT PRIMARY KEY,
+  user_id UUID NOT NULL,
+    CONSTRAINT fk_user_id
+    FOREIGN KEY (user_id)
+    REFERENCES users(id)
+    ON DELETE CASCADE,
+  created_at TIMESTAMP NOT NULL,
+  expires_at TIMESTAMP NOT NULL,
+  attempts INTEGER NOT NULL DEFAULT 0
+);
+
+-- +goose Down
+DROP TABLE login_challenges;
+DROP TABLE recovery_codes;
+DROP TABLE totp_credentials;
@@ -0,0 +1,35 @@
+-- +goose Up
+CREATE TABLE passkeys(
+  -- The credential ID as base64url, the form browsers send it in
+  id TEXT PRIMARY KEY,
+  user_id UUID NOT NULL,
+    CONSTRAINT fk_user_id
+    FOREIGN KEY (user_id)
+    REFERENCES users(id)
+    ON DELETE CASCADE,
+  name TEXT NOT NULL,
+  -- COSE_Key exactly as the authenticator reported it
+  public_key BYTEA NOT NULL,
+  sign_count BIGINT NOT NULL,
+  created_at TIMESTAMP NOT NULL,
+  last_used_at TIMESTAMP
+);
+
+CREATE INDEX passkeys_user_id_idx ON passkeys(user_id);
+
+-- Challenges for registrations (tied to a user) and logins (not yet)
+CREATE TABLE webauthn_challenges(
+  challenge TEXT PRIMARY KEY,
+  ceremony TEXT NOT NULL,
+  user_id UUID,
+    CONSTRAINT fk_user_id
+    FOREIGN KEY (user_id)
+    REFERENCES users(id)
+    ON DELETE CASCADE,
+  created_at TIMESTAMP NOT NULL,
+  expires_at TIMESTAMP NOT NULL
+);
+
+-- +goose Down
+DROP TABLE webauthn_challenges;
+DROP TABLE passkeys;
@@ -0,0 +1,78 @@
+-- +goose Up
+CREATE TABLE oauth_clients(
+  id UUID PRIMARY KEY,
+  owner_id UUID NOT NULL,
+    CONSTRAINT fk_owner_id
+    FOREIGN KEY (owner_id)
+    REFERENCES users(id)
+    ON DELETE CASCADE,
+  name TEXT NOT NULL,
+  -- Empty for public clients (mobile and browser apps), which rely on PKCE
+  secret_hash TEXT NOT NULL,
+  created_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE oauth_redirect_uris(
+  client_id UUID NOT NULL,
+    CONSTRAINT fk_client_id
+    FOREIGN KEY (client_id)
+    REFERENCES oauth_clients(id)
+    ON DELETE CASCADE,
+  uri TEXT NOT NULL,
+  PRIMARY KEY (client_id, uri)
+);
+
+-- What a user has allowed an app to do. Deleting a grant revokes the app.
+CREATE TABLE oauth_grants(
+  user_id UUID NOT NULL,
+    CONSTRAINT fk_user_id
+    FOREIGN KEY (user_id)
+    REFERENCES users(id)
+    ON DELETE CASCADE,
+  client_id UUID NOT NULL,
+    CONSTRAINT fk_client_id
+    FOREIGN KEY (client_id)
+    REFERENCES oauth_clients(id)
+    ON DELETE CASCADE,
+  scope TEXT NOT NULL,
+  created_at TIMESTAMP NOT NULL,
+  updated_at TIMESTAMP NOT NULL,
+  PRIMARY KEY (user_id, client_id)
+);
+
+CREATE TABLE oauth_codes(
+  code_hash TEXT PRIMARY KEY,
+  user_id UUID NOT NULL,
+  client_id UUID NOT NULL,
+    CONSTRAINT fk_grant
+    FOREIGN KEY (user_id, client_id)
+    REFERENCES oauth_grants(user_id, client_id)
+    ON DELETE CASCADE,
+  redirect_uri TEXT NOT NULL,
+  scope TEXT NOT NULL,
+  code_challenge TEXT NOT NULL,
+  created_at TIMESTAMP NOT NULL,
+  expires_at TIMESTAMP NOT NULL,
+  used_at TIMESTAMP
+);
+
+CREATE TABLE oauth_refresh_tokens(
+  token_hash TEXT PRIMARY KEY,
+  user_id UUID NOT NULL,
+  client_id UUID NOT NULL,
+    CONSTRAINT fk_grant
+    FOREIGN KEY (user_id, client_id)
+    REFERENCES oauth_grants(user_id, client_id)
+    ON DELETE CASCADE,
+  scope TEXT NOT NULL,
+  created_at TIMESTAMP NOT NULL,
+  expires_at TIMESTAMP NOT NULL,
+  revoked_at TIMESTAMP
+);
+
+-- +goose Down
+DROP TABLE oauth_refresh_tokens;
+DROP TABLE oauth_codes;
+DROP TABLE oauth_grants;
+DROP TABLE oauth_redirect_uris;
+DROP TABLE oauth_clients;
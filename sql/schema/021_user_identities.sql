@@ -0,0 +1,33 @@
+-- +goose Up
+-- Accounts at external OpenID Connect providers, by the provider's issuer
+-- and its stable subject identifier. Email addresses can change; those
+-- two don't.
+CREATE TABLE user_identities(
+  issuer TEXT NOT NULL,
+  subject TEXT NOT NULL,
+  user_id UUID NOT NULL,
+    CONSTRAINT fk_user_id
+    FOREIGN KEY (user_id)
+    REFERENCES users(id)
+    ON DELETE CASCADE,
+  -- The address the provider last reported, for display only
+  email TEXT NOT NULL,
+  created_at TIMESTAMP NOT NULL,
+  last_login_at TIMESTAMP NOT NULL,
+  PRIMARY KEY (issuer, subject)
+);
+
+CREATE INDEX user_identities_user_id_idx ON user_identities(user_id);
+
+-- Logins that have gone to the provider and not come back yet
+CREATE TABLE oidc_logins(
+  state_hash TEXT PRIMARY KEY,
+  nonce TEXT NOT NULL,
+  code_verifier TEXT NOT NULL,
+  created_at TIMESTAMP NOT NULL,
+  expires_at TIMESTAMP NOT NULL
+);
+
+-- +goose Down
+DROP TABLE oidc_logins;
+DROP TABLE user_identities;
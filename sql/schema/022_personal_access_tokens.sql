@@ -0,0 +1,25 @@
+-- +goose Up
+-- Long-lived tokens for bots and scripts. Only a hash of the token is
+-- kept; it's shown once, when it's made.
+CREATE TABLE personal_access_tokens(
+  id UUID PRIMARY KEY,
+  user_id UUID NOT NULL,
+    CONSTRAINT fk_user_id
+    FOREIGN KEY (user_id)
+    REFERENCES users(id)
+    ON DELETE CASCADE,
+  name TEXT NOT NULL,
+  token_hash TEXT NOT NULL UNIQUE,
+  -- Space separated, as in OAuth grants
+  scope TEXT NOT NULL,
+  created_at TIMESTAMP NOT NULL,
+  -- NULL for tokens that don't expire
+  expires_at TIMESTAMP,
+  last_used_at TIMESTAMP,
+  revoked_at TIMESTAMP
+);
+
+CREATE INDEX personal_access_tokens_user_id_idx ON personal_access_tokens(user_id);
+
+-- +goose Down
+DROP TABLE personal_access_tokens;
@@ -0,0 +1,23 @@
+-- +goose Up
+-- Refresh tokens are stored hashed, like every other bearer secret.
+-- HashToken is hex SHA-256, so tokens already handed out keep working.
+ALTER TABLE refresh_tokens RENAME COLUMN token TO token_hash;
+UPDATE refresh_tokens SET token_hash = encode(sha256(convert_to(token_hash, 'UTF8')), 'hex');
+
+-- Every refresh replaces the token with a new one in the same family. A
+-- replaced token coming back means it was copied, so the family goes.
+ALTER TABLE refresh_tokens ADD COLUMN family_id UUID;
+UPDATE refresh_tokens SET family_id = gen_random_uuid();
+ALTER TABLE refresh_tokens ALTER COLUMN family_id SET NOT NULL;
+ALTER TABLE refresh_tokens ADD COLUMN rotated_at TIMESTAMP;
+CREATE INDEX refresh_tokens_family_id_idx ON refresh_tokens(family_id);
+CREATE INDEX refresh_tokens_user_id_idx ON refresh_tokens(user_id);
+
+-- +goose Down
+-- Hashes can't be turned back into tokens, so everyone has to log in again
+DELETE FROM refresh_tokens;
+DROP INDEX refresh_tokens_user_id_idx;
+DROP INDEX refresh_tokens_family_id_idx;
+ALTER TABLE refresh_tokens DROP COLUMN rotated_at;
+ALTER TABLE refresh_tokens DROP COLUMN family_id;
+ALTER TABLE refresh_tokens RENAME COLUMN token_hash TO token;
@@ -0,0 +1,34 @@
+-- +goose Up
+-- A session is one login: a refresh token family plus where it came from.
+-- It's active while the family has a live token.
+CREATE TABLE sessions(
+  id UUID PRIMARY KEY,
+  user_id UUID NOT NULL,
+    CONSTRAINT fk_user_id
+    FOREIGN KEY (user_id)
+    REFERENCES users(id)
+    ON DELETE CASCADE,
+  user_agent TEXT NOT NULL,
+  ip TEXT NOT NULL,
+  device TEXT NOT NULL,
+  created_at TIMESTAMP NOT NULL,
+  last_used_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX sessions_user_id_idx ON sessions(user_id);
+
+-- Logins from before sessions were recorded
+INSERT INTO sessions (id, user_id, user_agent, ip, device, created_at, last_used_at)
+SELECT family_id, user_id, '', '', 'Unknown device', MIN(created_at), MAX(updated_at)
+FROM refresh_tokens
+GROUP BY family_id, user_id;
+
+ALTER TABLE refresh_tokens
+  ADD CONSTRAINT fk_family_id
+  FOREIGN KEY (family_id)
+  REFERENCES sessions(id)
+  ON DELETE CASCADE;
+
+-- +goose Down
+ALTER TABLE refresh_tokens DROP CONSTRAINT fk_family_id;
+DROP TABLE sessions;
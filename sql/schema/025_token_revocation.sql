@@ -0,0 +1,18 @@
+-- +goose Up
+-- Bumping a user's token version invalidates every access token issued
+-- before, e.g. after a password change.
+ALTER TABLE users ADD COLUMN token_version INTEGER NOT NULL DEFAULT 0;
+
+-- Access tokens turned away before they expire. id is a token's jti, or
+-- a session ID to cover every token from that session.
+CREATE TABLE revoked_access_tokens(
+  id TEXT PRIMARY KEY,
+  expires_at TIMESTAMP NOT NULL,
+  created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX revoked_access_tokens_created_at_idx ON revoked_access_tokens(created_at);
+
+-- +goose Down
+DROP TABLE revoked_access_tokens;
+ALTER TABLE users DROP COLUMN token_version;
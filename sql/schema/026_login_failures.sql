@@ -0,0 +1,13 @@
+-- +goose Up
+-- Failed logins, counted per account (by email, so unknown addresses are
+-- treated the same as real ones) and per client address.
+CREATE TABLE login_failures(
+  key TEXT PRIMARY KEY,
+  failures INTEGER NOT NULL,
+  first_failed_at TIMESTAMP NOT NULL,
+  last_failed_at TIMESTAMP NOT NULL,
+  locked_until TIMESTAMP
+);
+
+-- +goose Down
+DROP TABLE login_failures;
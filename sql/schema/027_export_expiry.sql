@@ -0,0 +1,10 @@
+-- +goose Up
+-- Finished archives, and the jobs behind them, are deleted once they
+-- expire.
+ALTER TABLE export_jobs ADD COLUMN expires_at TIMESTAMP;
+UPDATE export_jobs SET expires_at = finished_at + INTERVAL '7 days' WHERE finished_at IS NOT NULL;
+
+CREATE INDEX export_jobs_expires_at_idx ON export_jobs(expires_at);
+
+-- +goose Down
+ALTER TABLE export_jobs DROP COLUMN expires_at;
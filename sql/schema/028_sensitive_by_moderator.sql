@@ -0,0 +1,7 @@
+-- +goose Up
+-- Set when a moderator marks a chirp sensitive; only a moderator may then
+-- clear the flag.
+ALTER TABLE chirps ADD COLUMN sensitive_by_moderator bool NOT NULL DEFAULT false;
+
+-- +goose Down
+ALTER TABLE chirps DROP COLUMN sensitive_by_moderator;
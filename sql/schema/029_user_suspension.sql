@@ -0,0 +1,7 @@
+-- +goose Up
+-- Suspended users can't log in or refresh, and their personal access
+-- tokens stop working.
+ALTER TABLE users ADD COLUMN suspended_at TIMESTAMP;
+
+-- +goose Down
+ALTER TABLE users DROP COLUMN suspended_at;
@@ -0,0 +1,16 @@
+-- +goose Up
+-- A user has at most one export queued or running at a time. Any extra
+-- ones already queued are failed so the index can be built.
+UPDATE export_jobs SET status = 'failed', error = 'Superseded by an earlier export', finished_at = NOW()
+WHERE status IN ('pending', 'running')
+  AND id NOT IN (
+    SELECT DISTINCT ON (user_id) id FROM export_jobs
+    WHERE status IN ('pending', 'running')
+    ORDER BY user_id, created_at ASC
+  );
+
+CREATE UNIQUE INDEX export_jobs_one_active_idx ON export_jobs(user_id)
+WHERE status IN ('pending', 'running');
+
+-- +goose Down
+DROP INDEX export_jobs_one_active_idx;
@@ -0,0 +1,16 @@
+-- +goose Up
+-- Follows and likes from other servers notify too. Their actor is a remote
+-- actor, so a notification has exactly one of actor_id and remote_actor_id.
+ALTER TABLE notifications ALTER COLUMN actor_id DROP NOT NULL;
+ALTER TABLE notifications ADD COLUMN remote_actor_id TEXT
+  CONSTRAINT fk_remote_actor_id
+  REFERENCES remote_actors(id)
+  ON DELETE CASCADE;
+ALTER TABLE notifications ADD CONSTRAINT notifications_one_actor
+  CHECK ((actor_id IS NULL) <> (remote_actor_id IS NULL));
+
+-- +goose Down
+DELETE FROM notifications WHERE actor_id IS NULL;
+ALTER TABLE notifications DROP CONSTRAINT notifications_one_actor;
+ALTER TABLE notifications DROP COLUMN remote_actor_id;
+ALTER TABLE notifications ALTER COLUMN actor_id SET NOT NULL;
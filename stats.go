@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/plusk0/webserver/internal/analytics"
+	"github.com/plusk0/webserver/internal/auth"
+	"github.com/plusk0/webserver/internal/database"
+	"github.com/plusk0/webserver/internal/takeout"
+)
+
+const viewFlushInterval = 30 * time.Second
+
+func (conf *apiConfig) flushViews() {
+	conf.views.Run(viewFlushInterval, func(k analytics.Key, n int64) error {
+		params := database.AddChirpViewsParams{ChirpID: k.ChirpID, Day: k.Day, Views: n}
+		err := conf.dbQueries.AddChirpViews(context.Background(), params)
+		// The chirp was deleted since it was viewed, so the views have
+		// nowhere to go. Keeping them would retry the write forever.
+		if isForeignKeyViolation(err) {
+			return nil
+		}
+		return err
+	})
+}
+
+// chirpStatsHandlerFunc shows a chirp's author how often it was viewed and
+// liked. Only likes from other servers are counted, chirpy itself has none.
+// Chirpy Red members also get the per-day numbers, optionally as CSV.
+func (conf *apiConfig) chirpStatsHandlerFunc(w http.ResponseWriter, r *http.Request) {
+	tk, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	userID, err := conf.validateToken(r.Context(), tk, "chirps:read")
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, 404, "Failed to parse ChirpID")
+		return
+	}
+	chirp, err := conf.dbQueries.GetChirp(r.Context(), chirpID)
+	if err != nil {
+		respondWithError(w, 404, "ChirpNotFound")
+		return
+	}
+	if chirp.UserID.UUID != userID {
+		respondWithError(w, 403, "User not Authorized")
+		return
+	}
+	user, err := conf.dbQueries.GetUserByID(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, 404, "User not found")
+		return
+	}
+
+	rows, err := conf.dbQueries.GetChirpViews(r.Context(), chirpID)
+	if err != nil {
+		respondWithError(w, 500, "Failed to get stats")
+		return
+	}
+	likeRows, err := conf.dbQueries.GetChirpRemoteLikes(r.Context(), chirpID)
+	if err != nil {
+		respondWithError(w, 500, "Failed to get stats")
+		return
+	}
+	views := analytics.Days{}
+	for day, n := range conf.views.Pending(chirpID) {
+		views.Add(day, n)
+	}
+	for _, row := range rows {
+		views.Add(row.Day, row.Views)
+	}
+	likes := analytics.Days{}
+	for _, like := range likeRows {
+		likes.Add(like.CreatedAt, 1)
+	}
+
+	stats := ChirpStats{ChirpID: chirpID}
+	perDay := map[string]ChirpDayStats{}
+	for day, n := range views {
+		d := perDay[day]
+		d.Day, d.Views = day, n
+		perDay[day] = d
+		stats.Views += n
+	}
+	for day, n := range likes {
+		d := perDay[day]
+		d.Day, d.Likes = day, n
+		perDay[day] = d
+		stats.Likes += n
+	}
+	days := []ChirpDayStats{}
+	for _, d := range perDay {
+		days = append(days, d)
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i].Day < days[j].Day })
+
+	if r.URL.Query().Get("format") == "csv" {
+		if !user.IsChirpyRed {
+			respondWithError(w, 403, "CSV export requires Chirpy Red")
+			return
+		}
+		w.Header().Set("Content-Type", "text/csv")
+		if err := takeout.WriteCSV(w, days); err != nil {
+			respondWithError(w, 500, "Failed to write CSV")
+		}
+		return
+	}
+	if user.IsChirpyRed {
+		stats.Days = days
+	}
+	respondWithJSON(w, 200, stats)
+}
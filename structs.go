@@ -2,48 +2,270 @@ package main
 
 import (
 	"database/sql"
+	"html/template"
+	"net/http"
+	"net/netip"
 	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/plusk0/webserver/internal/analytics"
+	"github.com/plusk0/webserver/internal/auth"
 	"github.com/plusk0/webserver/internal/database"
+	"github.com/plusk0/webserver/internal/lockout"
+	"github.com/plusk0/webserver/internal/mailer"
+	"github.com/plusk0/webserver/internal/oidc"
+	"github.com/plusk0/webserver/internal/realtime"
+	"github.com/plusk0/webserver/internal/revocation"
+	"github.com/plusk0/webserver/internal/webauthn"
 )
 
 type apiConfig struct {
-	fileserverHits atomic.Int32
-	dbQueries      *database.Queries
-	platform       string
-	JWTKey         string
-	PolkaKey       string
+	fileserverHits      atomic.Int32
+	db                  *sql.DB
+	dbQueries           *database.Queries
+	platform            string
+	jwtKeys             *auth.Keyring
+	linkSecret          string
+	revoked             *revocation.List
+	loginPolicy         lockout.Policy
+	passwordPolicy      auth.PasswordPolicy
+	trustedProxies      []netip.Prefix
+	PolkaKey            string
+	hub                 *realtime.Hub
+	chirpDeletionPolicy string
+	exportDir           string
+	exportQueue         chan uuid.UUID
+	importDir           string
+	importQueue         chan uuid.UUID
+	views               *analytics.Counter
+	pages               map[string]*template.Template
+	mailer              mailer.Mailer
+	webauthn            *webauthn.RelyingParty
+	oidc                *oidc.Provider
+	baseURL             string
+	federationClient    *http.Client
+	deliveryWake        chan struct{}
 }
 
 type Chirp struct {
-	ID        uuid.UUID `json:"id"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
-	Body      string    `json:"body"`
-	UserID    uuid.UUID `json:"user_id"`
+	ID             uuid.UUID    `json:"id"`
+	CreatedAt      time.Time    `json:"created_at"`
+	UpdatedAt      time.Time    `json:"updated_at"`
+	Body           string       `json:"body"`
+	BodyHTML       string       `json:"body_html"`
+	UserID         uuid.UUID    `json:"user_id"`
+	ContentWarning string       `json:"content_warning,omitempty"`
+	Sensitive      bool         `json:"sensitive"`
+	Author         *ChirpAuthor `json:"author,omitempty"`
+}
+
+// ChirpAuthor is embedded in chirps when a client asks for ?include=author.
+type ChirpAuthor struct {
+	ID          uuid.UUID `json:"id"`
+	Handle      string    `json:"handle"`
+	DisplayName string    `json:"display_name"`
+	AvatarURL   string    `json:"avatar_url"`
 }
 
 type chirpReq struct {
-	Body   string    `json:"body"`
-	UserID uuid.UUID `json:"user_id"`
+	Body           string    `json:"body"`
+	UserID         uuid.UUID `json:"-"`
+	ContentWarning string    `json:"content_warning"`
+	Sensitive      bool      `json:"sensitive"`
+}
+
+type sensitiveReq struct {
+	Sensitive bool `json:"sensitive"`
+}
+
+type preferencesReq struct {
+	HideSensitive bool `json:"hide_sensitive"`
+}
+
+type deleteUsrReq struct {
+	Password string `json:"password"`
+}
+
+type forgotPasswordReq struct {
+	Email string `json:"email"`
+}
+
+type resetPasswordReq struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+type twoFactorReq struct {
+	ChallengeToken string `json:"challenge_token"`
+	Password       string `json:"password"`
+	Code           string `json:"code"`
+	RecoveryCode   string `json:"recovery_code"`
+}
+
+type passkeyReq struct {
+	Name       string              `json:"name"`
+	Credential publicKeyCredential `json:"credential"`
+}
+
+// publicKeyCredential is a PublicKeyCredential as serialized by its
+// toJSON(), for both registration and login responses.
+type publicKeyCredential struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    webauthn.URLBytes `json:"clientDataJSON"`
+		AttestationObject webauthn.URLBytes `json:"attestationObject"`
+		AuthenticatorData webauthn.URLBytes `json:"authenticatorData"`
+		Signature         webauthn.URLBytes `json:"signature"`
+		UserHandle        webauthn.URLBytes `json:"userHandle"`
+	} `json:"response"`
+}
+
+type accessTokenReq struct {
+	Name          string   `json:"name"`
+	Scopes        []string `json:"scopes"`
+	ExpiresInDays int      `json:"expires_in_days"`
+}
+
+type oauthClientReq struct {
+	Name         string   `json:"name"`
+	RedirectURIs []string `json:"redirect_uris"`
+	Confidential bool     `json:"confidential"`
 }
 
 type usrReq struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
+	Handle   string `json:"handle"`
 }
 
 type User struct {
-	ID           uuid.UUID `json:"id"`
+	ID            uuid.UUID `json:"id"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+	Email         string    `json:"email"`
+	password      string
+	Token         string `json:"token"`
+	RefreshToken  string `json:"refresh_token"`
+	IsChirpyRed   bool   `json:"is_chirpy_red"`
+	Handle        string `json:"handle"`
+	EmailVerified bool   `json:"email_verified"`
+}
+
+// LoginChallenge stands in for a User when the password was right but a
+// second factor is still needed.
+type LoginChallenge struct {
+	TwoFactorRequired bool      `json:"two_factor_required"`
+	ChallengeToken    string    `json:"challenge_token"`
+	ExpiresAt         time.Time `json:"expires_at"`
+}
+
+type TwoFactorEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+}
+
+type Passkey struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+// AccessToken is a personal access token. Token is only filled in when
+// it's first made.
+type AccessToken struct {
+	ID         uuid.UUID  `json:"id"`
+	Token      string     `json:"token,omitempty"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+// Session is somewhere the user is logged in. Current marks the one the
+// request came from.
+type Session struct {
+	ID         uuid.UUID `json:"id"`
+	Device     string    `json:"device"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	Current    bool      `json:"current"`
+}
+
+// PasswordRejected is the 400 body for a password the policy won't take,
+// listing every rule it broke.
+type PasswordRejected struct {
+	Error       string         `json:"error"`
+	FailedRules []PasswordRule `json:"failed_rules"`
+}
+
+type PasswordRule struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// Identity is an account at an external identity provider that can be
+// used to log in.
+type Identity struct {
+	Issuer      string    `json:"issuer"`
+	Email       string    `json:"email"`
+	CreatedAt   time.Time `json:"created_at"`
+	LastLoginAt time.Time `json:"last_login_at"`
+}
+
+// OAuthClient is a registered third-party app. ClientSecret is only
+// filled in when the app is first registered.
+type OAuthClient struct {
+	ClientID     uuid.UUID `json:"client_id"`
+	ClientSecret string    `json:"client_secret,omitempty"`
+	Name         string    `json:"name"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	Confidential bool      `json:"confidential"`
 	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
-	Email        string    `json:"email"`
-	password     string
-	Token        string `json:"token"`
+}
+
+type OAuthToken struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
 	RefreshToken string `json:"refresh_token"`
-	IsChirpyRed  bool   `json:"is_chirpy_red"`
+	Scope        string `json:"scope"`
+}
+
+// AuthorizedApp is an app the user has granted access to.
+type AuthorizedApp struct {
+	ClientID     uuid.UUID `json:"client_id"`
+	Name         string    `json:"name"`
+	Scopes       []string  `json:"scopes"`
+	AuthorizedAt time.Time `json:"authorized_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+type RecoveryCodes struct {
+	Codes []string `json:"recovery_codes"`
+}
+
+// Profile is what anyone can see about a user.
+type Profile struct {
+	ID          uuid.UUID `json:"id"`
+	Handle      string    `json:"handle"`
+	DisplayName string    `json:"display_name"`
+	Bio         string    `json:"bio"`
+	AvatarURL   string    `json:"avatar_url"`
+	CreatedAt   time.Time `json:"created_at"`
+	IsChirpyRed bool      `json:"is_chirpy_red"`
+}
+
+type profileReq struct {
+	Handle      *string `json:"handle"`
+	DisplayName *string `json:"display_name"`
+	Bio         *string `json:"bio"`
+	AvatarURL   *string `json:"avatar_url"`
 }
 
 type RefreshToken struct {
@@ -63,3 +285,178 @@ type WebHook struct {
 type WebhookData struct {
 	Data string `json:"user_id"`
 }
+
+type Message struct {
+	ID          uuid.UUID  `json:"id"`
+	Seq         int64      `json:"seq"`
+	CreatedAt   time.Time  `json:"created_at"`
+	SenderID    uuid.UUID  `json:"sender_id"`
+	RecipientID uuid.UUID  `json:"recipient_id"`
+	Body        string     `json:"body"`
+	ReadAt      *time.Time `json:"read_at,omitempty"`
+}
+
+type messageReq struct {
+	RecipientID uuid.UUID `json:"recipient_id"`
+	Body        string    `json:"body"`
+}
+
+type readReceiptReq struct {
+	MessageID uuid.UUID `json:"message_id"`
+}
+
+type readReceipt struct {
+	MessageID uuid.UUID `json:"message_id"`
+	ReadAt    time.Time `json:"read_at"`
+}
+
+type Notification struct {
+	ID            uuid.UUID  `json:"id"`
+	CreatedAt     time.Time  `json:"created_at"`
+	Type          string     `json:"type"`
+	ActorID       *uuid.UUID `json:"actor_id,omitempty"`
+	RemoteActorID string     `json:"remote_actor_id,omitempty"`
+	ChirpID       *uuid.UUID `json:"chirp_id,omitempty"`
+}
+
+type NotificationGroup struct {
+	Type           string      `json:"type"`
+	ChirpID        *uuid.UUID  `json:"chirp_id,omitempty"`
+	ActorIDs       []uuid.UUID `json:"actor_ids"`
+	RemoteActorIDs []string    `json:"remote_actor_ids,omitempty"`
+	Count          int         `json:"count"`
+	Summary        string      `json:"summary"`
+	Unread         bool        `json:"unread"`
+	LatestAt       time.Time   `json:"latest_at"`
+}
+
+type NotificationInbox struct {
+	UnreadCount   int64               `json:"unread_count"`
+	Notifications []NotificationGroup `json:"notifications"`
+}
+
+type notificationReadReq struct {
+	Type    string     `json:"type"`
+	ChirpID *uuid.UUID `json:"chirp_id"`
+}
+
+type ExportJob struct {
+	ID          uuid.UUID  `json:"id"`
+	CreatedAt   time.Time  `json:"created_at"`
+	Status      string     `json:"status"`
+	Error       string     `json:"error,omitempty"`
+	DownloadURL string     `json:"download_url,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+}
+
+type profileExport struct {
+	ID          uuid.UUID `json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	Email       string    `json:"email"`
+	IsChirpyRed bool      `json:"is_chirpy_red"`
+	Handle      string    `json:"handle"`
+	DisplayName string    `json:"display_name"`
+	Bio         string    `json:"bio"`
+	AvatarURL   string    `json:"avatar_url"`
+}
+
+type sessionExport struct {
+	ID         uuid.UUID `json:"id"`
+	Device     string    `json:"device"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+}
+
+// followerExport is a remote account following the user.
+type followerExport struct {
+	ActorID   string    `json:"actor_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// likeExport is a like of one of the user's chirps from a remote account.
+type likeExport struct {
+	ChirpID   uuid.UUID `json:"chirp_id"`
+	ActorID   string    `json:"actor_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type ImportJob struct {
+	ID        uuid.UUID        `json:"id"`
+	CreatedAt time.Time        `json:"created_at"`
+	Format    string           `json:"format"`
+	Status    string           `json:"status"`
+	Total     int32            `json:"total"`
+	Processed int32            `json:"processed"`
+	Imported  int32            `json:"imported"`
+	Skipped   int32            `json:"skipped"`
+	Errors    []ImportRowError `json:"errors"`
+}
+
+type ImportRowError struct {
+	Row   int32  `json:"row"`
+	Error string `json:"error"`
+}
+
+type ChirpStats struct {
+	ChirpID uuid.UUID       `json:"chirp_id"`
+	Views   int64           `json:"views"`
+	Likes   int64           `json:"likes"`
+	Days    []ChirpDayStats `json:"days,omitempty"`
+}
+
+type ChirpDayStats struct {
+	Day   string `json:"day"`
+	Views int64  `json:"views"`
+	Likes int64  `json:"likes"`
+}
+
+type pageData struct {
+	Title       string
+	Description string
+	Type        string
+	URL         string
+	Image       string
+	Chirp       chirpView
+	Profile     profileView
+	Chirps      []chirpView
+	Consent     consentView
+	// Private pages are never cached, by browsers or proxies
+	Private bool
+}
+
+type consentView struct {
+	ClientName   string
+	Scopes       []string
+	Params       []formField
+	RedirectHost string
+	Email        string
+	Error        string
+}
+
+type formField struct {
+	Name  string
+	Value string
+}
+
+type chirpView struct {
+	URL            string
+	AuthorURL      string
+	AuthorName     string
+	AuthorHandle   string
+	BodyHTML       template.HTML
+	ContentWarning string
+	Sensitive      bool
+	CreatedAt      time.Time
+}
+
+type profileView struct {
+	Name        string
+	Handle      string
+	Bio         string
+	AvatarURL   string
+	CreatedAt   time.Time
+	IsChirpyRed bool
+}
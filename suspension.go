@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/plusk0/webserver/internal/auth"
+)
+
+// errAccountSuspended is only returned once the user has proven who they
+// are, so it doesn't tell anyone else which accounts are suspended.
+var errAccountSuspended = errors.New("account is suspended")
+
+// checkNotSuspended looks userID up again, so a suspension made after a
+// login started still stops it.
+func (conf *apiConfig) checkNotSuspended(ctx context.Context, userID uuid.UUID) error {
+	user, err := conf.dbQueries.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if user.SuspendedAt.Valid {
+		return errAccountSuspended
+	}
+	return nil
+}
+
+// suspendUserHandlerFunc lets a moderator suspend a user. Every login
+// they have ends at once, and they can't log in again until unsuspended.
+func (conf *apiConfig) suspendUserHandlerFunc(w http.ResponseWriter, r *http.Request) {
+	conf.setSuspended(w, r, true)
+}
+
+func (conf *apiConfig) unsuspendUserHandlerFunc(w http.ResponseWriter, r *http.Request) {
+	conf.setSuspended(w, r, false)
+}
+
+func (conf *apiConfig) setSuspended(w http.ResponseWriter, r *http.Request, suspend bool) {
+	tk, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	modID, err := auth.ValidateJWT(tk, conf.jwtKeys)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	mod, err := conf.dbQueries.GetUserByID(r.Context(), modID)
+	if err != nil || !mod.IsModerator {
+		respondWithError(w, 403, "User not Authorized")
+		return
+	}
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, 404, "User not found")
+		return
+	}
+	if userID == modID {
+		respondWithError(w, 400, "Moderators can't suspend themselves")
+		return
+	}
+	if _, err := conf.dbQueries.GetUserByID(r.Context(), userID); err != nil {
+		respondWithError(w, 404, "User not found")
+		return
+	}
+
+	tx, err := conf.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, 500, "Failed to update user")
+		return
+	}
+	defer tx.Rollback()
+	q := conf.dbQueries.WithTx(tx)
+
+	action := auditUserUnsuspended
+	var n int64
+	var version int32
+	if suspend {
+		action = auditUserSuspended
+		if n, err = q.SuspendUser(r.Context(), userID); err == nil {
+			version, err = revokeUserAccess(r.Context(), q, userID)
+		}
+	} else {
+		n, err = q.UnsuspendUser(r.Context(), userID)
+	}
+	if err != nil {
+		respondWithError(w, 500, "Failed to update user")
+		return
+	}
+	// Suspending twice still logs the user out, but is only audited once
+	if n > 0 {
+		if err := audit(r.Context(), q, action, userID, map[string]any{"by": modID}); err != nil {
+			respondWithError(w, 500, "Failed to update user")
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		respondWithError(w, 500, "Failed to update user")
+		return
+	}
+	if suspend {
+		conf.revoked.SetTokenVersion(userID, version)
+	}
+	w.WriteHeader(204)
+}
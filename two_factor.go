@@ -0,0 +1,334 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/plusk0/webserver/internal/auth"
+	"github.com/plusk0/webserver/internal/database"
+	"github.com/plusk0/webserver/internal/totp"
+)
+
+const (
+	totpIssuer            = "Chirpy"
+	recoveryCodeCount     = 10
+	loginChallengeTTL     = 5 * time.Minute
+	loginChallengeRetries = 5
+)
+
+// enrollTwoFactorHandlerFunc starts TOTP enrollment. Nothing changes at
+// login until the secret is confirmed with a first code.
+func (conf *apiConfig) enrollTwoFactorHandlerFunc(w http.ResponseWriter, r *http.Request) {
+	tk, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	userID, err := auth.ValidateJWT(tk, conf.jwtKeys)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	user, err := conf.dbQueries.GetUserByID(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, 404, "User not found")
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		respondWithError(w, 500, "Failed to start enrollment")
+		return
+	}
+	params := database.StartTOTPEnrollmentParams{UserID: userID, Secret: secret}
+	n, err := conf.dbQueries.StartTOTPEnrollment(r.Context(), params)
+	if err != nil {
+		respondWithError(w, 500, "Failed to start enrollment")
+		return
+	}
+	// A confirmed credential is left alone by the upsert
+	if n == 0 {
+		respondWithError(w, 409, "Two-factor authentication is already on")
+		return
+	}
+	respondWithJSON(w, 200, TwoFactorEnrollment{
+		Secret: secret,
+		URI:    totp.URI(totpIssuer, user.Email, secret),
+	})
+}
+
+// confirmTwoFactorHandlerFunc turns 2FA on once the user proves their app
+// produces the right codes, and hands out recovery codes. They're shown
+// this once; only their hashes are kept.
+func (conf *apiConfig) confirmTwoFactorHandlerFunc(w http.ResponseWriter, r *http.Request) {
+	tk, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	userID, err := auth.ValidateJWT(tk, conf.jwtKeys)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	var req twoFactorReq
+	if err := readJSON(r, &req); err != nil {
+		respondWithError(w, 400, "Something went wrong")
+		return
+	}
+
+	cred, err := conf.dbQueries.GetTOTPCredential(r.Context(), userID)
+	if err != nil || cred.ConfirmedAt.Valid {
+		respondWithError(w, 400, "No enrollment in progress")
+		return
+	}
+	step, ok := totp.Validate(cred.Secret, req.Code, time.Now())
+	if !ok {
+		respondWithError(w, 400, "Invalid code")
+		return
+	}
+
+	tx, err := conf.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, 500, "Failed to enable two-factor authentication")
+		return
+	}
+	defer tx.Rollback()
+	q := conf.dbQueries.WithTx(tx)
+
+	n, err := q.ConfirmTOTPCredential(r.Context(), database.ConfirmTOTPCredentialParams{UserID: userID, LastStep: step})
+	if err != nil || n == 0 {
+		respondWithError(w, 500, "Failed to enable two-factor authentication")
+		return
+	}
+	codes, err := replaceRecoveryCodes(r.Context(), q, userID)
+	if err != nil {
+		respondWithError(w, 500, "Failed to enable two-factor authentication")
+		return
+	}
+	if err := audit(r.Context(), q, auditTwoFactorEnabled, userID, map[string]any{}); err != nil {
+		respondWithError(w, 500, "Failed to enable two-factor authentication")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		respondWithError(w, 500, "Failed to enable two-factor authentication")
+		return
+	}
+	respondWithJSON(w, 200, RecoveryCodes{codes})
+}
+
+// disableTwoFactorHandlerFunc needs the password and a current code on top
+// of the access token, so a stolen token alone can't strip 2FA.
+func (conf *apiConfig) disableTwoFactorHandlerFunc(w http.ResponseWriter, r *http.Request) {
+	tk, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	userID, err := auth.ValidateJWT(tk, conf.jwtKeys)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	var req twoFactorReq
+	if err := readJSON(r, &req); err != nil {
+		respondWithError(w, 400, "Something went wrong")
+		return
+	}
+	user, err := conf.dbQueries.GetUserByID(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, 404, "User not found")
+		return
+	}
+	valid, err := auth.CheckPasswordHash(req.Password, user.Password)
+	if err != nil || !valid {
+		respondWithError(w, 403, "Invalid password")
+		return
+	}
+	ok, err := checkSecondFactor(r.Context(), conf.dbQueries, userID, req)
+	if err != nil {
+		respondWithError(w, 500, "Failed to disable two-factor authentication")
+		return
+	}
+	if !ok {
+		respondWithError(w, 403, "Invalid code")
+		return
+	}
+
+	tx, err := conf.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, 500, "Failed to disable two-factor authentication")
+		return
+	}
+	defer tx.Rollback()
+	q := conf.dbQueries.WithTx(tx)
+
+	if err := q.DeleteTOTPCredential(r.Context(), userID); err != nil {
+		respondWithError(w, 500, "Failed to disable two-factor authentication")
+		return
+	}
+	if err := q.DeleteRecoveryCodes(r.Context(), userID); err != nil {
+		respondWithError(w, 500, "Failed to disable two-factor authentication")
+		return
+	}
+	if err := audit(r.Context(), q, auditTwoFactorDisabled, userID, map[string]any{}); err != nil {
+		respondWithError(w, 500, "Failed to disable two-factor authentication")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		respondWithError(w, 500, "Failed to disable two-factor authentication")
+		return
+	}
+	w.WriteHeader(204)
+}
+
+// loginTwoFactorHandlerFunc finishes a login that stopped at a challenge.
+// Each challenge allows a handful of guesses before the password has to be
+// entered again, and wrong guesses count towards locking the account.
+func (conf *apiConfig) loginTwoFactorHandlerFunc(w http.ResponseWriter, r *http.Request) {
+	var req twoFactorReq
+	if err := readJSON(r, &req); err != nil {
+		respondWithError(w, 400, "Something went wrong")
+		return
+	}
+	hash := auth.HashToken(req.ChallengeToken)
+	challenge, err := conf.dbQueries.AttemptLoginChallenge(r.Context(), hash)
+	if err != nil {
+		respondWithError(w, 401, "Invalid or expired challenge")
+		return
+	}
+	if challenge.Attempts > loginChallengeRetries {
+		_ = conf.dbQueries.DeleteLoginChallenge(r.Context(), hash)
+		respondWithError(w, 401, "Too many attempts, log in again")
+		return
+	}
+
+	user, err := conf.dbQueries.GetUserByID(r.Context(), challenge.UserID)
+	if err != nil {
+		respondWithError(w, 401, "Invalid or expired challenge")
+		return
+	}
+	err = conf.checkSecondFactorLogin(r.Context(), user, req)
+	var locked *loginLockedError
+	if errors.As(err, &locked) {
+		locked.setRetryAfter(w)
+		respondWithError(w, 429, "Too many failed login attempts, try again later")
+		return
+	}
+	if errors.Is(err, errLoginFailed) {
+		respondWithError(w, 401, "Invalid code")
+		return
+	}
+	if err != nil {
+		respondWithError(w, 500, "Failed to log in")
+		return
+	}
+	if err := conf.dbQueries.DeleteLoginChallenge(r.Context(), hash); err != nil {
+		respondWithError(w, 500, "Failed to log in")
+		return
+	}
+	conf.completeLogin(w, r, user)
+}
+
+// twoFactorEnabled reports whether logging in as userID needs a code.
+func (conf *apiConfig) twoFactorEnabled(ctx context.Context, userID uuid.UUID) (bool, error) {
+	cred, err := conf.dbQueries.GetTOTPCredential(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return cred.ConfirmedAt.Valid, nil
+}
+
+// startLoginChallenge returns the token that stands in for a password
+// check until the second factor is given.
+func (conf *apiConfig) startLoginChallenge(ctx context.Context, userID uuid.UUID) (LoginChallenge, error) {
+	// Abandoned challenges would otherwise pile up
+	if err := conf.dbQueries.DeleteExpiredLoginChallenges(ctx); err != nil {
+		return LoginChallenge{}, err
+	}
+	token, err := auth.MakeToken()
+	if err != nil {
+		return LoginChallenge{}, err
+	}
+	expires := time.Now().Add(loginChallengeTTL)
+	params := database.CreateLoginChallengeParams{TokenHash: auth.HashToken(token), UserID: userID, ExpiresAt: expires}
+	if err := conf.dbQueries.CreateLoginChallenge(ctx, params); err != nil {
+		return LoginChallenge{}, err
+	}
+	return LoginChallenge{true, token, expires}, nil
+}
+
+// checkSecondFactor accepts either a TOTP code or an unused recovery code.
+// Each is good for one use: a TOTP step can't be replayed and a recovery
+// code is spent.
+func checkSecondFactor(ctx context.Context, q *database.Queries, userID uuid.UUID, req twoFactorReq) (bool, error) {
+	if req.RecoveryCode != "" {
+		params := database.UseRecoveryCodeParams{UserID: userID, CodeHash: auth.HashToken(normalizeRecoveryCode(req.RecoveryCode))}
+		n, err := q.UseRecoveryCode(ctx, params)
+		return n == 1, err
+	}
+	cred, err := q.GetTOTPCredential(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	step, ok := totp.Validate(cred.Secret, req.Code, time.Now())
+	if !ok {
+		return false, nil
+	}
+	n, err := q.UseTOTPStep(ctx, database.UseTOTPStepParams{UserID: userID, LastStep: step})
+	return n == 1, err
+}
+
+// replaceRecoveryCodes swaps any existing recovery codes for a fresh set
+// and returns them in the form they should be shown.
+func replaceRecoveryCodes(ctx context.Context, q *database.Queries, userID uuid.UUID) ([]string, error) {
+	if err := q.DeleteRecoveryCodes(ctx, userID); err != nil {
+		return nil, err
+	}
+	enc := base32.StdEncoding.WithPadding(base32.NoPadding)
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		s := strings.ToLower(enc.EncodeToString(b))[:10]
+		codes[i] = s[:5] + "-" + s[5:]
+		params := database.CreateRecoveryCodeParams{CodeHash: auth.HashToken(normalizeRecoveryCode(codes[i])), UserID: userID}
+		if err := q.CreateRecoveryCode(ctx, params); err != nil {
+			return nil, err
+		}
+	}
+	return codes, nil
+}
+
+// normalizeRecoveryCode forgives case and the dash people may leave out.
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+func readJSON(r *http.Request, v any) error {
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read body: %v", err)
+	}
+	defer r.Body.Close()
+	return json.Unmarshal(data, v)
+}
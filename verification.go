@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/plusk0/webserver/internal/auth"
+	"github.com/plusk0/webserver/internal/database"
+	"github.com/plusk0/webserver/internal/mailer"
+)
+
+const (
+	verificationTTL      = 24 * time.Hour
+	verificationCooldown = time.Minute
+	verificationHourly   = 5
+)
+
+// validEmail accepts a bare address only, e.g. "bob@example.com", not
+// "Bob <bob@example.com>".
+func validEmail(s string) bool {
+	addr, err := mail.ParseAddress(s)
+	return err == nil && addr.Address == s
+}
+
+// errNoBaseURL is returned instead of mailing a link when BASE_URL isn't
+// set. The request's Host header is up to the client, so it never goes
+// into an email.
+var errNoBaseURL = errors.New("BASE_URL must be set to email links")
+
+// sendVerification mails userID a single-use link that verifies email.
+// Only the token's hash is stored.
+func (conf *apiConfig) sendVerification(ctx context.Context, userID uuid.UUID, email string) error {
+	if conf.baseURL == "" {
+		return errNoBaseURL
+	}
+	token, err := auth.MakeToken()
+	if err != nil {
+		return err
+	}
+	params := database.CreateEmailVerificationParams{
+		TokenHash: auth.HashToken(token),
+		UserID:    userID,
+		Email:     email,
+		ExpiresAt: time.Now().Add(verificationTTL),
+	}
+	if err := conf.dbQueries.CreateEmailVerification(ctx, params); err != nil {
+		return err
+	}
+	link := conf.baseURL + "/api/users/verify?token=" + url.QueryEscape(token)
+	return conf.mailer.Send(ctx, mailer.Message{
+		To:      email,
+		Subject: "Verify your Chirpy email address",
+		Body: fmt.Sprintf("Welcome to Chirpy!\n\n"+
+			"Open this link to verify your email address:\n\n%s\n\n"+
+			"The link works once and expires in 24 hours. If you didn't sign up, ignore this email.\n", link),
+	})
+}
+
+// verifyEmailHandlerFunc is where the emailed link points.
+func (conf *apiConfig) verifyEmailHandlerFunc(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		respondWithError(w, 400, "Missing token")
+		return
+	}
+	v, err := conf.dbQueries.UseEmailVerification(r.Context(), auth.HashToken(token))
+	if err != nil {
+		respondWithError(w, 400, "Invalid or expired link")
+		return
+	}
+	// The address may have changed since the link was sent
+	n, err := conf.dbQueries.VerifyUserEmail(r.Context(), database.VerifyUserEmailParams{ID: v.UserID, Email: v.Email})
+	if err != nil {
+		respondWithError(w, 500, "Failed to verify email")
+		return
+	}
+	if n == 0 {
+		respondWithError(w, 400, "Invalid or expired link")
+		return
+	}
+	respondWithJSON(w, 200, map[string]bool{"email_verified": true})
+}
+
+// resendVerificationHandlerFunc sends a fresh link, at most once a minute
+// and a few times an hour.
+func (conf *apiConfig) resendVerificationHandlerFunc(w http.ResponseWriter, r *http.Request) {
+	tk, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	userID, err := auth.ValidateJWT(tk, conf.jwtKeys)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	user, err := conf.dbQueries.GetUserByID(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, 404, "User not found")
+		return
+	}
+	if user.EmailVerifiedAt.Valid {
+		respondWithError(w, 400, "Email is already verified")
+		return
+	}
+
+	wait, err := conf.verificationWait(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, 500, "Failed to send email")
+		return
+	}
+	if wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())))
+		respondWithError(w, 429, "Too many verification emails, try again later")
+		return
+	}
+
+	if err := conf.sendVerification(r.Context(), userID, user.Email); err != nil {
+		fmt.Printf("Failed to send verification email: %v\n", err)
+		respondWithError(w, 500, "Failed to send email")
+		return
+	}
+	w.WriteHeader(202)
+}
+
+// verificationWait returns how long userID has to wait before another
+// verification email may go out, or zero if one may be sent now.
+func (conf *apiConfig) verificationWait(ctx context.Context, userID uuid.UUID) (time.Duration, error) {
+	for _, limit := range []struct {
+		window time.Duration
+		max    int64
+	}{{verificationCooldown, 1}, {time.Hour, verificationHourly}} {
+		params := database.CountEmailVerificationsSinceParams{UserID: userID, CreatedAt: time.Now().Add(-limit.window)}
+		sent, err := conf.dbQueries.CountEmailVerificationsSince(ctx, params)
+		if err != nil {
+			return 0, err
+		}
+		if sent >= limit.max {
+			return limit.window, nil
+		}
+	}
+	return 0, nil
+}
+
+// requireVerified answers 403 and returns false for accounts that haven't
+// verified their email yet.
+func (conf *apiConfig) requireVerified(w http.ResponseWriter, r *http.Request, userID uuid.UUID) bool {
+	user, err := conf.dbQueries.GetUserByID(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return false
+	}
+	if !user.EmailVerifiedAt.Valid {
+		respondWithError(w, 403, "Verify your email address first")
+		return false
+	}
+	return true
+}
+
+func newMailer() (mailer.Mailer, error) {
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		from = "Chirpy <no-reply@localhost>"
+	}
+	switch os.Getenv("MAILER") {
+	case "smtp":
+		host := os.Getenv("SMTP_HOST")
+		if host == "" {
+			return nil, errors.New("SMTP_HOST must be set when MAILER=smtp")
+		}
+		port := os.Getenv("SMTP_PORT")
+		if port == "" {
+			port = "587"
+		}
+		return &mailer.SMTP{
+			Addr:     net.JoinHostPort(host, port),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     from,
+		}, nil
+	case "", "outbox":
+		dir := os.Getenv("OUTBOX_DIR")
+		if dir == "" {
+			dir = filepath.Join(os.TempDir(), "chirpy-outbox")
+		}
+		return &mailer.Outbox{Dir: dir, From: from}, nil
+	}
+	return nil, fmt.Errorf("unknown MAILER %q, use smtp or outbox", os.Getenv("MAILER"))
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/plusk0/webserver/internal/auth"
+	"github.com/plusk0/webserver/internal/database"
+	"github.com/plusk0/webserver/internal/realtime"
+)
+
+const replayPageSize = 100
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+func (conf *apiConfig) wsHandlerFunc(w http.ResponseWriter, r *http.Request) {
+	// Browsers can't set headers on a WebSocket handshake, so accept the
+	// token as a query parameter as well.
+	tk, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		tk = r.URL.Query().Get("token")
+	}
+	// The socket carries direct messages both ways, so it needs more than
+	// the notifications scope
+	userID, err := conf.validateToken(r.Context(), tk, "messages")
+	if err != nil {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+
+	var since int64
+	if s := r.URL.Query().Get("since"); s != "" {
+		since, err = strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			respondWithError(w, 400, "Invalid sequence number")
+			return
+		}
+	}
+	if conf.hub.Full(userID) {
+		respondWithError(w, 429, "Too many connections")
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		fmt.Printf("Failed to upgrade websocket: %v\n", err)
+		return
+	}
+	client := realtime.NewClient(conf.hub, userID, conn)
+	if err := conf.hub.Register(client); err != nil {
+		msg := websocket.FormatCloseMessage(websocket.CloseTryAgainLater, err.Error())
+		_ = conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+		conn.Close()
+		return
+	}
+
+	ctx := context.Background()
+	client.Run(func(c *realtime.Client) {
+		conf.replayMessages(ctx, c, since)
+	}, func(c *realtime.Client, env realtime.Envelope) {
+		conf.handleWSEnvelope(ctx, c, env)
+	})
+}
+
+// replayMessages sends the messages stored after since a page at a time,
+// waiting for the write pump to drain each one, so a long backlog doesn't
+// overflow the send buffer and drop the connection.
+func (conf *apiConfig) replayMessages(ctx context.Context, c *realtime.Client, since int64) {
+	for {
+		params := database.GetMessagesSincePageParams{UserID: c.UserID, Since: since, PageSize: replayPageSize}
+		missed, err := conf.dbQueries.GetMessagesSincePage(ctx, params)
+		if err != nil {
+			c.SendError("Failed to resync messages")
+			return
+		}
+		for _, m := range missed {
+			since = m.Seq
+			env, err := realtime.NewEnvelope("message", m.Seq, dbMessageToJSON(m))
+			if err != nil {
+				continue
+			}
+			if !c.SendWait(env) {
+				return
+			}
+		}
+		if len(missed) < replayPageSize {
+			return
+		}
+	}
+}
+
+func (conf *apiConfig) handleWSEnvelope(ctx context.Context, c *realtime.Client, env realtime.Envelope) {
+	switch env.Type {
+	case "message.send":
+		var req messageReq
+		if err := json.Unmarshal(env.Data, &req); err != nil || req.Body == "" {
+			c.SendError("Invalid message")
+			return
+		}
+		params := database.CreateMessageParams{SenderID: c.UserID, RecipientID: req.RecipientID, Body: req.Body}
+		msg, err := conf.dbQueries.CreateMessage(ctx, params)
+		if err != nil {
+			c.SendError("Failed to send message")
+			return
+		}
+		out, err := realtime.NewEnvelope("message", msg.Seq, dbMessageToJSON(msg))
+		if err != nil {
+			return
+		}
+		conf.hub.Send(msg.RecipientID, out)
+		if msg.RecipientID != msg.SenderID {
+			conf.hub.Send(msg.SenderID, out)
+		}
+
+	case "typing":
+		var req messageReq
+		if err := json.Unmarshal(env.Data, &req); err != nil || req.RecipientID == uuid.Nil {
+			c.SendError("Invalid typing indicator")
+			return
+		}
+		out, err := realtime.NewEnvelope("typing", 0, map[string]uuid.UUID{"user_id": c.UserID})
+		if err != nil {
+			return
+		}
+		conf.hub.Send(req.RecipientID, out)
+
+	case "read":
+		var req readReceiptReq
+		if err := json.Unmarshal(env.Data, &req); err != nil {
+			c.SendError("Invalid read receipt")
+			return
+		}
+		params := database.MarkMessageReadParams{ID: req.MessageID, RecipientID: c.UserID}
+		msg, err := conf.dbQueries.MarkMessageRead(ctx, params)
+		if err != nil {
+			c.SendError("Message not found")
+			return
+		}
+		out, err := realtime.NewEnvelope("read", 0, readReceipt{msg.ID, msg.ReadAt.Time})
+		if err != nil {
+			return
+		}
+		conf.hub.Send(msg.SenderID, out)
+		conf.hub.Send(msg.RecipientID, out)
+
+	default:
+		c.SendError("Unknown message type")
+	}
+}
+
+func dbMessageToJSON(db database.Message) Message {
+	msg := Message{
+		ID:          db.ID,
+		Seq:         db.Seq,
+		CreatedAt:   db.CreatedAt,
+		SenderID:    db.SenderID,
+		RecipientID: db.RecipientID,
+		Body:        db.Body,
+	}
+	if db.ReadAt.Valid {
+		msg.ReadAt = &db.ReadAt.Time
+	}
+	return msg
+}